@@ -0,0 +1,16 @@
+package main
+
+import (
+	"dtsync/pkg/agent"
+	"dtsync/pkg/fs"
+	"log"
+	"os"
+)
+
+// RunAgent serves the local file system on stdin and stdout for a dtsync started on another host.
+// Logs must go to stderr, stdout belongs to the protocol.
+func RunAgent() {
+	if err := agent.NewServer(fs.LocalBackend{}).Serve(os.Stdin, os.Stdout); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"dtsync/pkg/args"
+	"dtsync/pkg/daemon"
+	"dtsync/pkg/metrics"
+	"dtsync/pkg/schedule"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RunDaemon is the main function of the daemon command, it serves the API until SIGINT or SIGTERM.
+func RunDaemon(arguments args.DaemonArguments) {
+	if err := runDaemon(arguments); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+func runDaemon(arguments args.DaemonArguments) error {
+	var options []daemon.Option
+
+	if arguments.MetricsListen != "" {
+		registry := metrics.NewRegistry()
+
+		stopMetrics, err := serveMetrics(arguments.MetricsListen, registry)
+		if err != nil {
+			return err
+		}
+
+		defer stopMetrics()
+
+		options = append(options, daemon.WithObserver(registry))
+	}
+
+	token, err := readToken(arguments.TokenFile)
+	if err != nil {
+		return err
+	} else if token != "" {
+		options = append(options, daemon.WithToken(token))
+	}
+
+	manager, err := daemon.New(synchronize, arguments.StatePath, options...)
+	if err != nil {
+		return err
+	}
+
+	if arguments.JobsPath != "" {
+		if err := putJobs(manager, arguments.JobsPath); err != nil {
+			return err
+		}
+	}
+
+	listener, err := daemon.Listen(arguments.Address, token != "")
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: manager.Handler(), ReadHeaderTimeout: 10 * time.Second} //nolint:gomnd
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	go manager.Schedule(ctx)
+
+	go func() {
+		<-ctx.Done()
+		manager.Shutdown()
+		server.Close()
+	}()
+
+	log.Printf("Listening on %s\n", arguments.Address)
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// putJobs defines the jobs of the JSON file, an array of jobs like the ones returned by `GET /jobs`.
+func putJobs(manager *daemon.Daemon, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var jobs []daemon.Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, job := range jobs {
+		if err := manager.PutJob(job); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// readToken returns the token of the daemon API in the file, or in the environment without a file.
+func readToken(path string) (string, error) {
+	if path == "" {
+		return os.Getenv(args.TokenEnv), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// RunCtl is the main function of the ctl command.
+func RunCtl(arguments args.CtlArguments) {
+	if err := runCtl(arguments); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+func runCtl(arguments args.CtlArguments) error {
+	token, err := readToken(arguments.TokenFile)
+	if err != nil {
+		return err
+	}
+
+	client := daemon.NewClient(arguments.Address, daemon.WithClientToken(token))
+
+	switch arguments.Command {
+	case "jobs":
+		jobs, err := client.Jobs()
+		if err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+		for _, job := range jobs {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", job.Name, describeSchedule(job.Schedule), strings.Join(job.Args, " "))
+		}
+
+		return writer.Flush()
+	case "put":
+		return client.PutJob(daemon.Job{Name: arguments.Params[0], Args: arguments.Params[1:]})
+	case "remove":
+		return client.RemoveJob(arguments.Params[0])
+	case "start":
+		run, err := client.Start(arguments.Params[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(run.ID)
+	case "watch":
+		run, err := client.Watch(context.Background(), arguments.Params[0], printRun)
+		if err != nil {
+			return err
+		}
+
+		printRun(run)
+		printRunFailures(run)
+	case "cancel":
+		run, err := client.Cancel(arguments.Params[0])
+		if err != nil {
+			return err
+		}
+
+		printRun(run)
+	case "status":
+		run, err := client.Run(arguments.Params[0])
+		if err != nil {
+			return err
+		}
+
+		printRun(run)
+		printRunFailures(run)
+	case "runs":
+		runs, err := client.Runs()
+		if err != nil {
+			return err
+		}
+
+		for _, run := range runs {
+			printRun(run)
+		}
+	}
+
+	return nil
+}
+
+// printRun prints the run and its counters in one line.
+func printRun(run daemon.Run) {
+	line := fmt.Sprintf("%s %s %s %s copied=%d replaced=%d removed=%d skipped=%d failed=%d",
+		run.ID, run.Job, run.State, run.Started.Format(time.DateTime),
+		run.Status.Copied, run.Status.Replaced, run.Status.Removed, run.Status.Skipped, run.Status.Failed)
+
+	if run.Paused {
+		line += " paused"
+	}
+
+	if run.Error != "" {
+		line += " error=" + run.Error
+	}
+
+	fmt.Println(line)
+}
+
+// printRunFailures prints the report of the failed entries, if there are any.
+func printRunFailures(run daemon.Run) {
+	if len(run.Failures) > 0 {
+		printFailures(run.Failures)
+	}
+}
+
+// describeSchedule returns the cron expression or interval of the schedule, `-` for jobs started manually.
+func describeSchedule(spec *schedule.Spec) string {
+	switch {
+	case spec == nil:
+		return "-"
+	case spec.Cron != "":
+		return spec.Cron
+	case spec.Every != 0:
+		return "@every " + time.Duration(spec.Every).String()
+	}
+
+	return "-"
+}
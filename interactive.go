@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"dtsync/pkg/args"
+	"dtsync/pkg/review"
+	"dtsync/pkg/sync"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// RunInteractive is the main function of `dtsync sync -interactive`.
+func RunInteractive(arguments args.Arguments) {
+	ctx, stop := interruptContext()
+	defer stop()
+
+	result, err := runInteractive(ctx, arguments)
+
+	var failed *sync.FailedError
+	if errors.As(err, &failed) {
+		printFailures(failed.Failures)
+	}
+
+	if result != nil {
+		fmt.Printf("Copied: %d, Replaced: %d, Removed: %d, Skipped: %d, Failed: %d, Conflicts: %d, Collisions: %d\n",
+			result.Status.Copied, result.Status.Replaced, result.Status.Removed, result.Status.Skipped,
+			result.Status.Failed, result.Status.Conflicts, result.Status.Collisions)
+	}
+
+	if errors.Is(err, context.Canceled) {
+		log.Println("Interrupted, the sync is incomplete")
+	} else if err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+// runInteractive plans the sync, lets the user review it and executes the approved entries,
+// it returns the result when they were executed.
+func runInteractive(ctx context.Context, arguments args.Arguments) (*sync.Result, error) {
+	if err := review.Supported(os.Stdin, os.Stdout); err != nil {
+		return nil, err
+	}
+
+	ui := review.New(fmt.Sprintf("dtsync %s -> %s", arguments.SrcRootPath, arguments.DstRootPath))
+
+	engine, closeEngine, err := newEngine(arguments, sync.Options{Observer: ui, Approve: ui.Approve})
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeEngine()
+
+	profile, err := useProfile(engine, arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("dst profile", profile.String())
+
+	if !arguments.SkipPreflight {
+		if _, err := engine.Preflight(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Println("Planning the sync...")
+
+	entries, err := engine.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the raw terminal doesn't signal Ctrl+C, the UI handles it as a key
+	if err := ui.Open(os.Stdin, os.Stdout); err != nil {
+		return nil, err
+	}
+
+	defer ui.Close()
+
+	if execute, err := ui.Review(ctx, review.NewTree(arguments.DstRootPath, entries)); err != nil || !execute {
+		return nil, err
+	}
+
+	var result sync.Result
+
+	err = ui.Execute(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = engine.Run(ctx)
+
+		return err
+	})
+
+	return &result, err
+}
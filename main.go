@@ -1,118 +1,478 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"dtsync/pkg/agent"
 	"dtsync/pkg/args"
+	"dtsync/pkg/compress"
+	"dtsync/pkg/crypt"
 	"dtsync/pkg/fs"
+	"dtsync/pkg/metrics"
 	"dtsync/pkg/screen"
+	"dtsync/pkg/sync"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	encodedRootPerm = 0o755
+	sshTimeout      = 30 * time.Second
 )
 
 func main() {
-	Run(args.Parse(os.Args))
+	var command string
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "repo":
+		RunRepo(args.ParseRepo(os.Args))
+	case "agent":
+		RunAgent()
+	case "daemon":
+		RunDaemon(args.ParseDaemon(os.Args))
+	case "ctl":
+		RunCtl(args.ParseCtl(os.Args))
+	default:
+		Run(args.Parse(os.Args))
+	}
 }
 
 // Run is the main function of the application.
 func Run(arguments args.Arguments) {
-	var (
-		srcErrChan, dstErrChan chan error
-		err                    error
-	)
+	if arguments.Interactive {
+		RunInteractive(arguments)
 
-	view := screen.NewView(os.Stdout)
-	if err = view.Start(); err != nil {
-		log.Println(err.Error())
+		return
 	}
 
-	defer view.Stop()
+	renderer, err := screen.NewRenderer(screen.Output(arguments.Output), os.Stdout, arguments.ProgressInterval)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
 
-	operation := fs.NewOperation()
-	scanner := fs.NewShadowScan()
+	if err := renderer.Start(); err != nil {
+		log.Println(err.Error())
+	}
 
-	defer scanner.Stop()
+	defer renderer.Stop()
 
-	srcErrChan = scanner.Start(arguments.SrcRootPath, arguments.DstRootPath,
-		func(srcPath, dstPath string) error {
-			if !operation.Exists(dstPath) {
-				view.AddStatus(screen.Status{SrcTotalFiles: 1, Copied: 1})
+	ctx, stop := interruptContext()
+	defer stop()
 
-				return operation.Copy(srcPath, dstPath)
-			} else if arguments.ReplaceNotMatchingFiles && !operation.Equal(srcPath, dstPath) {
-				view.AddStatus(screen.Status{SrcTotalFiles: 1, Replaced: 1})
+	addStatus := renderer.AddStatus
+	registry := metrics.NewRegistry()
 
-				return operation.Copy(srcPath, dstPath)
-			}
+	if arguments.MetricsListen != "" {
+		stopMetrics, err := serveMetrics(arguments.MetricsListen, registry)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
 
-			view.AddStatus(screen.Status{SrcTotalFiles: 1, Skipped: 1})
+		defer stopMetrics()
 
+		addStatus = func(status screen.Status) {
+			renderer.AddStatus(status)
+			registry.AddStatus("", status)
+		}
+	}
+
+	started := time.Now()
+	err = synchronizeObserved(ctx, arguments, viewObserver{StatusObserver: addStatus, renderer: renderer},
+		func(ctx context.Context) error {
 			return nil
-		},
-		func(srcPath, dstPath string) error {
-			if !operation.Exists(dstPath) {
-				view.AddStatus(screen.Status{SrcTotalDirectories: 1, Copied: 1})
+		})
 
-				return operation.Copy(srcPath, dstPath)
-			}
+	registry.Finished("", time.Since(started), err)
+	renderer.Stop()
 
-			view.AddStatus(screen.Status{SrcTotalDirectories: 1, Skipped: 1})
+	var failed *sync.FailedError
+	if errors.As(err, &failed) && screen.Output(arguments.Output) == screen.OutputJSON {
+		json.NewEncoder(os.Stdout).Encode(failuresEvent{Event: "failures", Failures: failed.Failures}) //nolint:errcheck
+	} else if errors.As(err, &failed) {
+		printFailures(failed.Failures)
+	}
 
-			return nil
-		},
-	)
+	if errors.Is(err, context.Canceled) {
+		log.Println("Interrupted, the sync is incomplete")
+	} else if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// failuresEvent is printed after the progress events of -output json when entries failed.
+type failuresEvent struct {
+	Event    string         `json:"event"`
+	Failures []sync.Failure `json:"failures"`
+}
+
+// viewObserver reports the progress to the status function and the file being copied to the renderer.
+type viewObserver struct {
+	sync.StatusObserver
+	renderer screen.Renderer
+}
+
+// Progress shows the file being copied.
+func (o viewObserver) Progress(progress fs.Progress) {
+	o.renderer.Progress(progress.Path, progress.Size, progress.Done)
+}
 
-	if arguments.RemoveDstLeftover {
-		dstErrChan = scanner.Start(arguments.DstRootPath, arguments.SrcRootPath,
-			func(srcPath, dstPath string) error {
-				if !operation.Exists(dstPath) {
-					view.AddStatus(screen.Status{DstTotalFiles: 1, Removed: 1})
+// Copied logs the method which copied the file.
+func (o viewObserver) Copied(path string, method fs.CopyMethod) {
+	o.renderer.Copied(path, string(method))
+}
 
-					return operation.Delete(srcPath)
-				}
+// Warned logs the entry skipped with a warning.
+func (o viewObserver) Warned(path string, err error) {
+	o.renderer.Warn(path, err.Error())
+}
 
-				return nil
-			},
-			func(srcPath, dstPath string) error {
-				if !operation.Exists(dstPath) {
-					view.AddStatus(screen.Status{DstTotalDirectories: 1, Removed: 1})
+// Profiled shows the profile of the dst file system.
+func (o viewObserver) Profiled(profile fs.Profile) {
+	o.renderer.Info("dst profile " + profile.String())
+}
 
-					return operation.Delete(srcPath)
-				}
+// synchronize runs one sync of the arguments, reporting the progress to addStatus,
+// until it is done or the context is canceled. It calls wait before each file, which blocks while transfers are paused.
+func synchronize(
+	ctx context.Context, arguments args.Arguments, addStatus func(screen.Status), wait func(context.Context) error,
+) error {
+	return synchronizeObserved(ctx, arguments, sync.StatusObserver(addStatus), wait)
+}
 
-				return nil
-			},
-		)
+// synchronizeObserved is synchronize reporting the progress and decisions to the observer.
+// The bytes to copy are pre-counted by the pre-flight checks or with -precount.
+// The dst file system is probed before, its profile adapts the comparisons.
+func synchronizeObserved(
+	ctx context.Context, arguments args.Arguments, observer sync.Observer, wait func(context.Context) error,
+) error {
+	engine, closeEngine, err := newEngine(arguments, sync.Options{Observer: observer, Wait: wait})
+	if err != nil {
+		return err
 	}
 
-	// waiting for any for ending conditions
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer closeEngine()
 
-	for i := 0; i < 2; i++ {
-		select {
-		case <-signalChan:
-			goto end
-		case err = <-srcErrChan:
-			if err != nil && !errors.Is(err, fs.ErrScannerAtEnd) {
-				goto end
+	if _, err := useProfile(engine, arguments); err != nil {
+		return err
+	}
+
+	var estimate sync.Estimate
+
+	switch {
+	case !arguments.SkipPreflight:
+		estimate, err = engine.Preflight(ctx)
+	case arguments.Precount:
+		estimate, err = engine.Estimate(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	observer.AddStatus(screen.Status{TotalBytes: estimate.TransferBytes})
+
+	_, err = engine.Run(ctx)
+
+	return err
+}
+
+// useProfile probes the dst file system unless -skip-probe, applies the -fs-profile overrides
+// and adapts the engine to the profile.
+func useProfile(engine *sync.Engine, arguments args.Arguments) (fs.Profile, error) {
+	profile := fs.FullProfile
+	if !arguments.SkipProbe {
+		probed, err := engine.Probe()
+		if err != nil {
+			return profile, err
+		}
+
+		profile = probed
+	}
+
+	profile, err := fs.ParseProfile(arguments.FSProfile, profile)
+	if err != nil {
+		return profile, err
+	}
+
+	engine.UseProfile(profile)
+
+	return profile, nil
+}
+
+// newEngine creates the engine of the arguments on their backends, completing the options
+// with the roots, the actions and the codec. The returned function closes the backends.
+func newEngine(arguments args.Arguments, options sync.Options) (*sync.Engine, func(), error) {
+	backend, closeBackend, err := newBackend(arguments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	codec, err := newCodec(backend, arguments)
+	if err != nil {
+		closeBackend()
+
+		return nil, nil, err
+	}
+
+	reflink, err := fs.ParseReflink(arguments.Reflink)
+	if err != nil {
+		closeBackend()
+
+		return nil, nil, err
+	}
+
+	deleteMode, err := sync.ParseDeleteMode(arguments.DeleteMode)
+	if err != nil {
+		closeBackend()
+
+		return nil, nil, err
+	}
+
+	normalization, err := fs.ParseNormalization(arguments.Normalize)
+	if err != nil {
+		closeBackend()
+
+		return nil, nil, err
+	}
+
+	collisions, err := fs.ParseCollisionPolicy(arguments.Collisions)
+	if err != nil {
+		closeBackend()
+
+		return nil, nil, err
+	}
+
+	switch {
+	case codec != nil && arguments.Restore:
+		options.OperationOptions = append(options.OperationOptions, fs.WithDecoder(codec))
+		options.ScanOptions = append(options.ScanOptions, fs.WithNameMapper(codec.DecodeName))
+		options.RemoveScanOptions = append(options.RemoveScanOptions, fs.WithNameMapper(codec.EncodeName))
+	case codec != nil:
+		options.OperationOptions = append(options.OperationOptions, fs.WithEncoder(codec))
+		options.ScanOptions = append(options.ScanOptions, fs.WithNameMapper(codec.EncodeName))
+		options.RemoveScanOptions = append(options.RemoveScanOptions, fs.WithNameMapper(codec.DecodeName))
+	}
+
+	if arguments.InPlace {
+		options.OperationOptions = append(options.OperationOptions, fs.WithInPlace(arguments.InPlaceMinSize))
+	}
+
+	options.OperationOptions = append(options.OperationOptions, fs.WithReflink(reflink))
+
+	if arguments.Sparse {
+		options.OperationOptions = append(options.OperationOptions, fs.WithSparse())
+	}
+
+	options.SrcRootPath = arguments.SrcRootPath
+	options.DstRootPath = arguments.DstRootPath
+	options.Replace = arguments.ReplaceNotMatchingFiles
+	options.Remove = arguments.RemoveDstLeftover
+	options.DeleteMode = deleteMode
+	options.Specials = arguments.Specials
+	options.Devices = arguments.Devices
+	options.Normalization = normalization
+	options.Collisions = collisions
+	options.Backend = backend
+	options.ContinueOnError = arguments.ContinueOnError
+	options.MaxErrors = arguments.MaxErrors
+	options.Retries = arguments.Retries
+
+	engine, err := sync.New(options)
+	if err != nil {
+		closeBackend()
+
+		return nil, nil, err
+	}
+
+	return engine, closeBackend, nil
+}
+
+// printFailures prints the report of the entries failed with -continue-on-error.
+func printFailures(failures []sync.Failure) {
+	fmt.Printf("\nFailed entries (%d):\n", len(failures))
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	for _, failure := range failures {
+		fmt.Fprintf(writer, "  %s\t%s\t%d\t%s\t%s\n",
+			failure.Cause, failure.Action, failure.Attempts, failure.Path, failure.Error)
+	}
+
+	writer.Flush()
+}
+
+// newBackend mounts the remote roots, the returned function closes the connections.
+func newBackend(arguments args.Arguments) (*fs.Mux, func(), error) {
+	var (
+		mux     = fs.NewMux()
+		closers []io.Closer
+	)
+
+	closeAll := func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}
+
+	sshConfig := fs.SSHConfig{KnownHostsFile: arguments.KnownHostsFile, Timeout: sshTimeout}
+	if len(arguments.SSHKeyFile) > 0 {
+		sshConfig.KeyFiles = []string{arguments.SSHKeyFile}
+	}
+
+	agentConfig := agent.Config{
+		Command: arguments.AgentCommand, KeyFile: arguments.SSHKeyFile,
+		KnownHostsFile: arguments.KnownHostsFile, Compress: arguments.AgentCompress,
+	}
+	s3Config := fs.S3Config{Endpoint: arguments.S3Endpoint, Region: arguments.S3Region, Profile: arguments.S3Profile}
+
+	for _, rootPath := range []string{arguments.SrcRootPath, arguments.DstRootPath} {
+		switch {
+		case fs.IsSFTPLocation(rootPath):
+			location, err := fs.ParseSFTPLocation(rootPath)
+			if err != nil {
+				closeAll()
+
+				return nil, nil, err
+			}
+
+			backend, err := fs.DialSFTP(location, sshConfig)
+			if err != nil {
+				closeAll()
+
+				return nil, nil, err
+			}
+
+			closers = append(closers, backend)
+			mux.Mount(rootPath, location.Path, backend)
+		case fs.IsS3Location(rootPath):
+			location, err := fs.ParseS3Location(rootPath)
+			if err != nil {
+				closeAll()
+
+				return nil, nil, err
 			}
 
-			if !arguments.RemoveDstLeftover {
-				goto end
+			backend, err := fs.DialS3(location, s3Config)
+			if err != nil {
+				closeAll()
+
+				return nil, nil, err
+			}
+
+			mux.Mount(rootPath, location.Prefix, backend)
+		case agent.IsLocation(rootPath):
+			location, err := agent.ParseLocation(rootPath)
+			if err != nil {
+				closeAll()
+
+				return nil, nil, err
 			}
-		case err = <-dstErrChan:
-			if err != nil && !errors.Is(err, fs.ErrScannerAtEnd) {
-				goto end
+
+			client, err := agent.Dial(location, agentConfig)
+			if err != nil {
+				closeAll()
+
+				return nil, nil, err
 			}
+
+			closers = append(closers, client)
+			mux.Mount(rootPath, location.Path, client)
 		}
 	}
 
-end:
-	view.Render()
+	return mux, closeAll, nil
+}
 
-	if err != nil && !errors.Is(err, fs.ErrScannerAtEnd) {
-		log.Println(err.Error())
+// newCodec creates the codec for the encoded root, it returns nil when src and dst are plain.
+// On restore the codecs are detected by the config files in the src root.
+func newCodec(backend fs.Backend, arguments args.Arguments) (fs.Codec, error) {
+	var codecs fs.ChainCodec
+
+	encodedRootPath := arguments.DstRootPath
+	if arguments.Restore {
+		encodedRootPath = arguments.SrcRootPath
+	} else if len(arguments.Compress) > 0 || len(arguments.KeyFile) > 0 || len(arguments.PassphraseFile) > 0 {
+		if err := fs.MkdirAll(backend, encodedRootPath, encodedRootPerm); err != nil {
+			return nil, err
+		}
+	}
+
+	compressor, err := newCompressor(backend, arguments, encodedRootPath)
+	if err != nil {
+		return nil, err
+	} else if compressor != nil {
+		codecs = append(codecs, compressor)
+	}
+
+	cipher, err := newCipher(backend, arguments, encodedRootPath)
+	if err != nil {
+		return nil, err
+	} else if cipher != nil {
+		codecs = append(codecs, cipher)
+	}
+
+	if len(codecs) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	return codecs, nil
+}
+
+func newCompressor(backend fs.Backend, arguments args.Arguments, encodedRootPath string) (*compress.Compressor, error) {
+	if arguments.Restore {
+		compressor, err := compress.Load(backend, encodedRootPath)
+		if errors.Is(err, compress.ErrNotCompressed) {
+			return nil, nil //nolint:nilnil
+		}
+
+		return compressor, err
+	} else if len(arguments.Compress) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	return compress.Setup(backend, encodedRootPath, compress.Config{
+		Algorithm: compress.Algorithm(arguments.Compress),
+		Level:     arguments.CompressLevel,
+	})
+}
+
+func newCipher(backend fs.Backend, arguments args.Arguments, encodedRootPath string) (*crypt.Cipher, error) {
+	var (
+		key crypt.Key
+		err error
+	)
+
+	if len(arguments.KeyFile) > 0 {
+		if key.KeyFile, err = os.ReadFile(arguments.KeyFile); err != nil {
+			return nil, err
+		}
 	}
+
+	if len(arguments.PassphraseFile) > 0 {
+		passphrase, err := os.ReadFile(arguments.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+
+		key.Passphrase = bytes.TrimRight(passphrase, "\r\n")
+	}
+
+	if !arguments.Restore && len(key.KeyFile) == 0 && len(key.Passphrase) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	cipher, err := crypt.Setup(backend, encodedRootPath, key, arguments.EncryptNames, !arguments.Restore)
+	if arguments.Restore && errors.Is(err, crypt.ErrNotEncrypted) {
+		return nil, nil //nolint:nilnil
+	}
+
+	return cipher, err
 }
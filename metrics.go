@@ -0,0 +1,33 @@
+package main
+
+import (
+	"dtsync/pkg/metrics"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serveMetrics serves the metrics of the registry at /metrics on the address, the returned function stops it.
+func serveMetrics(address string, registry *metrics.Registry) (func(), error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second} //nolint:gomnd
+
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err.Error())
+		}
+	}()
+
+	return func() {
+		server.Close()
+	}, nil
+}
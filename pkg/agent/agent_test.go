@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"dtsync/pkg/fs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgent(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_agent")
+	})
+	assert.NoError(t, os.Mkdir("test_agent", 0o755))
+	assert.NoError(t, os.Mkdir("test_agent/remote", 0o755))
+
+	random := rand.New(rand.NewSource(1)) //nolint:gosec
+	data := make([]byte, 512*1024)
+	random.Read(data)
+
+	changed := append([]byte{}, data...)
+	copy(changed[300000:], "changed block")
+
+	tests := []struct {
+		name     string
+		compress bool
+	}{
+		{name: "Plain", compress: false},
+		{name: "Zstd", compress: true},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				local     = "test_agent/" + test.name + ".bin"
+				remote    = "ssh:/host/test_agent/remote/" + test.name + "/data.bin"
+				remoteDir = "test_agent/remote/" + test.name
+				modTime   = time.Now().Add(-time.Hour)
+			)
+
+			client, sent := newPipeAgent(t, test.compress)
+			mux := fs.NewMux()
+			mux.Mount("ssh://host/test_agent/remote", "test_agent/remote", client)
+			operation := fs.NewOperation(fs.WithBackend(mux))
+
+			createTestFile(t, local, data, modTime)
+			assert.NoError(t, fs.MkdirAll(mux, remoteDir, 0o755))
+			assert.NoError(t, operation.Copy(local, remote))
+			assert.True(t, operation.Equal(local, remote))
+
+			entries, err := mux.ReadDir("ssh:/host/" + remoteDir)
+			assert.NoError(t, err)
+			assert.Len(t, entries, 1)
+			assert.Equal(t, "data.bin", entries[0].Name())
+
+			createTestFile(t, local, changed, modTime)
+			before := *sent
+			assert.NoError(t, operation.Copy(local, remote))
+			assert.Less(t, *sent-before, int64(len(data)/10))
+			assert.True(t, operation.Equal(local, remote))
+
+			patched, err := os.ReadFile(remoteDir + "/data.bin")
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(changed, patched))
+
+			_, err = mux.Stat("ssh:/host/" + remoteDir + "/missing")
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	t.Parallel()
+
+	location, err := ParseLocation("ssh://backup@host:2222/data")
+	assert.NoError(t, err)
+	assert.Equal(t, fs.Location{User: "backup", Host: "host", Port: "2222", Path: "/data"}, location)
+
+	location, err = ParseLocation("ssh://host")
+	assert.NoError(t, err)
+	assert.Equal(t, fs.Location{Host: "host", Path: "."}, location)
+
+	for _, rootPath := range []string{"ssh:///data", "sftp://host/data", "ssh://-oProxyCommand=sh/data", "ssh://-F@host/data"} {
+		_, err := ParseLocation(rootPath)
+		assert.ErrorIs(t, err, fs.ErrInvalidLocation, rootPath)
+	}
+}
+
+func createTestFile(t *testing.T, name string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	assert.NoError(t, os.WriteFile(name, data, 0o640))
+	assert.NoError(t, os.Chtimes(name, modTime, modTime))
+}
+
+// newPipeAgent serves the working directory by an agent connected over a pipe pair
+// and counts the bytes sent to it.
+func newPipeAgent(t *testing.T, compress bool) (*Client, *int64) {
+	t.Helper()
+
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+	served := make(chan error, 1)
+
+	go func() {
+		served <- NewServer(fs.LocalBackend{}).Serve(requestReader, responseWriter)
+		responseWriter.Close()
+	}()
+
+	sent := new(int64)
+	client, err := NewClient(responseReader, &countingWriter{WriteCloser: requestWriter, count: sent}, compress)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		assert.NoError(t, client.Close())
+		assert.NoError(t, <-served)
+	})
+
+	return client, sent
+}
+
+type countingWriter struct {
+	io.WriteCloser
+	count *int64
+}
+
+func (c *countingWriter) Write(data []byte) (int, error) {
+	*c.count += int64(len(data))
+
+	return c.WriteCloser.Write(data)
+}
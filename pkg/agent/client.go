@@ -0,0 +1,412 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"dtsync/pkg/fs"
+)
+
+const (
+	// Scheme is the URL scheme of locations served by an agent started over ssh.
+	Scheme = "ssh"
+	// DefaultCommand starts the agent on the remote host.
+	DefaultCommand = "dtsync agent"
+	maxBatchOps    = 1024
+)
+
+// Config configures how the agent is started.
+type Config struct {
+	// Command starts the agent on the remote host, defaults to DefaultCommand.
+	Command string
+	// KeyFile is passed to ssh as identity file.
+	KeyFile string
+	// KnownHostsFile is passed to ssh as user known hosts file.
+	KnownHostsFile string
+	// Compress enables zstd compression on the wire.
+	Compress bool
+}
+
+// IsLocation checks if a root path is an agent URL.
+func IsLocation(rootPath string) bool {
+	return strings.HasPrefix(rootPath, Scheme+"://")
+}
+
+// ParseLocation parses a `ssh://user@host:port/path` URL, user and port are left to ssh when missing.
+// Users and hosts starting with a dash are refused, as ssh would read them as options.
+func ParseLocation(rootPath string) (fs.Location, error) {
+	parsed, err := url.Parse(rootPath)
+	if err != nil {
+		return fs.Location{}, fmt.Errorf("%w: %w", fs.ErrInvalidLocation, err)
+	} else if parsed.Scheme != Scheme || parsed.Hostname() == "" ||
+		strings.HasPrefix(parsed.Hostname(), "-") || strings.HasPrefix(parsed.User.Username(), "-") {
+		return fs.Location{}, fmt.Errorf("%w: %s", fs.ErrInvalidLocation, rootPath)
+	}
+
+	location := fs.Location{User: parsed.User.Username(), Host: parsed.Hostname(), Port: parsed.Port(), Path: parsed.Path}
+	if location.Path == "" {
+		location.Path = "."
+	}
+
+	return location, nil
+}
+
+// Client is the sending side of the agent, it implements fs.Backend and fs.Patcher on the remote file system.
+// Requests are sent one after another, so it can be shared by the scanners.
+type Client struct {
+	lock   sync.Mutex
+	conn   *conn
+	writer io.Closer
+	wait   func() error
+}
+
+// Dial starts the agent with `ssh host command` and connects to its stdin and stdout.
+func Dial(location fs.Location, config Config) (*Client, error) {
+	command := config.Command
+	if command == "" {
+		command = DefaultCommand
+	}
+
+	var arguments []string
+
+	if location.Port != "" {
+		arguments = append(arguments, "-p", location.Port)
+	}
+
+	if config.KeyFile != "" {
+		arguments = append(arguments, "-i", config.KeyFile)
+	}
+
+	if config.KnownHostsFile != "" {
+		arguments = append(arguments, "-o", "UserKnownHostsFile="+config.KnownHostsFile)
+	}
+
+	host := location.Host
+	if location.User != "" {
+		host = location.User + "@" + host
+	}
+
+	// the host ends the options, even when the location was not parsed
+	cmd := exec.Command("ssh", append(arguments, "--", host, command)...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(stdout, stdin, config.Compress)
+	if err != nil {
+		stdin.Close()
+		cmd.Wait()
+
+		return nil, err
+	}
+
+	client.wait = cmd.Wait
+
+	return client, nil
+}
+
+// NewClient connects to an agent reading its responses from reader and writing the requests into writer.
+func NewClient(reader io.Reader, writer io.WriteCloser, compress bool) (*Client, error) {
+	client := &Client{conn: newConn(reader, writer), writer: writer}
+	client.conn.compress = compress
+
+	resp, err := client.call(request{Op: opHello, Version: protocolVersion, Compress: compress})
+	if err != nil {
+		client.conn.close()
+
+		return nil, err
+	} else if resp.Version != protocolVersion {
+		client.conn.close()
+
+		return nil, fmt.Errorf("%w: %d instead of %d", ErrVersionMismatch, resp.Version, protocolVersion)
+	}
+
+	return client, nil
+}
+
+// Close ends the session, which stops the agent.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	defer c.conn.close()
+
+	err := c.writer.Close()
+	if c.wait != nil {
+		if waitErr := c.wait(); err == nil {
+			err = waitErr
+		}
+	}
+
+	return err
+}
+
+// Stat returns the state of a file or directory, following symlinks.
+func (c *Client) Stat(name string) (iofs.FileInfo, error) {
+	resp, err := c.call(request{Op: opStat, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return *resp.Info, nil
+}
+
+// Lstat returns the state of a file or directory without following symlinks.
+func (c *Client) Lstat(name string) (iofs.FileInfo, error) {
+	resp, err := c.call(request{Op: opLstat, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return *resp.Info, nil
+}
+
+// ReadDir returns the entries of a directory sorted by name.
+func (c *Client) ReadDir(name string) ([]iofs.DirEntry, error) {
+	resp, err := c.call(request{Op: opReadDir, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]iofs.DirEntry, 0, len(resp.Entries))
+	for _, state := range resp.Entries {
+		entries = append(entries, iofs.FileInfoToDirEntry(state))
+	}
+
+	return entries, nil
+}
+
+// Open opens a file for reading.
+func (c *Client) Open(name string) (io.ReadCloser, error) {
+	resp, err := c.call(request{Op: opOpen, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteReader{client: c, handle: resp.Handle}, nil
+}
+
+// Create creates or truncates a file for writing.
+func (c *Client) Create(name string, perm iofs.FileMode) (io.WriteCloser, error) {
+	resp, err := c.call(request{Op: opCreate, Name: name, Mode: perm})
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteWriter{client: c, handle: resp.Handle}, nil
+}
+
+// Mkdir creates a directory.
+func (c *Client) Mkdir(name string, perm iofs.FileMode) error {
+	_, err := c.call(request{Op: opMkdir, Name: name, Mode: perm})
+
+	return err
+}
+
+// RemoveAll removes a file or directory (recursively).
+func (c *Client) RemoveAll(name string) error {
+	_, err := c.call(request{Op: opRemoveAll, Name: name})
+
+	return err
+}
+
+// Rename renames a file or directory, replacing an existing file.
+func (c *Client) Rename(oldName, newName string) error {
+	_, err := c.call(request{Op: opRename, Name: oldName, NewName: newName})
+
+	return err
+}
+
+// Chmod changes the mode.
+func (c *Client) Chmod(name string, mode iofs.FileMode) error {
+	_, err := c.call(request{Op: opChmod, Name: name, Mode: mode})
+
+	return err
+}
+
+// Chtimes changes the access and modify time.
+func (c *Client) Chtimes(name string, atime, mtime time.Time) error {
+	_, err := c.call(request{Op: opChtimes, Name: name, Atime: atime, Mtime: mtime})
+
+	return err
+}
+
+// Patch replaces the content of the existing file with the source,
+// sending only the blocks not found in the signature of the remote file.
+func (c *Client) Patch(name string, source io.Reader, perm iofs.FileMode) error {
+	resp, err := c.call(request{Op: opSignature, Name: name})
+	if err != nil {
+		return err
+	}
+
+	if resp.Signature == nil || resp.Signature.BlockSize <= 0 {
+		return fmt.Errorf("%w: invalid signature", ErrProtocol)
+	}
+
+	signature := *resp.Signature
+
+	if resp, err = c.call(request{Op: opPatch, Name: name, Mode: perm}); err != nil {
+		return err
+	}
+
+	var (
+		handle  = resp.Handle
+		hash    = sha256.New()
+		ops     []Op
+		pending int
+	)
+
+	send := func() error {
+		_, err := c.call(request{Op: opDelta, Handle: handle, Ops: ops})
+		ops, pending = nil, 0
+
+		return err
+	}
+
+	err = Delta(signature, io.TeeReader(source, hash), func(op Op) error {
+		ops = append(ops, op)
+		if pending += len(op.Data); pending >= transferSize || len(ops) >= maxBatchOps {
+			return send()
+		}
+
+		return nil
+	})
+	if err == nil && len(ops) > 0 {
+		err = send()
+	}
+
+	if err != nil {
+		c.call(request{Op: opClose, Handle: handle})
+
+		return err
+	}
+
+	_, err = c.call(request{Op: opClose, Handle: handle, Sum: hash.Sum(nil)})
+
+	return err
+}
+
+func (c *Client) call(req request) (response, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.conn.send(&req); err != nil {
+		return response{}, err
+	}
+
+	var resp response
+	if err := c.conn.receive(&resp); err != nil {
+		return response{}, err
+	} else if resp.Error != nil {
+		return response{}, resp.Error.err()
+	}
+
+	return resp, nil
+}
+
+// remoteReader reads a remote file in chunks of the transfer size.
+type remoteReader struct {
+	client *Client
+	handle uint64
+	buffer []byte
+	eof    bool
+	closed bool
+}
+
+func (r *remoteReader) Read(data []byte) (int, error) {
+	for len(r.buffer) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		resp, err := r.client.call(request{Op: opRead, Handle: r.handle})
+		if err != nil {
+			return 0, err
+		}
+
+		r.buffer, r.eof = resp.Data, resp.EOF
+	}
+
+	n := copy(data, r.buffer)
+	r.buffer = r.buffer[n:]
+
+	return n, nil
+}
+
+func (r *remoteReader) Close() error {
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	_, err := r.client.call(request{Op: opClose, Handle: r.handle})
+
+	return err
+}
+
+// remoteWriter collects the written data and sends it in chunks of the transfer size.
+type remoteWriter struct {
+	client *Client
+	handle uint64
+	buffer []byte
+	closed bool
+}
+
+func (w *remoteWriter) Write(data []byte) (int, error) {
+	w.buffer = append(w.buffer, data...)
+
+	if len(w.buffer) >= transferSize {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+func (w *remoteWriter) flush() error {
+	_, err := w.client.call(request{Op: opWrite, Handle: w.handle, Data: w.buffer})
+	w.buffer = nil
+
+	return err
+}
+
+func (w *remoteWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	if len(w.buffer) > 0 {
+		if err := w.flush(); err != nil {
+			w.client.call(request{Op: opClose, Handle: w.handle})
+
+			return err
+		}
+	}
+
+	_, err := w.client.call(request{Op: opClose, Handle: w.handle})
+
+	return err
+}
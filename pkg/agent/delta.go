@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	minBlockSize = 700
+	maxBlockSize = 128 * 1024
+	strongSize   = 16
+	maxLiteral   = 64 * 1024
+)
+
+// BlockSum is the weak rolling and the strong checksum of a block.
+type BlockSum struct {
+	Weak   uint32
+	Strong [strongSize]byte
+}
+
+// Signature lists the checksums of the blocks of a file, the last block can be shorter.
+type Signature struct {
+	Size      int64
+	BlockSize int
+	Blocks    []BlockSum
+}
+
+// Op is an instruction of a delta, either copy a block of the old file or insert the literal data.
+type Op struct {
+	Block int64
+	Data  []byte
+}
+
+// BlockSize returns the block size for a file of the given size, like rsync the square root of the size.
+func BlockSize(size int64) int {
+	blockSize := int(math.Sqrt(float64(size))) &^ 7
+
+	return min(max(blockSize, minBlockSize), maxBlockSize)
+}
+
+// NewSignature reads the file and calculates the checksums of its blocks.
+func NewSignature(reader io.Reader, blockSize int) (Signature, error) {
+	signature := Signature{BlockSize: blockSize}
+	block := make([]byte, blockSize)
+
+	for {
+		n, err := io.ReadFull(reader, block)
+		if n > 0 {
+			signature.Size += int64(n)
+			signature.Blocks = append(signature.Blocks, BlockSum{
+				Weak: newRollingSum(block[:n]).value(), Strong: strongSum(block[:n]),
+			})
+		}
+
+		switch {
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			return signature, nil
+		case err != nil:
+			return Signature{}, err
+		}
+	}
+}
+
+// Delta compares the source with the signature of the old file and emits the ops building the source from it.
+// Consecutive literal data is merged into one op.
+func Delta(signature Signature, source io.Reader, emit func(Op) error) error {
+	var (
+		index   = signature.index()
+		reader  = bufio.NewReaderSize(source, maxLiteral)
+		window  = make([]byte, 0, signature.BlockSize)
+		literal []byte
+	)
+
+	flush := func() error {
+		if len(literal) == 0 {
+			return nil
+		}
+
+		data := literal
+		literal = nil
+
+		return emit(Op{Block: -1, Data: data})
+	}
+
+	fill := func() error {
+		for len(window) < signature.BlockSize {
+			char, err := reader.ReadByte()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			window = append(window, char)
+		}
+
+		return nil
+	}
+
+	if err := fill(); err != nil {
+		return err
+	}
+
+	sum := newRollingSum(window)
+
+	for len(window) > 0 {
+		if block, ok := signature.match(index, sum.value(), window); ok {
+			if err := flush(); err != nil {
+				return err
+			} else if err := emit(Op{Block: block}); err != nil {
+				return err
+			}
+
+			window = window[:0]
+			if err := fill(); err != nil {
+				return err
+			}
+
+			sum = newRollingSum(window)
+
+			continue
+		}
+
+		out := window[0]
+		if literal = append(literal, out); len(literal) >= maxLiteral {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		in, err := reader.ReadByte()
+		switch {
+		case errors.Is(err, io.EOF):
+			window = window[1:]
+			sum.rollOut(out)
+		case err != nil:
+			return err
+		default:
+			window = append(window[1:], in)
+			sum.rollOut(out)
+			sum.rollIn(in)
+		}
+	}
+
+	return flush()
+}
+
+// Apply writes the data of the op into the target, reading copied blocks from the old file.
+func Apply(old io.ReaderAt, blockSize int, op Op, target io.Writer) error {
+	if op.Data != nil {
+		_, err := target.Write(op.Data)
+
+		return err
+	}
+
+	block := make([]byte, blockSize)
+
+	n, err := old.ReadAt(block, op.Block*int64(blockSize))
+	if err != nil && !(errors.Is(err, io.EOF) && n > 0) {
+		return err
+	}
+
+	_, err = target.Write(block[:n])
+
+	return err
+}
+
+func (s Signature) index() map[uint32][]int64 {
+	index := make(map[uint32][]int64, len(s.Blocks))
+
+	for i, block := range s.Blocks {
+		index[block.Weak] = append(index[block.Weak], int64(i))
+	}
+
+	return index
+}
+
+// match finds the block with the same length, weak and strong checksum as the window.
+func (s Signature) match(index map[uint32][]int64, weak uint32, window []byte) (int64, bool) {
+	candidates, ok := index[weak]
+	if !ok {
+		return 0, false
+	}
+
+	strong := strongSum(window)
+
+	for _, block := range candidates {
+		if s.blockLength(block) == len(window) && s.Blocks[block].Strong == strong {
+			return block, true
+		}
+	}
+
+	return 0, false
+}
+
+func (s Signature) blockLength(block int64) int {
+	return int(min(int64(s.BlockSize), s.Size-block*int64(s.BlockSize)))
+}
+
+func strongSum(data []byte) [strongSize]byte {
+	var strong [strongSize]byte
+
+	sum := sha256.Sum256(data)
+	copy(strong[:], sum[:])
+
+	return strong
+}
+
+// rollingSum is the rsync weak checksum, which can be moved over the data byte by byte.
+type rollingSum struct {
+	a, b, n uint32
+}
+
+func newRollingSum(data []byte) rollingSum {
+	sum := rollingSum{n: uint32(len(data))}
+
+	for i, char := range data {
+		sum.a += uint32(char)
+		sum.b += (sum.n - uint32(i)) * uint32(char)
+	}
+
+	return sum
+}
+
+func (r *rollingSum) rollOut(out byte) {
+	r.a -= uint32(out)
+	r.b -= r.n * uint32(out)
+	r.n--
+}
+
+func (r *rollingSum) rollIn(in byte) {
+	r.a += uint32(in)
+	r.b += r.a
+	r.n++
+}
+
+func (r rollingSum) value() uint32 {
+	return r.a&0xffff | r.b<<16
+}
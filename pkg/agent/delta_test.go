@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelta(t *testing.T) {
+	t.Parallel()
+
+	random := rand.New(rand.NewSource(1)) //nolint:gosec
+	old := make([]byte, 200*1024)
+	random.Read(old)
+
+	changed := append([]byte("inserted at the start"), old...)
+	changed = append(changed[:50000], changed[50100:]...)
+	copy(changed[100000:], "overwritten in the middle")
+	changed = append(changed, "appended at the end"...)
+
+	tests := []struct {
+		name       string
+		old        []byte
+		new        []byte
+		maxLiteral int
+	}{
+		{name: "Unchanged", old: old, new: old, maxLiteral: 0},
+		{name: "Changed", old: old, new: changed, maxLiteral: 4 * BlockSize(int64(len(old)))},
+		{name: "EmptyOld", old: nil, new: changed, maxLiteral: len(changed)},
+		{name: "EmptyNew", old: old, new: []byte{}, maxLiteral: 0},
+		{name: "ShortTail", old: old[:1000], new: old[:1000], maxLiteral: 0},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			blockSize := BlockSize(int64(len(test.old)))
+			signature, err := NewSignature(bytes.NewReader(test.old), blockSize)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(test.old)), signature.Size)
+
+			var (
+				rebuilt bytes.Buffer
+				literal int
+			)
+
+			err = Delta(signature, bytes.NewReader(test.new), func(op Op) error {
+				literal += len(op.Data)
+
+				return Apply(bytes.NewReader(test.old), blockSize, op, &rebuilt)
+			})
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(test.new, rebuilt.Bytes()))
+			assert.LessOrEqual(t, literal, test.maxLiteral)
+		})
+	}
+}
+
+func TestRollingSum(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	sum := newRollingSum(data[:8])
+
+	for i := 8; i < len(data); i++ {
+		sum.rollOut(data[i-8])
+		sum.rollIn(data[i])
+		assert.Equal(t, newRollingSum(data[i-7:i+1]).value(), sum.value())
+	}
+
+	sum.rollOut(data[len(data)-8])
+	assert.Equal(t, newRollingSum(data[len(data)-7:]).value(), sum.value())
+}
@@ -0,0 +1,264 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// protocolVersion is increased on incompatible changes of the messages.
+const protocolVersion = 1
+
+const (
+	frameHeaderSize = 5
+	maxFrameSize    = 64 * 1024 * 1024
+	minCompressSize = 256
+	flagZstd        = 1
+	transferSize    = 256 * 1024
+)
+
+var (
+	// ErrProtocol is returned for malformed frames and unexpected messages.
+	ErrProtocol = errors.New("agent protocol error")
+	// ErrVersionMismatch is returned when the agent speaks another protocol version.
+	ErrVersionMismatch = errors.New("agent protocol version mismatch")
+	// ErrInvalidHandle is returned for unknown or already closed handles.
+	ErrInvalidHandle = errors.New("invalid handle")
+	// ErrChecksumMismatch is returned when the patched file differs from the source.
+	ErrChecksumMismatch = errors.New("checksum mismatch after patch")
+)
+
+type op uint8
+
+const (
+	opHello op = iota + 1
+	opStat
+	opLstat
+	opReadDir
+	opOpen
+	opRead
+	opCreate
+	opWrite
+	opClose
+	opMkdir
+	opRemoveAll
+	opRename
+	opChmod
+	opChtimes
+	opSignature
+	opPatch
+	opDelta
+)
+
+// request is the message sent by the client, only the fields of the op are set.
+type request struct {
+	Op       op
+	Version  int
+	Compress bool
+	Name     string
+	NewName  string
+	Mode     iofs.FileMode
+	Atime    time.Time
+	Mtime    time.Time
+	Handle   uint64
+	Data     []byte
+	Ops      []Op
+	Sum      []byte
+}
+
+// response is the message sent by the agent for each request.
+type response struct {
+	Version   int
+	Error     *remoteError
+	Info      *fileInfo
+	Entries   []fileInfo
+	Handle    uint64
+	Data      []byte
+	EOF       bool
+	Signature *Signature
+}
+
+// remoteError transfers an error, keeping the kind of the well known file system errors.
+type remoteError struct {
+	Op      string
+	Path    string
+	Kind    int
+	Message string
+}
+
+// errorKinds are the errors which are recreated on the client, indexed by the transferred kind.
+var errorKinds = []error{
+	iofs.ErrNotExist, iofs.ErrExist, iofs.ErrPermission, iofs.ErrInvalid, ErrInvalidHandle, ErrChecksumMismatch,
+}
+
+func newRemoteError(err error) *remoteError {
+	remote := &remoteError{Kind: -1, Message: err.Error()}
+
+	var pathErr *iofs.PathError
+	if errors.As(err, &pathErr) {
+		remote.Op, remote.Path, remote.Message = pathErr.Op, pathErr.Path, pathErr.Err.Error()
+	}
+
+	for kind, target := range errorKinds {
+		if errors.Is(err, target) {
+			remote.Kind = kind
+
+			break
+		}
+	}
+
+	return remote
+}
+
+func (r *remoteError) err() error {
+	err := errors.New(r.Message)
+	if r.Kind >= 0 && r.Kind < len(errorKinds) {
+		err = errorKinds[r.Kind]
+	}
+
+	if r.Path != "" {
+		return &iofs.PathError{Op: r.Op, Path: r.Path, Err: err}
+	}
+
+	return err
+}
+
+// fileInfo transfers the state of a file.
+type fileInfo struct {
+	FileName    string
+	FileSize    int64
+	FileMode    iofs.FileMode
+	FileModTime time.Time
+}
+
+func newFileInfo(info iofs.FileInfo) fileInfo {
+	return fileInfo{FileName: info.Name(), FileSize: info.Size(), FileMode: info.Mode(), FileModTime: info.ModTime()}
+}
+
+func (f fileInfo) Name() string        { return f.FileName }
+func (f fileInfo) Size() int64         { return f.FileSize }
+func (f fileInfo) Mode() iofs.FileMode { return f.FileMode }
+func (f fileInfo) ModTime() time.Time  { return f.FileModTime }
+func (f fileInfo) IsDir() bool         { return f.FileMode.IsDir() }
+func (f fileInfo) Sys() any            { return nil }
+
+// conn sends and receives messages as frames of a flag byte, the big endian payload length
+// and the gob encoded payload, which is zstd compressed when the flag is set.
+// The gob streams span all frames, so the types are only described once.
+type conn struct {
+	reader   io.Reader
+	writer   io.Writer
+	encoded  bytes.Buffer
+	decoded  bytes.Buffer
+	encoder  *gob.Encoder
+	decoder  *gob.Decoder
+	compress bool
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+}
+
+func newConn(reader io.Reader, writer io.Writer) *conn {
+	c := &conn{reader: reader, writer: writer}
+	c.encoder = gob.NewEncoder(&c.encoded)
+	c.decoder = gob.NewDecoder(&c.decoded)
+
+	return c
+}
+
+func (c *conn) send(message any) error {
+	c.encoded.Reset()
+
+	if err := c.encoder.Encode(message); err != nil {
+		return err
+	}
+
+	var (
+		payload = c.encoded.Bytes()
+		flags   byte
+	)
+
+	if c.compress && len(payload) >= minCompressSize {
+		if c.zstdEnc == nil {
+			encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return err
+			}
+
+			c.zstdEnc = encoder
+		}
+
+		if compressed := c.zstdEnc.EncodeAll(payload, nil); len(compressed) < len(payload) {
+			payload, flags = compressed, flagZstd
+		}
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+
+	_, err := c.writer.Write(append(frame, payload...))
+
+	return err
+}
+
+// receive returns io.EOF when the stream ended between frames.
+func (c *conn) receive(message any) error {
+	var header [frameHeaderSize]byte
+
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize || header[0]&^flagZstd != 0 {
+		return fmt.Errorf("%w: invalid frame header %x", ErrProtocol, header)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+
+	if header[0]&flagZstd != 0 {
+		if c.zstdDec == nil {
+			decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxFrameSize))
+			if err != nil {
+				return err
+			}
+
+			c.zstdDec = decoder
+		}
+
+		decompressed, err := c.zstdDec.DecodeAll(payload, nil)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrProtocol, err)
+		}
+
+		payload = decompressed
+	}
+
+	c.decoded.Write(payload)
+
+	if err := c.decoder.Decode(message); err != nil {
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+
+	return nil
+}
+
+func (c *conn) close() {
+	if c.zstdEnc != nil {
+		c.zstdEnc.Close()
+	}
+
+	if c.zstdDec != nil {
+		c.zstdDec.Close()
+	}
+}
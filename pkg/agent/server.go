@@ -0,0 +1,295 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	iofs "io/fs"
+	"path"
+
+	"dtsync/pkg/fs"
+)
+
+// Server is the receiving side of the agent, serving the requests of one client on its backend.
+type Server struct {
+	backend fs.Backend
+	handles map[uint64]any
+	next    uint64
+}
+
+// patchState is the handle of a file being rebuilt from a delta into a temporary file.
+type patchState struct {
+	name      string
+	tempName  string
+	perm      iofs.FileMode
+	blockSize int
+	old       io.ReadCloser
+	oldAt     io.ReaderAt
+	temp      io.WriteCloser
+	hash      hash.Hash
+}
+
+// NewServer creates a server on the backend.
+func NewServer(backend fs.Backend) *Server {
+	return &Server{backend: backend, handles: map[uint64]any{}}
+}
+
+// Serve answers the requests read from the reader until it is closed.
+func (s *Server) Serve(reader io.Reader, writer io.Writer) error {
+	conn := newConn(reader, writer)
+
+	defer conn.close()
+	defer s.closeHandles()
+
+	for {
+		var req request
+
+		if err := conn.receive(&req); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if req.Op == opHello {
+			conn.compress = req.Compress
+		}
+
+		resp, err := s.handle(req)
+		if err != nil {
+			resp = response{Error: newRemoteError(err)}
+		}
+
+		if err := conn.send(&resp); err != nil {
+			return err
+		}
+	}
+}
+
+//nolint:cyclop
+func (s *Server) handle(req request) (response, error) {
+	switch req.Op {
+	case opHello:
+		return response{Version: protocolVersion}, nil
+	case opStat, opLstat:
+		stat := s.backend.Stat
+		if req.Op == opLstat {
+			stat = s.backend.Lstat
+		}
+
+		info, err := stat(req.Name)
+		if err != nil {
+			return response{}, err
+		}
+
+		state := newFileInfo(info)
+
+		return response{Info: &state}, nil
+	case opReadDir:
+		return s.readDir(req.Name)
+	case opOpen:
+		file, err := s.backend.Open(req.Name)
+		if err != nil {
+			return response{}, err
+		}
+
+		return response{Handle: s.add(file)}, nil
+	case opRead:
+		return s.read(req.Handle)
+	case opCreate:
+		file, err := s.backend.Create(req.Name, req.Mode)
+		if err != nil {
+			return response{}, err
+		}
+
+		return response{Handle: s.add(file)}, nil
+	case opWrite:
+		file, ok := s.handles[req.Handle].(io.WriteCloser)
+		if !ok {
+			return response{}, ErrInvalidHandle
+		}
+
+		_, err := file.Write(req.Data)
+
+		return response{}, err
+	case opClose:
+		return response{}, s.close(req.Handle, req.Sum)
+	case opMkdir:
+		return response{}, s.backend.Mkdir(req.Name, req.Mode)
+	case opRemoveAll:
+		return response{}, s.backend.RemoveAll(req.Name)
+	case opRename:
+		return response{}, s.backend.Rename(req.Name, req.NewName)
+	case opChmod:
+		return response{}, s.backend.Chmod(req.Name, req.Mode)
+	case opChtimes:
+		return response{}, s.backend.Chtimes(req.Name, req.Atime, req.Mtime)
+	case opSignature:
+		return s.signature(req.Name)
+	case opPatch:
+		return s.patch(req.Name, req.Mode)
+	case opDelta:
+		return response{}, s.delta(req.Handle, req.Ops)
+	}
+
+	return response{}, fmt.Errorf("%w: unknown op %d", ErrProtocol, req.Op)
+}
+
+func (s *Server) readDir(name string) (response, error) {
+	entries, err := s.backend.ReadDir(name)
+	if err != nil {
+		return response{}, err
+	}
+
+	states := make([]fileInfo, 0, len(entries))
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return response{}, err
+		}
+
+		states = append(states, newFileInfo(info))
+	}
+
+	return response{Entries: states}, nil
+}
+
+func (s *Server) read(handle uint64) (response, error) {
+	file, ok := s.handles[handle].(io.ReadCloser)
+	if !ok {
+		return response{}, ErrInvalidHandle
+	}
+
+	data := make([]byte, transferSize)
+
+	n, err := io.ReadFull(file, data)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return response{Data: data[:n], EOF: true}, nil
+	} else if err != nil {
+		return response{}, err
+	}
+
+	return response{Data: data[:n]}, nil
+}
+
+func (s *Server) signature(name string) (response, error) {
+	info, err := s.backend.Stat(name)
+	if err != nil {
+		return response{}, err
+	}
+
+	file, err := s.backend.Open(name)
+	if err != nil {
+		return response{}, err
+	}
+
+	defer file.Close()
+
+	signature, err := NewSignature(file, BlockSize(info.Size()))
+	if err != nil {
+		return response{}, err
+	}
+
+	return response{Signature: &signature}, nil
+}
+
+// patch opens the old file to copy blocks from and the temporary file next to it to write into.
+func (s *Server) patch(name string, perm iofs.FileMode) (response, error) {
+	info, err := s.backend.Stat(name)
+	if err != nil {
+		return response{}, err
+	}
+
+	old, err := s.backend.Open(name)
+	if err != nil {
+		return response{}, err
+	}
+
+	oldAt, ok := old.(io.ReaderAt)
+	if !ok {
+		old.Close()
+
+		return response{}, &iofs.PathError{Op: "patch", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	tempName := path.Join(path.Dir(name), fs.PartialPrefix+path.Base(name))
+
+	temp, err := s.backend.Create(tempName, perm)
+	if err != nil {
+		old.Close()
+
+		return response{}, err
+	}
+
+	return response{Handle: s.add(&patchState{
+		name: name, tempName: tempName, perm: perm, blockSize: BlockSize(info.Size()),
+		old: old, oldAt: oldAt, temp: temp, hash: sha256.New(),
+	})}, nil
+}
+
+func (s *Server) delta(handle uint64, ops []Op) error {
+	state, ok := s.handles[handle].(*patchState)
+	if !ok {
+		return ErrInvalidHandle
+	}
+
+	target := io.MultiWriter(state.temp, state.hash)
+
+	for _, op := range ops {
+		if err := Apply(state.oldAt, state.blockSize, op, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// close closes the handle, a patch is committed when the checksum of the source is given and matches.
+func (s *Server) close(handle uint64, sum []byte) error {
+	file, ok := s.handles[handle]
+	if !ok {
+		return ErrInvalidHandle
+	}
+
+	delete(s.handles, handle)
+
+	state, ok := file.(*patchState)
+	if !ok {
+		return file.(io.Closer).Close() //nolint:forcetypeassert
+	}
+
+	state.old.Close()
+
+	err := state.temp.Close()
+
+	switch {
+	case err != nil:
+	case sum == nil:
+		err = &iofs.PathError{Op: "patch", Path: state.name, Err: iofs.ErrInvalid}
+	case string(sum) != string(state.hash.Sum(nil)):
+		err = &iofs.PathError{Op: "patch", Path: state.name, Err: ErrChecksumMismatch}
+	default:
+		if err = s.backend.Rename(state.tempName, state.name); err == nil {
+			return s.backend.Chmod(state.name, state.perm)
+		}
+	}
+
+	s.backend.RemoveAll(state.tempName)
+
+	return err
+}
+
+func (s *Server) add(file any) uint64 {
+	s.next++
+	s.handles[s.next] = file
+
+	return s.next
+}
+
+func (s *Server) closeHandles() {
+	for handle := range s.handles {
+		s.close(handle, nil)
+	}
+}
@@ -1,8 +1,29 @@
 package args
 
 import (
+	"dtsync/pkg/agent"
+	"dtsync/pkg/repo"
+	"errors"
 	"flag"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrMissingRootPath is returned when -src or -dst is missing.
+	ErrMissingRootPath = errors.New("-src and -dst are required")
+	// ErrInteractiveJob is returned for jobs with -interactive, which run without a terminal.
+	ErrInteractiveJob = errors.New("-interactive is not supported by jobs")
+)
+
+const (
+	// DefaultInPlaceMinSize is the minimum size of files replaced in place with -inplace.
+	DefaultInPlaceMinSize = 64 * 1024 * 1024
+	// DefaultRetries is the number of retries of entries failed with a transient error with -continue-on-error.
+	DefaultRetries = 3
 )
 
 // Arguments is a struct that holds the parsed arguments.
@@ -11,23 +32,304 @@ type Arguments struct {
 	DstRootPath             string
 	ReplaceNotMatchingFiles bool
 	RemoveDstLeftover       bool
+	DeleteMode              string
+	InPlace                 bool
+	InPlaceMinSize          int64
+	Reflink                 string
+	Sparse                  bool
+	Specials                bool
+	Devices                 bool
+	FSProfile               string
+	Normalize               string
+	Collisions              string
+	Restore                 bool
+	KeyFile                 string
+	PassphraseFile          string
+	EncryptNames            bool
+	Compress                string
+	CompressLevel           int
+	SSHKeyFile              string
+	KnownHostsFile          string
+	S3Endpoint              string
+	S3Region                string
+	S3Profile               string
+	AgentCommand            string
+	AgentCompress           bool
+	MetricsListen           string
+	ContinueOnError         bool
+	MaxErrors               int
+	Retries                 int
+	SkipPreflight           bool
+	SkipProbe               bool
+	Precount                bool
+	Output                  string
+	ProgressInterval        time.Duration
+	Interactive             bool
 }
 
 // Parse parses the arguments.
+// With `dtsync restore` the src is an encrypted root that is decrypted into dst, `dtsync sync` is the default.
 func Parse(osArgs []string) Arguments {
+	args, flagSet, err := parse(osArgs, flag.ExitOnError)
+	if err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	return args
+}
+
+// ParseJob parses the arguments of a sync job, which are the command line without the program name.
+// Unlike Parse it returns an error instead of exiting.
+func ParseJob(jobArgs []string) (Arguments, error) {
+	args, _, err := parse(append([]string{"dtsync"}, jobArgs...), flag.ContinueOnError)
+	if err == nil && args.Interactive {
+		return args, ErrInteractiveJob
+	}
+
+	return args, err
+}
+
+func parse(osArgs []string, errorHandling flag.ErrorHandling) (Arguments, *flag.FlagSet, error) {
 	args := Arguments{}
-	flagSet := flag.NewFlagSet(osArgs[0], flag.ExitOnError)
+	flagSet := flag.NewFlagSet(osArgs[0], errorHandling)
+	flagArgs := osArgs[1:]
+	quiet := false
 
-	flagSet.StringVar(&args.SrcRootPath, "src", "", "The source root path (required)")
-	flagSet.StringVar(&args.DstRootPath, "dst", "", "The destination root path (required)")
+	if errorHandling == flag.ContinueOnError {
+		flagSet.SetOutput(io.Discard)
+	}
+
+	switch {
+	case len(flagArgs) > 0 && flagArgs[0] == "restore":
+		args.Restore = true
+		flagArgs = flagArgs[1:]
+	case len(flagArgs) > 0 && flagArgs[0] == "sync":
+		flagArgs = flagArgs[1:]
+	}
+
+	flagSet.StringVar(&args.SrcRootPath, "src", "",
+		"The source root path, sftp://, ssh://user@host:port/path or s3://bucket/prefix (required)")
+	flagSet.StringVar(&args.DstRootPath, "dst", "",
+		"The destination root path, sftp://, ssh://user@host:port/path or s3://bucket/prefix (required)")
 	flagSet.BoolVar(&args.ReplaceNotMatchingFiles, "replace", false, "Replace file on dst when different")
+	flagSet.BoolVar(&args.InPlace, "inplace", false, "Replace only the changed blocks of large files directly in the dst file")
+	flagSet.Int64Var(&args.InPlaceMinSize, "inplace-min-size", 0,
+		"The minimum size in bytes of files replaced in place (default 64 MiB)")
+	flagSet.StringVar(&args.Reflink, "reflink", "",
+		"Clone the copied files on btrfs and XFS: auto, always or never, which also avoids copy_file_range (default auto)")
+	flagSet.BoolVar(&args.Sparse, "sparse", false,
+		"Leave holes in place of the zero blocks of all copied files, the holes of sparse files are always kept")
+	flagSet.BoolVar(&args.Specials, "specials", false, "Recreate named pipes (FIFOs), sockets are always skipped with a warning")
+	flagSet.BoolVar(&args.Devices, "devices", false,
+		"Recreate block and character devices with their major and minor numbers, which usually needs root")
+	flagSet.StringVar(&args.FSProfile, "fs-profile", "",
+		"Override the probed dst file system profile, like time=2s,perms=false,case-sensitive=false")
+	flagSet.StringVar(&args.Normalize, "normalize", "",
+		"Unicode normalization of the dst names, nfc, nfd or none to keep them as they are (default none)")
+	flagSet.StringVar(&args.Collisions, "collisions", "",
+		"What to do with names colliding on dst after normalization or case folding: skip, rename or error (default skip)")
 	flagSet.BoolVar(&args.RemoveDstLeftover, "remove", false, "Remove files and directories in dst not included in src")
+	flagSet.StringVar(&args.DeleteMode, "delete-mode", "",
+		"When -remove deletes: before, during or after the copies if all succeeded, or delay to the end (default during)")
+	flagSet.StringVar(&args.KeyFile, "key-file", "", "Encrypt dst (decrypt src on restore) with a key derived from the file")
+	flagSet.StringVar(&args.PassphraseFile, "passphrase-file", "",
+		"Encrypt dst (decrypt src on restore) with a key derived from the passphrase in the file")
+	flagSet.BoolVar(&args.EncryptNames, "encrypt-names", false, "Encrypt also the file names (on first encrypted sync)")
+	flagSet.StringVar(&args.Compress, "compress", "", "Compress files on dst with zstd or gzip")
+	flagSet.IntVar(&args.CompressLevel, "compress-level", 0, "The compression level (0 is the algorithm default)")
+	flagSet.StringVar(&args.SSHKeyFile, "ssh-key", "", "The private key for sftp:// paths (default ~/.ssh/id_*)")
+	flagSet.StringVar(&args.KnownHostsFile, "known-hosts", "", "The known hosts for sftp:// paths (default ~/.ssh/known_hosts)")
+	flagSet.StringVar(&args.S3Endpoint, "s3-endpoint", "",
+		"The endpoint for s3:// paths, prefix with http:// to disable TLS (default s3.amazonaws.com)")
+	flagSet.StringVar(&args.S3Region, "s3-region", "", "The bucket region for s3:// paths (default looked up)")
+	flagSet.StringVar(&args.S3Profile, "s3-profile", "", "The shared credentials profile for s3:// paths (default AWS_PROFILE)")
+	flagSet.StringVar(&args.AgentCommand, "agent-command", "",
+		"The command starting the agent for ssh:// paths (default \""+agent.DefaultCommand+"\")")
+	flagSet.BoolVar(&args.AgentCompress, "agent-compress", false, "Compress the transfer to the agent of ssh:// paths with zstd")
+	flagSet.StringVar(&args.MetricsListen, "metrics-listen", "",
+		"Serve Prometheus metrics on the address (like :9360) at /metrics during the sync")
+	flagSet.BoolVar(&args.ContinueOnError, "continue-on-error", false,
+		"Record failed entries and continue, retry transient errors and report the failures at the end")
+	flagSet.IntVar(&args.MaxErrors, "max-errors", 0, "Stop when more entries failed with -continue-on-error (0 is unlimited)")
+	flagSet.IntVar(&args.Retries, "retries", DefaultRetries,
+		"The retries with -continue-on-error of entries failed with EAGAIN, EIO, a stale NFS handle or similar")
+	flagSet.BoolVar(&args.SkipPreflight, "skip-preflight", false,
+		"Skip the checks of overlapping roots, dst writability and free space before the sync")
+	flagSet.BoolVar(&args.SkipProbe, "skip-probe", false,
+		"Skip the dst profile probe before the sync, the comparisons assume a fully capable file system")
+	flagSet.BoolVar(&args.Precount, "precount", false,
+		"Count the bytes to copy before the sync for the progress and ETA also with -skip-preflight")
+	flagSet.StringVar(&args.Output, "output", "",
+		"The progress output: tty, plain log lines, json events or quiet (default tty on terminals, plain otherwise)")
+	flagSet.BoolVar(&quiet, "quiet", false, "Print no progress, like -output quiet")
+	flagSet.DurationVar(&args.ProgressInterval, "progress-interval", 0,
+		"The interval of the progress output (default 1s, 10s for plain lines)")
+	flagSet.BoolVar(&args.Interactive, "interactive", false,
+		"Review the planned actions in a terminal UI and execute only the approved ones")
 
-	if err := flagSet.Parse(osArgs[1:]); err != nil ||
-		len(args.SrcRootPath) == 0 || len(args.DstRootPath) == 0 {
+	if err := flagSet.Parse(flagArgs); err != nil {
+		return args, flagSet, err
+	} else if len(args.SrcRootPath) == 0 || len(args.DstRootPath) == 0 {
+		return args, flagSet, ErrMissingRootPath
+	}
+
+	if quiet {
+		args.Output = "quiet"
+	}
+
+	if args.InPlace && args.InPlaceMinSize == 0 {
+		args.InPlaceMinSize = DefaultInPlaceMinSize
+	}
+
+	return args, flagSet, nil
+}
+
+// RepoArguments is a struct that holds the parsed arguments of the repo command.
+type RepoArguments struct {
+	Command      string
+	RepoPath     string
+	SrcRootPath  string
+	DstRootPath  string
+	Snapshot     string
+	KeepLast     int
+	AvgChunkSize int
+}
+
+// ParseRepo parses the arguments of `dtsync repo <init|backup|restore|prune>`.
+func ParseRepo(osArgs []string) RepoArguments {
+	args := RepoArguments{}
+	flagSet := flag.NewFlagSet(osArgs[0]+" repo <init|backup|restore|prune>", flag.ExitOnError)
+
+	flagSet.StringVar(&args.RepoPath, "repo", "", "The repository path (required)")
+	flagSet.StringVar(&args.SrcRootPath, "src", "", "The source root path to backup (required for backup)")
+	flagSet.StringVar(&args.DstRootPath, "dst", "", "The destination root path to restore into (required for restore)")
+	flagSet.StringVar(&args.Snapshot, "snapshot", "latest", "The snapshot to restore")
+	flagSet.IntVar(&args.KeepLast, "keep", 0, "The number of newest snapshots to keep (required for prune)")
+	flagSet.IntVar(&args.AvgChunkSize, "avg-chunk-size", repo.DefaultAvgChunkSize,
+		"The targeted average chunk size in bytes, a power of two (init only)")
+
+	if len(osArgs) < 3 { //nolint:gomnd
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	args.Command = osArgs[2]
+
+	if err := flagSet.Parse(osArgs[3:]); err != nil || len(args.RepoPath) == 0 || !args.valid() {
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
 	return args
 }
+
+// valid checks the command specific requirements.
+func (a RepoArguments) valid() bool {
+	switch a.Command {
+	case "init":
+		return a.AvgChunkSize > 0
+	case "backup":
+		return len(a.SrcRootPath) > 0
+	case "restore":
+		return len(a.DstRootPath) > 0 && len(a.Snapshot) > 0
+	case "prune":
+		return a.KeepLast > 0
+	}
+
+	return false
+}
+
+// DefaultDaemonAddress is the unix socket the daemon listens on and ctl connects to,
+// in the runtime directory of the user.
+var DefaultDaemonAddress = "unix:" + filepath.Join(runtimeDir(), "dtsync.sock")
+
+// TokenEnv is the environment variable with the token of the daemon API, used without -token-file.
+const TokenEnv = "DTSYNC_TOKEN"
+
+// runtimeDir returns XDG_RUNTIME_DIR or a directory of the user in the temporary directory.
+func runtimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+
+	return filepath.Join(os.TempDir(), "dtsync-"+strconv.Itoa(os.Getuid()))
+}
+
+// DaemonArguments is a struct that holds the parsed arguments of the daemon command.
+type DaemonArguments struct {
+	Address       string
+	TokenFile     string
+	StatePath     string
+	JobsPath      string
+	MetricsListen string
+}
+
+// ParseDaemon parses the arguments of `dtsync daemon`.
+func ParseDaemon(osArgs []string) DaemonArguments {
+	args := DaemonArguments{}
+	flagSet := flag.NewFlagSet(osArgs[0]+" daemon", flag.ExitOnError)
+
+	flagSet.StringVar(&args.Address, "listen", DefaultDaemonAddress,
+		"The unix socket (unix:/path) or TCP address (host:port) of the API, only loopback ones without a token")
+	flagSet.StringVar(&args.TokenFile, "token-file", "",
+		"Require the token in the file as bearer token of all API requests (default $"+TokenEnv+")")
+	flagSet.StringVar(&args.StatePath, "state", "", "The file keeping the jobs and run results (default in memory only)")
+	flagSet.StringVar(&args.JobsPath, "jobs", "", "A JSON file of jobs with their schedules to define on start")
+	flagSet.StringVar(&args.MetricsListen, "metrics-listen", "",
+		"Serve Prometheus metrics of all jobs on the address (like :9360) at /metrics")
+
+	if err := flagSet.Parse(osArgs[2:]); err != nil || flagSet.NArg() > 0 {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	return args
+}
+
+// CtlArguments is a struct that holds the parsed arguments of the ctl command.
+type CtlArguments struct {
+	Address   string
+	TokenFile string
+	Command   string
+	Params    []string
+}
+
+// ParseCtl parses the arguments of `dtsync ctl <command> [params]`.
+// The params of `put <name> <sync arguments>` are passed on as they are.
+func ParseCtl(osArgs []string) CtlArguments {
+	args := CtlArguments{}
+	flagSet := flag.NewFlagSet(osArgs[0]+" ctl <jobs|put|remove|start|watch|cancel|runs|status>", flag.ExitOnError)
+
+	flagSet.StringVar(&args.Address, "addr", DefaultDaemonAddress, "The address of the daemon API")
+	flagSet.StringVar(&args.TokenFile, "token-file", "",
+		"Send the token in the file as bearer token to the daemon API (default $"+TokenEnv+")")
+
+	if err := flagSet.Parse(osArgs[2:]); err != nil || flagSet.NArg() == 0 {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	args.Command, args.Params = flagSet.Arg(0), flagSet.Args()[1:]
+
+	if !args.valid() {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	return args
+}
+
+// valid checks the number of params of the command.
+func (a CtlArguments) valid() bool {
+	switch a.Command {
+	case "jobs", "runs":
+		return len(a.Params) == 0
+	case "remove", "start", "watch", "cancel", "status":
+		return len(a.Params) == 1
+	case "put":
+		return len(a.Params) > 1
+	}
+
+	return false
+}
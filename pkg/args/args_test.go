@@ -2,6 +2,7 @@ package args
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,6 +19,7 @@ func TestParse(t *testing.T) {
 			DstRootPath:             "dst",
 			ReplaceNotMatchingFiles: false,
 			RemoveDstLeftover:       false,
+			Retries:                 DefaultRetries,
 		}, arguments)
 	})
 
@@ -30,6 +32,7 @@ func TestParse(t *testing.T) {
 			DstRootPath:             "dst",
 			ReplaceNotMatchingFiles: true,
 			RemoveDstLeftover:       false,
+			Retries:                 DefaultRetries,
 		}, arguments)
 	})
 
@@ -42,6 +45,7 @@ func TestParse(t *testing.T) {
 			DstRootPath:             "dst",
 			ReplaceNotMatchingFiles: false,
 			RemoveDstLeftover:       true,
+			Retries:                 DefaultRetries,
 		}, arguments)
 	})
 
@@ -54,6 +58,213 @@ func TestParse(t *testing.T) {
 			DstRootPath:             "dst",
 			ReplaceNotMatchingFiles: true,
 			RemoveDstLeftover:       true,
+			Retries:                 DefaultRetries,
 		}, arguments)
 	})
+
+	t.Run("DeleteMode", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{"dtsync", "-src", "src", "-dst", "dst", "-remove", "-delete-mode", "after"})
+		assert.Equal(t, Arguments{
+			SrcRootPath:       "src",
+			DstRootPath:       "dst",
+			RemoveDstLeftover: true,
+			DeleteMode:        "after",
+			Retries:           DefaultRetries,
+		}, arguments)
+	})
+
+	t.Run("Normalize", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{"dtsync", "-src", "src", "-dst", "dst", "-normalize", "nfc", "-collisions", "rename"})
+		assert.Equal(t, Arguments{
+			SrcRootPath: "src",
+			DstRootPath: "dst",
+			Normalize:   "nfc",
+			Collisions:  "rename",
+			Retries:     DefaultRetries,
+		}, arguments)
+	})
+
+	t.Run("InPlace", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{"dtsync", "-src", "src", "-dst", "dst", "-replace", "-inplace"})
+		assert.Equal(t, Arguments{
+			SrcRootPath:             "src",
+			DstRootPath:             "dst",
+			ReplaceNotMatchingFiles: true,
+			InPlace:                 true,
+			InPlaceMinSize:          DefaultInPlaceMinSize,
+			Retries:                 DefaultRetries,
+		}, arguments)
+	})
+
+	t.Run("ContinueOnError", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{
+			"dtsync", "-src", "src", "-dst", "dst", "-continue-on-error", "-max-errors", "10", "-retries", "0",
+		})
+		assert.Equal(t, Arguments{
+			SrcRootPath:     "src",
+			DstRootPath:     "dst",
+			ContinueOnError: true,
+			MaxErrors:       10,
+		}, arguments)
+	})
+
+	t.Run("Output", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{"dtsync", "-src", "src", "-dst", "dst", "-output", "json", "-progress-interval", "5s"})
+		assert.Equal(t, Arguments{
+			SrcRootPath:      "src",
+			DstRootPath:      "dst",
+			Retries:          DefaultRetries,
+			Output:           "json",
+			ProgressInterval: 5 * time.Second,
+		}, arguments)
+	})
+
+	t.Run("Interactive", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{"dtsync", "sync", "-src", "src", "-dst", "dst", "-interactive"})
+		assert.Equal(t, Arguments{
+			SrcRootPath: "src",
+			DstRootPath: "dst",
+			Retries:     DefaultRetries,
+			Interactive: true,
+		}, arguments)
+	})
+
+	t.Run("CopyMethod", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{"dtsync", "-src", "src", "-dst", "dst", "-reflink", "always", "-sparse"})
+		assert.Equal(t, Arguments{
+			SrcRootPath: "src",
+			DstRootPath: "dst",
+			Reflink:     "always",
+			Sparse:      true,
+			Retries:     DefaultRetries,
+		}, arguments)
+	})
+
+	t.Run("SpecialsAndProfile", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{
+			"dtsync", "-src", "src", "-dst", "dst", "-specials", "-devices", "-fs-profile", "time=2s",
+		})
+		assert.Equal(t, Arguments{
+			SrcRootPath: "src",
+			DstRootPath: "dst",
+			Specials:    true,
+			Devices:     true,
+			FSProfile:   "time=2s",
+			Retries:     DefaultRetries,
+		}, arguments)
+	})
+
+	t.Run("Quiet", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := Parse([]string{"dtsync", "-src", "src", "-dst", "dst", "-quiet"})
+		assert.Equal(t, Arguments{
+			SrcRootPath: "src",
+			DstRootPath: "dst",
+			Retries:     DefaultRetries,
+			Output:      "quiet",
+		}, arguments)
+	})
+}
+
+func TestParseRestore(t *testing.T) {
+	t.Parallel()
+
+	arguments := Parse([]string{"dtsync", "restore", "-src", "src", "-dst", "dst", "-key-file", "key"})
+	assert.Equal(t, Arguments{
+		SrcRootPath: "src",
+		DstRootPath: "dst",
+		Restore:     true,
+		KeyFile:     "key",
+		Retries:     DefaultRetries,
+	}, arguments)
+}
+
+func TestParseRepo(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Backup", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := ParseRepo([]string{"dtsync", "repo", "backup", "-repo", "repo", "-src", "src"})
+		assert.Equal(t, RepoArguments{
+			Command:      "backup",
+			RepoPath:     "repo",
+			SrcRootPath:  "src",
+			Snapshot:     "latest",
+			AvgChunkSize: 1 << 20,
+		}, arguments)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := ParseRepo([]string{"dtsync", "repo", "restore", "-repo", "repo", "-dst", "dst", "-snapshot", "id"})
+		assert.Equal(t, RepoArguments{
+			Command:      "restore",
+			RepoPath:     "repo",
+			DstRootPath:  "dst",
+			Snapshot:     "id",
+			AvgChunkSize: 1 << 20,
+		}, arguments)
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		t.Parallel()
+
+		arguments := ParseRepo([]string{"dtsync", "repo", "prune", "-repo", "repo", "-keep", "3"})
+		assert.Equal(t, RepoArguments{
+			Command:      "prune",
+			RepoPath:     "repo",
+			Snapshot:     "latest",
+			KeepLast:     3,
+			AvgChunkSize: 1 << 20,
+		}, arguments)
+	})
+}
+
+func TestParseJob(t *testing.T) {
+	t.Parallel()
+
+	arguments, err := ParseJob([]string{"-src", "src", "-dst", "dst", "-remove"})
+	assert.NoError(t, err)
+	assert.Equal(t, Arguments{
+		SrcRootPath: "src", DstRootPath: "dst", RemoveDstLeftover: true, Retries: DefaultRetries,
+	}, arguments)
+
+	_, err = ParseJob([]string{"-src", "src"})
+	assert.ErrorIs(t, err, ErrMissingRootPath)
+
+	_, err = ParseJob([]string{"-src", "src", "-dst", "dst", "-unknown"})
+	assert.Error(t, err)
+
+	_, err = ParseJob([]string{"-src", "src", "-dst", "dst", "-interactive"})
+	assert.ErrorIs(t, err, ErrInteractiveJob)
+}
+
+func TestParseCtl(t *testing.T) {
+	t.Parallel()
+
+	arguments := ParseCtl([]string{"dtsync", "ctl", "-addr", "localhost:7070", "put", "home", "-src", "src", "-dst", "dst"})
+	assert.Equal(t, CtlArguments{
+		Address: "localhost:7070",
+		Command: "put",
+		Params:  []string{"home", "-src", "src", "-dst", "dst"},
+	}, arguments)
 }
@@ -0,0 +1,250 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dtsync/pkg/fs"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// ErrUnknownAlgorithm is returned for unsupported compression algorithms.
+	ErrUnknownAlgorithm = errors.New("unknown compression algorithm")
+	// ErrNotCompressed is returned when the root or a file is not compressed by dtsync.
+	ErrNotCompressed = errors.New("not compressed")
+	// ErrAlgorithmMismatch is returned when the root is already compressed with another algorithm.
+	ErrAlgorithmMismatch = errors.New("compressed with another algorithm")
+)
+
+const (
+	// ConfigFileName is the name of the config file in the compressed root.
+	ConfigFileName = fs.ReservedPrefix + "compress.json"
+
+	formatVersion = 1
+	maxHeaderSize = 1 << 16
+	configPerm    = 0o600
+)
+
+// Algorithm is a supported compression algorithm.
+type Algorithm string
+
+const (
+	// Zstd compresses with zstandard into `.zst` files.
+	Zstd Algorithm = "zstd"
+	// Gzip compresses with gzip into `.gz` files.
+	Gzip Algorithm = "gzip"
+)
+
+// magic identifies compressed files.
+var magic = []byte("DTSC") //nolint:gochecknoglobals
+
+// Config is persisted in the compressed root, so that restore knows how to decompress.
+type Config struct {
+	Algorithm Algorithm `json:"algorithm"`
+	Level     int       `json:"level"`
+}
+
+// Compressor compresses file contents and appends the algorithm extension to file names.
+// The original size, modify time and mode are stored in a header in front of the compressed data.
+// It implements fs.Codec.
+type Compressor struct {
+	config    Config
+	extension string
+}
+
+// Setup creates the compressor, the config is written into the compressed root on the first run.
+// A root compressed with another algorithm is refused, the level only applies to the newly written files.
+func Setup(backend fs.Backend, rootPath string, config Config) (*Compressor, error) {
+	compressor, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	configPath := filepath.Join(rootPath, ConfigFileName)
+
+	data, err := fs.ReadFile(backend, configPath)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if data, err = json.MarshalIndent(config, "", "  "); err != nil {
+			return nil, err
+		}
+
+		return compressor, fs.WriteFile(backend, configPath, data, configPerm)
+	case err != nil:
+		return nil, err
+	}
+
+	var existing Config
+	if err := json.Unmarshal(data, &existing); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotCompressed, err)
+	} else if existing.Algorithm != config.Algorithm {
+		return nil, fmt.Errorf("%w: %s instead of %s", ErrAlgorithmMismatch, existing.Algorithm, config.Algorithm)
+	}
+
+	return compressor, nil
+}
+
+// Load creates the compressor from the config of the compressed root.
+func Load(backend fs.Backend, rootPath string) (*Compressor, error) {
+	data, err := fs.ReadFile(backend, filepath.Join(rootPath, ConfigFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotCompressed
+	} else if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotCompressed, err)
+	}
+
+	return New(config)
+}
+
+// New creates a compressor for the given config.
+func New(config Config) (*Compressor, error) {
+	compressor := &Compressor{config: config}
+
+	switch config.Algorithm {
+	case Zstd:
+		compressor.extension = ".zst"
+	case Gzip:
+		compressor.extension = ".gz"
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, config.Algorithm)
+	}
+
+	return compressor, nil
+}
+
+// EncodeName appends the extension to file names.
+func (c *Compressor) EncodeName(name string, isDir bool) (string, error) {
+	if isDir {
+		return name, nil
+	}
+
+	return name + c.extension, nil
+}
+
+// DecodeName strips the extension from file names.
+func (c *Compressor) DecodeName(name string, isDir bool) (string, error) {
+	if isDir {
+		return name, nil
+	} else if !strings.HasSuffix(name, c.extension) {
+		return "", fs.ErrUnknownName
+	}
+
+	return strings.TrimSuffix(name, c.extension), nil
+}
+
+// NewWriter writes the header and returns a compressing writer.
+func (c *Compressor) NewWriter(w io.Writer, header fs.Header) (io.WriteCloser, error) {
+	plainHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	preamble := append(append([]byte{}, magic...), formatVersion)
+	preamble = append(binary.BigEndian.AppendUint32(preamble, uint32(len(plainHeader))), plainHeader...)
+
+	if _, err := w.Write(preamble); err != nil {
+		return nil, err
+	}
+
+	switch c.config.Algorithm {
+	case Zstd:
+		level := zstd.SpeedDefault
+		if c.config.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.config.Level)
+		}
+
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+	case Gzip:
+		level := gzip.DefaultCompression
+		if c.config.Level != 0 {
+			level = c.config.Level
+		}
+
+		return gzip.NewWriterLevel(w, level)
+	}
+
+	return nil, ErrUnknownAlgorithm
+}
+
+// NewReader reads the header and returns a decompressing reader.
+// The decompressor is only created on the first read, so reading the header is cheap.
+func (c *Compressor) NewReader(r io.Reader) (io.ReadCloser, fs.Header, error) {
+	var header fs.Header
+
+	preamble := make([]byte, len(magic)+1+4) //nolint:gomnd
+	if _, err := io.ReadFull(r, preamble); err != nil {
+		return nil, header, fmt.Errorf("%w: %w", ErrNotCompressed, err)
+	}
+
+	if !bytes.Equal(preamble[:len(magic)], magic) || preamble[len(magic)] != formatVersion {
+		return nil, header, ErrNotCompressed
+	}
+
+	headerSize := binary.BigEndian.Uint32(preamble[len(magic)+1:])
+	if headerSize > maxHeaderSize {
+		return nil, header, ErrNotCompressed
+	}
+
+	plainHeader := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, plainHeader); err != nil {
+		return nil, header, fmt.Errorf("%w: %w", ErrNotCompressed, err)
+	}
+
+	return &reader{reader: r, algorithm: c.config.Algorithm}, header, json.Unmarshal(plainHeader, &header)
+}
+
+// reader lazily creates the decompressor.
+type reader struct {
+	reader       io.Reader
+	algorithm    Algorithm
+	decompressor io.ReadCloser
+}
+
+func (r *reader) Read(data []byte) (int, error) {
+	if r.decompressor == nil {
+		switch r.algorithm {
+		case Zstd:
+			decoder, err := zstd.NewReader(r.reader, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return 0, err
+			}
+
+			r.decompressor = decoder.IOReadCloser()
+		case Gzip:
+			decompressor, err := gzip.NewReader(r.reader)
+			if err != nil {
+				return 0, err
+			}
+
+			r.decompressor = decompressor
+		default:
+			return 0, ErrUnknownAlgorithm
+		}
+	}
+
+	return r.decompressor.Read(data)
+}
+
+func (r *reader) Close() error {
+	if r.decompressor == nil {
+		return nil
+	}
+
+	return r.decompressor.Close()
+}
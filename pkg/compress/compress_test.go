@@ -0,0 +1,116 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"dtsync/pkg/fs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressor(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_compressor")
+	})
+	assert.NoError(t, os.Mkdir("test_compressor", 0o755))
+
+	header := fs.Header{Size: 1, ModTime: time.Now().Truncate(time.Second), Mode: 0o644}
+	plain := []byte(strings.Repeat("2024-01-01 INFO something happened\n", 1000))
+
+	for _, algorithm := range []Algorithm{Zstd, Gzip} {
+		algorithm := algorithm
+
+		t.Run(string(algorithm), func(t *testing.T) {
+			t.Parallel()
+
+			compressor, err := New(Config{Algorithm: algorithm, Level: 3})
+			assert.NoError(t, err)
+
+			var buf bytes.Buffer
+
+			writer, err := compressor.NewWriter(&buf, header)
+			assert.NoError(t, err)
+			_, err = writer.Write(plain)
+			assert.NoError(t, err)
+			assert.NoError(t, writer.Close())
+			assert.Less(t, buf.Len(), len(plain)/10)
+
+			reader, decodedHeader, err := compressor.NewReader(bytes.NewReader(buf.Bytes()))
+			assert.NoError(t, err)
+			assert.True(t, header.ModTime.Equal(decodedHeader.ModTime))
+			assert.Equal(t, header.Mode, decodedHeader.Mode)
+
+			decompressed, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.NoError(t, reader.Close())
+			assert.Equal(t, plain, decompressed)
+		})
+	}
+
+	t.Run("Names", func(t *testing.T) {
+		t.Parallel()
+
+		compressor, err := New(Config{Algorithm: Zstd})
+		assert.NoError(t, err)
+
+		name, err := compressor.EncodeName("a/file.log", false)
+		assert.NoError(t, err)
+		assert.Equal(t, "a/file.log.zst", name)
+
+		name, err = compressor.EncodeName("a", true)
+		assert.NoError(t, err)
+		assert.Equal(t, "a", name)
+
+		name, err = compressor.DecodeName("a/file.log.zst", false)
+		assert.NoError(t, err)
+		assert.Equal(t, "a/file.log", name)
+
+		_, err = compressor.DecodeName("a/file.log", false)
+		assert.ErrorIs(t, err, fs.ErrUnknownName)
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := Load(fs.LocalBackend{}, "test_compressor")
+		assert.ErrorIs(t, err, ErrNotCompressed)
+
+		_, err = Setup(fs.LocalBackend{}, "test_compressor", Config{Algorithm: Gzip, Level: 9})
+		assert.NoError(t, err)
+
+		compressor, err := Load(fs.LocalBackend{}, "test_compressor")
+		assert.NoError(t, err)
+		assert.Equal(t, Config{Algorithm: Gzip, Level: 9}, compressor.config)
+
+		// the config of the first run is kept
+		_, err = Setup(fs.LocalBackend{}, "test_compressor", Config{Algorithm: Gzip, Level: 1})
+		assert.NoError(t, err)
+
+		_, err = Setup(fs.LocalBackend{}, "test_compressor", Config{Algorithm: Zstd})
+		assert.ErrorIs(t, err, ErrAlgorithmMismatch)
+
+		compressor, err = Load(fs.LocalBackend{}, "test_compressor")
+		assert.NoError(t, err)
+		assert.Equal(t, Config{Algorithm: Gzip, Level: 9}, compressor.config)
+
+		_, err = New(Config{Algorithm: "lzma"})
+		assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+	})
+
+	t.Run("NotCompressed", func(t *testing.T) {
+		t.Parallel()
+
+		compressor, err := New(Config{Algorithm: Zstd})
+		assert.NoError(t, err)
+
+		_, _, err = compressor.NewReader(bytes.NewReader([]byte("plain file content")))
+		assert.ErrorIs(t, err, ErrNotCompressed)
+	})
+}
@@ -0,0 +1,246 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dtsync/pkg/fs"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrNoKey is returned when neither a passphrase nor a key file is given.
+	ErrNoKey = errors.New("no passphrase or key file given")
+	// ErrWrongKey is returned when the key does not match the one used to create the destination.
+	ErrWrongKey = errors.New("wrong passphrase or key file")
+	// ErrNotEncrypted is returned when the encrypted root has no config.
+	ErrNotEncrypted = errors.New("root is not encrypted")
+	// ErrNameTooLong is returned for names which are longer than file systems allow once they are encrypted.
+	ErrNameTooLong = errors.New("name too long to encrypt")
+)
+
+const (
+	// ConfigFileName is the name of the config file in the encrypted root.
+	ConfigFileName = fs.ReservedPrefix + "crypt.json"
+
+	configVersion = 1
+	saltSize      = 32
+	keySize       = chacha20poly1305.KeySize
+	argonTime     = 3
+	argonMemory   = 64 << 10
+	argonThreads  = 4
+	configPerm    = 0o600
+	// maxNameLength is the longest name most file systems store, NAME_MAX on Linux.
+	maxNameLength = 255
+)
+
+// KDF identifies how the master key is derived.
+type KDF string
+
+const (
+	// KDFArgon2id derives the key from a passphrase.
+	KDFArgon2id KDF = "argon2id"
+	// KDFKeyFile derives the key from the content of a key file.
+	KDFKeyFile KDF = "hkdf-sha256"
+)
+
+// Config is persisted in the encrypted root, it does not contain any secret.
+type Config struct {
+	Version      int    `json:"version"`
+	KDF          KDF    `json:"kdf"`
+	Salt         []byte `json:"salt"`
+	EncryptNames bool   `json:"encrypt_names"`
+	Check        []byte `json:"check"`
+}
+
+// Key is the secret used to derive the encryption keys from.
+type Key struct {
+	Passphrase []byte
+	KeyFile    []byte
+}
+
+// Cipher encrypts file contents and optionally names with XChaCha20-Poly1305.
+// It implements fs.Codec.
+type Cipher struct {
+	config   Config
+	content  cipher.AEAD
+	names    cipher.AEAD
+	nameHMAC []byte
+}
+
+// Setup loads the config of the encrypted root or creates it when create is set.
+func Setup(backend fs.Backend, rootPath string, key Key, encryptNames, create bool) (*Cipher, error) {
+	configPath := filepath.Join(rootPath, ConfigFileName)
+
+	data, err := fs.ReadFile(backend, configPath)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist) && create:
+		return initialize(backend, configPath, key, encryptNames)
+	case errors.Is(err, os.ErrNotExist):
+		return nil, ErrNotEncrypted
+	case err != nil:
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotEncrypted, err)
+	}
+
+	return New(config, key)
+}
+
+// New creates a cipher for the given config and verifies the key.
+func New(config Config, key Key) (*Cipher, error) {
+	master, err := deriveMaster(config, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(config.Check, subKey(master, "check")) {
+		return nil, ErrWrongKey
+	}
+
+	content, err := chacha20poly1305.NewX(subKey(master, "content"))
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := chacha20poly1305.NewX(subKey(master, "names"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{config: config, content: content, names: names, nameHMAC: subKey(master, "name-nonce")}, nil
+}
+
+// EncodeName encrypts every path component when name encryption is enabled.
+// Components longer than maxNameLength once encrypted, about 150 bytes before, fail with ErrNameTooLong.
+func (c *Cipher) EncodeName(name string, _ bool) (string, error) {
+	if !c.config.EncryptNames {
+		return name, nil
+	}
+
+	components := strings.Split(name, string(filepath.Separator))
+	for i, component := range components {
+		mac := hmac.New(sha256.New, c.nameHMAC)
+		mac.Write([]byte(component))
+		nonce := mac.Sum(nil)[:chacha20poly1305.NonceSizeX]
+
+		components[i] = base64.RawURLEncoding.EncodeToString(c.names.Seal(nonce, nonce, []byte(component), nil))
+		if len(components[i]) > maxNameLength {
+			return "", fmt.Errorf("%w: %s has %d bytes, %d encrypted, at most %d", ErrNameTooLong, name,
+				len(component), len(components[i]), maxNameLength)
+		}
+	}
+
+	return filepath.Join(components...), nil
+}
+
+// DecodeName decrypts every path component when name encryption is enabled.
+func (c *Cipher) DecodeName(name string, _ bool) (string, error) {
+	if !c.config.EncryptNames {
+		return name, nil
+	}
+
+	components := strings.Split(name, string(filepath.Separator))
+	for i, component := range components {
+		data, err := base64.RawURLEncoding.DecodeString(component)
+		if err != nil || len(data) < chacha20poly1305.NonceSizeX {
+			return "", fs.ErrUnknownName
+		}
+
+		plain, err := c.names.Open(nil, data[:chacha20poly1305.NonceSizeX], data[chacha20poly1305.NonceSizeX:], nil)
+		if err != nil {
+			return "", fs.ErrUnknownName
+		}
+
+		components[i] = string(plain)
+	}
+
+	return filepath.Join(components...), nil
+}
+
+// NewWriter returns a writer that encrypts the header and the content into w.
+func (c *Cipher) NewWriter(w io.Writer, header fs.Header) (io.WriteCloser, error) {
+	return newStreamWriter(w, c.content, header)
+}
+
+// NewReader reads and decrypts the header and returns a reader that decrypts the content.
+func (c *Cipher) NewReader(r io.Reader) (io.ReadCloser, fs.Header, error) {
+	reader, header, err := newStreamReader(r, c.content)
+	if err != nil {
+		return nil, header, err
+	}
+
+	return io.NopCloser(reader), header, nil
+}
+
+// initialize creates a new config with a random salt.
+func initialize(backend fs.Backend, configPath string, key Key, encryptNames bool) (*Cipher, error) {
+	config := Config{Version: configVersion, KDF: KDFKeyFile, Salt: make([]byte, saltSize), EncryptNames: encryptNames}
+	if len(key.Passphrase) > 0 {
+		config.KDF = KDFArgon2id
+	}
+
+	if _, err := rand.Read(config.Salt); err != nil {
+		return nil, err
+	}
+
+	master, err := deriveMaster(config, key)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Check = subKey(master, "check")
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.WriteFile(backend, configPath, data, configPerm); err != nil {
+		return nil, err
+	}
+
+	return New(config, key)
+}
+
+// deriveMaster derives the master key from the passphrase or key file.
+func deriveMaster(config Config, key Key) ([]byte, error) {
+	switch {
+	case config.KDF == KDFArgon2id && len(key.Passphrase) > 0:
+		return argon2.IDKey(key.Passphrase, config.Salt, argonTime, argonMemory, argonThreads, keySize), nil
+	case config.KDF == KDFKeyFile && len(key.KeyFile) > 0:
+		master := make([]byte, keySize)
+		_, err := io.ReadFull(hkdf.New(sha256.New, key.KeyFile, config.Salt, []byte("dtsync master")), master)
+
+		return master, err
+	case len(key.Passphrase) > 0 || len(key.KeyFile) > 0:
+		return nil, fmt.Errorf("%w: root was encrypted using %s", ErrWrongKey, config.KDF)
+	}
+
+	return nil, ErrNoKey
+}
+
+// subKey derives a purpose bound key from the master key.
+func subKey(master []byte, purpose string) []byte {
+	key := make([]byte, keySize)
+	_, _ = io.ReadFull(hkdf.New(sha256.New, master, nil, []byte("dtsync "+purpose)), key)
+
+	return key
+}
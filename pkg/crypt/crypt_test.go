@@ -0,0 +1,137 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dtsync/pkg/fs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipher(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_cipher")
+	})
+	assert.NoError(t, os.Mkdir("test_cipher", 0o755))
+
+	key := Key{KeyFile: []byte("0123456789abcdef0123456789abcdef")}
+	cipher, err := Setup(fs.LocalBackend{}, "test_cipher", key, true, true)
+	assert.NoError(t, err)
+
+	header := fs.Header{Size: 3 * segmentSize, ModTime: time.Now().Truncate(time.Second), Mode: 0o640}
+
+	t.Run("Content", func(t *testing.T) {
+		t.Parallel()
+
+		for _, size := range []int{0, 1, segmentSize, 3*segmentSize + 7} {
+			plain := make([]byte, size)
+			rand.New(rand.NewSource(int64(size))).Read(plain) //nolint:gosec
+
+			encrypted := encrypt(t, cipher, header, plain)
+			if size >= 64 {
+				assert.NotContains(t, string(encrypted), string(plain[:64]))
+			}
+
+			reader, decodedHeader, err := cipher.NewReader(bytes.NewReader(encrypted))
+			assert.NoError(t, err)
+			assert.True(t, header.ModTime.Equal(decodedHeader.ModTime))
+			assert.Equal(t, header.Size, decodedHeader.Size)
+
+			decrypted, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, plain, decrypted)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		t.Parallel()
+
+		encrypted := encrypt(t, cipher, header, make([]byte, 2*segmentSize+1))
+		reader, _, err := cipher.NewReader(bytes.NewReader(encrypted[:len(encrypted)-17]))
+		assert.NoError(t, err)
+
+		_, err = io.ReadAll(reader)
+		assert.ErrorIs(t, err, ErrInvalidStream)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		t.Parallel()
+
+		encrypted := encrypt(t, cipher, header, []byte("hello world"))
+		encrypted[len(encrypted)-1] ^= 1
+
+		reader, _, err := cipher.NewReader(bytes.NewReader(encrypted))
+		assert.NoError(t, err)
+
+		_, err = io.ReadAll(reader)
+		assert.ErrorIs(t, err, ErrInvalidStream)
+	})
+
+	t.Run("Names", func(t *testing.T) {
+		t.Parallel()
+
+		name := filepath.Join("dir", "file.txt")
+		encoded, err := cipher.EncodeName(name, false)
+		assert.NoError(t, err)
+		assert.NotContains(t, encoded, "file")
+
+		again, err := cipher.EncodeName(name, false)
+		assert.NoError(t, err)
+		assert.Equal(t, encoded, again)
+
+		decoded, err := cipher.DecodeName(encoded, false)
+		assert.NoError(t, err)
+		assert.Equal(t, name, decoded)
+
+		_, err = cipher.DecodeName("plain.txt", false)
+		assert.ErrorIs(t, err, fs.ErrUnknownName)
+
+		// the longest names fit into 255 bytes once encrypted
+		encoded, err = cipher.EncodeName(filepath.Join("dir", strings.Repeat("a", 151)), false)
+		assert.NoError(t, err)
+		assert.Len(t, filepath.Base(encoded), 255)
+
+		_, err = cipher.EncodeName(filepath.Join("dir", strings.Repeat("a", 152)), false)
+		assert.ErrorIs(t, err, ErrNameTooLong)
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := Setup(fs.LocalBackend{}, "test_cipher", key, false, false)
+		assert.NoError(t, err)
+
+		_, err = Setup(fs.LocalBackend{}, "test_cipher", Key{KeyFile: []byte("wrong")}, false, false)
+		assert.ErrorIs(t, err, ErrWrongKey)
+
+		_, err = Setup(fs.LocalBackend{}, "test_cipher", Key{}, false, false)
+		assert.ErrorIs(t, err, ErrNoKey)
+
+		_, err = Setup(fs.LocalBackend{}, "test_cipher/not_exists", key, false, false)
+		assert.ErrorIs(t, err, ErrNotEncrypted)
+	})
+}
+
+func encrypt(t *testing.T, cipher *Cipher, header fs.Header, plain []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	writer, err := cipher.NewWriter(&buf, header)
+	assert.NoError(t, err)
+
+	_, err = writer.Write(plain)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	return buf.Bytes()
+}
@@ -0,0 +1,218 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"dtsync/pkg/fs"
+)
+
+var (
+	// ErrInvalidStream is returned when a file is not a valid encrypted stream.
+	ErrInvalidStream = errors.New("invalid encrypted stream")
+	// ErrStreamClosed is returned when writing into a closed stream.
+	ErrStreamClosed = errors.New("encrypted stream already closed")
+)
+
+const (
+	streamVersion  = 1
+	prefixSize     = 16
+	segmentSize    = 64 << 10
+	maxHeaderSize  = 1 << 20
+	lastSegmentBit = 1 << 63
+)
+
+// streamMagic identifies encrypted files.
+var streamMagic = []byte("DTSE") //nolint:gochecknoglobals
+
+// streamWriter encrypts the content in authenticated segments.
+// Each segment uses the random file prefix and its counter as nonce, the
+// last segment is flagged so that truncation is detected.
+type streamWriter struct {
+	writer  io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint64
+	buf     []byte
+	closed  bool
+}
+
+func newStreamWriter(w io.Writer, aead cipher.AEAD, header fs.Header) (*streamWriter, error) {
+	stream := &streamWriter{writer: w, aead: aead, prefix: make([]byte, prefixSize)}
+	if _, err := rand.Read(stream.prefix); err != nil {
+		return nil, err
+	}
+
+	plainHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedHeader := aead.Seal(nil, stream.nonce(false), plainHeader, stream.preamble())
+	stream.counter++
+
+	out := append(stream.preamble(), binary.BigEndian.AppendUint32(nil, uint32(len(sealedHeader)))...)
+	if _, err := w.Write(append(out, sealedHeader...)); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// Write buffers and encrypts the data segment wise.
+func (s *streamWriter) Write(data []byte) (int, error) {
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+
+	s.buf = append(s.buf, data...)
+
+	// a full segment is only written once it is clear that it is not the last one
+	for len(s.buf) > segmentSize {
+		if err := s.seal(s.buf[:segmentSize], false); err != nil {
+			return 0, err
+		}
+
+		s.buf = s.buf[segmentSize:]
+	}
+
+	return len(data), nil
+}
+
+// Close writes the last segment.
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	return s.seal(s.buf, true)
+}
+
+func (s *streamWriter) seal(plain []byte, last bool) error {
+	_, err := s.writer.Write(s.aead.Seal(nil, s.nonce(last), plain, nil))
+	s.counter++
+
+	return err
+}
+
+func (s *streamWriter) nonce(last bool) []byte {
+	return streamNonce(s.prefix, s.counter, last)
+}
+
+func (s *streamWriter) preamble() []byte {
+	return append(append(append([]byte{}, streamMagic...), streamVersion), s.prefix...)
+}
+
+// streamReader decrypts the content written by a streamWriter.
+type streamReader struct {
+	reader  *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint64
+	segment []byte
+	plain   []byte
+	done    bool
+}
+
+func newStreamReader(r io.Reader, aead cipher.AEAD) (*streamReader, fs.Header, error) {
+	var header fs.Header
+
+	stream := &streamReader{
+		reader:  bufio.NewReaderSize(r, segmentSize+aead.Overhead()),
+		aead:    aead,
+		segment: make([]byte, segmentSize+aead.Overhead()),
+	}
+
+	preamble := make([]byte, len(streamMagic)+1+prefixSize+4) //nolint:gomnd
+	if _, err := io.ReadFull(stream.reader, preamble); err != nil {
+		return nil, header, fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+
+	if !bytes.Equal(preamble[:len(streamMagic)], streamMagic) || preamble[len(streamMagic)] != streamVersion {
+		return nil, header, ErrInvalidStream
+	}
+
+	stream.prefix = preamble[len(streamMagic)+1 : len(streamMagic)+1+prefixSize]
+
+	headerSize := binary.BigEndian.Uint32(preamble[len(preamble)-4:])
+	if headerSize > maxHeaderSize {
+		return nil, header, ErrInvalidStream
+	}
+
+	sealedHeader := make([]byte, headerSize)
+	if _, err := io.ReadFull(stream.reader, sealedHeader); err != nil {
+		return nil, header, fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+
+	plainHeader, err := aead.Open(nil, streamNonce(stream.prefix, 0, false), sealedHeader,
+		preamble[:len(preamble)-4])
+	if err != nil {
+		return nil, header, fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+
+	stream.counter++
+
+	return stream, header, json.Unmarshal(plainHeader, &header)
+}
+
+// Read decrypts the next segment when the current one is consumed.
+func (s *streamReader) Read(data []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(data, s.plain)
+	s.plain = s.plain[n:]
+
+	return n, nil
+}
+
+func (s *streamReader) open() error {
+	n, err := io.ReadFull(s.reader, s.segment)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	// the last segment is the one that is followed by the end of the file
+	last := n < len(s.segment)
+	if !last {
+		if _, err := s.reader.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+
+	plain, err := s.aead.Open(s.segment[:0], streamNonce(s.prefix, s.counter, last), s.segment[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+
+	s.counter++
+	s.plain = plain
+	s.done = last
+
+	return nil
+}
+
+// streamNonce builds the nonce from the file prefix, the segment counter and the last segment flag.
+func streamNonce(prefix []byte, counter uint64, last bool) []byte {
+	if last {
+		counter |= lastSegmentBit
+	}
+
+	return binary.BigEndian.AppendUint64(append([]byte{}, prefix...), counter)
+}
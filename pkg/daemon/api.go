@@ -0,0 +1,284 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInsecureAddress is returned for a TCP address reachable from other hosts without a token,
+	// or a unix socket in a directory others can write to.
+	ErrInsecureAddress = errors.New("insecure daemon address")
+	// ErrUnauthorized is answered to requests without the token of the daemon.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+const (
+	// eventInterval is the interval of the progress events.
+	eventInterval = 500 * time.Millisecond
+	// socketDirPerm is the mode of a created directory of the unix socket, socketPerm the one of the socket.
+	socketDirPerm = 0o700
+	socketPerm    = 0o600
+)
+
+// apiError is the body of failed requests.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// Listen listens on `unix:/path`, a path containing a slash (unix socket) or `host:port` (TCP).
+// TCP addresses other than loopback ones need the API to be authenticated with a token.
+// The directory of a unix socket is created only accessible by the user, an existing one must be owned
+// by the user and not accessible by others, so they can't replace the socket. A stale socket file is removed
+// and the socket is only accessible by the user.
+func Listen(address string, authenticated bool) (net.Listener, error) {
+	network, address := splitAddress(address)
+	if network == "tcp" {
+		if !authenticated && !loopback(address) {
+			return nil, fmt.Errorf("%w: %s is not loopback, a token is required", ErrInsecureAddress, address)
+		}
+
+		return net.Listen(network, address)
+	}
+
+	if err := checkSocketDir(filepath.Dir(address)); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(address, socketPerm); err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// loopback returns true for a `host:port` address only reachable from the host itself.
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	} else if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkSocketDir creates the directory of a unix socket or checks that others can't replace the socket in it,
+// on platforms reporting the owner.
+func checkSocketDir(dir string) error {
+	if err := os.MkdirAll(dir, socketDirPerm); err != nil {
+		return err
+	}
+
+	state, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	uid, ok := owner(state)
+
+	switch {
+	case !state.IsDir():
+		return fmt.Errorf("%w: %s is not a directory", ErrInsecureAddress, dir)
+	case !ok:
+		return nil
+	case uid != os.Getuid():
+		return fmt.Errorf("%w: %s is owned by another user", ErrInsecureAddress, dir)
+	case state.Mode().Perm()&0o077 != 0:
+		return fmt.Errorf("%w: %s is accessible by others", ErrInsecureAddress, dir)
+	}
+
+	return nil
+}
+
+func splitAddress(address string) (string, string) {
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		return "unix", strings.TrimPrefix(address, "unix:")
+	case strings.HasPrefix(address, "tcp:"):
+		return "tcp", strings.TrimPrefix(address, "tcp:")
+	case strings.Contains(address, "/"):
+		return "unix", address
+	}
+
+	return "tcp", address
+}
+
+// Handler returns the HTTP/JSON API of the daemon, which needs the `Authorization: Bearer <token>` header
+// with WithToken:
+//
+//	GET    /jobs               list the jobs
+//	PUT    /jobs/{name}        define a job from {"args": [...], "schedule": {...}}
+//	GET    /jobs/{name}        get a job
+//	DELETE /jobs/{name}        remove a job
+//	POST   /jobs/{name}/runs   start a run of the job
+//	GET    /runs               list the runs, the newest first
+//	GET    /runs/{id}          get the progress or result of a run
+//	POST   /runs/{id}/cancel   cancel a run and wait until it stopped
+//	GET    /runs/{id}/events   stream the progress as server-sent events until the run is finished
+func (d *Daemon) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.authorized(r) {
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: ErrUnauthorized.Error()})
+
+			return
+		}
+
+		d.serveHTTP(w, r)
+	})
+}
+
+// authorized checks the bearer token of the request, all requests are authorized without a token.
+func (d *Daemon) authorized(r *http.Request) bool {
+	if d.token == "" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) == 1
+}
+
+//nolint:cyclop
+func (d *Daemon) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route := r.Method + " " + parts[0]
+
+	switch {
+	case route == "GET jobs" && len(parts) == 1:
+		writeJSON(w, http.StatusOK, d.Jobs())
+	case route == "GET jobs" && len(parts) == 2:
+		job, err := d.Job(parts[1])
+		writeResult(w, http.StatusOK, job, err)
+	case route == "PUT jobs" && len(parts) == 2:
+		job := Job{Name: parts[1]}
+		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+			writeError(w, fmt.Errorf("%w: %w", ErrInvalidJob, err))
+
+			return
+		}
+
+		job.Name = parts[1]
+		writeResult(w, http.StatusOK, job, d.PutJob(job))
+	case route == "DELETE jobs" && len(parts) == 2:
+		writeResult(w, http.StatusNoContent, nil, d.RemoveJob(parts[1]))
+	case route == "POST jobs" && len(parts) == 3 && parts[2] == "runs":
+		run, err := d.Start(parts[1])
+		writeResult(w, http.StatusAccepted, run, err)
+	case route == "GET runs" && len(parts) == 1:
+		writeJSON(w, http.StatusOK, d.Runs())
+	case route == "GET runs" && len(parts) == 2:
+		run, err := d.Run(parts[1])
+		writeResult(w, http.StatusOK, run, err)
+	case route == "POST runs" && len(parts) == 3 && parts[2] == "cancel":
+		run, err := d.Cancel(parts[1])
+		writeResult(w, http.StatusOK, run, err)
+	case route == "GET runs" && len(parts) == 3 && parts[2] == "events":
+		d.serveEvents(w, r, parts[1])
+	default:
+		writeJSON(w, http.StatusNotFound, apiError{Error: "unknown endpoint " + r.Method + " " + r.URL.Path})
+	}
+}
+
+// serveEvents sends a `status` event with the run whenever its progress changed and an `end` event when it finished.
+func (d *Daemon) serveEvents(w http.ResponseWriter, r *http.Request, id string) {
+	done, err := d.Done(id)
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "streaming not supported"})
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+
+	var last Run
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-done:
+			run, _ := d.Run(id)
+			writeEvent(w, "end", run)
+			flusher.Flush()
+
+			return
+		case <-ticker.C:
+			if run, _ := d.Run(id); run.Status != last.Status || last.ID == "" {
+				last = run
+				writeEvent(w, "status", run)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, run Run) {
+	data, _ := json.Marshal(run)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+func writeResult(w http.ResponseWriter, status int, value any, err error) {
+	if err != nil {
+		writeError(w, err)
+	} else if value == nil {
+		w.WriteHeader(status)
+	} else {
+		writeJSON(w, status, value)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, ErrJobNotFound), errors.Is(err, ErrRunNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrJobRunning), errors.Is(err, ErrRunFinished):
+		status = http.StatusConflict
+	case errors.Is(err, ErrInvalidJob):
+		status = http.StatusBadRequest
+	}
+
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
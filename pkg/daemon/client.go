@@ -0,0 +1,215 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrRequestFailed is returned when the daemon answered a request with an error.
+var ErrRequestFailed = errors.New("daemon request failed")
+
+// Client talks to the API of a daemon.
+type Client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithClientToken sends the bearer token of a daemon started WithToken.
+func WithClientToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// NewClient creates a client for the daemon listening on the address, in the format accepted by Listen.
+// An address with the http:// scheme is used as is.
+func NewClient(address string, options ...ClientOption) *Client {
+	client := &Client{http: http.DefaultClient}
+
+	network, socket := splitAddress(address)
+
+	switch {
+	case strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://"):
+		client.base = strings.TrimSuffix(address, "/")
+	case network == "tcp":
+		client.base = "http://" + socket
+	default:
+		var dialer net.Dialer
+
+		client.http = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}}
+		client.base = "http://dtsync"
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	return client
+}
+
+// Jobs returns the jobs sorted by name.
+func (c *Client) Jobs() ([]Job, error) {
+	var jobs []Job
+
+	return jobs, c.do(http.MethodGet, "/jobs", nil, &jobs)
+}
+
+// PutJob adds or replaces the job.
+func (c *Client) PutJob(job Job) error {
+	return c.do(http.MethodPut, "/jobs/"+url.PathEscape(job.Name), job, nil)
+}
+
+// RemoveJob removes the job.
+func (c *Client) RemoveJob(name string) error {
+	return c.do(http.MethodDelete, "/jobs/"+url.PathEscape(name), nil, nil)
+}
+
+// Start starts a run of the job.
+func (c *Client) Start(name string) (Run, error) {
+	var run Run
+
+	return run, c.do(http.MethodPost, "/jobs/"+url.PathEscape(name)+"/runs", nil, &run)
+}
+
+// Cancel cancels the run and returns its result.
+func (c *Client) Cancel(id string) (Run, error) {
+	var run Run
+
+	return run, c.do(http.MethodPost, "/runs/"+url.PathEscape(id)+"/cancel", nil, &run)
+}
+
+// Runs returns all runs, the newest first.
+func (c *Client) Runs() ([]Run, error) {
+	var runs []Run
+
+	return runs, c.do(http.MethodGet, "/runs", nil, &runs)
+}
+
+// Run returns the progress or result of the run.
+func (c *Client) Run(id string) (Run, error) {
+	var run Run
+
+	return run, c.do(http.MethodGet, "/runs/"+url.PathEscape(id), nil, &run)
+}
+
+// Watch calls onProgress with the run whenever its progress changed and returns the finished run.
+func (c *Client) Watch(ctx context.Context, id string, onProgress func(Run)) (Run, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/runs/"+url.PathEscape(id)+"/events", nil)
+	if err != nil {
+		return Run{}, err
+	}
+
+	response, err := c.send(request)
+	if err != nil {
+		return Run{}, err
+	}
+
+	defer response.Body.Close()
+
+	if err := checkResponse(response); err != nil {
+		return Run{}, err
+	}
+
+	var (
+		scanner = bufio.NewScanner(response.Body)
+		event   string
+	)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var run Run
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &run); err != nil {
+				return Run{}, err
+			}
+
+			if event == "end" {
+				return run, nil
+			}
+
+			onProgress(run)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Run{}, err
+	}
+
+	return Run{}, io.ErrUnexpectedEOF
+}
+
+func (c *Client) do(method, path string, body, result any) error {
+	var reader io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(context.Background(), method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.send(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if err := checkResponse(response); err != nil {
+		return err
+	} else if result != nil {
+		return json.NewDecoder(response.Body).Decode(result)
+	}
+
+	return nil
+}
+
+// send sends the request with the token.
+func (c *Client) send(request *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return c.http.Do(request)
+}
+
+func checkResponse(response *http.Response) error {
+	if response.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	var failed apiError
+	if err := json.NewDecoder(response.Body).Decode(&failed); err != nil || failed.Error == "" {
+		failed.Error = response.Status
+	}
+
+	return fmt.Errorf("%w: %s", ErrRequestFailed, failed.Error)
+}
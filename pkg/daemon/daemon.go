@@ -0,0 +1,506 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	gosync "sync"
+	"time"
+
+	"dtsync/pkg/args"
+	"dtsync/pkg/schedule"
+	"dtsync/pkg/screen"
+	"dtsync/pkg/sync"
+)
+
+// maxRuns is the number of finished runs kept as history.
+const maxRuns = 100
+
+var (
+	// ErrJobNotFound is returned for unknown job names.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrInvalidJob is returned for jobs without name or with invalid sync arguments.
+	ErrInvalidJob = errors.New("invalid job")
+	// ErrJobRunning is returned when a job is started or removed while it runs.
+	ErrJobRunning = errors.New("job is already running")
+	// ErrRunNotFound is returned for unknown run ids.
+	ErrRunNotFound = errors.New("run not found")
+	// ErrRunFinished is returned when a finished run is canceled.
+	ErrRunFinished = errors.New("run already finished")
+)
+
+// RunFunc runs one sync of the arguments, reporting the progress to addStatus, until it is done or ctx is canceled.
+// It calls wait before each file, which blocks while transfers are paused.
+type RunFunc func(
+	ctx context.Context, arguments args.Arguments, addStatus func(screen.Status), wait func(context.Context) error,
+) error
+
+// Observer is notified about the progress and the end of the runs of all jobs, like to export metrics.
+type Observer interface {
+	// AddStatus adds the progress of a run of the job.
+	AddStatus(job string, status screen.Status)
+	// Finished records the end of a run of the job, err is nil for successful runs.
+	Finished(job string, duration time.Duration, err error)
+}
+
+// Option configures a Daemon.
+type Option func(*Daemon)
+
+// WithObserver notifies the observer about all runs.
+func WithObserver(observer Observer) Option {
+	return func(d *Daemon) {
+		d.observer = observer
+	}
+}
+
+// WithToken requires the bearer token in all requests to the API, see Handler.
+func WithToken(token string) Option {
+	return func(d *Daemon) {
+		d.token = token
+	}
+}
+
+// Job is a named sync, defined by the command line arguments of dtsync without the program name.
+// Jobs with a schedule are started by Schedule and transfer only within its time windows.
+type Job struct {
+	Name     string         `json:"name"`
+	Args     []string       `json:"args"`
+	Schedule *schedule.Spec `json:"schedule,omitempty"`
+}
+
+// RunState is the state of a run.
+type RunState string
+
+const (
+	// RunRunning is the state of an ongoing run.
+	RunRunning RunState = "running"
+	// RunSucceeded is the state of a run which synced everything.
+	RunSucceeded RunState = "succeeded"
+	// RunFailed is the state of a run which stopped with an error.
+	RunFailed RunState = "failed"
+	// RunCanceled is the state of a run canceled by a client or the daemon shutdown.
+	RunCanceled RunState = "canceled"
+	// RunSkipped is the state of a scheduled run not started because the previous run of the job was still going.
+	RunSkipped RunState = "skipped"
+)
+
+// Run is the progress or result of one run of a job.
+type Run struct {
+	ID       string        `json:"id"`
+	Job      string        `json:"job"`
+	State    RunState      `json:"state"`
+	Started  time.Time     `json:"started"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Status   screen.Status `json:"status"`
+	Paused   bool          `json:"paused,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Failures are the entries which failed in a run with -continue-on-error.
+	Failures []sync.Failure `json:"failures,omitempty"`
+}
+
+// state is persisted in the state file.
+type state struct {
+	Jobs   []Job `json:"jobs"`
+	Runs   []Run `json:"runs"`
+	NextID int   `json:"nextId"`
+}
+
+// Daemon manages the jobs and their runs, each job runs at most once at a time.
+type Daemon struct {
+	lock      gosync.Mutex
+	run       RunFunc
+	statePath string
+	jobs      map[string]Job
+	runs      []*Run
+	active    map[string]*activeRun
+	nextID    int
+	wait      gosync.WaitGroup
+	tick      time.Duration
+	observer  Observer
+	token     string
+}
+
+// activeRun allows to cancel and wait for an ongoing run.
+type activeRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a daemon running the jobs with run.
+// The jobs and finished runs are kept in the state file, when the path is not empty.
+func New(run RunFunc, statePath string, options ...Option) (*Daemon, error) {
+	daemon := &Daemon{
+		run: run, statePath: statePath, jobs: map[string]Job{}, active: map[string]*activeRun{}, nextID: 1,
+		tick: scheduleTick,
+	}
+
+	for _, option := range options {
+		option(daemon)
+	}
+
+	if statePath == "" {
+		return daemon, nil
+	}
+
+	data, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return daemon, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", statePath, err)
+	}
+
+	for _, job := range saved.Jobs {
+		daemon.jobs[job.Name] = job
+	}
+
+	for i := range saved.Runs {
+		run := saved.Runs[i]
+		if run.State == RunRunning {
+			run.State, run.Error = RunFailed, "daemon stopped during the run"
+		}
+
+		daemon.runs = append(daemon.runs, &run)
+	}
+
+	daemon.nextID = max(saved.NextID, 1)
+
+	return daemon, nil
+}
+
+// Jobs returns the jobs sorted by name.
+func (d *Daemon) Jobs() []Job {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	jobs := make([]Job, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	return jobs
+}
+
+// Job returns the job with the name.
+func (d *Daemon) Job(name string) (Job, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	job, ok := d.jobs[name]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+
+	return job, nil
+}
+
+// PutJob adds or replaces the job after validating its arguments and schedule.
+func (d *Daemon) PutJob(job Job) error {
+	if job.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidJob)
+	} else if _, err := args.ParseJob(job.Args); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidJob, err)
+	} else if _, err := job.plan(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidJob, err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.jobs[job.Name] = job
+
+	return d.save()
+}
+
+// RemoveJob removes the job, its past runs are kept.
+func (d *Daemon) RemoveJob(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.jobs[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	} else if _, ok := d.active[name]; ok {
+		return fmt.Errorf("%w: %s", ErrJobRunning, name)
+	}
+
+	delete(d.jobs, name)
+
+	return d.save()
+}
+
+// Start starts a run of the job in the background.
+func (d *Daemon) Start(name string) (Run, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	job, ok := d.jobs[name]
+	if !ok {
+		return Run{}, fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	} else if _, ok := d.active[name]; ok {
+		return Run{}, fmt.Errorf("%w: %s", ErrJobRunning, name)
+	}
+
+	arguments, err := args.ParseJob(job.Args)
+	if err != nil {
+		return Run{}, fmt.Errorf("%w: %w", ErrInvalidJob, err)
+	}
+
+	plan, err := job.plan()
+	if err != nil {
+		return Run{}, fmt.Errorf("%w: %w", ErrInvalidJob, err)
+	}
+
+	run := &Run{ID: strconv.Itoa(d.nextID), Job: name, State: RunRunning, Started: time.Now()}
+	ctx, cancel := context.WithCancel(context.Background())
+	active := &activeRun{cancel: cancel, done: make(chan struct{})}
+
+	d.nextID++
+	d.runs = append(d.runs, run)
+	d.active[name] = active
+	d.wait.Add(1)
+
+	go func() {
+		defer d.wait.Done()
+		defer close(active.done)
+
+		err := d.run(ctx, arguments, func(status screen.Status) {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+
+			run.Status.Add(status)
+
+			if d.observer != nil {
+				d.observer.AddStatus(name, status)
+			}
+		}, func(ctx context.Context) error {
+			return d.pause(ctx, run, plan.Windows)
+		})
+
+		d.finish(ctx, run, err)
+		cancel()
+	}()
+
+	return *run, nil
+}
+
+// pause blocks while the windows do not allow transfers, marking the run as paused.
+func (d *Daemon) pause(ctx context.Context, run *Run, windows schedule.Windows) error {
+	if windows.Contains(time.Now()) {
+		return ctx.Err()
+	}
+
+	d.setPaused(run, true)
+	defer d.setPaused(run, false)
+
+	return windows.Wait(ctx)
+}
+
+func (d *Daemon) setPaused(run *Run, paused bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	run.Paused = paused
+}
+
+// Cancel cancels the run and waits until it stopped.
+func (d *Daemon) Cancel(id string) (Run, error) {
+	d.lock.Lock()
+
+	run := d.find(id)
+	if run == nil {
+		d.lock.Unlock()
+
+		return Run{}, fmt.Errorf("%w: %s", ErrRunNotFound, id)
+	}
+
+	active, ok := d.active[run.Job]
+	if run.State != RunRunning || !ok {
+		d.lock.Unlock()
+
+		return Run{}, fmt.Errorf("%w: %s", ErrRunFinished, id)
+	}
+
+	d.lock.Unlock()
+
+	active.cancel()
+	<-active.done
+
+	return d.Run(id)
+}
+
+// Runs returns all runs, the newest first.
+func (d *Daemon) Runs() []Run {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	runs := make([]Run, 0, len(d.runs))
+	for i := len(d.runs) - 1; i >= 0; i-- {
+		runs = append(runs, *d.runs[i])
+	}
+
+	return runs
+}
+
+// Run returns the run with the id.
+func (d *Daemon) Run(id string) (Run, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	run := d.find(id)
+	if run == nil {
+		return Run{}, fmt.Errorf("%w: %s", ErrRunNotFound, id)
+	}
+
+	return *run, nil
+}
+
+// Done returns a channel which is closed when the run is finished.
+func (d *Daemon) Done(id string) (<-chan struct{}, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	run := d.find(id)
+	if run == nil {
+		return nil, fmt.Errorf("%w: %s", ErrRunNotFound, id)
+	}
+
+	if active, ok := d.active[run.Job]; ok && run.State == RunRunning {
+		return active.done, nil
+	}
+
+	done := make(chan struct{})
+	close(done)
+
+	return done, nil
+}
+
+// Shutdown cancels all ongoing runs and waits until they stopped.
+func (d *Daemon) Shutdown() {
+	d.lock.Lock()
+	for _, active := range d.active {
+		active.cancel()
+	}
+	d.lock.Unlock()
+
+	d.wait.Wait()
+}
+
+func (d *Daemon) finish(ctx context.Context, run *Run, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	run.Finished = &now
+
+	var failed *sync.FailedError
+	if errors.As(err, &failed) {
+		run.Failures = failed.Failures
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		run.State, err = RunCanceled, ctx.Err()
+	case err != nil:
+		run.State, run.Error = RunFailed, err.Error()
+	default:
+		run.State = RunSucceeded
+	}
+
+	if d.observer != nil {
+		d.observer.Finished(run.Job, now.Sub(run.Started), err)
+	}
+
+	delete(d.active, run.Job)
+	d.keep()
+}
+
+// skip adds a skipped run of the job to the history.
+func (d *Daemon) skip(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	d.runs = append(d.runs, &Run{
+		ID: strconv.Itoa(d.nextID), Job: name, State: RunSkipped, Started: now, Finished: &now,
+		Error: "previous run still going",
+	})
+	d.nextID++
+	d.keep()
+}
+
+// keep trims the history to maxRuns finished runs and saves the state, the caller holds the lock.
+func (d *Daemon) keep() {
+	if finished := len(d.runs) - len(d.active); finished > maxRuns {
+		d.trim(finished - maxRuns)
+	}
+
+	d.save()
+}
+
+// trim removes the oldest finished runs.
+func (d *Daemon) trim(count int) {
+	runs := d.runs[:0]
+
+	for _, run := range d.runs {
+		if count > 0 && run.State != RunRunning {
+			count--
+
+			continue
+		}
+
+		runs = append(runs, run)
+	}
+
+	d.runs = runs
+}
+
+func (d *Daemon) find(id string) *Run {
+	for _, run := range d.runs {
+		if run.ID == id {
+			return run
+		}
+	}
+
+	return nil
+}
+
+// save writes the state file atomically, the caller holds the lock.
+func (d *Daemon) save() error {
+	if d.statePath == "" {
+		return nil
+	}
+
+	saved := state{Jobs: make([]Job, 0, len(d.jobs)), Runs: make([]Run, 0, len(d.runs)), NextID: d.nextID}
+	for _, job := range d.jobs {
+		saved.Jobs = append(saved.Jobs, job)
+	}
+
+	for _, run := range d.runs {
+		saved.Runs = append(saved.Runs, *run)
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp := filepath.Join(filepath.Dir(d.statePath), "."+filepath.Base(d.statePath)+".tmp")
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, d.statePath)
+}
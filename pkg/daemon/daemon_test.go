@@ -0,0 +1,225 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"dtsync/pkg/args"
+	"dtsync/pkg/screen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errSyncFailed = errors.New("sync failed")
+
+func TestDaemon(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_daemon")
+	})
+	assert.NoError(t, os.Mkdir("test_daemon", 0o755))
+
+	// the fake sync reports one copied file, then waits for the test to finish or fail it
+	proceed := make(chan error)
+	run := func(ctx context.Context, _ args.Arguments, addStatus func(screen.Status), _ func(context.Context) error) error {
+		addStatus(screen.Status{SrcTotalFiles: 1, Copied: 1})
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-proceed:
+			return err
+		}
+	}
+
+	daemon, err := New(run, "test_daemon/state.json")
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(daemon.Handler())
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	t.Run("Jobs", func(t *testing.T) {
+		assert.NoError(t, client.PutJob(Job{Name: "home", Args: []string{"-src", "/home", "-dst", "/backup", "-replace"}}))
+		assert.NoError(t, client.PutJob(Job{Name: "etc", Args: []string{"-src", "/etc", "-dst", "/backup/etc"}}))
+		assert.ErrorIs(t, client.PutJob(Job{Name: "invalid", Args: []string{"-src", "/etc"}}), ErrRequestFailed)
+
+		jobs, err := client.Jobs()
+		assert.NoError(t, err)
+		assert.Equal(t, []Job{
+			{Name: "etc", Args: []string{"-src", "/etc", "-dst", "/backup/etc"}},
+			{Name: "home", Args: []string{"-src", "/home", "-dst", "/backup", "-replace"}},
+		}, jobs)
+
+		assert.NoError(t, client.RemoveJob("etc"))
+		assert.ErrorIs(t, client.RemoveJob("etc"), ErrRequestFailed)
+	})
+
+	t.Run("RunToEnd", func(t *testing.T) {
+		run, err := client.Start("home")
+		assert.NoError(t, err)
+		assert.Equal(t, RunRunning, run.State)
+
+		_, err = client.Start("home")
+		assert.ErrorIs(t, err, ErrRequestFailed)
+
+		progress := make(chan Run, 10)
+		go func() {
+			<-progress
+			proceed <- nil
+		}()
+
+		finished, err := client.Watch(context.Background(), run.ID, func(run Run) {
+			progress <- run
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, RunSucceeded, finished.State)
+		assert.Equal(t, screen.Status{SrcTotalFiles: 1, Copied: 1}, finished.Status)
+		assert.NotNil(t, finished.Finished)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		run, err := client.Start("home")
+		assert.NoError(t, err)
+
+		proceed <- errSyncFailed
+
+		finished, err := client.Watch(context.Background(), run.ID, func(Run) {})
+		assert.NoError(t, err)
+		assert.Equal(t, RunFailed, finished.State)
+		assert.Equal(t, errSyncFailed.Error(), finished.Error)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		run, err := client.Start("home")
+		assert.NoError(t, err)
+
+		canceled, err := client.Cancel(run.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, RunCanceled, canceled.State)
+
+		_, err = client.Cancel(run.ID)
+		assert.ErrorIs(t, err, ErrRequestFailed)
+	})
+
+	t.Run("History", func(t *testing.T) {
+		runs, err := client.Runs()
+		assert.NoError(t, err)
+		assert.Len(t, runs, 3)
+		assert.Equal(t, []RunState{RunCanceled, RunFailed, RunSucceeded}, []RunState{runs[0].State, runs[1].State, runs[2].State})
+
+		reloaded, err := New(run, "test_daemon/state.json")
+		assert.NoError(t, err)
+		assert.Equal(t, runs, reloaded.Runs())
+		assert.Equal(t, daemon.Jobs(), reloaded.Jobs())
+	})
+
+	t.Run("UnknownEndpoint", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/unknown") //nolint:noctx
+		assert.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+}
+
+func TestUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_daemon_socket")
+	})
+	assert.NoError(t, os.Mkdir("test_daemon_socket", 0o700))
+
+	daemon, err := New(nil, "")
+	assert.NoError(t, err)
+
+	listener, err := Listen("unix:test_daemon_socket/dtsync.sock", false)
+	assert.NoError(t, err)
+
+	state, err := os.Stat("test_daemon_socket/dtsync.sock")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), state.Mode().Perm())
+
+	server := &http.Server{Handler: daemon.Handler()} //nolint:gosec
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := NewClient("unix:test_daemon_socket/dtsync.sock")
+	assert.NoError(t, client.PutJob(Job{Name: "job", Args: []string{"-src", "a", "-dst", "b"}}))
+
+	jobs, err := client.Jobs()
+	assert.NoError(t, err)
+	assert.Equal(t, []Job{{Name: "job", Args: []string{"-src", "a", "-dst", "b"}}}, jobs)
+}
+
+func TestListen(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_daemon_listen")
+	})
+
+	_, err := Listen("0.0.0.0:0", false)
+	assert.ErrorIs(t, err, ErrInsecureAddress)
+
+	for _, address := range []string{"127.0.0.1:0", "tcp:localhost:0"} {
+		listener, err := Listen(address, false)
+		assert.NoError(t, err)
+		listener.Close()
+	}
+
+	listener, err := Listen("0.0.0.0:0", true)
+	assert.NoError(t, err)
+	listener.Close()
+
+	listener, err = Listen("test_daemon_listen/run/dtsync.sock", false)
+	assert.NoError(t, err)
+	listener.Close()
+
+	state, err := os.Stat("test_daemon_listen/run")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), state.Mode().Perm())
+
+	// others could replace the socket in existing directories they can access or own
+	assert.NoError(t, os.Chmod("test_daemon_listen/run", 0o755))
+	_, err = Listen("test_daemon_listen/run/dtsync.sock", false)
+	assert.ErrorIs(t, err, ErrInsecureAddress)
+
+	assert.NoError(t, os.Chmod("test_daemon_listen/run", 0o1777))
+	_, err = Listen("test_daemon_listen/run/dtsync.sock", false)
+	assert.ErrorIs(t, err, ErrInsecureAddress)
+
+	// only root can give the directory away
+	if os.Getuid() == 0 {
+		assert.NoError(t, os.Chmod("test_daemon_listen/run", 0o700))
+		assert.NoError(t, os.Chown("test_daemon_listen/run", 1, -1))
+		_, err = Listen("test_daemon_listen/run/dtsync.sock", false)
+		assert.ErrorIs(t, err, ErrInsecureAddress)
+	}
+}
+
+func TestToken(t *testing.T) {
+	t.Parallel()
+
+	daemon, err := New(nil, "", WithToken("secret"))
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(daemon.Handler())
+	defer server.Close()
+
+	for token, authorized := range map[string]bool{"": false, "wrong": false, "secret": true} {
+		_, err := NewClient(server.URL, WithClientToken(token)).Jobs()
+		if authorized {
+			assert.NoError(t, err, token)
+		} else {
+			assert.ErrorIs(t, err, ErrRequestFailed, token)
+			assert.ErrorContains(t, err, ErrUnauthorized.Error(), token)
+		}
+	}
+}
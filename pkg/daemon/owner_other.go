@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package daemon
+
+import "os"
+
+// owner is not supported on this platform.
+func owner(os.FileInfo) (int, bool) {
+	return 0, false
+}
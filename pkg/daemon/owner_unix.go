@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// owner returns the user owning the file, false when it is unknown.
+func owner(state os.FileInfo) (int, bool) {
+	stat, ok := state.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return int(stat.Uid), true
+}
@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"dtsync/pkg/schedule"
+)
+
+// scheduleTick is the interval in which Schedule checks for due jobs.
+const scheduleTick = time.Second
+
+// due is the next start of a scheduled job.
+type due struct {
+	spec schedule.Spec
+	plan *schedule.Plan
+	next time.Time
+}
+
+// Schedule starts the runs of the jobs with a schedule when they are due, until ctx is canceled.
+// A run is skipped when the previous run of the job is still going.
+// Jobs which catch up start right away when their last run is older than the interval of the schedule.
+func (d *Daemon) Schedule(ctx context.Context) {
+	ticker := time.NewTicker(d.tick)
+	defer ticker.Stop()
+
+	dues := map[string]*due{}
+
+	for {
+		now := time.Now()
+		jobs := d.Jobs()
+		scheduled := make(map[string]bool, len(jobs))
+
+		for _, job := range jobs {
+			if job.Schedule == nil {
+				continue
+			}
+
+			scheduled[job.Name] = true
+
+			next, ok := dues[job.Name]
+			if !ok || !reflect.DeepEqual(next.spec, *job.Schedule) {
+				if next = d.firstDue(job, now); next == nil {
+					continue
+				}
+
+				dues[job.Name] = next
+			}
+
+			if next.next.IsZero() || now.Before(next.next) {
+				continue
+			}
+
+			if _, err := d.Start(job.Name); errors.Is(err, ErrJobRunning) {
+				d.skip(job.Name)
+			}
+
+			next.next = next.plan.Next(now).Add(next.plan.Jitter())
+		}
+
+		for name := range dues {
+			if !scheduled[name] {
+				delete(dues, name)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// firstDue returns the first start of the job after the daemon started or the job was changed.
+func (d *Daemon) firstDue(job Job, now time.Time) *due {
+	plan, err := job.plan()
+	if err != nil {
+		return nil
+	}
+
+	first := &due{spec: *job.Schedule, plan: plan, next: plan.Next(now).Add(plan.Jitter())}
+
+	if last, ok := d.lastStart(job.Name); ok && plan.CatchUp {
+		if missed := plan.Next(last); !missed.IsZero() && missed.Before(now) {
+			first.next = now
+		}
+	}
+
+	return first
+}
+
+// lastStart returns the start of the newest run of the job.
+func (d *Daemon) lastStart(name string) (time.Time, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i := len(d.runs) - 1; i >= 0; i-- {
+		if d.runs[i].Job == name {
+			return d.runs[i].Started, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// plan compiles the schedule, a job without schedule has an empty plan.
+func (j Job) plan() (*schedule.Plan, error) {
+	if j.Schedule == nil {
+		return &schedule.Plan{}, nil
+	}
+
+	return j.Schedule.Compile()
+}
@@ -0,0 +1,159 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"dtsync/pkg/args"
+	"dtsync/pkg/schedule"
+	"dtsync/pkg/screen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule(t *testing.T) {
+	t.Parallel()
+
+	t.Run("SkipWhileRunning", func(t *testing.T) {
+		t.Parallel()
+
+		started := make(chan struct{}, 10)
+		proceed := make(chan struct{})
+		run := func(ctx context.Context, _ args.Arguments, _ func(screen.Status), _ func(context.Context) error) error {
+			started <- struct{}{}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-proceed:
+				return nil
+			}
+		}
+
+		daemon, err := New(run, "")
+		assert.NoError(t, err)
+
+		daemon.tick = 10 * time.Millisecond
+		assert.NoError(t, daemon.PutJob(Job{
+			Name: "job", Args: []string{"-src", "a", "-dst", "b"},
+			Schedule: &schedule.Spec{Every: schedule.Duration(50 * time.Millisecond)},
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go daemon.Schedule(ctx)
+
+		<-started
+
+		assert.Eventually(t, func() bool {
+			runs := daemon.Runs()
+
+			return len(runs) >= 2 && runs[0].State == RunSkipped
+		}, time.Second, 10*time.Millisecond)
+
+		close(proceed)
+
+		assert.Eventually(t, func() bool {
+			for _, run := range daemon.Runs() {
+				if run.State == RunSucceeded {
+					return true
+				}
+			}
+
+			return false
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("CatchUp", func(t *testing.T) {
+		t.Parallel()
+
+		t.Cleanup(func() {
+			os.RemoveAll("test_schedule_catch_up")
+		})
+		assert.NoError(t, os.Mkdir("test_schedule_catch_up", 0o755))
+
+		// the last run was two hours ago, so the hourly job missed a start while the daemon was stopped
+		job := func(catchUp bool) Job {
+			return Job{
+				Name: "job", Args: []string{"-src", "a", "-dst", "b"},
+				Schedule: &schedule.Spec{Every: schedule.Duration(time.Hour), CatchUp: catchUp},
+			}
+		}
+		started := time.Now().Add(-2 * time.Hour)
+		saved := state{Jobs: []Job{job(true)}, Runs: []Run{{ID: "1", Job: "job", State: RunSucceeded, Started: started}}}
+
+		data, err := json.Marshal(saved)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile("test_schedule_catch_up/state.json", data, 0o600))
+
+		done := make(chan struct{}, 1)
+		run := func(context.Context, args.Arguments, func(screen.Status), func(context.Context) error) error {
+			done <- struct{}{}
+
+			return nil
+		}
+
+		daemon, err := New(run, "test_schedule_catch_up/state.json")
+		assert.NoError(t, err)
+
+		daemon.tick = 10 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go daemon.Schedule(ctx)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			assert.Fail(t, "missed run not caught up")
+		}
+
+		// without catch up the job waits for its next start
+		now := time.Now()
+		assert.Equal(t, now.Add(time.Hour), daemon.firstDue(job(false), now).next)
+	})
+
+	t.Run("PauseOutsideWindow", func(t *testing.T) {
+		t.Parallel()
+
+		// a window on every day but today
+		var days []string
+		for i := 1; i < 7; i++ {
+			days = append(days, time.Now().AddDate(0, 0, i).Weekday().String()[:3])
+		}
+
+		waited := make(chan error)
+		run := func(ctx context.Context, _ args.Arguments, _ func(screen.Status), wait func(context.Context) error) error {
+			err := wait(ctx)
+			waited <- err
+
+			return err
+		}
+
+		daemon, err := New(run, "")
+		assert.NoError(t, err)
+		assert.NoError(t, daemon.PutJob(Job{
+			Name: "job", Args: []string{"-src", "a", "-dst", "b"},
+			Schedule: &schedule.Spec{Windows: []string{strings.Join(days, ",") + " 00:00-24:00"}},
+		}))
+
+		started, err := daemon.Start("job")
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			run, _ := daemon.Run(started.ID)
+
+			return run.Paused
+		}, time.Second, 10*time.Millisecond)
+
+		go daemon.Cancel(started.ID)
+
+		assert.ErrorIs(t, <-waited, context.Canceled)
+	})
+}
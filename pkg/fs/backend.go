@@ -0,0 +1,499 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backend abstracts the file system the operations and the scanner work on.
+type Backend interface {
+	// Stat returns the state of a file or directory, following symlinks.
+	Stat(name string) (fs.FileInfo, error)
+	// Lstat returns the state of a file or directory without following symlinks.
+	Lstat(name string) (fs.FileInfo, error)
+	// ReadDir returns the entries of a directory sorted by name.
+	ReadDir(name string) ([]fs.DirEntry, error)
+	// Open opens a file for reading.
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates a file for writing.
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+	// Mkdir creates a directory.
+	Mkdir(name string, perm fs.FileMode) error
+	// RemoveAll removes a file or directory (recursively).
+	RemoveAll(name string) error
+	// Rename renames a file or directory, replacing an existing file.
+	Rename(oldName, newName string) error
+	// Chmod changes the mode.
+	Chmod(name string, mode fs.FileMode) error
+	// Chtimes changes the access and modify time.
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// PartialPrefix marks temporary files of unfinished transfers, which are skipped by the scanner in all directories.
+const PartialPrefix = ReservedPrefix + "partial-"
+
+// Patcher is implemented by backends that can update an existing file
+// by transferring only the blocks that differ from the source.
+type Patcher interface {
+	// Patch replaces the content of the existing file with the source.
+	Patch(name string, source io.Reader, perm fs.FileMode) error
+}
+
+// RandomAccessFile is an existing file which is read and written at offsets.
+type RandomAccessFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Truncate(size int64) error
+}
+
+// RandomAccessBackend is implemented by backends that can update files in place.
+type RandomAccessBackend interface {
+	// OpenFile opens an existing file for reading and writing without truncating it.
+	OpenFile(name string) (RandomAccessFile, error)
+}
+
+// Chowner is implemented by backends that can change the owner of files, like the local file system.
+type Chowner interface {
+	// Chown changes the owner and group.
+	Chown(name string, uid, gid int) error
+}
+
+// Mknoder is implemented by backends that can create FIFOs and device files, like the local file system.
+type Mknoder interface {
+	// Mknod creates a FIFO or device file of the type and permissions of the mode with the device number.
+	Mknod(name string, mode fs.FileMode, dev uint64) error
+}
+
+// Symlinker is implemented by backends that can create symlinks.
+type Symlinker interface {
+	// Symlink creates newName as a symlink to oldName.
+	Symlink(oldName, newName string) error
+}
+
+// XattrBackend is implemented by backends that can set extended attributes, like the local file system.
+type XattrBackend interface {
+	// SetXattr sets the extended attribute of the file.
+	SetXattr(name, attr string, value []byte) error
+}
+
+// TimedCreator is implemented by backends which store the modify time of a created file with its content,
+// like object storages, where a later Chtimes rewrites the whole object.
+type TimedCreator interface {
+	// CreateWithTimes creates or truncates a file for writing, which gets the modify time.
+	CreateWithTimes(name string, perm fs.FileMode, mtime time.Time) (io.WriteCloser, error)
+}
+
+// Aborter is implemented by the writers of backends which publish the file on close, like uploads.
+type Aborter interface {
+	// Abort discards everything written because of the error instead of publishing it, Close returns the error.
+	Abort(err error)
+}
+
+// LocalBackend is the backend of the local file system.
+type LocalBackend struct{}
+
+// Stat returns the state of a file or directory, following symlinks.
+func (LocalBackend) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Lstat returns the state of a file or directory without following symlinks.
+func (LocalBackend) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// ReadDir returns the entries of a directory sorted by name.
+func (LocalBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// Open opens a file for reading.
+func (LocalBackend) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// Create creates or truncates a file for writing.
+func (LocalBackend) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+// OpenFile opens an existing file for reading and writing without truncating it.
+func (LocalBackend) OpenFile(name string) (RandomAccessFile, error) {
+	return os.OpenFile(name, os.O_RDWR, 0)
+}
+
+// Mkdir creates a directory.
+func (LocalBackend) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+// RemoveAll removes a file or directory (recursively).
+func (LocalBackend) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+// Rename renames a file or directory, replacing an existing file.
+func (LocalBackend) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+// Chmod changes the mode.
+func (LocalBackend) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Chtimes changes the access and modify time.
+func (LocalBackend) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// Chown changes the owner and group.
+func (LocalBackend) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+// Symlink creates newName as a symlink to oldName.
+func (LocalBackend) Symlink(oldName, newName string) error {
+	return os.Symlink(oldName, newName)
+}
+
+// Mux routes paths to the backend mounted on the longest matching root
+// and all other paths to the local file system.
+// Roots are cleaned like the paths joined by the scanner, so `sftp://host/path`
+// is mounted as `sftp:/host/path`.
+type Mux struct {
+	mounts []mount
+}
+
+type mount struct {
+	root    string
+	base    string
+	backend Backend
+}
+
+// NewMux creates a mux that routes everything to the local file system.
+func NewMux() *Mux {
+	return &Mux{}
+}
+
+// Mount routes all paths below root to the backend, relative to the base path of the backend.
+func (m *Mux) Mount(root, base string, backend Backend) {
+	m.mounts = append(m.mounts, mount{root: filepath.Clean(root), base: base, backend: backend})
+
+	sort.Slice(m.mounts, func(i, j int) bool {
+		return len(m.mounts[i].root) > len(m.mounts[j].root)
+	})
+}
+
+// Resolve returns the backend and the path within the backend.
+func (m *Mux) Resolve(name string) (Backend, string) {
+	cleaned := filepath.Clean(name)
+
+	for _, mount := range m.mounts {
+		if cleaned == mount.root {
+			return mount.backend, mount.base
+		} else if strings.HasPrefix(cleaned, mount.root+string(filepath.Separator)) {
+			rel := filepath.ToSlash(strings.TrimPrefix(cleaned, mount.root+string(filepath.Separator)))
+
+			return mount.backend, path.Join(mount.base, rel)
+		}
+	}
+
+	return LocalBackend{}, name
+}
+
+// Within reports whether the path is the parent path or below it.
+func Within(path, parent string) bool {
+	return path == parent || strings.HasPrefix(path, strings.TrimSuffix(parent, string(filepath.Separator))+
+		string(filepath.Separator))
+}
+
+// Stat returns the state of a file or directory, following symlinks.
+func (m *Mux) Stat(name string) (fs.FileInfo, error) {
+	backend, name := m.Resolve(name)
+
+	return backend.Stat(name)
+}
+
+// Lstat returns the state of a file or directory without following symlinks.
+func (m *Mux) Lstat(name string) (fs.FileInfo, error) {
+	backend, name := m.Resolve(name)
+
+	return backend.Lstat(name)
+}
+
+// ReadDir returns the entries of a directory sorted by name.
+func (m *Mux) ReadDir(name string) ([]fs.DirEntry, error) {
+	backend, name := m.Resolve(name)
+
+	return backend.ReadDir(name)
+}
+
+// Open opens a file for reading.
+func (m *Mux) Open(name string) (io.ReadCloser, error) {
+	backend, name := m.Resolve(name)
+
+	return backend.Open(name)
+}
+
+// Create creates or truncates a file for writing.
+func (m *Mux) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	backend, name := m.Resolve(name)
+
+	return backend.Create(name, perm)
+}
+
+// Mkdir creates a directory.
+func (m *Mux) Mkdir(name string, perm fs.FileMode) error {
+	backend, name := m.Resolve(name)
+
+	return backend.Mkdir(name, perm)
+}
+
+// RemoveAll removes a file or directory (recursively).
+func (m *Mux) RemoveAll(name string) error {
+	backend, name := m.Resolve(name)
+
+	return backend.RemoveAll(name)
+}
+
+// Rename renames a file or directory, both paths must be on the same backend.
+func (m *Mux) Rename(oldName, newName string) error {
+	backend, oldName := m.Resolve(oldName)
+	_, newName = m.Resolve(newName)
+
+	return backend.Rename(oldName, newName)
+}
+
+// Chmod changes the mode.
+func (m *Mux) Chmod(name string, mode fs.FileMode) error {
+	backend, name := m.Resolve(name)
+
+	return backend.Chmod(name, mode)
+}
+
+// Chtimes changes the access and modify time.
+func (m *Mux) Chtimes(name string, atime, mtime time.Time) error {
+	backend, name := m.Resolve(name)
+
+	return backend.Chtimes(name, atime, mtime)
+}
+
+// Granularity returns the precision of the modify times stored by the backend for the given path.
+// Backends that do not store nanoseconds report it by implementing `Granularity() time.Duration`.
+func Granularity(backend Backend, name string) time.Duration {
+	if mux, ok := backend.(*Mux); ok {
+		backend, _ = mux.Resolve(name)
+	}
+
+	if precision, ok := backend.(interface{ Granularity() time.Duration }); ok {
+		return precision.Granularity()
+	}
+
+	return 0
+}
+
+// patcher returns the patcher of the backend for the given path and the path within it, nil if not supported.
+func patcher(backend Backend, name string) (Patcher, string) {
+	if mux, ok := backend.(*Mux); ok {
+		backend, name = mux.Resolve(name)
+	}
+
+	if patcher, ok := backend.(Patcher); ok {
+		return patcher, name
+	}
+
+	return nil, name
+}
+
+// randomAccess returns the random access backend for the given path and the path within it, nil if not supported.
+func randomAccess(backend Backend, name string) (RandomAccessBackend, string) {
+	if mux, ok := backend.(*Mux); ok {
+		backend, name = mux.Resolve(name)
+	}
+
+	if randomAccess, ok := backend.(RandomAccessBackend); ok {
+		return randomAccess, name
+	}
+
+	return nil, name
+}
+
+// chowner returns the chowner of the backend for the given path and the path within it, nil if not supported.
+func chowner(backend Backend, name string) (Chowner, string) {
+	if mux, ok := backend.(*Mux); ok {
+		backend, name = mux.Resolve(name)
+	}
+
+	if chowner, ok := backend.(Chowner); ok {
+		return chowner, name
+	}
+
+	return nil, name
+}
+
+// mknoder returns the mknoder of the backend for the given path and the path within it, nil if not supported.
+func mknoder(backend Backend, name string) (Mknoder, string) {
+	if mux, ok := backend.(*Mux); ok {
+		backend, name = mux.Resolve(name)
+	}
+
+	if mknoder, ok := backend.(Mknoder); ok {
+		return mknoder, name
+	}
+
+	return nil, name
+}
+
+// timedCreator returns the timed creator of the backend for the given path and the path within it,
+// nil if not supported.
+func timedCreator(backend Backend, name string) (TimedCreator, string) {
+	if mux, ok := backend.(*Mux); ok {
+		backend, name = mux.Resolve(name)
+	}
+
+	if creator, ok := backend.(TimedCreator); ok {
+		return creator, name
+	}
+
+	return nil, name
+}
+
+// abort discards the file being written because of the error, writers which can't abort are closed.
+func abort(writer io.WriteCloser, err error) {
+	if aborter, ok := writer.(Aborter); ok {
+		aborter.Abort(err)
+	} else {
+		writer.Close()
+	}
+}
+
+// ReadFile reads the whole file.
+func ReadFile(backend Backend, name string) ([]byte, error) {
+	file, err := backend.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// WriteFile writes the whole file.
+func WriteFile(backend Backend, name string, data []byte, perm fs.FileMode) error {
+	file, err := backend.Create(name, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
+
+// MkdirAll creates a directory and all missing parents.
+func MkdirAll(backend Backend, name string, perm fs.FileMode) error {
+	if mux, ok := backend.(*Mux); ok {
+		backend, name = mux.Resolve(name)
+	}
+
+	if state, err := backend.Stat(name); err == nil {
+		if !state.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+		}
+
+		return nil
+	}
+
+	if parent := filepath.Dir(name); parent != name {
+		if err := MkdirAll(backend, parent, perm); err != nil {
+			return err
+		}
+	}
+
+	if err := backend.Mkdir(name, perm); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	return nil
+}
+
+// backendFS exposes a directory of a backend as fs.FS, so it can be walked with fs.WalkDir.
+type backendFS struct {
+	backend Backend
+	root    string
+}
+
+func (b backendFS) path(name string) string {
+	if name == "." {
+		return b.root
+	}
+
+	return filepath.Join(b.root, filepath.FromSlash(name))
+}
+
+func (b backendFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	state, err := b.backend.Stat(b.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	file := &backendFile{state: state}
+	if !state.IsDir() {
+		if file.ReadCloser, err = b.backend.Open(b.path(name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+func (b backendFS) Stat(name string) (fs.FileInfo, error) {
+	return b.backend.Stat(b.path(name))
+}
+
+func (b backendFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return b.backend.ReadDir(b.path(name))
+}
+
+type backendFile struct {
+	io.ReadCloser
+	state fs.FileInfo
+}
+
+func (b *backendFile) Stat() (fs.FileInfo, error) {
+	return b.state, nil
+}
+
+func (b *backendFile) Read(data []byte) (int, error) {
+	if b.ReadCloser == nil {
+		return 0, &fs.PathError{Op: "read", Path: b.state.Name(), Err: fs.ErrInvalid}
+	}
+
+	return b.ReadCloser.Read(data)
+}
+
+func (b *backendFile) Close() error {
+	if b.ReadCloser == nil {
+		return nil
+	}
+
+	return b.ReadCloser.Close()
+}
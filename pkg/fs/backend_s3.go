@@ -0,0 +1,500 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// S3Scheme is the URL scheme of S3 locations.
+	S3Scheme = "s3"
+	// DefaultS3Endpoint is the endpoint of AWS S3.
+	DefaultS3Endpoint = "s3.amazonaws.com"
+
+	s3ModeKey       = "Mode"
+	s3ModTimeKey    = "Mtime"
+	s3MetadataKey   = "X-Amz-Meta-"
+	s3DirPerm       = 0o755
+	s3PartSize      = 16 << 20
+	s3MaxCopySize   = 5 << 30
+	s3NoSuchKeyCode = "NoSuchKey"
+)
+
+// errS3UploadAborted fails the reader of an aborted multipart upload.
+var errS3UploadAborted = errors.New("upload aborted")
+
+// S3Config configures the connection of the S3 backend.
+type S3Config struct {
+	// Endpoint is the host[:port] of the S3 API, prefix it with http:// to disable TLS.
+	Endpoint string
+	// Region of the bucket, looked up when empty.
+	Region string
+	// Profile of the shared credentials file, defaults to AWS_PROFILE or "default".
+	Profile string
+}
+
+// S3Location is a parsed `s3://bucket/prefix`.
+type S3Location struct {
+	Bucket string
+	Prefix string
+}
+
+// IsS3Location checks if a root path is an S3 URL.
+func IsS3Location(rootPath string) bool {
+	return strings.HasPrefix(rootPath, S3Scheme+"://")
+}
+
+// ParseS3Location parses a `s3://bucket/prefix` URL.
+func ParseS3Location(rootPath string) (S3Location, error) {
+	parsed, err := url.Parse(rootPath)
+	if err != nil {
+		return S3Location{}, fmt.Errorf("%w: %w", ErrInvalidLocation, err)
+	} else if parsed.Scheme != S3Scheme || parsed.Host == "" {
+		return S3Location{}, fmt.Errorf("%w: %s", ErrInvalidLocation, rootPath)
+	}
+
+	return S3Location{Bucket: parsed.Host, Prefix: strings.Trim(parsed.Path, "/")}, nil
+}
+
+// S3Backend is the backend of an S3 compatible object storage bucket.
+// Directories are implicit prefixes or empty `dir/` marker objects,
+// the mode and modify time are stored as object metadata.
+type S3Backend struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Backend creates a backend on an existing client.
+func NewS3Backend(client *minio.Client, bucket string) *S3Backend {
+	return &S3Backend{client: client, bucket: bucket}
+}
+
+// DialS3 creates a client authenticated with the credentials from the environment,
+// the shared credentials file or the instance role.
+func DialS3(location S3Location, config S3Config) (*S3Backend, error) {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultS3Endpoint
+	}
+
+	secure := !strings.HasPrefix(endpoint, "http://")
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{Profile: config.Profile},
+			&credentials.IAM{},
+		}),
+		Secure: secure,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewS3Backend(client, location.Bucket), nil
+}
+
+// Stat returns the state of an object or a prefix.
+func (s *S3Backend) Stat(name string) (fs.FileInfo, error) {
+	key := s3Key(name)
+	if key == "" {
+		return s3FileInfo{name: ".", mode: fs.ModeDir | s3DirPerm}, nil
+	}
+
+	info, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return newS3FileInfo(key, info), nil
+	} else if !isS3NotFound(err) {
+		return nil, err
+	}
+
+	if info, err = s.client.StatObject(context.Background(), s.bucket, key+"/", minio.StatObjectOptions{}); err == nil {
+		return newS3FileInfo(key, info), nil
+	} else if !isS3NotFound(err) {
+		return nil, err
+	}
+
+	objects, stop := s.list(key+"/", false, 1)
+	defer stop()
+
+	for object := range objects {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		return s3FileInfo{name: path.Base(key), mode: fs.ModeDir | s3DirPerm}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// Lstat is the same as Stat, objects have no symlinks.
+func (s *S3Backend) Lstat(name string) (fs.FileInfo, error) {
+	return s.Stat(name)
+}
+
+// ReadDir returns the objects and prefixes below the prefix sorted by name.
+func (s *S3Backend) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := s3Key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var entries []fs.DirEntry
+
+	objects, stop := s.list(prefix, false, 0)
+	defer stop()
+
+	for object := range objects {
+		if object.Err != nil {
+			return nil, object.Err
+		} else if object.Key == prefix {
+			continue
+		}
+
+		entries = append(entries, s3DirEntry{
+			backend: s,
+			key:     strings.TrimSuffix(object.Key, "/"),
+			isDir:   strings.HasSuffix(object.Key, "/"),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// Open opens an object for reading.
+func (s *S3Backend) Open(name string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(context.Background(), s.bucket, s3Key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// errors are reported lazily, stat to fail early for missing objects
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+
+		return nil, s3Error("open", name, err)
+	}
+
+	return object, nil
+}
+
+// Create uploads everything written into the returned writer, with the current time as modify time.
+// Small objects are uploaded at once on close, large ones are streamed as multipart upload.
+// Aborted uploads leave the existing object as it is.
+func (s *S3Backend) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	return s.CreateWithTimes(name, perm, time.Now())
+}
+
+// CreateWithTimes uploads like Create with the modify time in the metadata,
+// which spares copying the object onto itself to set it.
+func (s *S3Backend) CreateWithTimes(name string, perm fs.FileMode, mtime time.Time) (io.WriteCloser, error) {
+	return &s3Upload{backend: s, key: s3Key(name), metadata: s3Metadata(perm, mtime)}, nil
+}
+
+// Mkdir creates a directory marker object.
+func (s *S3Backend) Mkdir(name string, perm fs.FileMode) error {
+	if _, err := s.Stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+
+	return s.putMarker(s3Key(name), s3Metadata(perm, time.Now()))
+}
+
+// RemoveAll removes the object and everything below the prefix.
+func (s *S3Backend) RemoveAll(name string) error {
+	key := s3Key(name)
+
+	objects, stop := s.list(key+"/", true, 0)
+	defer stop()
+
+	for object := range objects {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		if err := s.client.RemoveObject(context.Background(), s.bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+
+	for _, object := range []string{key + "/", key} {
+		if err := s.client.RemoveObject(context.Background(), s.bucket, object, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rename copies the objects server side to the new name and removes the old ones.
+func (s *S3Backend) Rename(oldName, newName string) error {
+	oldKey, newKey := s3Key(oldName), s3Key(newName)
+
+	state, err := s.Stat(oldName)
+	if err != nil {
+		return err
+	} else if !state.IsDir() {
+		if err := s.copy(oldKey, newKey, nil); err != nil {
+			return err
+		}
+
+		return s.client.RemoveObject(context.Background(), s.bucket, oldKey, minio.RemoveObjectOptions{})
+	}
+
+	objects, stop := s.list(oldKey+"/", true, 0)
+	defer stop()
+
+	for object := range objects {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		if err := s.copy(object.Key, newKey+strings.TrimPrefix(object.Key, oldKey), nil); err != nil {
+			return err
+		}
+	}
+
+	return s.RemoveAll(oldName)
+}
+
+// Chmod replaces the mode in the metadata.
+func (s *S3Backend) Chmod(name string, mode fs.FileMode) error {
+	return s.updateMetadata(name, func(state fs.FileInfo) map[string]string {
+		return s3Metadata(mode, state.ModTime())
+	})
+}
+
+// Chtimes replaces the modify time in the metadata, the access time is not stored.
+func (s *S3Backend) Chtimes(name string, _, mtime time.Time) error {
+	return s.updateMetadata(name, func(state fs.FileInfo) map[string]string {
+		return s3Metadata(state.Mode(), mtime)
+	})
+}
+
+// updateMetadata copies the object (or directory marker) onto itself with replaced metadata.
+func (s *S3Backend) updateMetadata(name string, metadata func(fs.FileInfo) map[string]string) error {
+	state, err := s.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	key := s3Key(name)
+	if !state.IsDir() {
+		return s.copy(key, key, metadata(state))
+	}
+
+	return s.putMarker(key, metadata(state))
+}
+
+// putMarker writes the empty `dir/` object holding the metadata of a directory.
+func (s *S3Backend) putMarker(key string, metadata map[string]string) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, key+"/", bytes.NewReader(nil), 0,
+		minio.PutObjectOptions{UserMetadata: metadata, DisableContentSha256: true})
+
+	return err
+}
+
+// copy copies an object server side, objects larger than 5GiB are copied in parts.
+func (s *S3Backend) copy(srcKey, dstKey string, metadata map[string]string) error {
+	info, err := s.client.StatObject(context.Background(), s.bucket, srcKey, minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	dst := minio.CopyDestOptions{
+		Bucket: s.bucket, Object: dstKey,
+		ReplaceMetadata: metadata != nil, UserMetadata: metadata,
+	}
+	src := minio.CopySrcOptions{Bucket: s.bucket, Object: srcKey}
+
+	if info.Size > s3MaxCopySize {
+		_, err = s.client.ComposeObject(context.Background(), dst, src)
+	} else {
+		_, err = s.client.CopyObject(context.Background(), dst, src)
+	}
+
+	return err
+}
+
+// list lists the objects below the prefix. The returned function stops the listing and drains the channel,
+// the callers defer it, since the lister blocks on the channel when they return before its end.
+func (s *S3Backend) list(prefix string, recursive bool, maxKeys int) (<-chan minio.ObjectInfo, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix: prefix, Recursive: recursive, MaxKeys: maxKeys,
+	})
+
+	return objects, func() {
+		cancel()
+
+		for range objects { //nolint:revive
+		}
+	}
+}
+
+// s3Upload buffers the first part and switches to a streamed multipart upload when it is exceeded.
+type s3Upload struct {
+	backend  *S3Backend
+	key      string
+	metadata map[string]string
+	buf      bytes.Buffer
+	pipe     *io.PipeWriter
+	done     chan error
+	once     sync.Once
+	result   error
+}
+
+func (s *s3Upload) Write(data []byte) (int, error) {
+	if s.pipe != nil {
+		return s.pipe.Write(data)
+	} else if s.buf.Len()+len(data) <= s3PartSize {
+		return s.buf.Write(data)
+	}
+
+	reader, writer := io.Pipe()
+	s.pipe, s.done = writer, make(chan error, 1)
+
+	go func() {
+		_, err := s.backend.client.PutObject(context.Background(), s.backend.bucket, s.key, reader, -1,
+			minio.PutObjectOptions{UserMetadata: s.metadata, PartSize: s3PartSize})
+		reader.CloseWithError(err)
+		s.done <- err
+	}()
+
+	if _, err := s.pipe.Write(s.buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	s.buf.Reset()
+
+	return s.pipe.Write(data)
+}
+
+// Abort discards the upload, the multipart upload is aborted by failing its reader.
+// The error is wrapped, the client takes io.EOF and io.ErrUnexpectedEOF as the end of the last part.
+func (s *s3Upload) Abort(err error) {
+	s.once.Do(func() {
+		s.result = fmt.Errorf("%w: %w", errS3UploadAborted, err)
+		if s.pipe != nil {
+			s.pipe.CloseWithError(s.result)
+			<-s.done
+		}
+	})
+}
+
+// Close finishes the upload.
+func (s *s3Upload) Close() error {
+	s.once.Do(func() {
+		if s.pipe == nil {
+			_, s.result = s.backend.client.PutObject(context.Background(), s.backend.bucket, s.key,
+				bytes.NewReader(s.buf.Bytes()), int64(s.buf.Len()),
+				minio.PutObjectOptions{UserMetadata: s.metadata, DisableContentSha256: true})
+		} else if s.result = s.pipe.Close(); s.result == nil {
+			s.result = <-s.done
+		}
+	})
+
+	return s.result
+}
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newS3FileInfo(key string, info minio.ObjectInfo) s3FileInfo {
+	state := s3FileInfo{name: path.Base(key), size: info.Size, modTime: info.LastModified, mode: s3DirPerm}
+
+	if mode, err := strconv.ParseUint(info.Metadata.Get(s3MetadataKey+s3ModeKey), 8, 32); err == nil {
+		state.mode = fs.FileMode(mode).Perm()
+	}
+
+	if modTime, err := time.Parse(time.RFC3339Nano, info.Metadata.Get(s3MetadataKey+s3ModTimeKey)); err == nil {
+		state.modTime = modTime
+	}
+
+	if strings.HasSuffix(info.Key, "/") {
+		state.mode |= fs.ModeDir
+		state.size = 0
+	}
+
+	return state
+}
+
+func (s s3FileInfo) Name() string       { return s.name }
+func (s s3FileInfo) Size() int64        { return s.size }
+func (s s3FileInfo) Mode() fs.FileMode  { return s.mode }
+func (s s3FileInfo) ModTime() time.Time { return s.modTime }
+func (s s3FileInfo) IsDir() bool        { return s.mode.IsDir() }
+func (s s3FileInfo) Sys() any           { return nil }
+
+// s3DirEntry fetches the metadata of the object only when requested.
+type s3DirEntry struct {
+	backend *S3Backend
+	key     string
+	isDir   bool
+}
+
+func (s s3DirEntry) Name() string { return path.Base(s.key) }
+func (s s3DirEntry) IsDir() bool  { return s.isDir }
+
+func (s s3DirEntry) Type() fs.FileMode {
+	if s.isDir {
+		return fs.ModeDir
+	}
+
+	return 0
+}
+
+func (s s3DirEntry) Info() (fs.FileInfo, error) {
+	return s.backend.Stat(s.key)
+}
+
+func s3Key(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+func s3Metadata(mode fs.FileMode, modTime time.Time) map[string]string {
+	return map[string]string{
+		s3ModeKey:    strconv.FormatUint(uint64(mode.Perm()), 8),
+		s3ModTimeKey: modTime.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func isS3NotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == s3NoSuchKeyCode || minio.ToErrorResponse(err).StatusCode == http.StatusNotFound
+}
+
+func s3Error(op, name string, err error) error {
+	if isS3NotFound(err) {
+		return &fs.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+
+	return err
+}
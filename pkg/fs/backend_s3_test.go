@@ -0,0 +1,362 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3Backend(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_s3")
+	})
+	assert.NoError(t, os.MkdirAll("test_s3/dir/empty", 0o750))
+	createTestFile(t, "test_s3/dir/file.txt", 0o640, time.Now().Add(-time.Hour), []byte("hello"))
+	createTestFile(t, "test_s3/top.txt", 0o600, time.Now().Add(-time.Hour), []byte("world"))
+
+	backend := newInMemoryS3(t, "bucket")
+	mux := NewMux()
+	mux.Mount("s3://bucket/mirror", "mirror", backend)
+
+	operation := NewOperation(WithBackend(mux))
+
+	t.Run("Upload", func(t *testing.T) {
+		err := <-NewShadowScan(WithScanBackend(mux)).Start(context.Background(), "test_s3", "s3://bucket/mirror",
+			func(srcPath, dstPath string) error {
+				return operation.Copy(srcPath, dstPath)
+			},
+			func(srcPath, dstPath string) error {
+				if operation.Exists(dstPath) {
+					return nil
+				}
+
+				return operation.Copy(srcPath, dstPath)
+			},
+		)
+		assert.ErrorIs(t, err, ErrScannerAtEnd)
+
+		data, err := ReadFile(mux, "s3:/bucket/mirror/dir/file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+		assert.True(t, operation.Equal("test_s3/dir/file.txt", "s3:/bucket/mirror/dir/file.txt"))
+		assert.True(t, operation.Equal("test_s3/dir/empty", "s3:/bucket/mirror/dir/empty"))
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		foundedFiles := map[string]string{}
+		foundedDirectories := map[string]string{}
+
+		err := <-NewShadowScan(WithScanBackend(mux)).Start(context.Background(), "s3://bucket/mirror", "dest",
+			func(srcPath, dstPath string) error {
+				foundedFiles[srcPath] = dstPath
+
+				return nil
+			},
+			func(srcPath, dstPath string) error {
+				foundedDirectories[srcPath] = dstPath
+
+				return nil
+			},
+		)
+		assert.ErrorIs(t, err, ErrScannerAtEnd)
+		assert.Equal(t, map[string]string{
+			"s3:/bucket/mirror/dir/file.txt": "dest/dir/file.txt",
+			"s3:/bucket/mirror/top.txt":      "dest/top.txt",
+		}, foundedFiles)
+		assert.Equal(t, map[string]string{
+			"s3://bucket/mirror": "dest", "s3:/bucket/mirror/dir": "dest/dir", "s3:/bucket/mirror/dir/empty": "dest/dir/empty",
+		}, foundedDirectories)
+	})
+
+	t.Run("Multipart", func(t *testing.T) {
+		data := bytes.Repeat([]byte("0123456789abcdef"), (s3PartSize+1)/16+1)
+		assert.NoError(t, WriteFile(mux, "s3:/bucket/mirror/large.bin", data, 0o644))
+
+		uploaded, err := ReadFile(mux, "s3:/bucket/mirror/large.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, uploaded)
+		assert.NoError(t, mux.RemoveAll("s3:/bucket/mirror/large.bin"))
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		assert.NoError(t, mux.Rename("s3:/bucket/mirror/top.txt", "s3:/bucket/mirror/renamed.txt"))
+		assert.False(t, operation.Exists("s3:/bucket/mirror/top.txt"))
+		assert.True(t, operation.Equal("test_s3/top.txt", "s3:/bucket/mirror/renamed.txt"))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, operation.Delete("s3:/bucket/mirror/dir"))
+		assert.False(t, operation.Exists("s3:/bucket/mirror/dir"))
+		assert.False(t, operation.Exists("s3:/bucket/mirror/dir/file.txt"))
+	})
+}
+
+func TestS3Upload(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_s3_upload")
+	})
+	assert.NoError(t, os.Mkdir("test_s3_upload", 0o755))
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	createTestFile(t, "test_s3_upload/file.txt", 0o640, modTime, []byte("hello"))
+
+	backend, fake := newFakeS3(t, "bucket")
+	mux := NewMux()
+	mux.Mount("s3://bucket", "", backend)
+
+	t.Run("Times", func(t *testing.T) {
+		assert.NoError(t, NewOperation(WithBackend(mux)).Copy("test_s3_upload/file.txt", "s3:/bucket/file.txt"))
+
+		state, err := mux.Stat("s3:/bucket/file.txt")
+		assert.NoError(t, err)
+		assert.True(t, modTime.Equal(state.ModTime()))
+		assert.Zero(t, fake.copies, "the times are uploaded with the object")
+	})
+
+	for name, size := range map[string]int{"Small": 5, "Multipart": s3PartSize + 1} {
+		t.Run("Abort"+name, func(t *testing.T) {
+			upload, err := mux.Create("s3:/bucket/file.txt", 0o644)
+			assert.NoError(t, err)
+
+			_, err = upload.Write(bytes.Repeat([]byte("x"), size))
+			assert.NoError(t, err)
+
+			upload.(Aborter).Abort(io.ErrUnexpectedEOF)
+			assert.ErrorIs(t, upload.Close(), io.ErrUnexpectedEOF)
+
+			data, err := ReadFile(mux, "s3:/bucket/file.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(data), "the existing object is kept")
+		})
+	}
+}
+
+// TestS3StatDirectory is not parallel, it counts the goroutines.
+func TestS3StatDirectory(t *testing.T) {
+	backend, fake := newFakeS3(t, "bucket")
+
+	fake.lock.Lock()
+	for _, key := range []string{"dir/a.txt", "dir/b.txt", "dir/c.txt"} {
+		fake.objects[key] = fakeS3Object{modified: time.Now()}
+	}
+	fake.lock.Unlock()
+
+	before := runtime.NumGoroutine()
+
+	// the listing of the implicit directory has more objects than Stat reads
+	for i := 0; i < 20; i++ {
+		state, err := backend.Stat("dir")
+		assert.NoError(t, err)
+		assert.True(t, state.IsDir())
+	}
+
+	assert.Eventually(t, func() bool {
+		return runtime.NumGoroutine() < before+10
+	}, time.Second, 10*time.Millisecond, "the listings are stopped")
+}
+
+func TestParseS3Location(t *testing.T) {
+	t.Parallel()
+
+	location, err := ParseS3Location("s3://bucket/some/prefix/")
+	assert.NoError(t, err)
+	assert.Equal(t, S3Location{Bucket: "bucket", Prefix: "some/prefix"}, location)
+
+	_, err = ParseS3Location("s3:///prefix")
+	assert.ErrorIs(t, err, ErrInvalidLocation)
+}
+
+// newInMemoryS3 connects a client to an in-memory S3 server.
+func newInMemoryS3(t *testing.T, bucket string) *S3Backend {
+	t.Helper()
+
+	backend, _ := newFakeS3(t, bucket)
+
+	return backend
+}
+
+// newFakeS3 connects a client to an in-memory S3 server and returns the server too.
+func newFakeS3(t *testing.T, bucket string) (*S3Backend, *fakeS3) {
+	t.Helper()
+
+	fake := &fakeS3{bucket: bucket, objects: map[string]fakeS3Object{}, uploads: map[string]fakeS3Object{}}
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := minio.New(strings.TrimPrefix(server.URL, "https://"), &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Secure:       true,
+		Transport:    server.Client().Transport,
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+	})
+	assert.NoError(t, err)
+
+	return NewS3Backend(client, bucket), fake
+}
+
+// fakeS3 implements the subset of the S3 API used by the backend for a single bucket,
+// parts of multipart uploads must be uploaded in order and signatures are not checked.
+type fakeS3 struct {
+	lock    sync.Mutex
+	bucket  string
+	objects map[string]fakeS3Object
+	uploads map[string]fakeS3Object
+	copies  int
+}
+
+type fakeS3Object struct {
+	data     []byte
+	metadata http.Header
+	modified time.Time
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+f.bucket), "/")
+
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, query.Get("prefix"), query.Get("delimiter"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.uploads[key] = fakeS3Object{metadata: userMetadata(r.Header)}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		data, _ := io.ReadAll(r.Body)
+		upload := f.uploads[key]
+		upload.data = append(upload.data, data...)
+		f.uploads[key] = upload
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		upload := f.uploads[key]
+		upload.modified = time.Now()
+		f.objects[key] = upload
+		delete(f.uploads, key)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>etag</ETag>"+
+			"</CompleteMultipartUploadResult>", f.bucket, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copies++
+		f.copy(w, r, key)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = fakeS3Object{data: data, metadata: userMetadata(r.Header), modified: time.Now()}
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+
+			return
+		}
+
+		for name, values := range object.metadata {
+			w.Header()[name] = values
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object.data)
+		}
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, delimiter string) {
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	result := "<ListBucketResult><Name>" + f.bucket + "</Name><IsTruncated>false</IsTruncated>"
+	prefixes := map[string]bool{}
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := key[:len(prefix)+i+1]
+			if !prefixes[commonPrefix] {
+				prefixes[commonPrefix] = true
+				result += "<CommonPrefixes><Prefix>" + commonPrefix + "</Prefix></CommonPrefixes>"
+			}
+
+			continue
+		}
+
+		result += fmt.Sprintf("<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>",
+			key, len(f.objects[key].data), f.objects[key].modified.UTC().Format(time.RFC3339))
+	}
+
+	fmt.Fprint(w, result+"</ListBucketResult>")
+}
+
+func (f *fakeS3) copy(w http.ResponseWriter, r *http.Request, key string) {
+	source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+
+	object, ok := f.objects[strings.TrimPrefix(strings.TrimPrefix(source, "/"), f.bucket+"/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+
+		return
+	}
+
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		object.metadata = userMetadata(r.Header)
+	}
+
+	object.modified = time.Now()
+	f.objects[key] = object
+
+	fmt.Fprintf(w, `<CopyObjectResult><LastModified>%s</LastModified><ETag>"etag"</ETag></CopyObjectResult>`,
+		object.modified.UTC().Format(time.RFC3339))
+}
+
+func userMetadata(header http.Header) http.Header {
+	metadata := http.Header{}
+
+	for name, values := range header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			metadata[name] = values
+		}
+	}
+
+	return metadata
+}
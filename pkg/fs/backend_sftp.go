@@ -0,0 +1,308 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPScheme is the URL scheme of SFTP locations.
+const SFTPScheme = "sftp"
+
+var (
+	// ErrInvalidLocation is returned for malformed remote locations.
+	ErrInvalidLocation = errors.New("invalid location")
+	// ErrNoSSHAuth is returned when neither an agent nor a key file is available.
+	ErrNoSSHAuth = errors.New("no ssh agent or key file available")
+)
+
+// SSHConfig configures the SSH connection of remote backends.
+type SSHConfig struct {
+	// KeyFiles are private keys to authenticate with, defaults to the keys in ~/.ssh.
+	KeyFiles []string
+	// KnownHostsFile is used to verify the host key, defaults to ~/.ssh/known_hosts.
+	KnownHostsFile string
+	// Timeout of the connection establishment.
+	Timeout time.Duration
+}
+
+// Location is a parsed `sftp://user@host:port/path`.
+type Location struct {
+	User string
+	Host string
+	Port string
+	Path string
+}
+
+// IsSFTPLocation checks if a root path is an SFTP URL.
+func IsSFTPLocation(rootPath string) bool {
+	return strings.HasPrefix(rootPath, SFTPScheme+"://")
+}
+
+// ParseSFTPLocation parses a `sftp://user@host:port/path` URL.
+func ParseSFTPLocation(rootPath string) (Location, error) {
+	parsed, err := url.Parse(rootPath)
+	if err != nil {
+		return Location{}, fmt.Errorf("%w: %w", ErrInvalidLocation, err)
+	} else if parsed.Scheme != SFTPScheme || parsed.Hostname() == "" {
+		return Location{}, fmt.Errorf("%w: %s", ErrInvalidLocation, rootPath)
+	}
+
+	location := Location{User: parsed.User.Username(), Host: parsed.Hostname(), Port: parsed.Port(), Path: parsed.Path}
+
+	if location.Port == "" {
+		location.Port = "22"
+	}
+
+	if location.Path == "" {
+		location.Path = "."
+	}
+
+	if location.User == "" {
+		current, err := user.Current()
+		if err != nil {
+			return Location{}, err
+		}
+
+		location.User = current.Username
+	}
+
+	return location, nil
+}
+
+// SFTPBackend is the backend of a remote file system accessed via SFTP.
+type SFTPBackend struct {
+	client *sftp.Client
+	conn   io.Closer
+}
+
+// NewSFTPBackend creates a backend on an established SFTP client.
+func NewSFTPBackend(client *sftp.Client) *SFTPBackend {
+	return &SFTPBackend{client: client}
+}
+
+// DialSFTP connects to the host of the location, authenticating with the
+// SSH agent and the key files and verifying the host with the known hosts.
+func DialSFTP(location Location, config SSHConfig) (*SFTPBackend, error) {
+	clientConfig, agentConn, err := config.clientConfig(location.User)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(location.Host, location.Port), clientConfig)
+
+	// the agent signs only during the handshake
+	if agentConn != nil {
+		agentConn.Close()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return &SFTPBackend{client: client, conn: conn}, nil
+}
+
+// Close closes the SFTP session and the connection.
+func (s *SFTPBackend) Close() error {
+	err := s.client.Close()
+
+	if s.conn != nil {
+		return errors.Join(err, s.conn.Close())
+	}
+
+	return err
+}
+
+// Granularity returns the precision of the modify times, SFTP transfers them in seconds.
+func (s *SFTPBackend) Granularity() time.Duration {
+	return time.Second
+}
+
+// Space returns the free space of the remote file system, it needs the statvfs extension of OpenSSH.
+func (s *SFTPBackend) Space(name string) (Space, error) {
+	if _, ok := s.client.HasExtension("statvfs@openssh.com"); !ok {
+		return Space{}, errors.ErrUnsupported
+	}
+
+	stat, err := s.client.StatVFS(name)
+	if err != nil {
+		return Space{}, err
+	}
+
+	return Space{FreeBytes: stat.Bavail * stat.Frsize, Files: stat.Files, FreeFiles: stat.Favail}, nil
+}
+
+// Stat returns the state of a file or directory, following symlinks.
+func (s *SFTPBackend) Stat(name string) (fs.FileInfo, error) {
+	return s.client.Stat(name)
+}
+
+// Lstat returns the state of a file or directory without following symlinks.
+func (s *SFTPBackend) Lstat(name string) (fs.FileInfo, error) {
+	return s.client.Lstat(name)
+}
+
+// ReadDir returns the entries of a directory sorted by name.
+func (s *SFTPBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	states, err := s.client.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, len(states))
+	for i, state := range states {
+		entries[i] = fs.FileInfoToDirEntry(state)
+	}
+
+	return entries, nil
+}
+
+// Symlink creates newName as a symlink to oldName.
+func (s *SFTPBackend) Symlink(oldName, newName string) error {
+	return s.client.Symlink(oldName, newName)
+}
+
+// Open opens a file for reading.
+func (s *SFTPBackend) Open(name string) (io.ReadCloser, error) {
+	return s.client.Open(name)
+}
+
+// Create creates or truncates a file for writing.
+func (s *SFTPBackend) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	file, err := s.client.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Chmod(perm.Perm()); err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Mkdir creates a directory.
+func (s *SFTPBackend) Mkdir(name string, perm fs.FileMode) error {
+	if err := s.client.Mkdir(name); err != nil {
+		return err
+	}
+
+	return s.client.Chmod(name, perm.Perm())
+}
+
+// RemoveAll removes a file or directory (recursively).
+func (s *SFTPBackend) RemoveAll(name string) error {
+	return s.client.RemoveAll(name)
+}
+
+// Rename renames a file or directory, replacing an existing file.
+func (s *SFTPBackend) Rename(oldName, newName string) error {
+	return s.client.PosixRename(oldName, newName)
+}
+
+// Chmod changes the mode.
+func (s *SFTPBackend) Chmod(name string, mode fs.FileMode) error {
+	return s.client.Chmod(name, mode)
+}
+
+// Chtimes changes the access and modify time.
+func (s *SFTPBackend) Chtimes(name string, atime, mtime time.Time) error {
+	return s.client.Chtimes(name, atime, mtime)
+}
+
+// clientConfig builds the SSH client config with agent and key authentication.
+// It returns the connection to the agent, nil without one, which the caller closes after the handshake.
+func (c SSHConfig) clientConfig(username string) (*ssh.ClientConfig, net.Conn, error) {
+	home, _ := os.UserHomeDir()
+
+	knownHostsFile := c.KnownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		auth      []ssh.AuthMethod
+		signers   []ssh.Signer
+		agentConn net.Conn
+	)
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if agentConn, err = net.Dial("unix", socket); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+
+	fail := func(err error) (*ssh.ClientConfig, net.Conn, error) {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+
+		return nil, nil, err
+	}
+
+	keyFiles := c.KeyFiles
+	if len(keyFiles) == 0 {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			keyFiles = append(keyFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	for _, keyFile := range keyFiles {
+		data, err := os.ReadFile(keyFile)
+		if errors.Is(err, os.ErrNotExist) && len(c.KeyFiles) == 0 {
+			continue
+		} else if err != nil {
+			return fail(err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", keyFile, err))
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	if len(auth) == 0 {
+		return nil, nil, ErrNoSSHAuth
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         c.Timeout,
+	}, agentConn, nil
+}
@@ -0,0 +1,143 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSFTPBackend(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_sftp")
+	})
+	assert.NoError(t, os.MkdirAll("test_sftp/local/dir", 0o755))
+	assert.NoError(t, os.Mkdir("test_sftp/remote", 0o755))
+	createTestFile(t, "test_sftp/local/dir/file.txt", 0o640, time.Now().Add(-time.Hour), []byte("hello"))
+	createTestFile(t, "test_sftp/local/top.txt", 0o600, time.Now().Add(-time.Hour), []byte("world"))
+
+	backend := newInProcessSFTP(t)
+	mux := NewMux()
+	mux.Mount("sftp://user@localhost/test_sftp/remote", "test_sftp/remote", backend)
+
+	operation := NewOperation(WithBackend(mux))
+
+	t.Run("Upload", func(t *testing.T) {
+		err := <-NewShadowScan(WithScanBackend(mux)).Start(context.Background(), "test_sftp/local", "sftp://user@localhost/test_sftp/remote",
+			func(srcPath, dstPath string) error {
+				return operation.Copy(srcPath, dstPath)
+			},
+			func(srcPath, dstPath string) error {
+				if operation.Exists(dstPath) {
+					return nil
+				}
+
+				return operation.Copy(srcPath, dstPath)
+			},
+		)
+		assert.ErrorIs(t, err, ErrScannerAtEnd)
+
+		data, err := os.ReadFile("test_sftp/remote/dir/file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+		assert.True(t, operation.Equal("test_sftp/local/dir/file.txt", "sftp:/user@localhost/test_sftp/remote/dir/file.txt"))
+	})
+
+	t.Run("Download", func(t *testing.T) {
+		foundedFiles := map[string]string{}
+
+		err := <-NewShadowScan(WithScanBackend(mux)).Start(context.Background(), "sftp://user@localhost/test_sftp/remote", "dest",
+			func(srcPath, dstPath string) error {
+				foundedFiles[srcPath] = dstPath
+
+				return nil
+			},
+			func(srcPath, dstPath string) error {
+				return nil
+			},
+		)
+		assert.ErrorIs(t, err, ErrScannerAtEnd)
+		assert.Equal(t, map[string]string{
+			"sftp:/user@localhost/test_sftp/remote/dir/file.txt": "dest/dir/file.txt",
+			"sftp:/user@localhost/test_sftp/remote/top.txt":      "dest/top.txt",
+		}, foundedFiles)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, operation.Delete("sftp:/user@localhost/test_sftp/remote/dir"))
+		assert.False(t, operation.Exists("sftp:/user@localhost/test_sftp/remote/dir"))
+	})
+}
+
+func TestSSHAgentConn(t *testing.T) {
+	dir := t.TempDir()
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	assert.NoError(t, err)
+
+	defer listener.Close()
+
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(dir, "agent.sock"))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "known_hosts"), nil, 0o600))
+
+	config := SSHConfig{KnownHostsFile: filepath.Join(dir, "known_hosts"), KeyFiles: []string{filepath.Join(dir, "id_missing")}}
+
+	// the agent connection is closed when the config fails after it was dialed
+	_, agentConn, err := config.clientConfig("user")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Nil(t, agentConn)
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestParseSFTPLocation(t *testing.T) {
+	t.Parallel()
+
+	location, err := ParseSFTPLocation("sftp://backup@example.com:2222/srv/data")
+	assert.NoError(t, err)
+	assert.Equal(t, Location{User: "backup", Host: "example.com", Port: "2222", Path: "/srv/data"}, location)
+
+	location, err = ParseSFTPLocation("sftp://backup@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, Location{User: "backup", Host: "example.com", Port: "22", Path: "."}, location)
+
+	_, err = ParseSFTPLocation("sftp:///srv/data")
+	assert.ErrorIs(t, err, ErrInvalidLocation)
+}
+
+// newInProcessSFTP connects a client to an SFTP server serving the local file system over pipes.
+func newInProcessSFTP(t *testing.T) *SFTPBackend {
+	t.Helper()
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	assert.NoError(t, err)
+
+	go server.Serve() //nolint:errcheck
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	return NewSFTPBackend(client)
+}
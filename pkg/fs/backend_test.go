@@ -0,0 +1,88 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMux(t *testing.T) {
+	t.Parallel()
+
+	remote := &SFTPBackend{}
+	mux := NewMux()
+	mux.Mount("sftp://user@host:22/srv/data", "/srv/data", remote)
+
+	t.Run("Root", func(t *testing.T) {
+		t.Parallel()
+
+		backend, name := mux.Resolve("sftp://user@host:22/srv/data")
+		assert.Equal(t, remote, backend)
+		assert.Equal(t, "/srv/data", name)
+	})
+
+	t.Run("JoinedByScanner", func(t *testing.T) {
+		t.Parallel()
+
+		backend, name := mux.Resolve("sftp:/user@host:22/srv/data/a/b.txt")
+		assert.Equal(t, remote, backend)
+		assert.Equal(t, "/srv/data/a/b.txt", name)
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		t.Parallel()
+
+		backend, name := mux.Resolve("sftp:/user@host:22/srv/database")
+		assert.Equal(t, LocalBackend{}, backend)
+		assert.Equal(t, "sftp:/user@host:22/srv/database", name)
+	})
+}
+
+func TestMkdirAll(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_mkdir_all")
+	})
+
+	assert.NoError(t, MkdirAll(LocalBackend{}, "test_mkdir_all/a/b", 0o755))
+	assert.NoError(t, MkdirAll(LocalBackend{}, "test_mkdir_all/a/b", 0o755))
+
+	state, err := os.Stat("test_mkdir_all/a/b")
+	assert.NoError(t, err)
+	assert.True(t, state.IsDir())
+
+	assert.NoError(t, WriteFile(LocalBackend{}, "test_mkdir_all/file", []byte("hello"), 0o644))
+	assert.ErrorIs(t, MkdirAll(LocalBackend{}, "test_mkdir_all/file", 0o755), os.ErrExist)
+
+	data, err := ReadFile(LocalBackend{}, "test_mkdir_all/file")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestFreeSpace(t *testing.T) {
+	t.Parallel()
+
+	space, err := FreeSpace(NewMux(), ".")
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+
+	assert.NoError(t, err)
+	assert.Positive(t, space.FreeBytes)
+
+	_, err = FreeSpace(&S3Backend{}, ".")
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestWithin(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, Within("/a/b", "/a/b"))
+	assert.True(t, Within("/a/b/c", "/a/b"))
+	assert.True(t, Within("/a/b/c", "/a/b/"))
+	assert.False(t, Within("/a/bc", "/a/b"))
+	assert.False(t, Within("/a", "/a/b"))
+}
@@ -0,0 +1,136 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// ReservedPrefix marks files in an encoded root that belong to dtsync itself.
+// They are never synced when a NameMapper is used.
+const ReservedPrefix = ".dtsync-"
+
+var (
+	// ErrSkipName is returned by a NameMapper for entries that must not be synced.
+	ErrSkipName = errors.New("name is skipped")
+	// ErrUnknownName is returned by a NameMapper for entries without counterpart.
+	ErrUnknownName = errors.New("name has no counterpart")
+)
+
+// NameMapper maps the relative path of a scanned entry to the relative path on the other side.
+type NameMapper = func(name string, isDir bool) (string, error)
+
+// Header holds the original attributes of an encoded file.
+type Header struct {
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+	Mode    fs.FileMode `json:"mode"`
+}
+
+// Codec transforms names and contents of files on their way to the destination.
+type Codec interface {
+	// EncodeName maps a plain relative path to the encoded relative path.
+	EncodeName(name string, isDir bool) (string, error)
+	// DecodeName maps an encoded relative path back to the plain relative path.
+	DecodeName(name string, isDir bool) (string, error)
+	// NewWriter returns a writer that encodes the header and the content into w.
+	NewWriter(w io.Writer, header Header) (io.WriteCloser, error)
+	// NewReader reads the header from r and returns a reader that decodes the content.
+	// Reading the header must not require decoding the whole content.
+	NewReader(r io.Reader) (io.ReadCloser, Header, error)
+}
+
+// ChainCodec applies multiple codecs, the first one is the innermost.
+type ChainCodec []Codec
+
+// EncodeName applies all codecs in order.
+func (c ChainCodec) EncodeName(name string, isDir bool) (string, error) {
+	var err error
+
+	for _, codec := range c {
+		if name, err = codec.EncodeName(name, isDir); err != nil {
+			return "", err
+		}
+	}
+
+	return name, nil
+}
+
+// DecodeName applies all codecs in reverse order.
+func (c ChainCodec) DecodeName(name string, isDir bool) (string, error) {
+	var err error
+
+	for i := len(c) - 1; i >= 0; i-- {
+		if name, err = c[i].DecodeName(name, isDir); err != nil {
+			return "", err
+		}
+	}
+
+	return name, nil
+}
+
+// NewWriter stacks the writers so that the first codec is applied first.
+func (c ChainCodec) NewWriter(w io.Writer, header Header) (io.WriteCloser, error) {
+	writers := make(chainCloser, len(c))
+
+	for i := len(c) - 1; i >= 0; i-- {
+		writer, err := c[i].NewWriter(w, header)
+		if err != nil {
+			return nil, err
+		}
+
+		writers[i] = writer
+		w = writer
+	}
+
+	return chainWriter{Writer: w, chainCloser: writers}, nil
+}
+
+// NewReader stacks the readers so that the last codec is decoded first.
+func (c ChainCodec) NewReader(r io.Reader) (io.ReadCloser, Header, error) {
+	var (
+		header  Header
+		readers = make(chainCloser, 0, len(c))
+	)
+
+	for i := len(c) - 1; i >= 0; i-- {
+		reader, codecHeader, err := c[i].NewReader(r)
+		if err != nil {
+			readers.Close()
+
+			return nil, header, err
+		}
+
+		readers = append([]io.Closer{reader}, readers...)
+		header = codecHeader
+		r = reader
+	}
+
+	return chainReader{Reader: r, chainCloser: readers}, header, nil
+}
+
+// chainCloser closes all closers, innermost first.
+type chainCloser []io.Closer
+
+func (c chainCloser) Close() error {
+	var errs []error
+
+	for _, closer := range c {
+		if closer != nil {
+			errs = append(errs, closer.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type chainWriter struct {
+	io.Writer
+	chainCloser
+}
+
+type chainReader struct {
+	io.Reader
+	chainCloser
+}
@@ -0,0 +1,113 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainCodec(t *testing.T) {
+	t.Parallel()
+
+	codec := ChainCodec{prefixCodec("inner-"), prefixCodec("outer-")}
+	header := Header{Size: 5, ModTime: time.Now().Truncate(time.Second), Mode: 0o644}
+
+	t.Run("Names", func(t *testing.T) {
+		t.Parallel()
+
+		name, err := codec.EncodeName("file", false)
+		assert.NoError(t, err)
+		assert.Equal(t, "outer-inner-file", name)
+
+		name, err = codec.DecodeName(name, false)
+		assert.NoError(t, err)
+		assert.Equal(t, "file", name)
+
+		_, err = codec.DecodeName("inner-outer-file", false)
+		assert.ErrorIs(t, err, ErrUnknownName)
+	})
+
+	t.Run("Content", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+
+		writer, err := codec.NewWriter(&buf, header)
+		assert.NoError(t, err)
+		_, err = writer.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Close())
+		assert.True(t, strings.HasPrefix(buf.String(), "outer-"))
+
+		reader, decodedHeader, err := codec.NewReader(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, header.Size, decodedHeader.Size)
+
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+		assert.Equal(t, "hello", string(content))
+	})
+}
+
+// prefixCodec prefixes names and writes the prefix and the header line in front of the content.
+type prefixCodec string
+
+func (p prefixCodec) EncodeName(name string, _ bool) (string, error) {
+	return string(p) + name, nil
+}
+
+func (p prefixCodec) DecodeName(name string, _ bool) (string, error) {
+	if !strings.HasPrefix(name, string(p)) {
+		return "", ErrUnknownName
+	}
+
+	return strings.TrimPrefix(name, string(p)), nil
+}
+
+func (p prefixCodec) NewWriter(w io.Writer, header Header) (io.WriteCloser, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(append(append([]byte(p), data...), '\n'))
+
+	return nopWriteCloser{w}, err
+}
+
+func (p prefixCodec) NewReader(r io.Reader) (io.ReadCloser, Header, error) {
+	var header Header
+
+	prefix := make([]byte, len(p))
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix) != string(p) {
+		return nil, header, ErrUnknownName
+	}
+
+	line := []byte{}
+
+	for b := make([]byte, 1); ; {
+		if _, err := r.Read(b); err != nil {
+			return nil, header, err
+		} else if b[0] == '\n' {
+			break
+		}
+
+		line = append(line, b[0])
+	}
+
+	return io.NopCloser(r), header, json.Unmarshal(line, &header)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
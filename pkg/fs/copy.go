@@ -0,0 +1,79 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+var (
+	// ErrReflinkUnsupported is returned by copies with ReflinkAlways which can't clone the file.
+	ErrReflinkUnsupported = errors.New("reflink not supported")
+	// ErrUnknownReflink is returned for reflink modes other than the Reflink constants.
+	ErrUnknownReflink = errors.New("unknown reflink mode")
+)
+
+// Reflink selects whether plain copies clone the files on the file systems supporting it, like btrfs and XFS.
+type Reflink string
+
+const (
+	// ReflinkAuto clones the files when possible and falls back to the other copy methods.
+	ReflinkAuto Reflink = "auto"
+	// ReflinkAlways fails the plain copies which can't be cloned.
+	ReflinkAlways Reflink = "always"
+	// ReflinkNever copies the data, also copy_file_range is skipped as it may clone.
+	ReflinkNever Reflink = "never"
+)
+
+// ParseReflink returns the reflink mode of the name, ReflinkAuto when it is empty.
+func ParseReflink(name string) (Reflink, error) {
+	switch reflink := Reflink(name); reflink {
+	case "":
+		return ReflinkAuto, nil
+	case ReflinkAuto, ReflinkAlways, ReflinkNever:
+		return reflink, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownReflink, name)
+}
+
+// CopyMethod is the way a file was copied.
+type CopyMethod string
+
+const (
+	// MethodReflink cloned the file, sharing its blocks until they are changed.
+	MethodReflink CopyMethod = "reflink"
+	// MethodCopyFileRange copied the file in the kernel, or on the server of network file systems.
+	MethodCopyFileRange CopyMethod = "copy_file_range"
+	// MethodSendfile copied the file in the kernel.
+	MethodSendfile CopyMethod = "sendfile"
+	// MethodStream copied the file through user space buffers, like all encoded and remote copies.
+	MethodStream CopyMethod = "stream"
+)
+
+// FastCopier is implemented by backends which copy files within their file system without user space buffers.
+type FastCopier interface {
+	// CopyFile copies the src file to dst with the fastest method the reflink mode allows,
+	// reporting the bytes copied to progress. The file is written aside and renamed to dst when it is complete.
+	// It returns errors.ErrUnsupported when none of the methods works for the files, before dst is touched.
+	CopyFile(src, dst string, perm fs.FileMode, reflink Reflink, progress func(n int64)) (CopyMethod, error)
+}
+
+// fastCopier returns the fast copier holding both files and their paths within it, nil if not supported.
+func fastCopier(backend Backend, src, dst string) (FastCopier, string, string) {
+	srcBackend, dstBackend := backend, backend
+
+	if mux, ok := backend.(*Mux); ok {
+		srcBackend, src = mux.Resolve(src)
+		dstBackend, dst = mux.Resolve(dst)
+	}
+
+	srcCopier, srcOK := srcBackend.(FastCopier)
+	dstCopier, dstOK := dstBackend.(FastCopier)
+
+	if !srcOK || !dstOK || srcCopier != dstCopier {
+		return nil, src, dst
+	}
+
+	return dstCopier, src, dst
+}
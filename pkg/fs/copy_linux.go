@@ -0,0 +1,186 @@
+//go:build linux
+
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelCopyChunk is the number of bytes copied by one copy_file_range or sendfile call, between progress reports.
+const kernelCopyChunk = 1024 * 1024
+
+// CopyFile clones the file with FICLONE, copies it with copy_file_range or with sendfile,
+// whichever works first for the reflink mode.
+func (LocalBackend) CopyFile(
+	src, dst string, perm fs.FileMode, reflink Reflink, progress func(n int64),
+) (CopyMethod, error) {
+	source, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+
+	defer source.Close()
+
+	partial := filepath.Join(filepath.Dir(dst), PartialPrefix+filepath.Base(dst))
+
+	destination, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return "", err
+	}
+
+	method, err := kernelCopy(source, destination, reflink, progress)
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(partial)
+
+		return "", err
+	}
+
+	if err := os.Rename(partial, dst); err != nil {
+		os.Remove(partial)
+
+		return "", err
+	}
+
+	return method, nil
+}
+
+// kernelCopy copies the source into the empty destination, leaving the holes of sparse sources.
+func kernelCopy(source, destination *os.File, reflink Reflink, progress func(n int64)) (CopyMethod, error) {
+	srcFd, dstFd := int(source.Fd()), int(destination.Fd())
+
+	if reflink != ReflinkNever {
+		err := unix.IoctlFileClone(dstFd, srcFd)
+
+		switch {
+		case err == nil:
+			if state, err := destination.Stat(); err == nil {
+				progress(state.Size())
+			}
+
+			return MethodReflink, nil
+		case reflink == ReflinkAlways:
+			return "", fmt.Errorf("%w: %w", ErrReflinkUnsupported, err)
+		}
+	}
+
+	state, err := source.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	segments, err := dataSegments(srcFd, state.Size())
+	if err != nil {
+		return "", err
+	}
+
+	if reflink != ReflinkNever {
+		copied, err := copySegments(destination, state.Size(), segments, progress, func(offset int64, n int) (int, error) {
+			srcOffset, dstOffset := offset, offset
+
+			return unix.CopyFileRange(srcFd, &srcOffset, dstFd, &dstOffset, n, 0)
+		})
+		if copied || !unsupported(err) {
+			return MethodCopyFileRange, err
+		}
+	}
+
+	copied, err := copySegments(destination, state.Size(), segments, progress, func(offset int64, n int) (int, error) {
+		if _, err := destination.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+
+		return unix.Sendfile(dstFd, srcFd, &offset, n)
+	})
+	if copied || !unsupported(err) {
+		return MethodSendfile, err
+	}
+
+	return "", errors.ErrUnsupported
+}
+
+// segment is a range of a file holding data.
+type segment struct {
+	start, end int64
+}
+
+// dataSegments returns the ranges of the file holding data, found with SEEK_DATA and SEEK_HOLE.
+// Without support for them the whole file is one range.
+func dataSegments(fd int, size int64) ([]segment, error) {
+	var segments []segment
+
+	for offset := int64(0); offset < size; {
+		start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+
+		switch {
+		case errors.Is(err, unix.ENXIO):
+			// only a hole up to the end
+			return segments, nil
+		case errors.Is(err, unix.EINVAL):
+			return []segment{{0, size}}, nil
+		case err != nil:
+			return nil, err
+		}
+
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, segment{start, min(end, size)})
+		offset = end
+	}
+
+	return segments, nil
+}
+
+// copySegments calls the copy step for the data segments until each is copied and extends the destination
+// to the size, it returns whether any bytes were copied.
+// The skipped holes are reported as progress with the next copied bytes, so a failed first step reports nothing.
+func copySegments(
+	destination *os.File, size int64, segments []segment, progress func(n int64), step func(offset int64, n int) (int, error),
+) (bool, error) {
+	var (
+		copied bool
+		end    int64
+	)
+
+	for _, segment := range segments {
+		for offset := segment.start; offset < segment.end; {
+			n, err := step(offset, int(min(kernelCopyChunk, segment.end-offset)))
+			if err != nil {
+				return copied, err
+			} else if n == 0 {
+				// the source was truncated while it is copied
+				break
+			}
+
+			copied = true
+			offset += int64(n)
+			progress(offset - end)
+			end = offset
+		}
+	}
+
+	if size > end {
+		progress(size - end)
+	}
+
+	return copied, destination.Truncate(size)
+}
+
+// unsupported reports whether the kernel copy failed because the file systems or the kernel don't support it.
+func unsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EBADF)
+}
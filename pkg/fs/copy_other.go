@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fs
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// CopyFile is not supported on this platform, the files are streamed.
+func (LocalBackend) CopyFile(string, string, fs.FileMode, Reflink, func(int64)) (CopyMethod, error) {
+	return "", errors.ErrUnsupported
+}
@@ -0,0 +1,145 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReflink(t *testing.T) {
+	t.Parallel()
+
+	for name, expected := range map[string]Reflink{
+		"": ReflinkAuto, "auto": ReflinkAuto, "always": ReflinkAlways, "never": ReflinkNever,
+	} {
+		reflink, err := ParseReflink(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, reflink)
+	}
+
+	_, err := ParseReflink("sometimes")
+	assert.ErrorIs(t, err, ErrUnknownReflink)
+}
+
+func TestCopyMethod(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_copy_method")
+	})
+	assert.NoError(t, os.Mkdir("test_copy_method", 0o755))
+
+	content := bytes.Repeat([]byte("abc"), 1000)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	createTestFile(t, "test_copy_method/src", 0o640, modTime, content)
+
+	// copy copies the src with the reflink mode, it returns the reported method and the bytes reported as progress
+	copyWith := func(t *testing.T, reflink Reflink, dst string) (CopyMethod, int64, error) {
+		t.Helper()
+
+		var (
+			method CopyMethod
+			done   int64
+		)
+
+		operation := NewOperation(WithReflink(reflink), WithProgress(func(progress Progress) {
+			done += progress.Delta
+		}), WithCopyReport(func(reported string, copyMethod CopyMethod) {
+			assert.Equal(t, dst, reported)
+			method = copyMethod
+		}))
+
+		return method, done, operation.Copy("test_copy_method/src", dst)
+	}
+
+	t.Run("Auto", func(t *testing.T) {
+		t.Parallel()
+
+		method, done, err := copyWith(t, ReflinkAuto, "test_copy_method/auto")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(content)), done)
+		assertCopied(t, content, modTime, "test_copy_method/auto")
+
+		if runtime.GOOS == "linux" {
+			assert.Contains(t, []CopyMethod{MethodReflink, MethodCopyFileRange, MethodSendfile}, method)
+		} else {
+			assert.Equal(t, MethodStream, method)
+		}
+	})
+
+	t.Run("Never", func(t *testing.T) {
+		t.Parallel()
+
+		method, _, err := copyWith(t, ReflinkNever, "test_copy_method/never")
+		assert.NoError(t, err)
+		assert.Contains(t, []CopyMethod{MethodSendfile, MethodStream}, method)
+		assertCopied(t, content, modTime, "test_copy_method/never")
+	})
+
+	t.Run("Always", func(t *testing.T) {
+		t.Parallel()
+
+		createTestFile(t, "test_copy_method/always", 0o640, modTime, []byte("old"))
+
+		method, _, err := copyWith(t, ReflinkAlways, "test_copy_method/always")
+		if errors.Is(err, ErrReflinkUnsupported) {
+			// the file system can't clone, the dst is left as it was
+			data, err := os.ReadFile("test_copy_method/always")
+			assert.NoError(t, err)
+			assert.Equal(t, "old", string(data))
+			assert.NoFileExists(t, "test_copy_method/"+PartialPrefix+"always")
+
+			return
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, MethodReflink, method)
+		assertCopied(t, content, modTime, "test_copy_method/always")
+	})
+
+	t.Run("Remote", func(t *testing.T) {
+		t.Parallel()
+
+		mux := NewMux()
+		mux.Mount("test_copy_method/remote", "test_copy_method/remote", streamBackend{})
+		assert.NoError(t, os.Mkdir("test_copy_method/remote", 0o755))
+
+		var method CopyMethod
+
+		operation := NewOperation(WithBackend(mux), WithCopyReport(func(_ string, copyMethod CopyMethod) {
+			method = copyMethod
+		}))
+		assert.NoError(t, operation.Copy("test_copy_method/src", "test_copy_method/remote/dst"))
+		assert.Equal(t, MethodStream, method)
+		assertCopied(t, content, modTime, "test_copy_method/remote/dst")
+
+		operation = NewOperation(WithBackend(mux), WithReflink(ReflinkAlways))
+		assert.ErrorIs(t, operation.Copy("test_copy_method/src", "test_copy_method/remote/always"), ErrReflinkUnsupported)
+	})
+}
+
+// streamBackend is the local file system without fast copies, like a remote backend.
+type streamBackend struct {
+	LocalBackend
+}
+
+// CopyFile hides the fast copy of the local backend.
+func (streamBackend) CopyFile() {}
+
+func assertCopied(t *testing.T, content []byte, modTime time.Time, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	state, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), state.Mode().Perm())
+	assert.True(t, modTime.Equal(state.ModTime()))
+}
@@ -1,5 +1,7 @@
 package fs
 
+import "context"
+
 // OperationI is the interface for the operations.
 type OperationI interface {
 	// Delete a file or directory (recursively).
@@ -16,13 +18,17 @@ type OperationI interface {
 	 *   Check includes: creation time, modify time, size,
 	 */
 	Equal(src, dst string) bool
+	// CopyAttributes applies the mode, modify time and owner of src to dst, used for the directories
+	// once their entries are copied.
+	CopyAttributes(src, dst string) error
 }
 
 // ShadowScanI is the interface for the FS scanning library.
 type ShadowScanI interface {
 	// Start a scanning process for a given root path calling the callbacks with
-	// the src path and des path. It returns fs.ErrScannerAtEnd when reaching the end.
-	Start(rootPath, dstRootPath string, fileCallback, dirCallback ScannerCallback) chan error
+	// the src path and des path. It returns fs.ErrScannerAtEnd when reaching the end
+	// and the error of ctx when it is canceled before.
+	Start(ctx context.Context, rootPath, dstRootPath string, fileCallback, dirCallback ScannerCallback) <-chan error
 	// Stop all scanning processes.
 	// Once stopped, the instance cant be reused.
 	Stop()
@@ -1,6 +1,10 @@
 package fs
 
-import "github.com/stretchr/testify/mock"
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
 
 type MockFS struct {
 	mock.Mock
@@ -38,12 +42,24 @@ func (m *MockFS) Equal(src, dst string) bool {
 	return ret.Get(0).(bool) //nolint:forcetypeassert
 }
 
+func (m *MockFS) CopyAttributes(src, dst string) error {
+	ret := m.Called(src, dst)
+
+	if ret.Get(0) == nil {
+		return nil
+	}
+
+	return ret.Error(0)
+}
+
 type MockShadowScanner struct {
 	mock.Mock
 }
 
-func (m *MockShadowScanner) Start(rootPath, dstRootPath string, fileCallback, dirCallback ScannerCallback) chan error {
-	ret := m.Called(rootPath, dstRootPath, fileCallback, dirCallback)
+func (m *MockShadowScanner) Start(
+	ctx context.Context, rootPath, dstRootPath string, fileCallback, dirCallback ScannerCallback,
+) <-chan error {
+	ret := m.Called(ctx, rootPath, dstRootPath, fileCallback, dirCallback)
 
 	return ret.Get(0).(chan error) //nolint:forcetypeassert
 }
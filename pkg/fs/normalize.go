@@ -0,0 +1,296 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// ErrUnknownNormalization is returned for a normalization form which is not supported.
+	ErrUnknownNormalization = errors.New("unknown normalization")
+	// ErrUnknownCollisionPolicy is returned for a collision policy which is not supported.
+	ErrUnknownCollisionPolicy = errors.New("unknown collision policy")
+	// ErrNameCollision is returned for names of a directory which are the same on dst
+	// after the normalization or case folding.
+	ErrNameCollision = errors.New("name collision")
+)
+
+// Normalization is the Unicode normalization form of the dst names.
+type Normalization string
+
+const (
+	// NormalizeNone keeps the names as they are.
+	NormalizeNone Normalization = "none"
+	// NormalizeNFC composes the names, like most Linux tools write them.
+	NormalizeNFC Normalization = "nfc"
+	// NormalizeNFD decomposes the names, like HFS+ stores them.
+	NormalizeNFD Normalization = "nfd"
+)
+
+// ParseNormalization parses a normalization form, NormalizeNone when it is empty.
+func ParseNormalization(name string) (Normalization, error) {
+	switch form := Normalization(name); form {
+	case "":
+		return NormalizeNone, nil
+	case NormalizeNone, NormalizeNFC, NormalizeNFD:
+		return form, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownNormalization, name)
+}
+
+// apply returns the name in the normalization form.
+func (n Normalization) apply(name string) string {
+	switch n {
+	case NormalizeNFC:
+		return norm.NFC.String(name)
+	case NormalizeNFD:
+		return norm.NFD.String(name)
+	}
+
+	return name
+}
+
+// CollisionPolicy is what happens to an entry whose dst name collides with the one of an earlier entry
+// of its directory, in the order of the walk.
+type CollisionPolicy string
+
+const (
+	// CollisionSkip skips the entry.
+	CollisionSkip CollisionPolicy = "skip"
+	// CollisionRename copies the entry with a suffix before the extension of its name, like `name~1.txt`.
+	CollisionRename CollisionPolicy = "rename"
+	// CollisionError stops the walk with ErrNameCollision.
+	CollisionError CollisionPolicy = "error"
+)
+
+// ParseCollisionPolicy parses a collision policy, CollisionSkip when it is empty.
+func ParseCollisionPolicy(name string) (CollisionPolicy, error) {
+	switch policy := CollisionPolicy(name); policy {
+	case "":
+		return CollisionSkip, nil
+	case CollisionSkip, CollisionRename, CollisionError:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownCollisionPolicy, name)
+}
+
+// WithNormalization normalizes the scanned names to the form before the name mapper and handles the names
+// of a directory which collide after the normalization or on the dst profile, which may be case-insensitive
+// or normalization-insensitive. The collisions are passed to the report, with the policy CollisionError
+// the walk stops at the first one.
+func WithNormalization(
+	form Normalization, profile Profile, policy CollisionPolicy, report func(path string, err error),
+) ShadowScanOption {
+	return func(s *ShadowScan) {
+		s.namer = newNamer(form, profile, policy)
+		s.namer.report = report
+	}
+}
+
+// WithDenormalization maps the names mapped by the name mapper back to the names they were normalized from,
+// looked up in the directories of the dst root path, for the walks of dst. The names of the other root are
+// named like WithNormalization with the same arguments names them, so renamed collisions find their entry.
+func WithDenormalization(form Normalization, profile Profile, policy CollisionPolicy) ShadowScanOption {
+	return func(s *ShadowScan) {
+		s.namer = newNamer(form, profile, policy)
+		s.namer.reverse = true
+	}
+}
+
+// NamesCollide reports whether names of a directory can collide on a file system of the profile
+// after the normalization.
+func NamesCollide(form Normalization, profile Profile) bool {
+	return form != NormalizeNone || !profile.CaseSensitive || !profile.NormalizationSensitive
+}
+
+// namer names the entries of a walk on dst.
+type namer struct {
+	form Normalization
+	// foldCase and foldForm are set for dst file systems which don't distinguish names by case
+	// or by normalization.
+	foldCase, foldForm bool
+	policy             CollisionPolicy
+	report             func(path string, err error)
+	// reverse maps the names of dst back to the names of the other root.
+	reverse bool
+}
+
+func newNamer(form Normalization, profile Profile, policy CollisionPolicy) *namer {
+	return &namer{
+		form: form, foldCase: !profile.CaseSensitive, foldForm: !profile.NormalizationSensitive, policy: policy,
+	}
+}
+
+// key is what the names colliding on dst have in common.
+func (n *namer) key(name string) string {
+	name = n.form.apply(name)
+	if n.foldForm {
+		name = norm.NFC.String(name)
+	}
+
+	if n.foldCase {
+		name = cases.Fold().String(name)
+	}
+
+	return name
+}
+
+// assign names the next entry of a directory on dst, the taken names are its earlier entries by their key.
+// It returns the name of the earlier entry when they collide, and false when the entry is not renamed.
+func (n *namer) assign(taken map[string]string, name string) (string, string, bool) {
+	dstName := n.form.apply(name)
+
+	other, collides := taken[n.key(dstName)]
+	if !collides {
+		taken[n.key(dstName)] = name
+
+		return dstName, "", true
+	} else if n.policy != CollisionRename {
+		return "", other, false
+	}
+
+	ext := path.Ext(dstName)
+	if ext == dstName {
+		ext = ""
+	}
+
+	for suffix := 1; ; suffix++ {
+		renamed := dstName[:len(dstName)-len(ext)] + "~" + strconv.Itoa(suffix) + ext
+		if _, exists := taken[n.key(renamed)]; !exists {
+			taken[n.key(renamed)] = name
+
+			return renamed, other, true
+		}
+	}
+}
+
+// nameDir is a directory of the walk with the names given to its entries.
+type nameDir struct {
+	// scanned is the relative path in the walked root, named the relative path in the other one.
+	scanned string
+	named   string
+	// taken are the names of the entries on dst by their key, index the names of the other root
+	// by the key of their dst names when reversed, loaded with the first entry.
+	taken map[string]string
+	index map[string]string
+}
+
+// names is the state of a namer during a walk, the directories from the root to the current one.
+type names struct {
+	*namer
+	backend            Backend
+	scannedRoot, other string
+	dirs               []*nameDir
+}
+
+// newNames starts naming a walk of the scanned root.
+func (n *namer) newNames(backend Backend, scannedRoot, other string) *names {
+	return &names{
+		namer: n, backend: backend, scannedRoot: scannedRoot, other: other,
+		dirs: []*nameDir{{scanned: ".", named: ".", taken: map[string]string{}}},
+	}
+}
+
+// parent returns the directory of the walked entry, leaving the directories whose entries are done.
+func (n *names) parent(relPath string) *nameDir {
+	dir := path.Dir(relPath)
+	for len(n.dirs) > 1 && n.dirs[len(n.dirs)-1].scanned != dir {
+		n.dirs = n.dirs[:len(n.dirs)-1]
+	}
+
+	return n.dirs[len(n.dirs)-1]
+}
+
+// normalize returns the relative path on dst of the walked entry, ErrSkipName when it collides and is skipped.
+func (n *names) normalize(relPath string, isDir bool) (string, error) {
+	if relPath == "." {
+		return relPath, nil
+	}
+
+	parent := n.parent(relPath)
+
+	dstName, other, ok := n.assign(parent.taken, path.Base(relPath))
+	if other != "" {
+		scannedPath := filepath.Join(n.scannedRoot, filepath.FromSlash(relPath))
+		otherPath := filepath.Join(n.scannedRoot, filepath.FromSlash(parent.scanned), other)
+
+		switch {
+		case n.policy == CollisionError:
+			return "", fmt.Errorf("%w: %s and %s", ErrNameCollision, otherPath, scannedPath)
+		case n.report != nil && ok:
+			n.report(scannedPath, fmt.Errorf("%w with %s, renamed to %s", ErrNameCollision, otherPath, dstName))
+		case n.report != nil:
+			n.report(scannedPath, fmt.Errorf("%w with %s, skipped", ErrNameCollision, otherPath))
+		}
+	}
+
+	if !ok {
+		return "", ErrSkipName
+	}
+
+	named := path.Join(parent.named, dstName)
+	if isDir {
+		n.dirs = append(n.dirs, &nameDir{scanned: relPath, named: named, taken: map[string]string{}})
+	}
+
+	return named, nil
+}
+
+// denormalize returns the relative path in the other root of the walked entry, whose path is mapped to the
+// normalized one. Entries without a counterpart keep the mapped name.
+func (n *names) denormalize(relPath, mapped string, isDir bool) string {
+	if relPath == "." {
+		return mapped
+	}
+
+	parent := n.parent(relPath)
+	if parent.index == nil {
+		parent.index = n.load(parent.named)
+	}
+
+	name := path.Base(filepath.ToSlash(mapped))
+	if otherName, exists := parent.index[n.key(name)]; exists {
+		name = otherName
+	}
+
+	named := path.Join(parent.named, name)
+	if isDir {
+		n.dirs = append(n.dirs, &nameDir{scanned: relPath, named: named})
+	}
+
+	return filepath.FromSlash(named)
+}
+
+// load indexes the entries of the directory in the other root by the key of their dst names,
+// it is empty when the directory can't be read.
+func (n *names) load(dir string) map[string]string {
+	index := map[string]string{}
+
+	entries, err := n.backend.ReadDir(filepath.Join(n.other, filepath.FromSlash(dir)))
+	if err != nil {
+		return index
+	}
+
+	taken := map[string]string{}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), PartialPrefix) {
+			continue
+		}
+
+		if dstName, _, ok := n.assign(taken, entry.Name()); ok {
+			index[n.key(dstName)] = entry.Name()
+		}
+	}
+
+	return index
+}
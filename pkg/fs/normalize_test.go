@@ -0,0 +1,171 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	cafeNFC = "caf\u00e9"
+	cafeNFD = "cafe\u0301"
+)
+
+func TestParseNormalization(t *testing.T) {
+	t.Parallel()
+
+	for name, expected := range map[string]Normalization{
+		"": NormalizeNone, "none": NormalizeNone, "nfc": NormalizeNFC, "nfd": NormalizeNFD,
+	} {
+		form, err := ParseNormalization(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, form)
+	}
+
+	_, err := ParseNormalization("nfkc")
+	assert.ErrorIs(t, err, ErrUnknownNormalization)
+
+	for name, expected := range map[string]CollisionPolicy{
+		"": CollisionSkip, "skip": CollisionSkip, "rename": CollisionRename, "error": CollisionError,
+	} {
+		policy, err := ParseCollisionPolicy(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, policy)
+	}
+
+	_, err = ParseCollisionPolicy("overwrite")
+	assert.ErrorIs(t, err, ErrUnknownCollisionPolicy)
+}
+
+func TestShadowScanNormalization(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_normalize")
+	})
+	assert.NoError(t, os.MkdirAll("test_normalize/src/Docs", 0o755))
+	assert.NoError(t, os.MkdirAll("test_normalize/src/docs", 0o755))
+	assert.NoError(t, os.MkdirAll("test_normalize/dst/Docs", 0o755))
+	assert.NoError(t, os.MkdirAll("test_normalize/dst/docs~1", 0o755))
+
+	for _, name := range []string{
+		"src/Docs/a.txt", "src/docs/b.txt", "src/README.md", "src/Readme.md", "src/" + cafeNFD,
+		"dst/Docs/a.txt", "dst/docs~1/b.txt", "dst/README.md", "dst/Readme~1.md", "dst/" + cafeNFC, "dst/gone.txt",
+	} {
+		createTestFile(t, "test_normalize/"+name, 0o644, time.Now(), []byte{})
+	}
+
+	scan := func(t *testing.T, scanner ShadowScanI, srcRootPath, dstRootPath string) (map[string]string, error) {
+		t.Helper()
+
+		found := map[string]string{}
+		callback := func(srcPath, dstPath string) error {
+			found[srcPath] = dstPath
+
+			return nil
+		}
+
+		err := <-scanner.Start(context.Background(), srcRootPath, dstRootPath, callback, callback)
+		if err == ErrScannerAtEnd {
+			err = nil
+		}
+
+		return found, err
+	}
+
+	t.Run("Skip", func(t *testing.T) {
+		t.Parallel()
+
+		reported := map[string]error{}
+		scanner := NewShadowScan(WithNormalization(NormalizeNFC, Profile{}, CollisionSkip, func(path string, err error) {
+			reported[path] = err
+		}))
+
+		found, err := scan(t, scanner, "test_normalize/src", "dest")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"test_normalize/src": "dest", "test_normalize/src/Docs": "dest/Docs",
+			"test_normalize/src/Docs/a.txt": "dest/Docs/a.txt", "test_normalize/src/README.md": "dest/README.md",
+			"test_normalize/src/" + cafeNFD: "dest/" + cafeNFC,
+		}, found)
+		assert.Len(t, reported, 2)
+		assert.ErrorIs(t, reported["test_normalize/src/Readme.md"], ErrNameCollision)
+		assert.ErrorContains(t, reported["test_normalize/src/docs"], "test_normalize/src/Docs, skipped")
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		t.Parallel()
+
+		reported := map[string]error{}
+		scanner := NewShadowScan(WithNormalization(NormalizeNFC, Profile{}, CollisionRename, func(path string, err error) {
+			reported[path] = err
+		}))
+
+		found, err := scan(t, scanner, "test_normalize/src", "dest")
+		assert.NoError(t, err)
+		assert.Equal(t, "dest/Readme~1.md", found["test_normalize/src/Readme.md"])
+		assert.Equal(t, "dest/docs~1", found["test_normalize/src/docs"])
+		assert.Equal(t, "dest/docs~1/b.txt", found["test_normalize/src/docs/b.txt"])
+		assert.ErrorContains(t, reported["test_normalize/src/Readme.md"], "renamed to Readme~1.md")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := scan(t, NewShadowScan(WithNormalization(NormalizeNFC, Profile{}, CollisionError, nil)),
+			"test_normalize/src", "dest")
+		assert.ErrorIs(t, err, ErrNameCollision)
+	})
+
+	t.Run("CaseSensitive", func(t *testing.T) {
+		t.Parallel()
+
+		found, err := scan(t, NewShadowScan(WithNormalization(NormalizeNFD, FullProfile, CollisionError, nil)),
+			"test_normalize/src", "dest")
+		assert.NoError(t, err)
+		assert.Len(t, found, 8)
+		assert.Equal(t, "dest/"+cafeNFD, found["test_normalize/src/"+cafeNFD])
+	})
+
+	t.Run("NormalizationInsensitive", func(t *testing.T) {
+		t.Parallel()
+
+		assert.NoError(t, os.MkdirAll("test_normalize/kept", 0o755))
+		createTestFile(t, "test_normalize/kept/"+cafeNFC, 0o644, time.Now(), []byte{})
+		createTestFile(t, "test_normalize/kept/"+cafeNFD, 0o644, time.Now(), []byte{})
+
+		// the names are kept, but they are the same file on dst
+		reported := map[string]error{}
+		profile := Profile{CaseSensitive: true}
+		found, err := scan(t, NewShadowScan(WithNormalization(NormalizeNone, profile, CollisionSkip,
+			func(path string, err error) {
+				reported[path] = err
+			})), "test_normalize/kept", "dest")
+		assert.NoError(t, err)
+		assert.Len(t, found, 2)
+		assert.Len(t, reported, 1)
+		assert.True(t, NamesCollide(NormalizeNone, profile))
+		assert.False(t, NamesCollide(NormalizeNone, FullProfile))
+	})
+
+	t.Run("Denormalization", func(t *testing.T) {
+		t.Parallel()
+
+		found, err := scan(t, NewShadowScan(WithDenormalization(NormalizeNFC, Profile{}, CollisionRename)),
+			"test_normalize/dst", "test_normalize/src")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"test_normalize/dst": "test_normalize/src", "test_normalize/dst/Docs": "test_normalize/src/Docs",
+			"test_normalize/dst/Docs/a.txt":   "test_normalize/src/Docs/a.txt",
+			"test_normalize/dst/docs~1":       "test_normalize/src/docs",
+			"test_normalize/dst/docs~1/b.txt": "test_normalize/src/docs/b.txt",
+			"test_normalize/dst/README.md":    "test_normalize/src/README.md",
+			"test_normalize/dst/Readme~1.md":  "test_normalize/src/Readme.md",
+			"test_normalize/dst/" + cafeNFC:   "test_normalize/src/" + cafeNFD,
+			"test_normalize/dst/gone.txt":     "test_normalize/src/gone.txt",
+		}, found)
+	})
+}
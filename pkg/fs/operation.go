@@ -1,69 +1,331 @@
 package fs
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"time"
 )
 
+const (
+	// inPlaceBlockSize is the size of the blocks compared by in place updates.
+	inPlaceBlockSize = 1024 * 1024
+	// dirWritePerm lets the owner fill new directories, their final mode is applied by CopyAttributes.
+	dirWritePerm = 0o700
+	// modeBits are the bits of the mode applied by chmod.
+	modeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+)
+
+// OperationOption configures an Operation.
+type OperationOption func(*Operation)
+
+// WithEncoder encodes files on Copy and compares against the encoded header on Equal.
+func WithEncoder(codec Codec) OperationOption {
+	return func(o *Operation) {
+		o.encoder = codec
+	}
+}
+
+// WithDecoder decodes files on Copy and compares the decoded header on Equal.
+func WithDecoder(codec Codec) OperationOption {
+	return func(o *Operation) {
+		o.decoder = codec
+	}
+}
+
+// WithBackend runs the operations on the given backend instead of the local file system.
+func WithBackend(backend Backend) OperationOption {
+	return func(o *Operation) {
+		o.backend = backend
+	}
+}
+
+// WithInPlace updates existing files of at least minSize in place, writing only the blocks that differ.
+// A failure leaves a partly updated file behind, which is replaced on the next sync.
+func WithInPlace(minSize int64) OperationOption {
+	return func(o *Operation) {
+		o.inPlace = true
+		o.inPlaceMinSize = minSize
+	}
+}
+
+// WithProgress reports the bytes read from the src files while they are copied.
+func WithProgress(progress func(Progress)) OperationOption {
+	return func(o *Operation) {
+		o.progress = progress
+	}
+}
+
+// WithReflink selects whether plain copies clone the files, ReflinkAuto by default.
+// Plain copies within the local file system are copied in the kernel unless ReflinkNever prevents it.
+func WithReflink(reflink Reflink) OperationOption {
+	return func(o *Operation) {
+		o.reflink = reflink
+	}
+}
+
+// WithSparse leaves holes in place of the zero blocks of all copied files, not only of sparse src files.
+// The zero blocks are found while streaming the files, so the kernel copies are used only with ReflinkAlways.
+func WithSparse() OperationOption {
+	return func(o *Operation) {
+		o.sparse = true
+	}
+}
+
+// WithSpecials recreates the FIFOs on Copy, which returns ErrSpecialSkipped for them otherwise.
+func WithSpecials() OperationOption {
+	return func(o *Operation) {
+		o.specials = true
+	}
+}
+
+// WithDevices recreates the block and character devices on Copy with their device numbers, which usually needs root.
+// Copy returns ErrSpecialSkipped for them otherwise.
+func WithDevices() OperationOption {
+	return func(o *Operation) {
+		o.devices = true
+	}
+}
+
+// WithCopyReport reports the method which copied each file.
+func WithCopyReport(report func(dst string, method CopyMethod)) OperationOption {
+	return func(o *Operation) {
+		o.report = report
+	}
+}
+
+// Progress is the state of a file being copied, reported after each read.
+type Progress struct {
+	Path string
+	Size int64
+	Done int64
+	// Delta are the bytes read since the last report.
+	Delta int64
+}
+
 // Operation provides FS operations.
-type Operation struct{}
+type Operation struct {
+	backend        Backend
+	encoder        Codec
+	decoder        Codec
+	inPlace        bool
+	inPlaceMinSize int64
+	progress       func(Progress)
+	reflink        Reflink
+	sparse         bool
+	specials       bool
+	devices        bool
+	profile        Profile
+	report         func(dst string, method CopyMethod)
+}
 
 // NewOperation creates a new operation.
-func NewOperation() OperationI {
-	return &Operation{}
+func NewOperation(options ...OperationOption) OperationI {
+	operation := &Operation{backend: LocalBackend{}, profile: FullProfile}
+
+	for _, option := range options {
+		option(operation)
+	}
+
+	return operation
 }
 
-// Delete a file or directory (recursively).
+// Delete a file or directory (recursively), symlinks are removed themselves.
+// Read-only directories below it are made writable when they can't be emptied otherwise.
 func (o *Operation) Delete(path string) error {
-	if exists := o.Exists(path); exists {
-		return os.RemoveAll(path)
+	state, err := o.backend.Lstat(path)
+	if err != nil {
+		return os.ErrNotExist
+	}
+
+	err = o.backend.RemoveAll(path)
+	if errors.Is(err, fs.ErrPermission) && state.IsDir() {
+		if err := o.unlockTree(path); err != nil {
+			return err
+		}
+
+		return o.backend.RemoveAll(path)
+	}
+
+	return err
+}
+
+// unlockTree makes the directory and all directories below it writable for the owner.
+func (o *Operation) unlockTree(dir string) error {
+	if _, _, err := Unlock(o.backend, dir); err != nil {
+		return err
+	}
+
+	entries, err := o.backend.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := o.unlockTree(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Unlock makes the existing directory readable, writable and searchable for the owner, so its entries
+// can be changed. It returns the mode before and true when it changed it.
+func Unlock(backend Backend, dir string) (os.FileMode, bool, error) {
+	state, err := backend.Stat(dir)
+	if err != nil {
+		return 0, false, err
+	} else if !state.IsDir() || state.Mode().Perm()&dirWritePerm == dirWritePerm {
+		return state.Mode() & modeBits, false, nil
 	}
 
-	return os.ErrNotExist
+	return state.Mode() & modeBits, true, backend.Chmod(dir, state.Mode()&modeBits|dirWritePerm)
 }
 
 // Copy a file or directory (recursively).
+// Directories are created writable for the owner, CopyAttributes applies their final mode and times
+// once they are filled. Special files are recreated with WithSpecials and WithDevices, see SpecialSkipped.
 func (o *Operation) Copy(src, dst string) error {
-	srcState, err := os.Stat(src)
+	srcState, err := o.backend.Stat(src)
 	if err != nil {
 		return err
 	}
 
 	if srcState.IsDir() {
-		return os.Mkdir(dst, srcState.Mode())
+		return o.backend.Mkdir(dst, srcState.Mode()|dirWritePerm)
 	} else if !srcState.Mode().IsRegular() {
-		return nil
+		return o.copySpecial(src, dst, srcState)
+	}
+
+	switch {
+	case o.encoder != nil:
+		return o.reported(dst, MethodStream, o.encode(src, dst, srcState))
+	case o.decoder != nil:
+		return o.reported(dst, MethodStream, o.decode(src, dst))
 	}
 
-	source, err := os.Open(src)
+	if patcher, name := patcher(o.backend, dst); patcher != nil {
+		if dstState, err := o.backend.Stat(dst); err == nil && dstState.Mode().IsRegular() {
+			return o.reported(dst, MethodStream, o.patch(src, dst, srcState, patcher, name))
+		}
+	}
+
+	if randomAccess, name := randomAccess(o.backend, dst); o.inPlace && randomAccess != nil {
+		if dstState, err := o.backend.Stat(dst); err == nil && dstState.Mode().IsRegular() &&
+			srcState.Size() >= o.inPlaceMinSize && dstState.Size() >= o.inPlaceMinSize {
+			return o.reported(dst, MethodStream, o.updateInPlace(src, dst, srcState, randomAccess, name))
+		}
+	}
+
+	method, err := o.copyFile(src, dst, srcState)
+
+	switch {
+	case errors.Is(err, errors.ErrUnsupported) && o.reflink == ReflinkAlways:
+		return fmt.Errorf("%w: %s", ErrReflinkUnsupported, dst)
+	case errors.Is(err, errors.ErrUnsupported):
+		return o.reported(dst, MethodStream, o.stream(src, dst, srcState))
+	case err != nil:
+		return err
+	}
+
+	return o.reported(dst, method, o.backend.Chtimes(dst, time.Now(), srcState.ModTime()))
+}
+
+// copyFile copies the file with the fast copier of the backend, it returns errors.ErrUnsupported without one
+// or when the zero blocks are searched.
+func (o *Operation) copyFile(src, dst string, srcState os.FileInfo) (CopyMethod, error) {
+	copier, srcName, dstName := fastCopier(o.backend, src, dst)
+	if copier == nil || o.sparse && o.reflink != ReflinkAlways {
+		return "", errors.ErrUnsupported
+	}
+
+	progress := func(int64) {}
+
+	if o.progress != nil {
+		state := Progress{Path: src, Size: srcState.Size()}
+		progress = func(n int64) {
+			state.Done += n
+			state.Delta = n
+			o.progress(state)
+		}
+	}
+
+	return copier.CopyFile(srcName, dstName, srcState.Mode(), o.reflink, progress)
+}
+
+// stream copies the file through a user space buffer, recreating the holes of sparse files.
+func (o *Operation) stream(src, dst string, srcState os.FileInfo) error {
+	source, err := o.backend.Open(src)
 	if err != nil {
 		return err
 	}
 
 	defer source.Close()
 
-	destination, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, srcState.Mode())
+	destination, timed, err := o.create(dst, srcState.Mode(), srcState.ModTime())
 	if err != nil {
 		return err
 	}
 
-	defer destination.Close()
+	// remote backends publish the upload on close, so a failed copy is aborted instead
+	if err := o.write(destination, o.track(source, src, srcState.Size()), hasHoles(srcState)); err != nil {
+		abort(destination, err)
 
-	if _, err := io.Copy(destination, source); err != nil {
 		return err
 	}
 
-	if err := os.Chtimes(dst, time.Now(), srcState.ModTime()); err != nil {
+	if err := destination.Close(); err != nil || timed {
 		return err
 	}
 
+	return o.backend.Chtimes(dst, time.Now(), srcState.ModTime())
+}
+
+// create creates the dst file, with the modify time on backends storing it with the content.
+// timed is false when the time still has to be set after the file is closed.
+func (o *Operation) create(dst string, perm os.FileMode, mtime time.Time) (io.WriteCloser, bool, error) {
+	if creator, name := timedCreator(o.backend, dst); creator != nil {
+		destination, err := creator.CreateWithTimes(name, perm, mtime)
+
+		return destination, true, err
+	}
+
+	destination, err := o.backend.Create(dst, perm)
+
+	return destination, false, err
+}
+
+// write copies the reader into the new dst file, with holes in place of the zero blocks if the src has holes
+// or WithSparse is set.
+func (o *Operation) write(destination io.Writer, reader io.Reader, holes bool) error {
+	if holes || o.sparse {
+		return copySparse(destination, reader)
+	}
+
+	_, err := io.Copy(destination, reader)
+
+	return err
+}
+
+// reported reports the method of the copy when it succeeded and returns its error.
+func (o *Operation) reported(dst string, method CopyMethod, err error) error {
+	if err == nil && o.report != nil {
+		o.report(dst, method)
+	}
+
 	return err
 }
 
 // Exists checks if a file or directory exists and what type it is.
 func (o *Operation) Exists(path string) bool {
-	if state, err := os.Stat(path); err == nil {
+	if state, err := o.backend.Stat(path); err == nil {
 		if state.IsDir() {
 			return true
 		}
@@ -74,30 +336,334 @@ func (o *Operation) Exists(path string) bool {
 	return false
 }
 
+// EntryType is the type of a file system entry, entries of different types can't replace each other in place.
+type EntryType string
+
+const (
+	// TypeFile is a regular file.
+	TypeFile EntryType = "file"
+	// TypeDir is a directory.
+	TypeDir EntryType = "dir"
+	// TypeSymlink is a symbolic link.
+	TypeSymlink EntryType = "symlink"
+	// TypeSpecial is a named pipe, socket or device.
+	TypeSpecial EntryType = "special"
+)
+
+// TypeOf returns the entry type of the mode.
+func TypeOf(mode fs.FileMode) EntryType {
+	switch {
+	case mode.IsDir():
+		return TypeDir
+	case mode&fs.ModeSymlink != 0:
+		return TypeSymlink
+	case mode.IsRegular():
+		return TypeFile
+	}
+
+	return TypeSpecial
+}
+
 // Equal checks if two files are equal.
 /* Returns:
 * - one not exists -> false.
 * - Directory==File || File==Directory -> false.
 * - Directory==Directory -> true.
  * - File==File -> true if equal, false if not equal.
+ * - Special==Special -> true if type, device number, mode and modify time are equal.
 */
 func (o *Operation) Equal(src, dst string) bool {
-	srcState, err := os.Stat(src)
+	srcState, err := o.backend.Stat(src)
 	if err != nil {
 		return false
 	}
 
-	dstStatus, err := os.Stat(dst)
+	dstStatus, err := o.backend.Stat(dst)
 	if err != nil {
 		return false
 	}
 
+	switch {
+	case srcState.IsDir() != dstStatus.IsDir():
+		return false
+	case TypeOf(srcState.Mode()) == TypeSpecial || TypeOf(dstStatus.Mode()) == TypeSpecial:
+		return o.equalSpecial(srcState, dstStatus) && o.equalTime(src, dst, srcState.ModTime(), dstStatus.ModTime())
+	case o.encoder != nil && !srcState.IsDir():
+		return o.equalHeader(src, srcState, dst, o.encoder)
+	case o.decoder != nil && !srcState.IsDir():
+		return o.equalHeader(dst, dstStatus, src, o.decoder)
+	}
+
 	if srcState.IsDir() {
-		return srcState.IsDir() == dstStatus.IsDir() &&
-			srcState.Mode().Perm().String() == dstStatus.Mode().Perm().String()
+		return srcState.IsDir() == dstStatus.IsDir() && o.equalPerm(srcState.Mode(), dstStatus.Mode())
 	}
 
 	return srcState.Size() == dstStatus.Size() &&
-		srcState.ModTime() == dstStatus.ModTime() &&
-		srcState.Mode().Perm().String() == dstStatus.Mode().Perm().String()
+		o.equalTime(src, dst, srcState.ModTime(), dstStatus.ModTime()) &&
+		o.equalPerm(srcState.Mode(), dstStatus.Mode())
+}
+
+// CopyAttributes applies the owner, mode and modify time of src to dst, only those which differ.
+// The owner is changed only on backends supporting it and kept when the user may not change it.
+func (o *Operation) CopyAttributes(src, dst string) error {
+	srcState, err := o.backend.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	dstState, err := o.backend.Stat(dst)
+	if err != nil {
+		return err
+	}
+
+	// chown clears the setuid and setgid bits, so it goes first
+	if chowner, name := chowner(o.backend, dst); chowner != nil {
+		uid, gid, srcOK := owner(srcState)
+		dstUID, dstGID, dstOK := owner(dstState)
+
+		if srcOK && dstOK && (uid != dstUID || gid != dstGID) {
+			if err := chowner.Chown(name, uid, gid); err != nil && !errors.Is(err, fs.ErrPermission) {
+				return err
+			}
+		}
+	}
+
+	if o.profile.Permissions && srcState.Mode()&modeBits != dstState.Mode()&modeBits {
+		if err := o.backend.Chmod(dst, srcState.Mode()&modeBits); err != nil {
+			return err
+		}
+	}
+
+	if !o.equalTime(src, dst, srcState.ModTime(), dstState.ModTime()) {
+		return o.backend.Chtimes(dst, time.Now(), srcState.ModTime())
+	}
+
+	return nil
+}
+
+// patch updates the existing dst file with the changed blocks of the src file.
+func (o *Operation) patch(src, dst string, srcState os.FileInfo, patcher Patcher, name string) error {
+	source, err := o.backend.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer source.Close()
+
+	if err := patcher.Patch(name, o.track(source, src, srcState.Size()), srcState.Mode()); err != nil {
+		return err
+	}
+
+	return o.backend.Chtimes(dst, time.Now(), srcState.ModTime())
+}
+
+// updateInPlace compares the hashes of the src and dst file block by block, writes only the differing blocks
+// into dst, truncates it to the src size and applies the src mode and modify time.
+func (o *Operation) updateInPlace(src, dst string, srcState os.FileInfo, randomAccess RandomAccessBackend, name string) error {
+	source, err := o.backend.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer source.Close()
+
+	destination, err := randomAccess.OpenFile(name)
+	if err != nil {
+		return err
+	}
+
+	defer destination.Close()
+
+	var (
+		reader   = o.track(source, src, srcState.Size())
+		srcBlock = make([]byte, inPlaceBlockSize)
+		dstBlock = make([]byte, inPlaceBlockSize)
+		offset   int64
+	)
+
+	for {
+		n, err := io.ReadFull(reader, srcBlock)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		m, err := destination.ReadAt(dstBlock[:n], offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if m != n || sha256.Sum256(srcBlock[:n]) != sha256.Sum256(dstBlock[:n]) {
+			if _, err := destination.WriteAt(srcBlock[:n], offset); err != nil {
+				return err
+			}
+		}
+
+		offset += int64(n)
+	}
+
+	if err := destination.Truncate(offset); err != nil {
+		return err
+	}
+
+	if err := destination.Close(); err != nil {
+		return err
+	}
+
+	if o.profile.Permissions {
+		if err := o.backend.Chmod(dst, srcState.Mode()&modeBits); err != nil {
+			return err
+		}
+	}
+
+	return o.backend.Chtimes(dst, time.Now(), srcState.ModTime())
+}
+
+// encode copies the src file into the encoded dst file.
+func (o *Operation) encode(src, dst string, srcState os.FileInfo) error {
+	source, err := o.backend.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer source.Close()
+
+	destination, timed, err := o.create(dst, srcState.Mode(), srcState.ModTime())
+	if err != nil {
+		return err
+	}
+
+	writer, err := o.encoder.NewWriter(destination, Header{
+		Size: srcState.Size(), ModTime: srcState.ModTime(), Mode: srcState.Mode(),
+	})
+	if err != nil {
+		abort(destination, err)
+
+		return err
+	}
+
+	if _, err := io.Copy(writer, o.track(source, src, srcState.Size())); err != nil {
+		abort(destination, err)
+
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		abort(destination, err)
+
+		return err
+	}
+
+	if err := destination.Close(); err != nil || timed {
+		return err
+	}
+
+	return o.backend.Chtimes(dst, time.Now(), srcState.ModTime())
+}
+
+// decode copies the encoded src file into the plain dst file.
+func (o *Operation) decode(src, dst string) error {
+	source, err := o.backend.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer source.Close()
+
+	reader, header, err := o.decoder.NewReader(source)
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close()
+
+	destination, timed, err := o.create(dst, header.Mode, header.ModTime)
+	if err != nil {
+		return err
+	}
+
+	if err := o.write(destination, o.track(reader, src, header.Size), false); err != nil {
+		abort(destination, err)
+
+		return err
+	}
+
+	// timed creators store the mode with the content too
+	if err := destination.Close(); err != nil || timed {
+		return err
+	}
+
+	if o.profile.Permissions {
+		if err := o.backend.Chmod(dst, header.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+
+	return o.backend.Chtimes(dst, time.Now(), header.ModTime)
+}
+
+// track reports the bytes read from the src file with the plain size to the progress, if there is one.
+func (o *Operation) track(reader io.Reader, src string, size int64) io.Reader {
+	if o.progress == nil {
+		return reader
+	}
+
+	return &progressReader{Reader: reader, progress: o.progress, state: Progress{Path: src, Size: size}}
+}
+
+// progressReader reports the bytes read to the progress.
+type progressReader struct {
+	io.Reader
+	progress func(Progress)
+	state    Progress
+}
+
+func (r *progressReader) Read(data []byte) (int, error) {
+	n, err := r.Reader.Read(data)
+	if n > 0 {
+		r.state.Done += int64(n)
+		r.state.Delta = int64(n)
+		r.progress(r.state)
+	}
+
+	return n, err
+}
+
+// equalHeader compares the state of a plain file with the header of an encoded file.
+func (o *Operation) equalHeader(plain string, plainState os.FileInfo, encoded string, codec Codec) bool {
+	file, err := o.backend.Open(encoded)
+	if err != nil {
+		return false
+	}
+
+	defer file.Close()
+
+	reader, header, err := codec.NewReader(file)
+	if err != nil {
+		return false
+	}
+
+	defer reader.Close()
+
+	return plainState.Size() == header.Size &&
+		o.equalTime(plain, encoded, plainState.ModTime(), header.ModTime) &&
+		o.equalPerm(plainState.Mode(), header.Mode)
+}
+
+// equalTime compares the modify times with the coarser precision of both backends,
+// times closer than the granularity of the profile are equal too.
+func (o *Operation) equalTime(src, dst string, srcTime, dstTime time.Time) bool {
+	granularity := Granularity(o.backend, src)
+	if dstGranularity := Granularity(o.backend, dst); dstGranularity > granularity {
+		granularity = dstGranularity
+	}
+
+	return srcTime.Truncate(granularity).Equal(dstTime.Truncate(granularity)) ||
+		srcTime.Sub(dstTime).Abs() < o.profile.TimeGranularity
+}
+
+// equalPerm compares the permission bits, which are equal on file systems without permissions.
+func (o *Operation) equalPerm(srcMode, dstMode fs.FileMode) bool {
+	return !o.profile.Permissions || srcMode.Perm() == dstMode.Perm()
 }
@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"bytes"
 	"io/fs"
 	"os"
 	"testing"
@@ -19,6 +20,9 @@ func TestDelete(t *testing.T) {
 	assert.NoError(t, os.Mkdir("test_delete/dir", 0o755))
 	createTestFile(t, "test_delete/dir/file.txt", 0x755, time.Now(), []byte{})
 	createTestFile(t, "test_delete/file.txt", 0x755, time.Now(), []byte{})
+	assert.NoError(t, os.Mkdir("test_delete/target", 0o755))
+	assert.NoError(t, os.Symlink("target", "test_delete/link"))
+	assert.NoError(t, os.Symlink("missing", "test_delete/dangling"))
 
 	operation := NewOperation()
 
@@ -34,6 +38,14 @@ func TestDelete(t *testing.T) {
 		assert.NoError(t, operation.Delete("test_delete/dir"))
 	})
 
+	t.Run("Symlink", func(t *testing.T) {
+		t.Parallel()
+
+		assert.NoError(t, operation.Delete("test_delete/link"))
+		assert.NoError(t, operation.Delete("test_delete/dangling"))
+		assert.DirExists(t, "test_delete/target")
+	})
+
 	t.Run("NonExistingFile", func(t *testing.T) {
 		t.Parallel()
 
@@ -51,6 +63,16 @@ func TestDelete(t *testing.T) {
 	})
 }
 
+func TestTypeOf(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, TypeFile, TypeOf(0o644))
+	assert.Equal(t, TypeDir, TypeOf(fs.ModeDir|0o755))
+	assert.Equal(t, TypeSymlink, TypeOf(fs.ModeSymlink|0o777))
+	assert.Equal(t, TypeSpecial, TypeOf(fs.ModeNamedPipe|0o644))
+	assert.Equal(t, TypeSpecial, TypeOf(fs.ModeDevice|fs.ModeCharDevice|0o644))
+}
+
 func TestCopy(t *testing.T) {
 	t.Parallel()
 
@@ -110,6 +132,36 @@ func TestCopy(t *testing.T) {
 	})
 }
 
+func TestCopyProgress(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_copy_progress")
+	})
+	assert.NoError(t, os.Mkdir("test_copy_progress", 0o755))
+	createTestFile(t, "test_copy_progress/src", 0o644, time.Now(), bytes.Repeat([]byte("a"), 3*inPlaceBlockSize))
+
+	var reports []Progress
+
+	operation := NewOperation(WithProgress(func(progress Progress) {
+		reports = append(reports, progress)
+	}))
+	assert.NoError(t, operation.Copy("test_copy_progress/src", "test_copy_progress/dst"))
+
+	// the progress is reported while the file is copied, not only at the end
+	assert.Greater(t, len(reports), 1)
+
+	var delta int64
+	for _, report := range reports {
+		delta += report.Delta
+	}
+
+	last := reports[len(reports)-1]
+	assert.Equal(t, Progress{Path: "test_copy_progress/src", Size: 3 * inPlaceBlockSize, Done: 3 * inPlaceBlockSize,
+		Delta: last.Delta}, last)
+	assert.Equal(t, last.Done, delta)
+}
+
 func TestExists(t *testing.T) {
 	t.Parallel()
 
@@ -202,6 +254,17 @@ func TestEqual(t *testing.T) {
 		assert.False(t, operation.Equal("test_equal/a.txt", "test_equal/not_exists.txt"))
 	})
 
+	t.Run("Profile", func(t *testing.T) {
+		t.Parallel()
+
+		createTestFile(t, "test_equal/e.txt", 0o644, now.Add(time.Second), []byte("test"))
+
+		operation := NewOperation(WithProfile(Profile{TimeGranularity: 2 * time.Second}))
+		assert.True(t, operation.Equal("test_equal/a.txt", "test_equal/e.txt"))
+		assert.True(t, operation.Equal("test_equal/a", "test_equal/c"))
+		assert.False(t, operation.Equal("test_equal/a.txt", "test_equal/c.txt"))
+	})
+
 	t.Run("DirectoryEqual", func(t *testing.T) {
 		t.Parallel()
 
@@ -221,6 +284,114 @@ func TestEqual(t *testing.T) {
 	})
 }
 
+func TestCopyInPlace(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_in_place")
+	})
+	assert.NoError(t, os.Mkdir("test_in_place", 0o755))
+
+	old := bytes.Repeat([]byte{1}, 3*inPlaceBlockSize+100)
+	changed := bytes.Repeat([]byte{1}, 3*inPlaceBlockSize+100)
+	changed[inPlaceBlockSize+10] = 2
+
+	tests := []struct {
+		name    string
+		src     []byte
+		mode    fs.FileMode
+		minSize int64
+		written int64
+	}{
+		{name: "ChangedBlock", src: changed, mode: 0o644, minSize: 0, written: inPlaceBlockSize},
+		{name: "Shrunk", src: changed[:2*inPlaceBlockSize], mode: 0o644, minSize: 0, written: inPlaceBlockSize},
+		{name: "Extended", src: append(append([]byte{}, old...), 3, 3), mode: 0o644, minSize: 0, written: 102},
+		{name: "ChangedMode", src: changed, mode: 0o600, minSize: 0, written: inPlaceBlockSize},
+		{name: "BelowMinSize", src: changed, mode: 0o644, minSize: int64(len(old)) + 1, written: 0},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			src, dst := "test_in_place/"+test.name+".src", "test_in_place/"+test.name+".dst"
+			createTestFile(t, src, test.mode, time.Now().Add(-time.Hour), test.src)
+			createTestFile(t, dst, 0o644, time.Now(), old)
+
+			backend := &countingBackend{}
+			operation := NewOperation(WithBackend(backend), WithInPlace(test.minSize))
+			assert.NoError(t, operation.Copy(src, dst))
+
+			data, err := os.ReadFile(dst)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(test.src, data))
+			assert.Equal(t, test.written, backend.written)
+			assert.True(t, operation.Equal(src, dst))
+
+			state, err := os.Stat(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, test.mode, state.Mode().Perm())
+		})
+	}
+}
+
+func TestCopyAttributes(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.Chmod("test_copy_attributes/src", 0o755)
+		os.Chmod("test_copy_attributes/dst", 0o755)
+		os.RemoveAll("test_copy_attributes")
+	})
+	assert.NoError(t, os.MkdirAll("test_copy_attributes/src", 0o755))
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, os.Chmod("test_copy_attributes/src", 0o500))
+	assert.NoError(t, os.Chtimes("test_copy_attributes/src", modTime, modTime))
+
+	// the new directory is writable until its attributes are applied
+	operation := NewOperation()
+	assert.NoError(t, operation.Copy("test_copy_attributes/src", "test_copy_attributes/dst"))
+	createTestFile(t, "test_copy_attributes/dst/file.txt", 0o644, time.Now(), []byte{})
+	assert.False(t, operation.Equal("test_copy_attributes/src", "test_copy_attributes/dst"))
+
+	assert.NoError(t, operation.CopyAttributes("test_copy_attributes/src", "test_copy_attributes/dst"))
+
+	state, err := os.Stat("test_copy_attributes/dst")
+	assert.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o500), state.Mode().Perm())
+	assert.Equal(t, modTime, state.ModTime())
+	assert.True(t, operation.Equal("test_copy_attributes/src", "test_copy_attributes/dst"))
+}
+
+// countingBackend counts the bytes written at offsets.
+type countingBackend struct {
+	LocalBackend
+	written int64
+}
+
+func (c *countingBackend) OpenFile(name string) (RandomAccessFile, error) {
+	file, err := c.LocalBackend.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingFile{RandomAccessFile: file, written: &c.written}, nil
+}
+
+type countingFile struct {
+	RandomAccessFile
+	written *int64
+}
+
+func (c *countingFile) WriteAt(data []byte, offset int64) (int, error) {
+	*c.written += int64(len(data))
+
+	return c.RandomAccessFile.WriteAt(data, offset)
+}
+
 func createTestFile(t *testing.T, path string, mod fs.FileMode, modTime time.Time, content []byte) {
 	t.Helper()
 
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// owner returns the user and group owning the file, false when they are unknown.
+func owner(state os.FileInfo) (int, int, bool) {
+	stat, ok := state.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return int(stat.Uid), int(stat.Gid), true
+}
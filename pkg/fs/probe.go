@@ -0,0 +1,218 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidProfile is returned for profile overrides which are not a comma separated list of known keys and values.
+var ErrInvalidProfile = errors.New("invalid file system profile")
+
+// probeDir is the directory Probe creates its files in, the scanner skips it like partial files.
+const probeDir = PartialPrefix + "probe"
+
+// probeTimes are the modify times set by Probe, with odd seconds and nanoseconds rounded differently.
+// The whole odd second is 1 s off on file systems storing 2 s, also when they round to the nearest time.
+var probeTimes = []time.Time{
+	time.Unix(1_000_000_001, 123_456_789), time.Unix(1_000_000_003, 987_654_321), time.Unix(1_000_000_005, 0), //nolint:gomnd
+}
+
+// granularities are the modify time precisions of common file systems, like NTFS and SMB with 100 ns,
+// exFAT with 10 ms and FAT with 2 s.
+var granularities = []time.Duration{
+	time.Nanosecond, 100 * time.Nanosecond, time.Microsecond, time.Millisecond, 10 * time.Millisecond, //nolint:gomnd
+	time.Second, 2 * time.Second, //nolint:gomnd
+}
+
+// Profile is what a file system supports, as detected by Probe.
+type Profile struct {
+	// TimeGranularity is the precision of the stored modify times, modify times closer than it are equal.
+	TimeGranularity time.Duration
+	// Permissions is true when the permission bits are stored.
+	Permissions bool
+	// CaseSensitive is true when names differing only by case are different entries.
+	CaseSensitive bool
+	// NormalizationSensitive is true when names differing only by Unicode normalization are different entries,
+	// unlike on APFS or HFS+.
+	NormalizationSensitive bool
+	// Xattrs is true when extended attributes can be set.
+	Xattrs bool
+	// Symlinks is true when symlinks can be created.
+	Symlinks bool
+}
+
+// FullProfile supports everything with exact modify times, it is the profile of unprobed file systems.
+var FullProfile = Profile{
+	Permissions: true, CaseSensitive: true, NormalizationSensitive: true, Xattrs: true, Symlinks: true,
+}
+
+// String returns the profile in the format of ParseProfile.
+func (p Profile) String() string {
+	return fmt.Sprintf("time=%s,perms=%t,case-sensitive=%t,normalization-sensitive=%t,xattrs=%t,symlinks=%t",
+		p.TimeGranularity, p.Permissions, p.CaseSensitive, p.NormalizationSensitive, p.Xattrs, p.Symlinks)
+}
+
+// ParseProfile overrides the values of the profile with the comma separated list of key=value pairs,
+// like `time=2s,perms=false`. The keys are time, perms, case-sensitive, normalization-sensitive, xattrs and symlinks.
+func ParseProfile(overrides string, profile Profile) (Profile, error) {
+	if overrides == "" {
+		return profile, nil
+	}
+
+	for _, override := range strings.Split(overrides, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(override), "=")
+
+		var err error
+
+		switch key {
+		case "time":
+			profile.TimeGranularity, err = time.ParseDuration(value)
+		case "perms":
+			profile.Permissions, err = strconv.ParseBool(value)
+		case "case-sensitive":
+			profile.CaseSensitive, err = strconv.ParseBool(value)
+		case "normalization-sensitive":
+			profile.NormalizationSensitive, err = strconv.ParseBool(value)
+		case "xattrs":
+			profile.Xattrs, err = strconv.ParseBool(value)
+		case "symlinks":
+			profile.Symlinks, err = strconv.ParseBool(value)
+		default:
+			err = errors.ErrUnsupported
+		}
+
+		if err != nil {
+			return profile, fmt.Errorf("%w: %s", ErrInvalidProfile, override)
+		}
+	}
+
+	return profile, nil
+}
+
+// WithProfile adapts the operation to the dst file system: modify times closer than its granularity are equal,
+// and without permissions the modes are neither compared nor applied.
+func WithProfile(profile Profile) OperationOption {
+	return func(o *Operation) {
+		o.profile = profile
+	}
+}
+
+// Probe detects the profile of the file system holding the existing directory by writing test files
+// in a temporary directory within it. Capabilities which can't be tested are unsupported.
+func Probe(backend Backend, dir string) (Profile, error) {
+	root := filepath.Join(dir, probeDir)
+	if err := backend.Mkdir(root, 0o700); err != nil { //nolint:gomnd
+		return Profile{}, err
+	}
+
+	defer backend.RemoveAll(root) //nolint:errcheck
+
+	file := filepath.Join(root, "probe")
+
+	writer, err := backend.Create(file, 0o600) //nolint:gomnd
+	if err != nil {
+		return Profile{}, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return Profile{}, err
+	}
+
+	// a second file with the name in upper case is found only on case-insensitive file systems
+	_, err = backend.Lstat(filepath.Join(root, "PROBE"))
+
+	return Profile{
+		TimeGranularity:        probeGranularity(backend, file),
+		Permissions:            probePermissions(backend, file),
+		CaseSensitive:          errors.Is(err, fs.ErrNotExist),
+		NormalizationSensitive: probeNormalization(backend, root),
+		Xattrs:                 probeXattrs(backend, file),
+		Symlinks:               probeSymlinks(backend, root),
+	}, nil
+}
+
+// probeNormalization reports whether a file with a composed name is not found by its decomposed name.
+func probeNormalization(backend Backend, root string) bool {
+	writer, err := backend.Create(filepath.Join(root, "probe-\u00e9"), 0o600) //nolint:gomnd
+	if err != nil {
+		return true
+	}
+
+	if err := writer.Close(); err != nil {
+		return true
+	}
+
+	_, err = backend.Lstat(filepath.Join(root, "probe-e\u0301"))
+
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// probeGranularity sets the probe times and returns the smallest granularity their stored times are within,
+// the largest one when they can't be set.
+func probeGranularity(backend Backend, file string) time.Duration {
+	var deviation time.Duration
+
+	for _, probeTime := range probeTimes {
+		if err := backend.Chtimes(file, probeTime, probeTime); err != nil {
+			return granularities[len(granularities)-1]
+		}
+
+		state, err := backend.Stat(file)
+		if err != nil {
+			return granularities[len(granularities)-1]
+		}
+
+		deviation = max(deviation, state.ModTime().Sub(probeTime).Abs())
+	}
+
+	for _, granularity := range granularities {
+		if deviation < granularity {
+			return granularity
+		}
+	}
+
+	return granularities[len(granularities)-1]
+}
+
+// probePermissions reports whether two different modes are stored.
+func probePermissions(backend Backend, file string) bool {
+	for _, perm := range []fs.FileMode{0o640, 0o600} {
+		if err := backend.Chmod(file, perm); err != nil {
+			return false
+		}
+
+		if state, err := backend.Stat(file); err != nil || state.Mode().Perm() != perm {
+			return false
+		}
+	}
+
+	return true
+}
+
+// probeXattrs reports whether an extended attribute can be set on backends supporting them.
+func probeXattrs(backend Backend, file string) bool {
+	if mux, ok := backend.(*Mux); ok {
+		backend, file = mux.Resolve(file)
+	}
+
+	xattrs, ok := backend.(XattrBackend)
+
+	return ok && xattrs.SetXattr(file, "user.dtsync.probe", []byte("probe")) == nil
+}
+
+// probeSymlinks reports whether a symlink can be created on backends supporting them.
+func probeSymlinks(backend Backend, root string) bool {
+	link := filepath.Join(root, "link")
+	if mux, ok := backend.(*Mux); ok {
+		backend, link = mux.Resolve(link)
+	}
+
+	symlinker, ok := backend.(Symlinker)
+
+	return ok && symlinker.Symlink("probe", link) == nil
+}
@@ -0,0 +1,117 @@
+package fs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/unicode/norm"
+)
+
+// fatBackend stores the modify times in 2 seconds and ignores the modes, like FAT.
+type fatBackend struct {
+	LocalBackend
+}
+
+func (fatBackend) Chmod(string, fs.FileMode) error {
+	return nil
+}
+
+func (b fatBackend) Chtimes(name string, atime, mtime time.Time) error {
+	return b.LocalBackend.Chtimes(name, atime, mtime.Round(2*time.Second))
+}
+
+// apfsBackend finds the names in any normalization form, like APFS.
+type apfsBackend struct {
+	LocalBackend
+}
+
+func (b apfsBackend) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	return b.LocalBackend.Create(norm.NFC.String(name), perm)
+}
+
+func (b apfsBackend) Lstat(name string) (fs.FileInfo, error) {
+	return b.LocalBackend.Lstat(norm.NFC.String(name))
+}
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_probe")
+	})
+	assert.NoError(t, os.Mkdir("test_probe", 0o755))
+
+	t.Run("Local", func(t *testing.T) {
+		t.Parallel()
+
+		profile, err := Probe(LocalBackend{}, "test_probe")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Nanosecond, profile.TimeGranularity)
+		assert.True(t, profile.CaseSensitive)
+		assert.True(t, profile.NormalizationSensitive)
+		assert.True(t, profile.Symlinks)
+	})
+
+	t.Run("FAT", func(t *testing.T) {
+		t.Parallel()
+
+		mux := NewMux()
+		mux.Mount("test_probe/fat", "test_probe/fat", fatBackend{})
+		assert.NoError(t, os.Mkdir("test_probe/fat", 0o755))
+
+		profile, err := Probe(mux, "test_probe/fat")
+		assert.NoError(t, err)
+		assert.Equal(t, 2*time.Second, profile.TimeGranularity)
+		assert.False(t, profile.Permissions)
+		assert.NoDirExists(t, "test_probe/fat/"+probeDir)
+	})
+
+	t.Run("APFS", func(t *testing.T) {
+		t.Parallel()
+
+		assert.NoError(t, os.Mkdir("test_probe/apfs", 0o755))
+
+		profile, err := Probe(apfsBackend{}, "test_probe/apfs")
+		assert.NoError(t, err)
+		assert.True(t, profile.CaseSensitive)
+		assert.False(t, profile.NormalizationSensitive)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := Probe(LocalBackend{}, "test_probe/missing")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+func TestParseProfile(t *testing.T) {
+	t.Parallel()
+
+	profile, err := ParseProfile("time=2s, perms=false,case-sensitive=false,normalization-sensitive=false", FullProfile)
+	assert.NoError(t, err)
+	assert.Equal(t, Profile{TimeGranularity: 2 * time.Second, Xattrs: true, Symlinks: true}, profile)
+
+	parsed, err := ParseProfile(profile.String(), FullProfile)
+	assert.NoError(t, err)
+	assert.Equal(t, profile, parsed)
+
+	profile, err = ParseProfile("", FullProfile)
+	assert.NoError(t, err)
+	assert.Equal(t, FullProfile, profile)
+
+	profile, err = ParseProfile("xattrs=false,symlinks=false", FullProfile)
+	assert.NoError(t, err)
+	assert.False(t, profile.Xattrs)
+	assert.False(t, profile.Symlinks)
+	assert.Contains(t, profile.String(), ",xattrs=false,symlinks=false")
+
+	for _, overrides := range []string{"time=fast", "perms", "owner=true", "xattrs=maybe"} {
+		_, err = ParseProfile(overrides, FullProfile)
+		assert.ErrorIs(t, err, ErrInvalidProfile, overrides)
+	}
+}
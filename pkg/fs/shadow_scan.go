@@ -1,12 +1,13 @@
 package fs
 
 import (
+	"context"
 	"errors"
 	"io/fs"
-	"os"
+	"path"
 	"path/filepath"
-
-	"golang.org/x/sys/unix"
+	"strings"
+	"sync"
 )
 
 var (
@@ -21,42 +22,102 @@ var (
 // When the callback returns an error, the scanner stops.
 type ScannerCallback = func(srcPath, dstPath string) error
 
+// ShadowScanOption configures a ShadowScan.
+type ShadowScanOption func(*ShadowScan)
+
+// WithNameMapper maps the scanned relative paths before they are joined with the dst root path.
+// Entries mapped to ErrSkipName are skipped, entries mapped to ErrUnknownName get an empty dst path.
+// Files with the ReservedPrefix in the root are skipped.
+func WithNameMapper(mapper NameMapper) ShadowScanOption {
+	return func(s *ShadowScan) {
+		s.mapper = mapper
+	}
+}
+
+// WithScanBackend walks the given backend instead of the local file system.
+func WithScanBackend(backend Backend) ShadowScanOption {
+	return func(s *ShadowScan) {
+		s.backend = backend
+	}
+}
+
+// WithErrorHandler passes the errors of the walk itself, like an unreadable directory, to the handler.
+// The walk continues when it returns nil, skipping the contents of the unreadable directory.
+func WithErrorHandler(handler func(path string, err error) error) ShadowScanOption {
+	return func(s *ShadowScan) {
+		s.errorHandler = handler
+	}
+}
+
 // ShadowScan provides FS scanning functionality.
 // The callback is called with the src and dst path.
 type ShadowScan struct {
-	stop bool
+	stopOnce     sync.Once
+	stopped      chan struct{}
+	mapper       NameMapper
+	backend      Backend
+	errorHandler func(path string, err error) error
+	namer        *namer
 }
 
 // NewShadowScan creates a new scanner.
-func NewShadowScan() ShadowScanI {
-	return &ShadowScan{}
+func NewShadowScan(options ...ShadowScanOption) ShadowScanI {
+	scanner := &ShadowScan{backend: LocalBackend{}, stopped: make(chan struct{})}
+
+	for _, option := range options {
+		option(scanner)
+	}
+
+	return scanner
 }
 
 // Start starts the scanner.
-func (s *ShadowScan) Start(srcRootPath, dstRootPath string, fileCallback, dirCallback ScannerCallback) chan error {
-	errChan := make(chan error)
-
-	if s.stop {
+// Temporary files with the PartialPrefix are skipped.
+// The walk stops before the next entry when ctx is canceled or the scanner is stopped,
+// a callback in progress is finished. The returned channel receives exactly one error.
+func (s *ShadowScan) Start(
+	ctx context.Context, srcRootPath, dstRootPath string, fileCallback, dirCallback ScannerCallback,
+) <-chan error {
+	errChan := make(chan error, 1)
+
+	if s.isStopped() {
 		errChan <- ErrShadowScanStopped
 
 		return errChan
 	}
 
 	go func() {
-		err := fs.WalkDir(os.DirFS(srcRootPath), ".", func(srcPath string, dirEntry fs.DirEntry, err error) error {
+		walkFS := backendFS{backend: s.backend, root: srcRootPath}
+
+		var names *names
+		if s.namer != nil {
+			names = s.namer.newNames(s.backend, srcRootPath, dstRootPath)
+		}
+
+		err := fs.WalkDir(walkFS, ".", func(srcPath string, dirEntry fs.DirEntry, err error) error {
 			switch {
-			case errors.Is(err, unix.ENOENT):
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case s.isStopped():
+				return ErrShadowScanStopped
+			case errors.Is(err, fs.ErrNotExist):
 				return nil
+			case err != nil && s.errorHandler != nil:
+				return s.errorHandler(filepath.Join(srcRootPath, srcPath), err)
 			case err != nil:
 				return err
-			case s.stop:
-				return ErrShadowScanStopped
 			}
 
-			dstPath := filepath.Join(
-				dstRootPath,
-				srcPath,
-			)
+			dstPath, err := s.dstPath(dstRootPath, srcPath, dirEntry.IsDir(), names)
+
+			switch {
+			case errors.Is(err, ErrSkipName) && dirEntry.IsDir():
+				return fs.SkipDir
+			case errors.Is(err, ErrSkipName):
+				return nil
+			case err != nil:
+				return err
+			}
 
 			if srcPath != "." {
 				srcPath = filepath.Join(
@@ -84,7 +145,51 @@ func (s *ShadowScan) Start(srcRootPath, dstRootPath string, fileCallback, dirCal
 	return errChan
 }
 
+// dstPath maps the relative path and joins it with the dst root path.
+// The names normalize the path before the mapper, or denormalize the mapped one when they are reversed.
+func (s *ShadowScan) dstPath(dstRootPath, relPath string, isDir bool, names *names) (string, error) {
+	if strings.HasPrefix(path.Base(relPath), PartialPrefix) {
+		return "", ErrSkipName
+	} else if s.mapper != nil && strings.HasPrefix(relPath, ReservedPrefix) && !strings.Contains(relPath, "/") {
+		return "", ErrSkipName
+	}
+
+	var err error
+	if names != nil && !names.reverse {
+		if relPath, err = names.normalize(relPath, isDir); err != nil {
+			return "", err
+		}
+	}
+
+	mapped := filepath.FromSlash(relPath)
+	if s.mapper != nil && relPath != "." {
+		mapped, err = s.mapper(mapped, isDir)
+		if errors.Is(err, ErrUnknownName) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	if names != nil && names.reverse {
+		mapped = names.denormalize(relPath, mapped, isDir)
+	}
+
+	return filepath.Join(dstRootPath, mapped), nil
+}
+
 // Stop stops the scanner.
 func (s *ShadowScan) Stop() {
-	s.stop = true
+	s.stopOnce.Do(func() {
+		close(s.stopped)
+	})
+}
+
+func (s *ShadowScan) isStopped() bool {
+	select {
+	case <-s.stopped:
+		return true
+	default:
+		return false
+	}
 }
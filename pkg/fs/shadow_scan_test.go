@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
@@ -22,6 +23,7 @@ func TestShadowScan(t *testing.T) {
 	createTestFile(t, "test_shadow_scan/b/hello.txt", 0x755, time.Now(), []byte{})
 	createTestFile(t, "test_shadow_scan/b/world.txt", 0x755, time.Now(), []byte{})
 	createTestFile(t, "test_shadow_scan/a/b/some.txt", 0x755, time.Now(), []byte{})
+	createTestFile(t, "test_shadow_scan/a/"+PartialPrefix+"hello.txt", 0x755, time.Now(), []byte{})
 
 	t.Run("Normal", func(t *testing.T) {
 		t.Parallel()
@@ -30,7 +32,7 @@ func TestShadowScan(t *testing.T) {
 		foundedFiles := map[string]string{}
 		foundedDirectories := map[string]string{}
 
-		errChan := scanner.Start("test_shadow_scan", "dest",
+		errChan := scanner.Start(context.Background(), "test_shadow_scan", "dest",
 			func(srcPath, dstPath string) error {
 				foundedFiles[srcPath] = dstPath
 
@@ -56,11 +58,44 @@ func TestShadowScan(t *testing.T) {
 		}, foundedDirectories)
 	})
 
+	t.Run("NameMapper", func(t *testing.T) {
+		t.Parallel()
+
+		scanner := NewShadowScan(WithNameMapper(func(name string, isDir bool) (string, error) {
+			switch name {
+			case "b":
+				return "", ErrSkipName
+			case "a/hello.txt":
+				return "", ErrUnknownName
+			}
+
+			return name + "_mapped", nil
+		}))
+		foundedFiles := map[string]string{}
+
+		errChan := scanner.Start(context.Background(), "test_shadow_scan", "dest",
+			func(srcPath, dstPath string) error {
+				foundedFiles[srcPath] = dstPath
+
+				return nil
+			},
+			func(srcPath, dstPath string) error {
+				return nil
+			},
+		)
+
+		err := <-errChan
+		assert.Equal(t, ErrScannerAtEnd, err)
+		assert.Equal(t, map[string]string{
+			"test_shadow_scan/a/b/some.txt": "dest/a/b/some.txt_mapped", "test_shadow_scan/a/hello.txt": "",
+		}, foundedFiles)
+	})
+
 	t.Run("Stopped", func(t *testing.T) {
 		t.Parallel()
 
 		scanner := NewShadowScan()
-		errChan := scanner.Start("test_shadow_scan", "dest",
+		errChan := scanner.Start(context.Background(), "test_shadow_scan", "dest",
 			func(srcPath, dstPath string) error {
 				time.Sleep(time.Second)
 
@@ -80,4 +115,55 @@ func TestShadowScan(t *testing.T) {
 		assert.Error(t, err)
 		assert.Equal(t, ErrShadowScanStopped, err)
 	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		scanned := 0
+
+		// the callback in progress when ctx is canceled is finished, the next entry is not scanned
+		errChan := NewShadowScan().Start(ctx, "test_shadow_scan", "dest",
+			func(srcPath, dstPath string) error {
+				return nil
+			},
+			func(srcPath, dstPath string) error {
+				cancel()
+				scanned++
+
+				return nil
+			},
+		)
+
+		assert.ErrorIs(t, <-errChan, context.Canceled)
+		assert.Equal(t, 1, scanned)
+	})
+
+	t.Run("ErrorHandler", func(t *testing.T) {
+		t.Parallel()
+
+		var handled []string
+
+		scanner := NewShadowScan(WithErrorHandler(func(path string, err error) error {
+			handled = append(handled, path)
+
+			return nil
+		}))
+
+		// the walk of a path below a file fails, the handler continues it
+		errChan := scanner.Start(context.Background(), "test_shadow_scan/a/hello.txt/x", "dest", nil, nil)
+		assert.Equal(t, ErrScannerAtEnd, <-errChan)
+		assert.Equal(t, []string{"test_shadow_scan/a/hello.txt/x"}, handled)
+	})
+
+	t.Run("StartStopped", func(t *testing.T) {
+		t.Parallel()
+
+		scanner := NewShadowScan()
+		scanner.Stop()
+		scanner.Stop()
+
+		errChan := scanner.Start(context.Background(), "test_shadow_scan", "dest", nil, nil)
+		assert.Equal(t, ErrShadowScanStopped, <-errChan)
+	})
 }
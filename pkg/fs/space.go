@@ -0,0 +1,31 @@
+package fs
+
+import "errors"
+
+// Space is the free space of a file system.
+// Files is the total number of inodes, 0 when the file system allocates them dynamically.
+type Space struct {
+	FreeBytes uint64
+	Files     uint64
+	FreeFiles uint64
+}
+
+// SpaceBackend is implemented by backends which report the free space of their file system.
+type SpaceBackend interface {
+	// Space returns the free space of the file system holding the path.
+	Space(name string) (Space, error)
+}
+
+// FreeSpace returns the free space of the file system holding the path.
+// It returns errors.ErrUnsupported when the backend doesn't report it.
+func FreeSpace(backend Backend, name string) (Space, error) {
+	if mux, ok := backend.(*Mux); ok {
+		backend, name = mux.Resolve(name)
+	}
+
+	if space, ok := backend.(SpaceBackend); ok {
+		return space.Space(name)
+	}
+
+	return Space{}, errors.ErrUnsupported
+}
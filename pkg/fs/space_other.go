@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package fs
+
+import "errors"
+
+// Space is not supported on this platform.
+func (LocalBackend) Space(string) (Space, error) {
+	return Space{}, errors.ErrUnsupported
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package fs
+
+import "syscall"
+
+// Space returns the free space of the file system holding the path, available to unprivileged users.
+func (LocalBackend) Space(name string) (Space, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(name, &stat); err != nil {
+		return Space{}, err
+	}
+
+	return Space{
+		FreeBytes: uint64(stat.Bavail) * uint64(stat.Bsize), //nolint:unconvert
+		Files:     uint64(stat.Files),                       //nolint:unconvert
+		FreeFiles: uint64(stat.Ffree),                       //nolint:unconvert
+	}, nil
+}
@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// sparseBlockSize is the size of the zero blocks which are left as holes, the block size of most file systems.
+const sparseBlockSize = 4096
+
+// zeroBlock is compared with the blocks written by sparse copies.
+var zeroBlock = make([]byte, sparseBlockSize)
+
+// sparseFile is a file which can be written with holes, like local and SFTP files.
+type sparseFile interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+}
+
+// copySparse copies the reader into the empty file, seeking over the zero blocks instead of writing them
+// and truncating the file to its size at the end, so the file systems leave holes in their place.
+// Files which can't seek are written completely.
+func copySparse(writer io.Writer, reader io.Reader) error {
+	file, ok := writer.(sparseFile)
+	if !ok {
+		_, err := io.Copy(writer, reader)
+
+		return err
+	}
+
+	var (
+		buffer   = make([]byte, 64*sparseBlockSize) //nolint:gomnd
+		offset   int64
+		position int64
+	)
+
+	for {
+		n, err := io.ReadFull(reader, buffer)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		for data := buffer[:n]; len(data) > 0; {
+			// the run of blocks which are all zero or all not zero
+			zero := isZero(data[:min(len(data), sparseBlockSize)])
+			end := min(len(data), sparseBlockSize)
+
+			for end < len(data) && isZero(data[end:min(len(data), end+sparseBlockSize)]) == zero {
+				end = min(len(data), end+sparseBlockSize)
+			}
+
+			if !zero {
+				if position != offset {
+					if _, err := file.Seek(offset, io.SeekStart); err != nil {
+						return err
+					}
+				}
+
+				if _, err := file.Write(data[:end]); err != nil {
+					return err
+				}
+
+				position = offset + int64(end)
+			}
+
+			offset += int64(end)
+			data = data[end:]
+		}
+	}
+
+	if position != offset {
+		return file.Truncate(offset)
+	}
+
+	return nil
+}
+
+// isZero reports whether the block of at most sparseBlockSize bytes contains only zeros.
+func isZero(block []byte) bool {
+	return bytes.Equal(block, zeroBlock[:len(block)])
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package fs
+
+import "os"
+
+// hasHoles is not supported on this platform, the files are copied completely.
+func hasHoles(os.FileInfo) bool {
+	return false
+}
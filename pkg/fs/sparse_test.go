@@ -0,0 +1,123 @@
+//go:build linux
+
+package fs
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopySparse(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_copy_sparse")
+	})
+	assert.NoError(t, os.MkdirAll("test_copy_sparse/stream", 0o755))
+
+	const size = 8 * 1024 * 1024
+
+	// the sparse file has data in the middle, the dense one has zeros around it
+	sparse, err := os.Create("test_copy_sparse/sparse")
+	assert.NoError(t, err)
+	assert.NoError(t, sparse.Truncate(size))
+	_, err = sparse.WriteAt([]byte("data"), size/2)
+	assert.NoError(t, err)
+	assert.NoError(t, sparse.Close())
+
+	content, err := os.ReadFile("test_copy_sparse/sparse")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile("test_copy_sparse/dense", content, 0o644))
+
+	mux := NewMux()
+	mux.Mount("test_copy_sparse/stream", "test_copy_sparse/stream", streamBackend{})
+
+	for name, test := range map[string]struct {
+		src, dst string
+		options  []OperationOption
+		holes    bool
+	}{
+		"Kernel":           {"test_copy_sparse/sparse", "test_copy_sparse/kernel", nil, true},
+		"Stream":           {"test_copy_sparse/sparse", "test_copy_sparse/stream/sparse", []OperationOption{WithBackend(mux)}, true},
+		"Dense":            {"test_copy_sparse/dense", "test_copy_sparse/dense_copy", nil, false},
+		"ZeroBlocks":       {"test_copy_sparse/dense", "test_copy_sparse/zero_blocks", []OperationOption{WithSparse()}, true},
+		"ZeroBlocksStream": {"test_copy_sparse/dense", "test_copy_sparse/stream/dense", []OperationOption{WithSparse(), WithBackend(mux)}, true},
+	} {
+		name, test := name, test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var done int64
+
+			options := append([]OperationOption{WithProgress(func(progress Progress) {
+				done += progress.Delta
+			})}, test.options...)
+			assert.NoError(t, NewOperation(options...).Copy(test.src, test.dst))
+
+			data, err := os.ReadFile(test.dst)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(content, data))
+			assert.Equal(t, int64(size), done)
+			assert.Equal(t, test.holes, allocated(t, test.dst) < size/2)
+		})
+	}
+}
+
+func TestCopySparseTail(t *testing.T) {
+	t.Parallel()
+
+	var (
+		file    = &memoryFile{}
+		content = append(bytes.Repeat([]byte("a"), sparseBlockSize+1), make([]byte, 3*sparseBlockSize)...)
+	)
+
+	assert.NoError(t, copySparse(file, bytes.NewReader(content)))
+	assert.Equal(t, content, file.data)
+	assert.Equal(t, []int{sparseBlockSize * 2}, file.writes)
+}
+
+// memoryFile is a sparse file in memory recording the size of the writes.
+type memoryFile struct {
+	data     []byte
+	position int64
+	writes   []int
+}
+
+func (f *memoryFile) Write(data []byte) (int, error) {
+	if end := int(f.position) + len(data); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+
+	copy(f.data[f.position:], data)
+	f.position += int64(len(data))
+	f.writes = append(f.writes, len(data))
+
+	return len(data), nil
+}
+
+func (f *memoryFile) Seek(offset int64, _ int) (int64, error) {
+	f.position = offset
+
+	return offset, nil
+}
+
+func (f *memoryFile) Truncate(size int64) error {
+	f.data = append(f.data, make([]byte, int(size)-len(f.data))...)
+
+	return nil
+}
+
+// allocated returns the bytes allocated by the file.
+func allocated(t *testing.T, path string) int64 {
+	t.Helper()
+
+	state, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	return state.Sys().(*syscall.Stat_t).Blocks * 512
+}
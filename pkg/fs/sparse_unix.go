@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// hasHoles reports whether the file has less blocks allocated than its size needs, because it is sparse.
+func hasHoles(state os.FileInfo) bool {
+	stat, ok := state.Sys().(*syscall.Stat_t)
+
+	return ok && state.Mode().IsRegular() && int64(stat.Blocks)*512 < state.Size() //nolint:unconvert,gomnd
+}
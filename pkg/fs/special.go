@@ -0,0 +1,81 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrSpecialSkipped is returned for special files which are not recreated, sockets are never recreated.
+var ErrSpecialSkipped = errors.New("special file skipped")
+
+// SpecialSkipped returns ErrSpecialSkipped with the kind of the special file when it is not recreated,
+// FIFOs are recreated with specials and block and character devices with devices.
+// It returns nil for all other types.
+func SpecialSkipped(mode fs.FileMode, specials, devices bool) error {
+	switch {
+	case mode&fs.ModeSocket != 0:
+		return fmt.Errorf("%w: socket", ErrSpecialSkipped)
+	case mode&fs.ModeNamedPipe != 0 && !specials:
+		return fmt.Errorf("%w: fifo", ErrSpecialSkipped)
+	case mode&fs.ModeDevice != 0 && !devices:
+		return fmt.Errorf("%w: device", ErrSpecialSkipped)
+	case mode&fs.ModeIrregular != 0:
+		return fmt.Errorf("%w: irregular file", ErrSpecialSkipped)
+	}
+
+	return nil
+}
+
+// copySpecial recreates the FIFO or device file with the device number of src, it is created with the
+// PartialPrefix and renamed, so it replaces an existing dst.
+func (o *Operation) copySpecial(src, dst string, srcState os.FileInfo) error {
+	if err := SpecialSkipped(srcState.Mode(), o.specials, o.devices); err != nil {
+		return fmt.Errorf("%w: %s", err, src)
+	}
+
+	partial := filepath.Join(filepath.Dir(dst), PartialPrefix+filepath.Base(dst))
+	mknoder, name := mknoder(o.backend, partial)
+	dev, ok := device(srcState)
+
+	if mknoder == nil || !ok {
+		return fmt.Errorf("%w: special file %s", errors.ErrUnsupported, dst)
+	}
+
+	if err := mknoder.Mknod(name, srcState.Mode(), dev); err != nil {
+		return err
+	}
+
+	var err error
+
+	// mknod applies the umask
+	if o.profile.Permissions {
+		err = o.backend.Chmod(partial, srcState.Mode()&modeBits)
+	}
+
+	if err == nil {
+		err = o.backend.Chtimes(partial, time.Now(), srcState.ModTime())
+	}
+
+	if err == nil {
+		err = o.backend.Rename(partial, dst)
+	}
+
+	if err != nil {
+		o.backend.RemoveAll(partial) //nolint:errcheck
+	}
+
+	return err
+}
+
+// equalSpecial reports whether both special files have the same type, device number and mode.
+func (o *Operation) equalSpecial(srcState, dstState os.FileInfo) bool {
+	srcDev, srcOK := device(srcState)
+	dstDev, dstOK := device(dstState)
+
+	return srcState.Mode().Type() == dstState.Mode().Type() && srcOK == dstOK && srcDev == dstDev &&
+		(!o.profile.Permissions || srcState.Mode()&modeBits == dstState.Mode()&modeBits)
+}
@@ -0,0 +1,19 @@
+//go:build !(linux || darwin)
+
+package fs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// device is not supported on this platform.
+func device(os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// Mknod is not supported on this platform.
+func (LocalBackend) Mknod(string, fs.FileMode, uint64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,103 @@
+//go:build linux
+
+package fs
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestSpecialSkipped(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, SpecialSkipped(0o644, false, false))
+	assert.NoError(t, SpecialSkipped(fs.ModeDir|0o755, false, false))
+	assert.ErrorIs(t, SpecialSkipped(fs.ModeNamedPipe|0o644, false, true), ErrSpecialSkipped)
+	assert.NoError(t, SpecialSkipped(fs.ModeNamedPipe|0o644, true, false))
+	assert.ErrorIs(t, SpecialSkipped(fs.ModeDevice|fs.ModeCharDevice|0o666, true, false), ErrSpecialSkipped)
+	assert.NoError(t, SpecialSkipped(fs.ModeDevice|0o660, false, true))
+	assert.EqualError(t, SpecialSkipped(fs.ModeSocket|0o755, true, true), "special file skipped: socket")
+}
+
+func TestSyscallMode(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, uint32(0o640), syscallMode(fs.ModeNamedPipe|0o640))
+	assert.Equal(t, uint32(unix.S_ISUID|unix.S_ISGID|unix.S_ISVTX|0o755),
+		syscallMode(fs.ModeDevice|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky|0o755))
+}
+
+func TestCopySpecial(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_copy_special")
+	})
+	assert.NoError(t, os.MkdirAll("test_copy_special/src", 0o755))
+	assert.NoError(t, os.MkdirAll("test_copy_special/dst", 0o755))
+	assert.NoError(t, unix.Mkfifo("test_copy_special/src/fifo", 0o640))
+
+	listener, err := net.Listen("unix", "test_copy_special/src/socket")
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		t.Parallel()
+
+		operation := NewOperation()
+		assert.ErrorIs(t, operation.Copy("test_copy_special/src/fifo", "test_copy_special/dst/skipped"), ErrSpecialSkipped)
+		assert.NoFileExists(t, "test_copy_special/dst/skipped")
+	})
+
+	t.Run("FIFO", func(t *testing.T) {
+		t.Parallel()
+
+		operation := NewOperation(WithSpecials())
+		assert.NoError(t, operation.Copy("test_copy_special/src/fifo", "test_copy_special/dst/fifo"))
+
+		state, err := os.Lstat("test_copy_special/dst/fifo")
+		assert.NoError(t, err)
+		assert.Equal(t, fs.ModeNamedPipe|0o640, state.Mode())
+		assert.True(t, operation.Equal("test_copy_special/src/fifo", "test_copy_special/dst/fifo"))
+
+		// the existing one is replaced
+		assert.NoError(t, operation.Copy("test_copy_special/src/fifo", "test_copy_special/dst/fifo"))
+	})
+
+	t.Run("Socket", func(t *testing.T) {
+		t.Parallel()
+
+		operation := NewOperation(WithSpecials(), WithDevices())
+		assert.ErrorIs(t, operation.Copy("test_copy_special/src/socket", "test_copy_special/dst/socket"), ErrSpecialSkipped)
+	})
+
+	t.Run("Device", func(t *testing.T) {
+		t.Parallel()
+
+		err := unix.Mknod("test_copy_special/src/null", syscall.S_IFCHR|0o666, int(unix.Mkdev(1, 3)))
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip("creating devices is not permitted")
+		}
+
+		assert.NoError(t, err)
+
+		operation := NewOperation(WithDevices())
+		assert.NoError(t, operation.Copy("test_copy_special/src/null", "test_copy_special/dst/null"))
+
+		var stat unix.Stat_t
+		assert.NoError(t, unix.Lstat("test_copy_special/dst/null", &stat))
+		assert.Equal(t, uint32(syscall.S_IFCHR), stat.Mode&syscall.S_IFMT)
+		assert.Equal(t, unix.Mkdev(1, 3), stat.Rdev)
+		assert.True(t, operation.Equal("test_copy_special/src/null", "test_copy_special/dst/null"))
+	})
+}
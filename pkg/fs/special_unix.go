@@ -0,0 +1,63 @@
+//go:build linux || darwin
+
+package fs
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// device returns the device number of the device file, false when it is unknown.
+func device(state os.FileInfo) (uint64, bool) {
+	stat, ok := state.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(stat.Rdev), true //nolint:unconvert
+}
+
+// Mknod creates a FIFO or device file.
+func (LocalBackend) Mknod(name string, mode fs.FileMode, dev uint64) error {
+	perm := syscallMode(mode)
+
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		perm |= unix.S_IFIFO
+	case mode&fs.ModeCharDevice != 0:
+		perm |= unix.S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		perm |= unix.S_IFBLK
+	default:
+		return &os.PathError{Op: "mknod", Path: name, Err: syscall.EINVAL}
+	}
+
+	if err := unix.Mknod(name, perm, int(dev)); err != nil {
+		return &os.PathError{Op: "mknod", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// syscallMode returns the permissions and the setuid, setgid and sticky bits of the mode in the bits of the system,
+// like os.Chmod converts them.
+func syscallMode(mode fs.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+
+	if mode&fs.ModeSetuid != 0 {
+		perm |= unix.S_ISUID
+	}
+
+	if mode&fs.ModeSetgid != 0 {
+		perm |= unix.S_ISGID
+	}
+
+	if mode&fs.ModeSticky != 0 {
+		perm |= unix.S_ISVTX
+	}
+
+	return perm
+}
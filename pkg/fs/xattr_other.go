@@ -0,0 +1,10 @@
+//go:build !(linux || darwin)
+
+package fs
+
+import "errors"
+
+// SetXattr is not supported on this platform.
+func (LocalBackend) SetXattr(string, string, []byte) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package fs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetXattr sets the extended attribute of the file.
+func (LocalBackend) SetXattr(name, attr string, value []byte) error {
+	if err := unix.Setxattr(name, attr, value, 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+
+	return nil
+}
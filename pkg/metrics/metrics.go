@@ -0,0 +1,274 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dtsync/pkg/screen"
+)
+
+// DurationBuckets are the upper bounds in seconds of the run duration histogram.
+var DurationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600}
+
+// metric is the description of a metric family.
+type metric struct {
+	name, help, kind string
+}
+
+var (
+	entriesTotal  = metric{"dtsync_entries_total", "Files and directories handled, by action.", "counter"}
+	bytesTotal    = metric{"dtsync_bytes_total", "Bytes of the files handled, by action.", "counter"}
+	scannedTotal  = metric{"dtsync_scanned_total", "Entries scanned, by tree and type.", "counter"}
+	copiesTotal   = metric{"dtsync_copies_total", "Files copied or replaced, by copy method.", "counter"}
+	errorsTotal   = metric{"dtsync_errors_total", "Runs stopped by an error, by error type.", "counter"}
+	failuresTotal = metric{"dtsync_failures_total", "Entries failed in runs continuing on errors, by cause.", "counter"}
+	runsTotal     = metric{"dtsync_runs_total", "Finished runs, by result.", "counter"}
+	inFlight      = metric{"dtsync_operations_in_flight", "File operations in progress.", "gauge"}
+	retryQueue    = metric{"dtsync_retry_queue_depth", "Failed entries waiting for a retry.", "gauge"}
+	lastRun       = metric{"dtsync_last_run_timestamp_seconds", "Unix time of the end of the last run.", "gauge"}
+	runDuration   = metric{"dtsync_run_duration_seconds", "Duration of the finished runs.", "histogram"}
+	scalarMetrics = []metric{
+		entriesTotal, bytesTotal, scannedTotal, copiesTotal, errorsTotal, failuresTotal, runsTotal, inFlight, retryQueue,
+		lastRun,
+	}
+)
+
+// Registry collects the progress and results of the sync runs and serves them in the Prometheus text format.
+// The series carry the job name as label, unless it is empty.
+type Registry struct {
+	lock       sync.Mutex
+	values     map[string]map[string]float64 // metric name -> labels -> value
+	histograms map[string]*histogram         // labels -> run duration
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{values: map[string]map[string]float64{}, histograms: map[string]*histogram{}}
+}
+
+// AddStatus adds the progress of a run of the job.
+func (r *Registry) AddStatus(job string, status screen.Status) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for action, count := range map[string]int{
+		"copied": status.Copied, "replaced": status.Replaced, "removed": status.Removed, "skipped": status.Skipped,
+		"failed": status.Failed, "conflicted": status.Conflicts,
+	} {
+		r.add(entriesTotal, float64(count), "job", job, "action", action)
+	}
+
+	for action, size := range map[string]int64{
+		"copied": status.CopiedBytes, "replaced": status.ReplacedBytes,
+		"removed": status.RemovedBytes, "skipped": status.SkippedBytes,
+	} {
+		r.add(bytesTotal, float64(size), "job", job, "action", action)
+	}
+
+	r.add(scannedTotal, float64(status.SrcTotalFiles), "job", job, "tree", "src", "type", "file")
+	r.add(scannedTotal, float64(status.SrcTotalDirectories), "job", job, "tree", "src", "type", "directory")
+	r.add(scannedTotal, float64(status.DstTotalFiles), "job", job, "tree", "dst", "type", "file")
+	r.add(scannedTotal, float64(status.DstTotalDirectories), "job", job, "tree", "dst", "type", "directory")
+	for method, count := range map[string]int{
+		"reflink": status.Reflinked, "kernel": status.KernelCopied, "stream": status.Streamed,
+	} {
+		r.add(copiesTotal, float64(count), "job", job, "method", method)
+	}
+
+	for cause, count := range map[string]int{
+		"permission": status.PermissionFailed, "not_exist": status.NotExistFailed, "exist": status.ExistFailed,
+		"no_space": status.NoSpaceFailed, "transient": status.TransientFailed, "other": status.OtherFailed,
+	} {
+		r.add(failuresTotal, float64(count), "job", job, "cause", cause)
+	}
+
+	r.add(inFlight, float64(status.Active), "job", job)
+	r.add(retryQueue, float64(status.Retrying), "job", job)
+}
+
+// Finished records the end of a run of the job, err is nil for successful runs.
+func (r *Registry) Finished(job string, duration time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	result := "succeeded"
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		result = "canceled"
+	case err != nil:
+		result = "failed"
+		r.add(errorsTotal, 1, "job", job, "type", ErrorType(err))
+	}
+
+	r.add(runsTotal, 1, "job", job, "result", result)
+	r.set(lastRun, float64(time.Now().Unix()), "job", job)
+
+	key := labels("job", job)
+
+	h, ok := r.histograms[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(DurationBuckets))}
+		r.histograms[key] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range DurationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+
+	h.count++
+	h.sum += seconds
+}
+
+// ErrorType classifies the error for the type label of the errors.
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, iofs.ErrNotExist):
+		return "not_exist"
+	case errors.Is(err, iofs.ErrExist):
+		return "exist"
+	case errors.Is(err, iofs.ErrPermission):
+		return "permission"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return "connection"
+	}
+
+	return "other"
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (r *Registry) WriteTo(writer io.Writer) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var builder strings.Builder
+
+	for _, metric := range scalarMetrics {
+		series := r.values[metric.name]
+		if len(series) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&builder, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+
+		for _, key := range sortedKeys(series) {
+			fmt.Fprintf(&builder, "%s%s %s\n", metric.name, key, formatValue(series[key]))
+		}
+	}
+
+	if len(r.histograms) > 0 {
+		fmt.Fprintf(&builder, "# HELP %s %s\n# TYPE %s %s\n",
+			runDuration.name, runDuration.help, runDuration.name, runDuration.kind)
+	}
+
+	for _, key := range sortedKeys(r.histograms) {
+		h := r.histograms[key]
+
+		for i, bound := range DurationBuckets {
+			fmt.Fprintf(&builder, "%s_bucket%s %d\n", runDuration.name, withLabel(key, "le", formatValue(bound)), h.buckets[i])
+		}
+
+		fmt.Fprintf(&builder, "%s_bucket%s %d\n", runDuration.name, withLabel(key, "le", "+Inf"), h.count)
+		fmt.Fprintf(&builder, "%s_sum%s %s\n", runDuration.name, key, formatValue(h.sum))
+		fmt.Fprintf(&builder, "%s_count%s %d\n", runDuration.name, key, h.count)
+	}
+
+	written, err := io.WriteString(writer, builder.String())
+
+	return int64(written), err
+}
+
+// add adds the delta to the series, the caller holds the lock.
+func (r *Registry) add(metric metric, delta float64, labelPairs ...string) {
+	series, ok := r.values[metric.name]
+	if !ok {
+		series = map[string]float64{}
+		r.values[metric.name] = series
+	}
+
+	series[labels(labelPairs...)] += delta
+}
+
+// set sets the value of the series, the caller holds the lock.
+func (r *Registry) set(metric metric, value float64, labelPairs ...string) {
+	series, ok := r.values[metric.name]
+	if !ok {
+		series = map[string]float64{}
+		r.values[metric.name] = series
+	}
+
+	series[labels(labelPairs...)] = value
+}
+
+// labels renders the name and value pairs as `{name="value",...}`, leaving out empty values.
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2) //nolint:gomnd
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			parts = append(parts, pairs[i]+`="`+escape(pairs[i+1])+`"`)
+		}
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// withLabel appends a label to rendered labels.
+func withLabel(key, name, value string) string {
+	label := name + `="` + escape(value) + `"`
+	if key == "" {
+		return "{" + label + "}"
+	}
+
+	return strings.TrimSuffix(key, "}") + "," + label + "}"
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
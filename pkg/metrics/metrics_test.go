@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"dtsync/pkg/screen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	registry := NewRegistry()
+	registry.AddStatus("home", screen.Status{
+		SrcTotalFiles: 2, Copied: 2, CopiedBytes: 300, Active: 1, Reflinked: 1, Streamed: 1,
+	})
+	registry.AddStatus("home", screen.Status{Active: -1, Retrying: 2, Failed: 1, PermissionFailed: 1})
+	registry.AddStatus("home", screen.Status{Retrying: -1, Failed: 1, TransientFailed: 1})
+	registry.AddStatus(`we"ird`, screen.Status{DstTotalDirectories: 1, Removed: 1})
+	registry.Finished("home", 10*time.Second, nil)
+	registry.Finished("home", 2*time.Minute, fmt.Errorf("copy: %w", os.ErrPermission))
+	registry.Finished("", time.Second, context.Canceled)
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+
+	for _, line := range []string{
+		"# TYPE dtsync_entries_total counter",
+		`dtsync_entries_total{job="home",action="copied"} 2`,
+		`dtsync_bytes_total{job="home",action="copied"} 300`,
+		`dtsync_scanned_total{job="home",tree="src",type="file"} 2`,
+		`dtsync_copies_total{job="home",method="reflink"} 1`,
+		`dtsync_copies_total{job="home",method="kernel"} 0`,
+		`dtsync_entries_total{job="we\"ird",action="removed"} 1`,
+		`dtsync_operations_in_flight{job="home"} 0`,
+		`dtsync_retry_queue_depth{job="home"} 1`,
+		`dtsync_failures_total{job="home",cause="permission"} 1`,
+		`dtsync_failures_total{job="home",cause="transient"} 1`,
+		`dtsync_failures_total{job="home",cause="no_space"} 0`,
+		`dtsync_errors_total{job="home",type="permission"} 1`,
+		`dtsync_runs_total{job="home",result="failed"} 1`,
+		`dtsync_runs_total{job="home",result="succeeded"} 1`,
+		`dtsync_runs_total{result="canceled"} 1`,
+		"# TYPE dtsync_run_duration_seconds histogram",
+		`dtsync_run_duration_seconds_bucket{job="home",le="5"} 0`,
+		`dtsync_run_duration_seconds_bucket{job="home",le="15"} 1`,
+		`dtsync_run_duration_seconds_bucket{job="home",le="300"} 2`,
+		`dtsync_run_duration_seconds_bucket{job="home",le="+Inf"} 2`,
+		`dtsync_run_duration_seconds_sum{job="home"} 130`,
+		`dtsync_run_duration_seconds_count{job="home"} 2`,
+		`dtsync_run_duration_seconds_bucket{le="1"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "not_exist", ErrorType(fmt.Errorf("open: %w", os.ErrNotExist)))
+	assert.Equal(t, "exist", ErrorType(os.ErrExist))
+	assert.Equal(t, "timeout", ErrorType(context.DeadlineExceeded))
+	assert.Equal(t, "connection", ErrorType(io.ErrUnexpectedEOF))
+	assert.Equal(t, "other", ErrorType(assert.AnError))
+}
@@ -0,0 +1,181 @@
+package repo
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	// DefaultMinChunkSize is the default lower bound of a chunk.
+	DefaultMinChunkSize = 256 << 10
+	// DefaultAvgChunkSize is the default targeted average size of a chunk.
+	DefaultAvgChunkSize = 1 << 20
+	// DefaultMaxChunkSize is the default upper bound of a chunk.
+	DefaultMaxChunkSize = 4 << 20
+)
+
+// ErrInvalidChunkerConfig is returned when the chunk size bounds are inconsistent.
+var ErrInvalidChunkerConfig = errors.New("invalid chunker config")
+
+// gear is the random lookup table of the gear rolling hash.
+var gear = newGearTable() //nolint:gochecknoglobals
+
+// ChunkerConfig holds the size bounds used for the content-defined chunking.
+type ChunkerConfig struct {
+	MinSize int `json:"min_size"`
+	AvgSize int `json:"avg_size"`
+	MaxSize int `json:"max_size"`
+}
+
+// DefaultChunkerConfig returns the default chunk size bounds.
+func DefaultChunkerConfig() ChunkerConfig {
+	return ChunkerConfig{
+		MinSize: DefaultMinChunkSize,
+		AvgSize: DefaultAvgChunkSize,
+		MaxSize: DefaultMaxChunkSize,
+	}
+}
+
+// Validate checks that the bounds are usable.
+func (c ChunkerConfig) Validate() error {
+	if c.MinSize <= 0 || c.MinSize >= c.AvgSize || c.AvgSize >= c.MaxSize ||
+		bits.OnesCount(uint(c.AvgSize)) != 1 {
+		return ErrInvalidChunkerConfig
+	}
+
+	return nil
+}
+
+// Chunker splits a stream into content-defined chunks using FastCDC
+// with normalized chunking, so that local changes only affect nearby chunks.
+type Chunker struct {
+	reader     io.Reader
+	config     ChunkerConfig
+	maskSmall  uint64
+	maskLarge  uint64
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+// NewChunker creates a new chunker reading from the given reader.
+func NewChunker(reader io.Reader, config ChunkerConfig) (*Chunker, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	avgBits := bits.TrailingZeros(uint(config.AvgSize))
+
+	return &Chunker{
+		reader:    reader,
+		config:    config,
+		maskSmall: topMask(avgBits + 1),
+		maskLarge: topMask(avgBits - 1),
+		buf:       make([]byte, config.MaxSize),
+	}, nil
+}
+
+// Next returns the next chunk or io.EOF when the stream is exhausted.
+// The returned slice is only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+
+	return chunk, nil
+}
+
+// fill makes sure that at least one maximum sized chunk is buffered (or the rest of the stream).
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= c.config.MaxSize {
+		return nil
+	}
+
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cut returns the length of the next chunk in data.
+func (c *Chunker) cut(data []byte) int {
+	size := len(data)
+	if size <= c.config.MinSize {
+		return size
+	}
+
+	if size > c.config.MaxSize {
+		size = c.config.MaxSize
+	}
+
+	normal := c.config.AvgSize
+	if size < normal {
+		normal = size
+	}
+
+	var hash uint64
+
+	i := c.config.MinSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.maskSmall == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < size; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.maskLarge == 0 {
+			return i + 1
+		}
+	}
+
+	return size
+}
+
+// topMask returns a mask with the given number of most significant bits set.
+func topMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// newGearTable creates a deterministic pseudo random table (splitmix64),
+// so that all repositories cut at the same positions.
+func newGearTable() [256]uint64 {
+	var (
+		table [256]uint64
+		state uint64 = 0x6474_7379_6e63 // "dtsync"
+	)
+
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunker(t *testing.T) {
+	t.Parallel()
+
+	config := ChunkerConfig{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10}
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data) //nolint:gosec
+
+	t.Run("Reassemble", func(t *testing.T) {
+		t.Parallel()
+
+		chunks := chunkAll(t, data, config)
+		assert.Equal(t, data, bytes.Join(chunks, nil))
+
+		for i, chunk := range chunks {
+			assert.LessOrEqual(t, len(chunk), config.MaxSize)
+
+			if i < len(chunks)-1 {
+				assert.GreaterOrEqual(t, len(chunk), config.MinSize)
+			}
+		}
+	})
+
+	t.Run("Deterministic", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, chunkAll(t, data, config), chunkAll(t, data, config))
+	})
+
+	t.Run("ShiftResistant", func(t *testing.T) {
+		t.Parallel()
+
+		shifted := append([]byte("inserted"), data...)
+		original := map[string]struct{}{}
+
+		for _, chunk := range chunkAll(t, data, config) {
+			original[string(chunk)] = struct{}{}
+		}
+
+		chunks := chunkAll(t, shifted, config)
+		shared := 0
+
+		for _, chunk := range chunks {
+			if _, ok := original[string(chunk)]; ok {
+				shared++
+			}
+		}
+
+		assert.Greater(t, shared, len(chunks)*9/10)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Empty(t, chunkAll(t, []byte{}, config))
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewChunker(bytes.NewReader(data), ChunkerConfig{MinSize: 1, AvgSize: 3, MaxSize: 8})
+		assert.ErrorIs(t, err, ErrInvalidChunkerConfig)
+	})
+}
+
+func chunkAll(t *testing.T, data []byte, config ChunkerConfig) [][]byte {
+	t.Helper()
+
+	chunker, err := NewChunker(bytes.NewReader(data), config)
+	assert.NoError(t, err)
+
+	var chunks [][]byte
+
+	for {
+		chunk, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			return chunks
+		}
+
+		assert.NoError(t, err)
+
+		chunks = append(chunks, append([]byte{}, chunk...))
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin)
+
+package repo
+
+// lock does not lock the repository on this platform.
+func (r *Repository) lock(bool) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,61 @@
+//go:build linux || darwin
+
+package repo
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"dtsync/pkg/fs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_lock")
+	})
+	assert.NoError(t, os.MkdirAll("test_lock/src", 0o755))
+
+	repository, err := Init("test_lock/repo", DefaultChunkerConfig())
+	assert.NoError(t, err)
+
+	// a running backup holds the shared lock
+	unlock, err := repository.lock(false)
+	assert.NoError(t, err)
+
+	_, _, err = repository.Backup(context.Background(), fs.NewShadowScan(), "test_lock/src")
+	assert.NoError(t, err)
+
+	_, err = repository.Prune(0)
+	assert.ErrorIs(t, err, ErrRepositoryLocked)
+
+	unlock()
+
+	// a running prune holds the exclusive lock
+	unlock, err = repository.lock(true)
+	assert.NoError(t, err)
+
+	_, _, err = repository.Backup(context.Background(), fs.NewShadowScan(), "test_lock/src")
+	assert.ErrorIs(t, err, ErrRepositoryLocked)
+
+	err = repository.Restore(LatestSnapshot, "test_lock/dst")
+	assert.ErrorIs(t, err, ErrRepositoryLocked)
+
+	unlock()
+
+	// a running restore holds the shared lock
+	unlock, err = repository.lock(false)
+	assert.NoError(t, err)
+
+	assert.NoError(t, repository.Restore(LatestSnapshot, "test_lock/dst"))
+
+	unlock()
+
+	stats, err := repository.Prune(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.RemovedSnapshots)
+}
@@ -0,0 +1,40 @@
+//go:build linux || darwin
+
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// lock takes the lock file of the repository, shared or exclusive, without waiting for other processes.
+// The returned function releases it.
+func (r *Repository) lock(exclusive bool) (func(), error) {
+	file, err := os.OpenFile(filepath.Join(r.root, lockFileName), os.O_RDONLY|os.O_CREATE, filePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+
+	if err := unix.Flock(int(file.Fd()), how|unix.LOCK_NB); err != nil {
+		file.Close()
+
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, ErrRepositoryLocked
+		}
+
+		return nil, &os.PathError{Op: "flock", Path: file.Name(), Err: err}
+	}
+
+	// closing the file releases the lock
+	return func() {
+		file.Close()
+	}, nil
+}
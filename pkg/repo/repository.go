@@ -0,0 +1,503 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dtsync/pkg/fs"
+)
+
+var (
+	// ErrRepositoryExists is returned when initializing an already existing repository.
+	ErrRepositoryExists = errors.New("repository already exists")
+	// ErrNotARepository is returned when the path does not contain a repository.
+	ErrNotARepository = errors.New("not a repository")
+	// ErrSnapshotNotFound is returned when the requested snapshot does not exist.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrCorruptChunk is returned when a chunk does not match its hash.
+	ErrCorruptChunk = errors.New("chunk is corrupt")
+	// ErrRepositoryLocked is returned when a prune runs at the same time as a backup or another prune.
+	ErrRepositoryLocked = errors.New("repository is locked by another backup, restore or prune")
+)
+
+const (
+	// LatestSnapshot refers to the most recent snapshot.
+	LatestSnapshot = "latest"
+
+	repositoryVersion = 1
+	configFileName    = "config.json"
+	lockFileName      = "lock"
+	chunksDirName     = "chunks"
+	snapshotsDirName  = "snapshots"
+	snapshotExtension = ".json"
+	dirPerm           = 0o700
+	filePerm          = 0o600
+)
+
+// EntryType is the type of snapshot entry.
+type EntryType string
+
+const (
+	// EntryDirectory is a directory entry.
+	EntryDirectory EntryType = "dir"
+	// EntryFile is a regular file entry.
+	EntryFile EntryType = "file"
+)
+
+// Config is the persisted repository configuration.
+type Config struct {
+	Version int           `json:"version"`
+	Chunker ChunkerConfig `json:"chunker"`
+}
+
+// Entry is a file or directory recorded in a snapshot.
+type Entry struct {
+	Path    string        `json:"path"`
+	Type    EntryType     `json:"type"`
+	Mode    iofs.FileMode `json:"mode"`
+	ModTime time.Time     `json:"mod_time"`
+	Size    int64         `json:"size"`
+	Chunks  []string      `json:"chunks,omitempty"`
+}
+
+// Snapshot is the file manifest of a single backup run.
+type Snapshot struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Entries []Entry   `json:"entries"`
+}
+
+// BackupStats summarizes a backup run.
+type BackupStats struct {
+	Files        int
+	Directories  int
+	Bytes        int64
+	NewChunks    int
+	NewBytes     int64
+	ReusedChunks int
+}
+
+// PruneStats summarizes a prune run.
+type PruneStats struct {
+	RemovedSnapshots int
+	RemovedChunks    int
+}
+
+// Repository is a content-addressed store of deduplicated chunks and snapshot manifests.
+type Repository struct {
+	root   string
+	config Config
+}
+
+// Init creates a new repository at the given path.
+func Init(root string, chunker ChunkerConfig) (*Repository, error) {
+	if err := chunker.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(root, configFileName)); err == nil {
+		return nil, ErrRepositoryExists
+	}
+
+	for _, dir := range []string{root, filepath.Join(root, chunksDirName), filepath.Join(root, snapshotsDirName)} {
+		if err := os.MkdirAll(dir, dirPerm); err != nil {
+			return nil, err
+		}
+	}
+
+	repository := &Repository{root: root, config: Config{Version: repositoryVersion, Chunker: chunker}}
+
+	data, err := json.MarshalIndent(repository.config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomic(filepath.Join(root, configFileName), data); err != nil {
+		return nil, err
+	}
+
+	return repository, nil
+}
+
+// Open opens an existing repository.
+func Open(root string) (*Repository, error) {
+	data, err := os.ReadFile(filepath.Join(root, configFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotARepository
+	} else if err != nil {
+		return nil, err
+	}
+
+	repository := &Repository{root: root}
+	if err := json.Unmarshal(data, &repository.config); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotARepository, err)
+	}
+
+	if repository.config.Version != repositoryVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrNotARepository, repository.config.Version)
+	}
+
+	return repository, repository.config.Chunker.Validate()
+}
+
+// Backup scans the source root with the given scanner and stores a new snapshot, unless ctx is canceled before.
+// Backups can run at the same time, but not during a prune, which would remove the chunks they reuse.
+func (r *Repository) Backup(
+	ctx context.Context, scanner fs.ShadowScanI, srcRootPath string,
+) (Snapshot, BackupStats, error) {
+	var stats BackupStats
+
+	unlock, err := r.lock(false)
+	if err != nil {
+		return Snapshot{}, stats, err
+	}
+
+	defer unlock()
+
+	snapshot := Snapshot{ID: newSnapshotID(), Time: time.Now().UTC(), Source: srcRootPath}
+
+	// the empty dst root makes the scanner report the relative path as dst path
+	err = <-scanner.Start(ctx, srcRootPath, "",
+		func(srcPath, relPath string) error {
+			state, err := os.Stat(srcPath)
+			if err != nil {
+				return err
+			} else if !state.Mode().IsRegular() {
+				return nil
+			}
+
+			chunks, err := r.storeFile(srcPath, &stats)
+			if err != nil {
+				return err
+			}
+
+			stats.Files++
+			stats.Bytes += state.Size()
+			snapshot.Entries = append(snapshot.Entries, Entry{
+				Path: filepath.ToSlash(relPath), Type: EntryFile, Mode: state.Mode(),
+				ModTime: state.ModTime(), Size: state.Size(), Chunks: chunks,
+			})
+
+			return nil
+		},
+		func(srcPath, relPath string) error {
+			state, err := os.Stat(srcPath)
+			if err != nil {
+				return err
+			}
+
+			stats.Directories++
+			snapshot.Entries = append(snapshot.Entries, Entry{
+				Path: filepath.ToSlash(relPath), Type: EntryDirectory,
+				Mode: state.Mode(), ModTime: state.ModTime(),
+			})
+
+			return nil
+		},
+	)
+	if err != nil && !errors.Is(err, fs.ErrScannerAtEnd) {
+		return Snapshot{}, stats, err
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return Snapshot{}, stats, err
+	}
+
+	return snapshot, stats, writeFileAtomic(r.snapshotPath(snapshot.ID), data)
+}
+
+// Restore writes the given snapshot (or LatestSnapshot) into the destination root.
+// It holds the shared lock, so a prune cannot remove the chunks being read.
+func (r *Repository) Restore(snapshotID, dstRootPath string) error {
+	unlock, err := r.lock(false)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	snapshot, err := r.Snapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dstRootPath, dirPerm); err != nil {
+		return err
+	}
+
+	var directories []Entry
+
+	for _, entry := range snapshot.Entries {
+		path := filepath.Join(dstRootPath, filepath.FromSlash(entry.Path))
+
+		switch entry.Type {
+		case EntryDirectory:
+			if err := os.MkdirAll(path, dirPerm); err != nil {
+				return err
+			}
+
+			directories = append(directories, entry)
+		case EntryFile:
+			if err := r.restoreFile(entry, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	// apply directory attributes last (children first), so restoring the content is not blocked
+	for i := len(directories) - 1; i >= 0; i-- {
+		path := filepath.Join(dstRootPath, filepath.FromSlash(directories[i].Path))
+		if err := applyAttributes(path, directories[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Snapshot loads the snapshot with the given id or LatestSnapshot.
+func (r *Repository) Snapshot(snapshotID string) (Snapshot, error) {
+	if snapshotID == LatestSnapshot {
+		snapshots, err := r.Snapshots()
+		if err != nil {
+			return Snapshot{}, err
+		} else if len(snapshots) == 0 {
+			return Snapshot{}, ErrSnapshotNotFound
+		}
+
+		return snapshots[len(snapshots)-1], nil
+	}
+
+	data, err := os.ReadFile(r.snapshotPath(snapshotID))
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, ErrSnapshotNotFound
+	} else if err != nil {
+		return Snapshot{}, err
+	}
+
+	var snapshot Snapshot
+
+	return snapshot, json.Unmarshal(data, &snapshot)
+}
+
+// Snapshots returns all snapshots, oldest first.
+func (r *Repository) Snapshots() ([]Snapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(r.root, snapshotsDirName))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]Snapshot, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotExtension) {
+			continue
+		}
+
+		snapshot, err := r.Snapshot(strings.TrimSuffix(entry.Name(), snapshotExtension))
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	return snapshots, nil
+}
+
+// Prune removes all but the newest keep snapshots and deletes chunks no longer referenced.
+// It fails with ErrRepositoryLocked while a backup or another prune runs.
+func (r *Repository) Prune(keep int) (PruneStats, error) {
+	var stats PruneStats
+
+	unlock, err := r.lock(true)
+	if err != nil {
+		return stats, err
+	}
+
+	defer unlock()
+
+	snapshots, err := r.Snapshots()
+	if err != nil {
+		return stats, err
+	}
+
+	for len(snapshots) > keep {
+		if err := os.Remove(r.snapshotPath(snapshots[0].ID)); err != nil {
+			return stats, err
+		}
+
+		snapshots = snapshots[1:]
+		stats.RemovedSnapshots++
+	}
+
+	referenced := map[string]struct{}{}
+
+	for _, snapshot := range snapshots {
+		for _, entry := range snapshot.Entries {
+			for _, chunk := range entry.Chunks {
+				referenced[chunk] = struct{}{}
+			}
+		}
+	}
+
+	err = filepath.WalkDir(filepath.Join(r.root, chunksDirName), func(path string, entry iofs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		if _, ok := referenced[entry.Name()]; ok {
+			return nil
+		}
+
+		stats.RemovedChunks++
+
+		return os.Remove(path)
+	})
+
+	return stats, err
+}
+
+// storeFile splits a file into chunks and stores the unknown ones.
+func (r *Repository) storeFile(path string, stats *BackupStats) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	chunker, err := NewChunker(file, r.config.Chunker)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+
+	for {
+		chunk, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			return hashes, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		hashes = append(hashes, hash)
+
+		chunkPath := r.chunkPath(hash)
+		if _, err := os.Stat(chunkPath); err == nil {
+			stats.ReusedChunks++
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(chunkPath), dirPerm); err != nil {
+			return nil, err
+		}
+
+		if err := writeFileAtomic(chunkPath, chunk); err != nil {
+			return nil, err
+		}
+
+		stats.NewChunks++
+		stats.NewBytes += int64(len(chunk))
+	}
+}
+
+// restoreFile reassembles a file from its chunks.
+func (r *Repository) restoreFile(entry Entry, path string) error {
+	destination, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, filePerm)
+	if err != nil {
+		return err
+	}
+
+	defer destination.Close()
+
+	for _, hash := range entry.Chunks {
+		chunk, err := os.ReadFile(r.chunkPath(hash))
+		if err != nil {
+			return err
+		}
+
+		if sum := sha256.Sum256(chunk); hex.EncodeToString(sum[:]) != hash {
+			return fmt.Errorf("%w: %s", ErrCorruptChunk, hash)
+		}
+
+		if _, err := destination.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	if err := destination.Close(); err != nil {
+		return err
+	}
+
+	return applyAttributes(path, entry)
+}
+
+func (r *Repository) chunkPath(hash string) string {
+	return filepath.Join(r.root, chunksDirName, hash[:2], hash)
+}
+
+func (r *Repository) snapshotPath(snapshotID string) string {
+	return filepath.Join(r.root, snapshotsDirName, filepath.Base(snapshotID)+snapshotExtension)
+}
+
+// applyAttributes sets the recorded mode and modify time.
+func applyAttributes(path string, entry Entry) error {
+	if err := os.Chmod(path, entry.Mode.Perm()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, time.Now(), entry.ModTime)
+}
+
+// writeFileAtomic writes the data into a temporary file and renames it to the final path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// newSnapshotID creates a sortable and unique snapshot id.
+func newSnapshotID() string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"dtsync/pkg/fs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_repository")
+	})
+	assert.NoError(t, os.MkdirAll("test_repository/src/dir", 0o755))
+
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(data) //nolint:gosec
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	assert.NoError(t, os.WriteFile("test_repository/src/dir/image.bin", data, 0o640))
+	assert.NoError(t, os.WriteFile("test_repository/src/small.txt", []byte("hello"), 0o600))
+	assert.NoError(t, os.Chtimes("test_repository/src/dir/image.bin", modTime, modTime))
+
+	config := ChunkerConfig{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10}
+	repository, err := Init("test_repository/repo", config)
+	assert.NoError(t, err)
+
+	_, err = Init("test_repository/repo", config)
+	assert.ErrorIs(t, err, ErrRepositoryExists)
+
+	repository, err = Open(repository.root)
+	assert.NoError(t, err)
+
+	first, firstStats, err := repository.Backup(context.Background(), fs.NewShadowScan(), "test_repository/src")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, firstStats.Files)
+	assert.Equal(t, 2, firstStats.Directories)
+	assert.Equal(t, int64(len(data)+5), firstStats.Bytes)
+
+	// change a few bytes in the middle of the large file
+	copy(data[100<<10:], "changed")
+	assert.NoError(t, os.WriteFile("test_repository/src/dir/image.bin", data, 0o640))
+	assert.NoError(t, os.Chtimes("test_repository/src/dir/image.bin", modTime, modTime))
+
+	time.Sleep(10 * time.Millisecond)
+
+	second, secondStats, err := repository.Backup(context.Background(), fs.NewShadowScan(), "test_repository/src")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Less(t, secondStats.NewBytes, firstStats.NewBytes/4)
+	assert.Greater(t, secondStats.ReusedChunks, 0)
+
+	t.Run("Restore", func(t *testing.T) {
+		assert.NoError(t, repository.Restore(LatestSnapshot, "test_repository/restore"))
+
+		restored, err := os.ReadFile("test_repository/restore/dir/image.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, restored)
+
+		state, err := os.Stat("test_repository/restore/dir/image.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o640), state.Mode().Perm())
+		assert.True(t, modTime.Equal(state.ModTime()))
+
+		assert.ErrorIs(t, repository.Restore("unknown", "test_repository/restore"), ErrSnapshotNotFound)
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		stats, err := repository.Prune(1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stats.RemovedSnapshots)
+		assert.Greater(t, stats.RemovedChunks, 0)
+
+		snapshots, err := repository.Snapshots()
+		assert.NoError(t, err)
+		assert.Len(t, snapshots, 1)
+		assert.Equal(t, second.ID, snapshots[0].ID)
+
+		assert.NoError(t, repository.Restore(second.ID, "test_repository/restore_pruned"))
+
+		restored, err := os.ReadFile("test_repository/restore_pruned/dir/image.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, restored)
+	})
+}
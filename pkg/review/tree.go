@@ -0,0 +1,292 @@
+package review
+
+import (
+	"path/filepath"
+	"sort"
+
+	"dtsync/pkg/fs"
+	"dtsync/pkg/sync"
+)
+
+// node is an entry of the plan or a directory grouping the entries below it.
+type node struct {
+	name string
+	path string
+	// entry is nil for directories without an action.
+	entry    *sync.PlanEntry
+	approved bool
+	expanded bool
+	depth    int
+	parent   *node
+	children []*node
+}
+
+// Tree is the plan grouped by directory, with the approvals, the folding and the action filter.
+// Hidden entries keep their approval.
+type Tree struct {
+	root   *node
+	filter map[sync.Action]bool
+	// rows are the visible nodes in display order, cursor is the index of the selected one.
+	rows   []*node
+	cursor int
+}
+
+// Actions are the actions of a plan, in display order.
+var Actions = []sync.Action{sync.ActionCopy, sync.ActionReplace, sync.ActionRemove}
+
+// NewTree groups the entries by their dst directories below the dst root, all of them approved.
+// Directories which are copied or removed as a whole are folded.
+func NewTree(dstRootPath string, entries []sync.PlanEntry) *Tree {
+	root := &node{name: filepath.Clean(dstRootPath), path: filepath.Clean(dstRootPath), expanded: true}
+	nodes := map[string]*node{root.path: root}
+
+	var find func(path string) *node
+
+	find = func(path string) *node {
+		if found, ok := nodes[path]; ok {
+			return found
+		}
+
+		parent := root
+		if dir := filepath.Dir(path); dir != path && fs.Within(dir, root.path) {
+			parent = find(dir)
+		}
+
+		created := &node{name: filepath.Base(path), path: path, expanded: true, depth: parent.depth + 1, parent: parent}
+		parent.children = append(parent.children, created)
+		nodes[path] = created
+
+		return created
+	}
+
+	for i := range entries {
+		found := find(filepath.Clean(entries[i].DstPath))
+		found.entry, found.approved = &entries[i], true
+		found.expanded = !entries[i].IsDir || entries[i].Action == sync.ActionReplace
+	}
+
+	var sortChildren func(parent *node)
+
+	sortChildren = func(parent *node) {
+		sort.Slice(parent.children, func(i, j int) bool {
+			return parent.children[i].name < parent.children[j].name
+		})
+
+		for _, child := range parent.children {
+			sortChildren(child)
+		}
+	}
+
+	sortChildren(root)
+
+	tree := &Tree{root: root, filter: map[sync.Action]bool{}}
+	for _, action := range Actions {
+		tree.filter[action] = true
+	}
+
+	tree.refresh()
+
+	return tree
+}
+
+// Approved returns the approved entries in tree order.
+func (t *Tree) Approved() []sync.PlanEntry {
+	var approved []sync.PlanEntry
+
+	t.walk(t.root, func(n *node) {
+		if n.entry != nil && n.approved {
+			approved = append(approved, *n.entry)
+		}
+	})
+
+	return approved
+}
+
+// Count returns the approved and all entries of the action and the bytes of the approved ones.
+func (t *Tree) Count(action sync.Action) (approved, total int, bytes int64) {
+	t.walk(t.root, func(n *node) {
+		if n.entry == nil || n.entry.Action != action {
+			return
+		}
+
+		total++
+
+		if n.approved {
+			approved++
+			bytes += n.entry.Size
+		}
+	})
+
+	return approved, total, bytes
+}
+
+// Filtered reports whether the entries of the action are shown.
+func (t *Tree) Filtered(action sync.Action) bool {
+	return t.filter[action]
+}
+
+// Filter shows or hides the entries of the action.
+func (t *Tree) Filter(action sync.Action) {
+	t.filter[action] = !t.filter[action]
+	t.refresh()
+}
+
+// ShowAll shows the entries of all actions.
+func (t *Tree) ShowAll() {
+	for _, action := range Actions {
+		t.filter[action] = true
+	}
+
+	t.refresh()
+}
+
+// Move moves the cursor by the rows, within the visible rows.
+func (t *Tree) Move(rows int) {
+	t.cursor = max(min(t.cursor+rows, len(t.rows)-1), 0)
+}
+
+// Expand unfolds the selected directory.
+func (t *Tree) Expand() {
+	if selected := t.selected(); selected != nil && len(selected.children) > 0 {
+		selected.expanded = true
+		t.refresh()
+	}
+}
+
+// Collapse folds the selected directory or, when it is folded, selects its parent.
+func (t *Tree) Collapse() {
+	selected := t.selected()
+
+	switch {
+	case selected == nil:
+	case selected.expanded && len(selected.children) > 0:
+		selected.expanded = false
+		t.refresh()
+	case selected.parent != nil:
+		for i, row := range t.rows {
+			if row == selected.parent {
+				t.cursor = i
+			}
+		}
+	}
+}
+
+// Toggle approves or rejects the selected entry with the shown entries below it.
+// Rejecting an entry also rejects the removals of its directories, which would remove it,
+// approving one also approves the copies of its directories, which it is copied into.
+func (t *Tree) Toggle() {
+	if selected := t.selected(); selected != nil {
+		t.set(selected, t.state(selected) != stateApproved)
+	}
+}
+
+// ApproveAll approves or rejects all shown entries.
+func (t *Tree) ApproveAll(approved bool) {
+	t.set(t.root, approved)
+}
+
+func (t *Tree) set(n *node, approved bool) {
+	t.walk(n, func(n *node) {
+		if n.entry != nil && t.filter[n.entry.Action] {
+			n.approved = approved
+		}
+	})
+
+	for parent := n.parent; parent != nil; parent = parent.parent {
+		switch {
+		case parent.entry == nil:
+		case !approved && parent.entry.Action == sync.ActionRemove:
+			parent.approved = false
+		case approved && parent.entry.Action == sync.ActionCopy:
+			parent.approved = true
+		}
+	}
+}
+
+// state is the approval of the shown entries of the node and below it.
+type state int
+
+const (
+	stateNone state = iota
+	stateRejected
+	statePartial
+	stateApproved
+)
+
+func (t *Tree) state(n *node) state {
+	var approved, total int
+
+	t.walk(n, func(n *node) {
+		if n.entry != nil && t.filter[n.entry.Action] {
+			total++
+
+			if n.approved {
+				approved++
+			}
+		}
+	})
+
+	switch {
+	case total == 0:
+		return stateNone
+	case approved == 0:
+		return stateRejected
+	case approved < total:
+		return statePartial
+	}
+
+	return stateApproved
+}
+
+// selected returns the node under the cursor, nil when nothing is shown.
+func (t *Tree) selected() *node {
+	if t.cursor >= len(t.rows) {
+		return nil
+	}
+
+	return t.rows[t.cursor]
+}
+
+// refresh lists the visible rows, the nodes with shown entries below unfolded directories,
+// and keeps the cursor on the selected node when it is still shown.
+func (t *Tree) refresh() {
+	selected := t.selected()
+	t.rows = t.rows[:0]
+
+	var list func(n *node)
+
+	list = func(n *node) {
+		if t.state(n) == stateNone {
+			return
+		}
+
+		t.rows = append(t.rows, n)
+
+		if n.expanded {
+			for _, child := range n.children {
+				list(child)
+			}
+		}
+	}
+
+	list(t.root)
+
+	for i, row := range t.rows {
+		if row == selected {
+			t.cursor = i
+
+			return
+		}
+	}
+
+	t.Move(0)
+}
+
+// walk calls the function for the node and all nodes below it.
+func (t *Tree) walk(n *node, function func(*node)) {
+	function(n)
+
+	for _, child := range n.children {
+		t.walk(child, function)
+	}
+}
@@ -0,0 +1,141 @@
+package review
+
+import (
+	"testing"
+
+	"dtsync/pkg/sync"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// plan is a plan of copies into a new directory, a replacement and the removal of a directory.
+func plan() []sync.PlanEntry {
+	entry := func(action sync.Action, path string, isDir bool, size int64) sync.PlanEntry {
+		return sync.PlanEntry{Decision: sync.Decision{
+			Action: action, SrcPath: "src/" + path, DstPath: "dst/" + path, IsDir: isDir, Size: size,
+		}}
+	}
+
+	return []sync.PlanEntry{
+		entry(sync.ActionCopy, "new", true, 0),
+		entry(sync.ActionCopy, "new/a.txt", false, 1),
+		entry(sync.ActionCopy, "new/b.txt", false, 2),
+		entry(sync.ActionReplace, "dir/changed.txt", false, 4),
+		entry(sync.ActionRemove, "old", true, 0),
+		entry(sync.ActionRemove, "old/c.txt", false, 8),
+	}
+}
+
+// paths returns the dst paths of the visible rows.
+func paths(tree *Tree) []string {
+	var paths []string
+
+	for _, row := range tree.rows {
+		paths = append(paths, row.path)
+	}
+
+	return paths
+}
+
+// approvedPaths returns the dst paths of the approved entries.
+func approvedPaths(tree *Tree) []string {
+	var paths []string
+
+	for _, entry := range tree.Approved() {
+		paths = append(paths, entry.DstPath)
+	}
+
+	return paths
+}
+
+func TestTree(t *testing.T) {
+	t.Parallel()
+
+	tree := NewTree("dst/", plan())
+
+	assert.Equal(t, []string{"dst", "dst/dir", "dst/dir/changed.txt", "dst/new", "dst/old"}, paths(tree))
+	assert.Len(t, tree.Approved(), 6)
+
+	approved, total, bytes := tree.Count(sync.ActionCopy)
+	assert.Equal(t, 3, approved)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, int64(3), bytes)
+
+	tree.Move(3)
+	tree.Expand()
+	assert.Equal(t, []string{"dst", "dst/dir", "dst/dir/changed.txt", "dst/new", "dst/new/a.txt", "dst/new/b.txt", "dst/old"},
+		paths(tree))
+
+	tree.Collapse()
+	assert.Equal(t, "dst/new", tree.selected().path)
+	tree.Collapse()
+	assert.Equal(t, "dst", tree.selected().path)
+
+	tree.Move(-10)
+	assert.Equal(t, 0, tree.cursor)
+	tree.Move(100)
+	assert.Equal(t, "dst/old", tree.selected().path)
+}
+
+func TestTreeToggle(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Subtree", func(t *testing.T) {
+		t.Parallel()
+
+		tree := NewTree("dst", plan())
+		tree.Move(3)
+		tree.Toggle()
+
+		assert.Equal(t, []string{"dst/dir/changed.txt", "dst/old", "dst/old/c.txt"}, approvedPaths(tree))
+		assert.Equal(t, statePartial, tree.state(tree.root))
+
+		tree.Toggle()
+		assert.Len(t, tree.Approved(), 6)
+	})
+
+	t.Run("RemovedDirectory", func(t *testing.T) {
+		t.Parallel()
+
+		tree := NewTree("dst", plan())
+		tree.Move(4)
+		tree.Expand()
+		tree.Move(1)
+		tree.Toggle()
+
+		assert.Equal(t, []string{"dst/dir/changed.txt", "dst/new", "dst/new/a.txt", "dst/new/b.txt"}, approvedPaths(tree))
+	})
+
+	t.Run("CopiedDirectory", func(t *testing.T) {
+		t.Parallel()
+
+		tree := NewTree("dst", plan())
+		tree.ApproveAll(false)
+		assert.Empty(t, tree.Approved())
+
+		tree.Move(3)
+		tree.Expand()
+		tree.Move(1)
+		tree.Toggle()
+
+		assert.Equal(t, []string{"dst/new", "dst/new/a.txt"}, approvedPaths(tree))
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		t.Parallel()
+
+		tree := NewTree("dst", plan())
+		tree.Filter(sync.ActionCopy)
+		tree.Filter(sync.ActionRemove)
+
+		assert.False(t, tree.Filtered(sync.ActionCopy))
+		assert.Equal(t, []string{"dst", "dst/dir", "dst/dir/changed.txt"}, paths(tree))
+
+		tree.ApproveAll(false)
+		assert.Equal(t, []string{"dst/new", "dst/new/a.txt", "dst/new/b.txt", "dst/old", "dst/old/c.txt"},
+			approvedPaths(tree))
+
+		tree.ShowAll()
+		assert.Len(t, paths(tree), 5)
+	})
+}
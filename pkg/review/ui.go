@@ -0,0 +1,491 @@
+package review
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"dtsync/pkg/fs"
+	"dtsync/pkg/screen"
+	"dtsync/pkg/sync"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// ErrNotTerminal is returned when the review runs without a terminal.
+var ErrNotTerminal = errors.New("the interactive review requires a terminal")
+
+const (
+	// headerLines are the title and the summary above the tree.
+	headerLines = 2
+	// detailLines are the details of the selected entry and the help below the tree.
+	detailLines = 5
+	// defaultWidth and defaultHeight are the size of terminals which don't report it.
+	defaultWidth  = 80
+	defaultHeight = 24
+	// ctrlC is the key interrupting the review or the execution, the raw terminal doesn't signal it.
+	ctrlC = "\x03"
+)
+
+// keys maps the escape sequences of the terminal to key names.
+var keys = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+	"\x1b[5~": "pgup", "\x1b[6~": "pgdown", "\x1b[H": "home", "\x1b[F": "end",
+	"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+	"\r": "enter", "\n": "enter", " ": "space", "\x1b": "esc",
+}
+
+// actionColors tell the actions apart.
+var actionColors = map[sync.Action]*color.Color{
+	sync.ActionCopy:    color.New(color.FgGreen),
+	sync.ActionReplace: color.New(color.FgYellow),
+	sync.ActionRemove:  color.New(color.FgRed),
+}
+
+// UI is the full-screen review of a plan on a terminal, which then shows the progress of its execution.
+// It is the observer of the executing engine.
+type UI struct {
+	tree        *Tree
+	approve     func(sync.Decision) bool
+	title       string
+	writer      io.Writer
+	size        func() (int, int)
+	keys        <-chan string
+	renderer    *screen.TTYRenderer
+	restore     func()
+	offset      int
+	interrupted bool
+}
+
+// New creates the UI with the title, Open shows it on the terminal.
+func New(title string) *UI {
+	return &UI{title: title, restore: func() {}}
+}
+
+// Supported returns ErrNotTerminal unless both files are terminals.
+func Supported(in, out *os.File) error {
+	if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		return ErrNotTerminal
+	}
+
+	return nil
+}
+
+// Open switches the terminal to raw mode and the alternate screen, Close restores it.
+// It is opened before the engine runs, which reports its progress to it.
+func (u *UI) Open(in, out *os.File) error {
+	if err := Supported(in, out); err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return err
+	}
+
+	u.attach(newlineWriter{out}, func() (int, int) {
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil || width <= 0 || height <= 0 {
+			return defaultWidth, defaultHeight
+		}
+
+		return width, height
+	}, readKeys(in))
+
+	u.restore = func() {
+		fmt.Fprint(out, "\033[?25h\033[?1049l")
+		term.Restore(int(in.Fd()), state) //nolint:errcheck
+	}
+
+	fmt.Fprint(out, "\033[?1049h\033[?25l")
+
+	return nil
+}
+
+// attach sets the output, its size and the input keys.
+func (u *UI) attach(writer io.Writer, size func() (int, int), keys <-chan string) {
+	u.writer, u.size, u.keys = writer, size, keys
+	u.renderer = screen.NewTTYRenderer(writer, func() int {
+		width, _ := size()
+
+		return width
+	}, 0)
+}
+
+// Close restores the terminal.
+func (u *UI) Close() {
+	u.restore()
+}
+
+// Review shows the tree until the approved entries are executed with `y` or the review is quit,
+// it returns whether to execute them. Approve accepts the approved entries after it.
+func (u *UI) Review(ctx context.Context, tree *Tree) (bool, error) {
+	u.tree = tree
+	defer func() {
+		u.approve = sync.Approved(tree.Approved())
+	}()
+
+	for {
+		u.draw()
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case key, ok := <-u.keys:
+			if !ok {
+				return false, io.EOF
+			}
+
+			if execute, done := u.handle(key); done {
+				return execute, nil
+			}
+		}
+	}
+}
+
+// handle applies the key to the tree, it returns whether the review is done and the entries are executed.
+func (u *UI) handle(key string) (execute, done bool) {
+	_, height := u.size()
+	page := max(height-headerLines-detailLines, 1)
+
+	switch key {
+	case "up", "k":
+		u.tree.Move(-1)
+	case "down", "j":
+		u.tree.Move(1)
+	case "pgup":
+		u.tree.Move(-page)
+	case "pgdown":
+		u.tree.Move(page)
+	case "home", "g":
+		u.tree.Move(-len(u.tree.rows))
+	case "end", "G":
+		u.tree.Move(len(u.tree.rows))
+	case "right", "l":
+		u.tree.Expand()
+	case "left", "h":
+		u.tree.Collapse()
+	case "enter":
+		if selected := u.tree.selected(); selected != nil && selected.expanded {
+			u.tree.Collapse()
+		} else {
+			u.tree.Expand()
+		}
+	case "space":
+		u.tree.Toggle()
+	case "c":
+		u.tree.Filter(sync.ActionCopy)
+	case "r":
+		u.tree.Filter(sync.ActionReplace)
+	case "d":
+		u.tree.Filter(sync.ActionRemove)
+	case "f":
+		u.tree.ShowAll()
+	case "a":
+		u.tree.ApproveAll(true)
+	case "n":
+		u.tree.ApproveAll(false)
+	case "y":
+		return true, true
+	case "q", "esc", ctrlC:
+		return false, true
+	}
+
+	return false, false
+}
+
+// draw redraws the whole screen, scrolling the tree to keep the cursor visible.
+func (u *UI) draw() {
+	var (
+		buffer        bytes.Buffer
+		width, height = u.size()
+		rows          = max(height-headerLines-detailLines, 1)
+	)
+
+	u.offset = min(max(u.offset, u.tree.cursor-rows+1), u.tree.cursor)
+	u.offset = max(min(u.offset, len(u.tree.rows)-rows), 0)
+
+	buffer.WriteString("\033[H")
+	u.line(&buffer, color.New(color.Bold).Sprint(screen.Fit(u.title, width)))
+	u.line(&buffer, u.summary(width))
+
+	for i := u.offset; i < u.offset+rows; i++ {
+		if i < len(u.tree.rows) {
+			u.line(&buffer, u.row(u.tree.rows[i], i == u.tree.cursor, width))
+		} else {
+			u.line(&buffer, "")
+		}
+	}
+
+	for _, detail := range u.details(width) {
+		u.line(&buffer, detail)
+	}
+
+	buffer.WriteString("\033[K" + screen.Fit(
+		"↑↓ move  ←→ fold  space approve  a/n all/none  c/r/d filter  f show all  y execute  q quit", width))
+	u.writer.Write(buffer.Bytes()) //nolint:errcheck
+}
+
+// line writes the cleared line.
+func (u *UI) line(buffer *bytes.Buffer, text string) {
+	buffer.WriteString("\033[K" + text + "\n")
+}
+
+// summary shows the approved entries and bytes of each action, the hidden actions are dimmed.
+func (u *UI) summary(width int) string {
+	var (
+		parts  []string
+		length int
+	)
+
+	for _, action := range Actions {
+		approved, total, bytes := u.tree.Count(action)
+
+		part := fmt.Sprintf("%s %d/%d", action, approved, total)
+		if action != sync.ActionRemove {
+			part += " (" + screen.FormatBytes(bytes) + ")"
+		}
+
+		if length += len(part) + 2; length > width { //nolint:gomnd
+			break
+		}
+
+		if u.tree.Filtered(action) {
+			parts = append(parts, actionColors[action].Sprint(part))
+		} else {
+			parts = append(parts, color.New(color.Faint).Sprint(part))
+		}
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// row shows the node with its approval, action, name and size, indented by its depth.
+func (u *UI) row(n *node, selected bool, width int) string {
+	const actionWidth = 7
+
+	var (
+		marker = "   "
+		action = strings.Repeat(" ", actionWidth)
+		check  = map[state]string{stateRejected: "[ ]", statePartial: "[-]", stateApproved: "[x]"}[u.tree.state(n)]
+		name   = n.name
+		size   string
+	)
+
+	switch {
+	case len(n.children) > 0 && n.expanded:
+		marker = " ▾ "
+	case len(n.children) > 0:
+		marker = " ▸ "
+	}
+
+	if n.entry != nil {
+		action = actionColors[n.entry.Action].Sprintf("%-*s", actionWidth, n.entry.Action)
+
+		if !n.entry.IsDir {
+			size = screen.FormatBytes(n.entry.Size)
+		}
+	}
+
+	if n.entry == nil || n.entry.IsDir {
+		name += "/"
+	}
+
+	prefix := strings.Repeat("  ", n.depth) + marker + check + " "
+	name = screen.Fit(name, width-len([]rune(prefix))-actionWidth-len(size)-3)             //nolint:gomnd
+	padding := max(width-len([]rune(prefix))-actionWidth-len([]rune(name))-len(size)-2, 1) //nolint:gomnd
+
+	if selected {
+		prefix, name = color.New(color.ReverseVideo).Sprint(prefix), color.New(color.ReverseVideo).Sprint(name)
+	}
+
+	return prefix + action + " " + name + strings.Repeat(" ", padding) + size
+}
+
+// details show the paths, sizes, mtimes and reason of the selected entry.
+func (u *UI) details(width int) []string {
+	details := make([]string, detailLines-1)
+
+	selected := u.tree.selected()
+
+	switch {
+	case selected == nil:
+		details[0] = "Nothing to do for the shown actions"
+	case selected.entry == nil:
+		details[0] = "Directory : " + selected.path
+	default:
+		entry := selected.entry
+		details[0] = fmt.Sprintf("%-7s   : %s", entry.Action, entry.Reason)
+		details[1] = "Src       : " +
+			describe(entry.SrcPath, entry.Size, entry.SrcModTime, !entry.IsDir && entry.Action != sync.ActionRemove)
+		details[2] = "Dst       : " +
+			describe(entry.DstPath, entry.Size, entry.DstModTime, !entry.IsDir && entry.Action == sync.ActionRemove)
+	}
+
+	if selected != nil && len(selected.children) > 0 {
+		var approved, total int
+
+		u.tree.walk(selected, func(n *node) {
+			if n.entry != nil && n != selected {
+				total++
+
+				if n.approved {
+					approved++
+				}
+			}
+		})
+
+		details[3] = fmt.Sprintf("Below     : %d of %d entries approved", approved, total)
+	}
+
+	for i := range details {
+		details[i] = screen.Fit(details[i], width)
+	}
+
+	return details
+}
+
+// describe shows the path with its mtime and, when it has the size, the size.
+func describe(path string, size int64, modTime time.Time, sized bool) string {
+	if modTime.IsZero() {
+		return path + "  (missing)"
+	} else if !sized {
+		return path + "  " + modTime.Format(time.DateTime)
+	}
+
+	return path + "  " + screen.FormatBytes(size) + "  " + modTime.Format(time.DateTime)
+}
+
+// Execute runs the execution of the approved entries and shows its progress until it is done
+// and a key is pressed. `q` or Ctrl+C interrupt it, the files in progress are finished.
+func (u *UI) Execute(ctx context.Context, execute func(ctx context.Context) error) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var total int64
+
+	for _, entry := range u.tree.Approved() {
+		if entry.Action != sync.ActionRemove {
+			total += entry.Size
+		}
+	}
+
+	fmt.Fprintf(u.writer, "\033[H\033[J%s\n\nExecuting %d approved entries, press q to interrupt\n\n",
+		color.New(color.Bold).Sprint(u.title), len(u.tree.Approved()))
+	u.renderer.AddStatus(screen.Status{TotalBytes: total})
+
+	if err := u.renderer.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- execute(runCtx)
+	}()
+
+	var (
+		err  error
+		keys = u.keys
+	)
+
+	for waiting := true; waiting; {
+		select {
+		case err = <-done:
+			waiting = false
+		case key, ok := <-keys:
+			switch {
+			case !ok:
+				keys = nil
+			case key == "q" || key == ctrlC:
+				u.interrupted = true
+				cancel()
+			}
+		}
+	}
+
+	u.renderer.Stop()
+
+	message := "Done"
+	if err != nil {
+		message = "Failed: " + err.Error()
+	}
+
+	fmt.Fprintf(u.writer, "\n%s, press any key to exit", message)
+
+	select {
+	case <-u.keys:
+	case <-ctx.Done():
+	}
+
+	return err
+}
+
+// Interrupted reports whether the execution was interrupted with a key.
+func (u *UI) Interrupted() bool {
+	return u.interrupted
+}
+
+// AddStatus shows the progress counters.
+func (u *UI) AddStatus(status screen.Status) {
+	u.renderer.AddStatus(status)
+}
+
+// Decided ignores the decision, the approvals are applied by sync.Options.Approve.
+func (u *UI) Decided(sync.Decision) {}
+
+// Approve accepts the entries approved in the review, it is the sync.Options.Approve of the executing engine.
+func (u *UI) Approve(decision sync.Decision) bool {
+	return u.approve != nil && u.approve(decision)
+}
+
+// Progress shows the file being copied.
+func (u *UI) Progress(progress fs.Progress) {
+	u.renderer.Progress(progress.Path, progress.Size, progress.Done)
+}
+
+// readKeys reads the keys of the terminal until it fails, each read is one key or escape sequence.
+func readKeys(in io.Reader) <-chan string {
+	keyChan := make(chan string)
+
+	go func() {
+		defer close(keyChan)
+
+		buffer := make([]byte, 16) //nolint:gomnd
+
+		for {
+			n, err := in.Read(buffer)
+			if err != nil {
+				return
+			}
+
+			key := string(buffer[:n])
+			if name, ok := keys[key]; ok {
+				key = name
+			}
+
+			keyChan <- key
+		}
+	}()
+
+	return keyChan
+}
+
+// newlineWriter writes CRLF line ends, the raw terminal doesn't return the cursor on LF.
+type newlineWriter struct {
+	io.Writer
+}
+
+func (w newlineWriter) Write(data []byte) (int, error) {
+	if _, err := w.Writer.Write(bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
@@ -0,0 +1,103 @@
+package review
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"dtsync/pkg/screen"
+	"dtsync/pkg/sync"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testUI creates a UI of 60x16 characters writing to the buffer.
+func testUI(buffer *bytes.Buffer, keys <-chan string) *UI {
+	ui := New("dtsync src -> dst")
+	ui.attach(buffer, func() (int, int) {
+		return 60, 16 //nolint:gomnd
+	}, keys)
+
+	return ui
+}
+
+func TestUIReview(t *testing.T) {
+	t.Parallel()
+
+	var (
+		buffer bytes.Buffer
+		keys   = make(chan string, 10)
+		ui     = testUI(&buffer, keys)
+	)
+
+	for _, key := range []string{"end", "space", "up", "d", "y"} {
+		keys <- key
+	}
+
+	execute, err := ui.Review(context.Background(), NewTree("dst", plan()))
+	assert.NoError(t, err)
+	assert.True(t, execute)
+	assert.True(t, ui.Approve(sync.Decision{Action: sync.ActionCopy, DstPath: "dst/new/a.txt"}))
+	assert.False(t, ui.Approve(sync.Decision{Action: sync.ActionRemove, DstPath: "dst/old"}))
+	assert.Equal(t, []string{"dst/dir/changed.txt", "dst/new", "dst/new/a.txt", "dst/new/b.txt"}, approvedPaths(ui.tree))
+	assert.False(t, ui.tree.Filtered("remove"))
+
+	screens := strings.Split(buffer.String(), "\033[H")
+	last := screens[len(screens)-1]
+
+	assert.Len(t, screens, 6)
+	assert.Equal(t, 15, strings.Count(last, "\n"))
+	assert.Contains(t, last, "copy 3/3 (3 B)  replace 1/1 (4 B)  remove 0/2")
+	assert.Contains(t, last, "[x] copy    new/")
+	assert.Contains(t, last, "Below     : 2 of 2 entries approved")
+	assert.NotContains(t, last, "old/")
+
+	for _, line := range strings.Split(last, "\n") {
+		assert.LessOrEqual(t, len([]rune(strings.TrimPrefix(line, "\033[K"))), 60)
+	}
+}
+
+func TestUIQuit(t *testing.T) {
+	t.Parallel()
+
+	for _, key := range []string{"q", "esc", ctrlC} {
+		keys := make(chan string, 1)
+		keys <- key
+
+		execute, err := testUI(&bytes.Buffer{}, keys).Review(context.Background(), NewTree("dst", plan()))
+		assert.NoError(t, err)
+		assert.False(t, execute)
+	}
+}
+
+func TestUIExecute(t *testing.T) {
+	t.Parallel()
+
+	var (
+		buffer bytes.Buffer
+		keys   = make(chan string, 2)
+		ui     = testUI(&buffer, keys)
+	)
+
+	keys <- "y"
+	keys <- "q"
+
+	execute, err := ui.Review(context.Background(), NewTree("dst", plan()))
+	assert.NoError(t, err)
+	assert.True(t, execute)
+
+	// the canceled context ends the wait for the key closing the screen
+	ctx, cancel := context.WithCancel(context.Background())
+	err = ui.Execute(ctx, func(runCtx context.Context) error {
+		<-runCtx.Done()
+		ui.AddStatus(screen.Status{Copied: 1})
+		cancel()
+
+		return runCtx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, ui.Interrupted())
+	assert.Contains(t, buffer.String(), "Executing 6 approved entries")
+	assert.Contains(t, buffer.String(), "Failed: context canceled, press any key to exit")
+}
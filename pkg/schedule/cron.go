@@ -0,0 +1,173 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronYears limits the search for the next time of expressions which never match, like `0 0 30 2 *`.
+const maxCronYears = 5
+
+// ErrInvalidCron is returned for malformed cron expressions.
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Cron is a parsed cron expression of the five fields minute, hour, day of month, month and day of week.
+// Each field accepts `*`, values, ranges `a-b`, steps `*/s` or `a-b/s` and lists of them,
+// months and days of week also their three letter english names.
+// Like in crontab a day matches either the day of month or the day of week, when both are restricted.
+type Cron struct {
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+// ParseCron parses a cron expression or one of the macros @yearly, @monthly, @weekly, @daily and @hourly.
+func ParseCron(expression string) (*Cron, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expression)]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 { //nolint:gomnd
+		return nil, fmt.Errorf("%w: %q needs 5 fields", ErrInvalidCron, expression)
+	}
+
+	var (
+		cron Cron
+		err  error
+	)
+
+	if cron.minutes, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	} else if cron.hours, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	} else if cron.days, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	} else if cron.months, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	} else if cron.weekdays, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+
+	// 7 is another name of sunday
+	if cron.weekdays&(1<<7) != 0 {
+		cron.weekdays |= 1
+	}
+
+	cron.anyDay, cron.anyWeekday = fields[2] == "*", fields[4] == "*"
+
+	return &cron, nil
+}
+
+// Next returns the first matching minute after t, the zero time if there is none within the next years.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(maxCronYears, 0, 0)
+
+	for t.Before(end) {
+		switch {
+		case c.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c *Cron) matchDay(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// parseField returns the matching values of the field as bits.
+func parseField(field string, minimum, maximum int, names []string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepText, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("%w: step of %q", ErrInvalidCron, part)
+			}
+		}
+
+		low, high := minimum, maximum
+
+		if valueRange != "*" {
+			lowText, highText, isRange := strings.Cut(valueRange, "-")
+
+			var err error
+			if low, err = parseValue(lowText, minimum, maximum, names); err != nil {
+				return 0, err
+			}
+
+			high = low
+			if isRange {
+				if high, err = parseValue(highText, minimum, maximum, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = maximum
+			}
+
+			if high < low {
+				return 0, fmt.Errorf("%w: range %q", ErrInvalidCron, part)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseValue(text string, minimum, maximum int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(text, name) {
+			return i + minimum, nil
+		}
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value < minimum || value > maximum {
+		return 0, fmt.Errorf("%w: value %q not in %d-%d", ErrInvalidCron, text, minimum, maximum)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,64 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCron(t *testing.T) {
+	t.Parallel()
+
+	// a wednesday
+	from := time.Date(2024, time.January, 3, 10, 30, 15, 0, time.UTC)
+
+	for _, test := range []struct {
+		name       string
+		expression string
+		next       time.Time
+	}{
+		{"EveryMinute", "* * * * *", time.Date(2024, time.January, 3, 10, 31, 0, 0, time.UTC)},
+		{"Daily", "@daily", time.Date(2024, time.January, 4, 0, 0, 0, 0, time.UTC)},
+		{"Step", "*/20 * * * *", time.Date(2024, time.January, 3, 10, 40, 0, 0, time.UTC)},
+		{"RangeStep", "5-50/15 9-11 * * *", time.Date(2024, time.January, 3, 10, 35, 0, 0, time.UTC)},
+		{"List", "0 2,14 * * *", time.Date(2024, time.January, 3, 14, 0, 0, 0, time.UTC)},
+		{"Weekday", "0 3 * * sat", time.Date(2024, time.January, 6, 3, 0, 0, 0, time.UTC)},
+		{"SundayAsSeven", "0 3 * * 7", time.Date(2024, time.January, 7, 3, 0, 0, 0, time.UTC)},
+		{"Month", "0 0 1 mar *", time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		{"LeapDay", "0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"DayOrWeekday", "0 0 15 * fri", time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC)},
+		{"Never", "0 0 30 2 *", time.Time{}},
+	} {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			cron, err := ParseCron(test.expression)
+			assert.NoError(t, err)
+			assert.Equal(t, test.next, cron.Next(from))
+		})
+	}
+
+	t.Run("HalfHourOffset", func(t *testing.T) {
+		t.Parallel()
+
+		// the hours start at :30 in absolute time
+		kolkata := time.FixedZone("IST", 5*3600+1800)
+
+		cron, err := ParseCron("0 11 * * *")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Date(2024, time.January, 3, 11, 0, 0, 0, kolkata),
+			cron.Next(time.Date(2024, time.January, 3, 10, 45, 0, 0, kolkata)))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+			_, err := ParseCron(expression)
+			assert.ErrorIs(t, err, ErrInvalidCron, expression)
+		}
+	})
+}
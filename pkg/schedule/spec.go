@@ -0,0 +1,115 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrInvalidSpec is returned for schedules without or with both a cron expression and an interval.
+var ErrInvalidSpec = errors.New("invalid schedule")
+
+// Duration is a time.Duration marshaled as text like `90s` or `1h30m`.
+type Duration time.Duration
+
+// MarshalJSON marshals the duration as text.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON unmarshals the duration from text.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+
+	return nil
+}
+
+// Spec is the schedule of a job as configured.
+type Spec struct {
+	// Cron is a cron expression, see Cron.
+	Cron string `json:"cron,omitempty"`
+	// Every is the interval between the starts of the runs, instead of Cron.
+	Every Duration `json:"every,omitempty"`
+	// Jitter is the maximum random delay added to each start.
+	Jitter Duration `json:"jitter,omitempty"`
+	// CatchUp starts a run right away when a start was missed, like while the daemon was stopped.
+	CatchUp bool `json:"catchUp,omitempty"`
+	// Windows are the time windows in which transfers are allowed, see Window.
+	Windows []string `json:"windows,omitempty"`
+}
+
+// Plan is a compiled Spec.
+type Plan struct {
+	cron    *Cron
+	every   time.Duration
+	jitter  time.Duration
+	CatchUp bool
+	Windows Windows
+}
+
+// Compile validates the spec and compiles it to a plan.
+func (s Spec) Compile() (*Plan, error) {
+	plan := &Plan{every: time.Duration(s.Every), jitter: time.Duration(s.Jitter), CatchUp: s.CatchUp}
+
+	switch {
+	case s.Cron != "" && s.Every != 0:
+		return nil, fmt.Errorf("%w: cron and every are exclusive", ErrInvalidSpec)
+	case s.Cron != "":
+		cron, err := ParseCron(s.Cron)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.cron = cron
+	case s.Every < 0:
+		return nil, fmt.Errorf("%w: negative interval", ErrInvalidSpec)
+	case s.Every == 0 && len(s.Windows) == 0:
+		return nil, fmt.Errorf("%w: needs cron, every or windows", ErrInvalidSpec)
+	}
+
+	if s.Jitter < 0 {
+		return nil, fmt.Errorf("%w: negative jitter", ErrInvalidSpec)
+	}
+
+	windows, err := ParseWindows(s.Windows)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Windows = windows
+
+	return plan, nil
+}
+
+// Next returns the start following the one at t without jitter, the zero time when the job is only started manually.
+func (p *Plan) Next(t time.Time) time.Time {
+	switch {
+	case p.cron != nil:
+		return p.cron.Next(t)
+	case p.every > 0:
+		return t.Add(p.every)
+	}
+
+	return time.Time{}
+}
+
+// Jitter returns a random delay up to the maximum jitter.
+func (p *Plan) Jitter() time.Duration {
+	if p.jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(p.jitter) + 1)) //nolint:gosec
+}
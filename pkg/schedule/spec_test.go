@@ -0,0 +1,80 @@
+package schedule
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpec(t *testing.T) {
+	t.Parallel()
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Parallel()
+
+		var spec Spec
+		assert.NoError(t, json.Unmarshal(
+			[]byte(`{"every": "1h30m", "jitter": "5m", "catchUp": true, "windows": ["22:00-06:00"]}`), &spec))
+		assert.Equal(t, Spec{
+			Every: Duration(90 * time.Minute), Jitter: Duration(5 * time.Minute), CatchUp: true, Windows: []string{"22:00-06:00"},
+		}, spec)
+
+		data, err := json.Marshal(Spec{Every: Duration(time.Minute)})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"every":"1m0s"}`, string(data))
+
+		assert.Error(t, json.Unmarshal([]byte(`{"every": "soon"}`), &spec))
+	})
+
+	t.Run("Next", func(t *testing.T) {
+		t.Parallel()
+
+		from := time.Date(2024, time.January, 3, 10, 30, 0, 0, time.UTC)
+
+		plan, err := Spec{Cron: "0 * * * *"}.Compile()
+		assert.NoError(t, err)
+		assert.Equal(t, from.Add(30*time.Minute), plan.Next(from))
+
+		plan, err = Spec{Every: Duration(time.Hour)}.Compile()
+		assert.NoError(t, err)
+		assert.Equal(t, from.Add(time.Hour), plan.Next(from))
+
+		plan, err = Spec{Windows: []string{"22:00-06:00"}}.Compile()
+		assert.NoError(t, err)
+		assert.True(t, plan.Next(from).IsZero())
+	})
+
+	t.Run("Jitter", func(t *testing.T) {
+		t.Parallel()
+
+		plan, err := Spec{Every: Duration(time.Hour), Jitter: Duration(time.Minute)}.Compile()
+		assert.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			jitter := plan.Jitter()
+			assert.True(t, jitter >= 0 && jitter <= time.Minute, jitter)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, spec := range []Spec{
+			{},
+			{Cron: "@daily", Every: Duration(time.Hour)},
+			{Every: Duration(-time.Hour)},
+			{Every: Duration(time.Hour), Jitter: Duration(-time.Minute)},
+		} {
+			_, err := spec.Compile()
+			assert.ErrorIs(t, err, ErrInvalidSpec)
+		}
+
+		_, err := Spec{Cron: "daily"}.Compile()
+		assert.ErrorIs(t, err, ErrInvalidCron)
+
+		_, err = Spec{Every: Duration(time.Hour), Windows: []string{"always"}}.Compile()
+		assert.ErrorIs(t, err, ErrInvalidWindow)
+	})
+}
@@ -0,0 +1,168 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	minutesPerDay = 24 * 60
+	allWeekdays   = 1<<7 - 1
+)
+
+// ErrInvalidWindow is returned for malformed time windows.
+var ErrInvalidWindow = errors.New("invalid time window")
+
+// Window is a daily time range in which transfers are allowed, like `22:00-06:00` or `Mon-Fri 09:00-17:00`.
+// A range ending before it starts passes midnight and belongs to the day it starts.
+type Window struct {
+	weekdays   uint8
+	start, end int // minutes of the day, end is exclusive
+}
+
+// ParseWindow parses a window of an optional list or range of weekdays and a time range `HH:MM-HH:MM`.
+func ParseWindow(text string) (Window, error) {
+	window := Window{weekdays: allWeekdays}
+
+	fields := strings.Fields(text)
+	switch len(fields) {
+	case 1:
+	case 2: //nolint:gomnd
+		weekdays, err := parseField(fields[0], 0, 7, dayNames)
+		if err != nil {
+			return Window{}, fmt.Errorf("%w: weekdays of %q", ErrInvalidWindow, text)
+		}
+
+		window.weekdays = uint8(weekdays&allWeekdays | weekdays>>7)
+	default:
+		return Window{}, fmt.Errorf("%w: %q", ErrInvalidWindow, text)
+	}
+
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return Window{}, fmt.Errorf("%w: %q needs a time range", ErrInvalidWindow, text)
+	}
+
+	var err error
+	if window.start, err = parseClock(start); err != nil {
+		return Window{}, fmt.Errorf("%w: %q: %w", ErrInvalidWindow, text, err)
+	} else if window.end, err = parseClock(end); err != nil {
+		return Window{}, fmt.Errorf("%w: %q: %w", ErrInvalidWindow, text, err)
+	}
+
+	if window.start == window.end {
+		return Window{}, fmt.Errorf("%w: %q is empty", ErrInvalidWindow, text)
+	}
+
+	return window, nil
+}
+
+// Contains reports whether the window allows transfers at t.
+func (w Window) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	weekday := int(t.Weekday())
+
+	if w.start < w.end {
+		return w.weekdays&(1<<weekday) != 0 && minute >= w.start && minute < w.end
+	}
+
+	yesterday := (weekday + 6) % 7 //nolint:gomnd
+
+	return w.weekdays&(1<<weekday) != 0 && minute >= w.start || w.weekdays&(1<<yesterday) != 0 && minute < w.end
+}
+
+// parseClock returns the minutes of the day of `HH:MM`, `24:00` is the end of the day.
+func parseClock(text string) (int, error) {
+	hours, minutes, ok := strings.Cut(text, ":")
+	if !ok {
+		return 0, fmt.Errorf("clock %q is not HH:MM", text)
+	}
+
+	hour, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, fmt.Errorf("clock %q: %w", text, err)
+	}
+
+	minute, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, fmt.Errorf("clock %q: %w", text, err)
+	}
+
+	clock := hour*60 + minute
+	if hour < 0 || minute < 0 || minute >= 60 || clock > minutesPerDay {
+		return 0, fmt.Errorf("clock %q out of range", text)
+	}
+
+	return clock, nil
+}
+
+// Windows are the time windows of a job, no windows allow transfers all the time.
+type Windows []Window
+
+// ParseWindows parses the windows.
+func ParseWindows(texts []string) (Windows, error) {
+	windows := make(Windows, 0, len(texts))
+
+	for _, text := range texts {
+		window, err := ParseWindow(text)
+		if err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
+
+// Contains reports whether any window allows transfers at t.
+func (w Windows) Contains(t time.Time) bool {
+	if len(w) == 0 {
+		return true
+	}
+
+	for _, window := range w {
+		if window.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextOpen returns the first minute from t on in which transfers are allowed, t itself when they already are.
+func (w Windows) NextOpen(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+
+	t = t.Truncate(time.Minute)
+	for i := 0; i < 8*minutesPerDay; i++ {
+		if t = t.Add(time.Minute); w.Contains(t) {
+			return t
+		}
+	}
+
+	return t
+}
+
+// Wait blocks until transfers are allowed or ctx is canceled.
+func (w Windows) Wait(ctx context.Context) error {
+	for now := time.Now(); !w.Contains(now); now = time.Now() {
+		timer := time.NewTimer(w.NextOpen(now).Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,94 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+	t.Parallel()
+
+	// 2024-01-05 is a friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	t.Run("Daily", func(t *testing.T) {
+		t.Parallel()
+
+		window, err := ParseWindow("09:00-17:30")
+		assert.NoError(t, err)
+		assert.False(t, window.Contains(at(5, 8, 59)))
+		assert.True(t, window.Contains(at(5, 9, 0)))
+		assert.True(t, window.Contains(at(6, 17, 29)))
+		assert.False(t, window.Contains(at(6, 17, 30)))
+	})
+
+	t.Run("PastMidnight", func(t *testing.T) {
+		t.Parallel()
+
+		// friday night until saturday morning, not saturday night
+		window, err := ParseWindow("Mon-Fri 22:00-06:00")
+		assert.NoError(t, err)
+		assert.True(t, window.Contains(at(5, 23, 0)))
+		assert.True(t, window.Contains(at(6, 5, 59)))
+		assert.False(t, window.Contains(at(6, 23, 0)))
+		assert.False(t, window.Contains(at(8, 5, 0)))
+		assert.True(t, window.Contains(at(8, 22, 0)))
+	})
+
+	t.Run("WholeDay", func(t *testing.T) {
+		t.Parallel()
+
+		window, err := ParseWindow("sat,sun 00:00-24:00")
+		assert.NoError(t, err)
+		assert.False(t, window.Contains(at(5, 23, 59)))
+		assert.True(t, window.Contains(at(6, 0, 0)))
+		assert.True(t, window.Contains(at(7, 23, 59)))
+	})
+
+	t.Run("Windows", func(t *testing.T) {
+		t.Parallel()
+
+		windows, err := ParseWindows([]string{"01:00-02:00", "Sat 12:00-13:00"})
+		assert.NoError(t, err)
+		assert.True(t, windows.Contains(at(5, 1, 30)))
+		assert.False(t, windows.Contains(at(5, 12, 30)))
+		assert.True(t, windows.Contains(at(6, 12, 30)))
+		assert.Equal(t, at(6, 1, 0), windows.NextOpen(at(5, 2, 10)))
+		assert.Equal(t, at(5, 1, 30), windows.NextOpen(at(5, 1, 30)))
+		assert.True(t, Windows(nil).Contains(at(5, 12, 0)))
+	})
+
+	t.Run("Wait", func(t *testing.T) {
+		t.Parallel()
+
+		assert.NoError(t, Windows(nil).Wait(context.Background()))
+
+		// closed today until tomorrow
+		var windows Windows
+		for i := 1; i < 7; i++ {
+			window, err := ParseWindow(time.Now().AddDate(0, 0, i).Weekday().String()[:3] + " 00:00-24:00")
+			assert.NoError(t, err)
+
+			windows = append(windows, window)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, windows.Wait(ctx), context.DeadlineExceeded)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, text := range []string{"", "09:00", "9-17", "25:00-26:00", "09:60-10:00", "10:00-10:00", "Foo 09:00-10:00", "a b c"} {
+			_, err := ParseWindow(text)
+			assert.ErrorIs(t, err, ErrInvalidWindow, text)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package screen
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// JSONRenderer prints a JSON progress event per line periodically and a final `done` event, for wrapper tools.
+type JSONRenderer struct {
+	*tracker
+	encoder *json.Encoder
+}
+
+// JSONEvent is a line printed by the JSON renderer.
+type JSONEvent struct {
+	// Event is `progress` or, for the last one, `done`.
+	Event          string    `json:"event"`
+	ElapsedSeconds float64   `json:"elapsedSeconds"`
+	Status         Status    `json:"status"`
+	BytesPerSecond float64   `json:"bytesPerSecond"`
+	ETASeconds     *float64  `json:"etaSeconds,omitempty"`
+	Current        *JSONFile `json:"current,omitempty"`
+}
+
+// JSONCopied is the event printed for each copied file, with the method copying it.
+type JSONCopied struct {
+	// Event is `copied`.
+	Event  string `json:"event"`
+	Path   string `json:"path"`
+	Method string `json:"method"`
+}
+
+// JSONWarning is the event printed for each entry skipped with a warning.
+type JSONWarning struct {
+	// Event is `warning`.
+	Event   string `json:"event"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// JSONInfo is the event printed for each message about the sync.
+type JSONInfo struct {
+	// Event is `info`.
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+// JSONFile is the large file being copied.
+type JSONFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Done int64  `json:"done"`
+}
+
+// NewJSONRenderer creates a renderer printing every second by default.
+func NewJSONRenderer(writer io.Writer, interval time.Duration) *JSONRenderer {
+	if interval == 0 {
+		interval = ttyInterval
+	}
+
+	renderer := &JSONRenderer{encoder: json.NewEncoder(writer)}
+	renderer.tracker = newTracker(writer, interval, renderer.print)
+
+	return renderer
+}
+
+func (r *JSONRenderer) print(s snapshot) {
+	event := JSONEvent{
+		Event: "progress", ElapsedSeconds: s.elapsed.Seconds(), Status: s.status, BytesPerSecond: s.bytesPerSecond,
+	}
+
+	if s.final {
+		event.Event = "done"
+	}
+
+	if s.status.TotalBytes > 0 && s.bytesPerSecond > 0 {
+		eta := float64(max(s.status.TotalBytes-s.status.DoneBytes, 0)) / s.bytesPerSecond
+		event.ETASeconds = &eta
+	}
+
+	if s.current.path != "" {
+		event.Current = &JSONFile{Path: s.current.path, Size: s.current.size, Done: s.current.done}
+	}
+
+	r.encoder.Encode(event) //nolint:errcheck
+}
+
+// Copied prints a `copied` event with the method which copied the file.
+func (r *JSONRenderer) Copied(path, method string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.encoder.Encode(JSONCopied{Event: "copied", Path: path, Method: method}) //nolint:errcheck
+}
+
+// Warn prints a `warning` event.
+func (r *JSONRenderer) Warn(path, message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.encoder.Encode(JSONWarning{Event: "warning", Path: path, Message: message}) //nolint:errcheck
+}
+
+// Info prints an `info` event.
+func (r *JSONRenderer) Info(message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.encoder.Encode(JSONInfo{Event: "info", Message: message}) //nolint:errcheck
+}
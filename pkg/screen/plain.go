@@ -0,0 +1,63 @@
+package screen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PlainRenderer prints a line with the counters periodically and a final one, without escape codes for logs.
+type PlainRenderer struct {
+	*tracker
+}
+
+// NewPlainRenderer creates a renderer printing every 10 seconds by default.
+func NewPlainRenderer(writer io.Writer, interval time.Duration) *PlainRenderer {
+	if interval == 0 {
+		interval = plainInterval
+	}
+
+	renderer := &PlainRenderer{}
+	renderer.tracker = newTracker(writer, interval, renderer.print)
+
+	return renderer
+}
+
+func (r *PlainRenderer) print(s snapshot) {
+	var line strings.Builder
+
+	for _, notice := range s.notices {
+		if notice.path != "" {
+			fmt.Fprintf(r.writer, "%s path=%q message=%q\n", notice.event, notice.path, notice.message)
+		} else {
+			fmt.Fprintf(r.writer, "%s message=%q\n", notice.event, notice.message)
+		}
+	}
+
+	event := "progress"
+	if s.final {
+		event = "done"
+	}
+
+	fmt.Fprintf(&line, "%s elapsed=%s src_files=%d src_dirs=%d dst_files=%d dst_dirs=%d "+
+		"copied=%d replaced=%d removed=%d skipped=%d skipped_specials=%d failed=%d conflicts=%d collisions=%d "+
+		"reflinked=%d kernel_copied=%d streamed=%d transferred=%q rate=\"%s/s\"",
+		event, s.elapsed.Truncate(time.Second), s.status.SrcTotalFiles, s.status.SrcTotalDirectories,
+		s.status.DstTotalFiles, s.status.DstTotalDirectories, s.status.Copied, s.status.Replaced,
+		s.status.Removed, s.status.Skipped, s.status.SkippedSpecials, s.status.Failed, s.status.Conflicts,
+		s.status.Collisions, s.status.Reflinked, s.status.KernelCopied, s.status.Streamed,
+		FormatBytes(s.status.DoneBytes), FormatBytes(int64(s.bytesPerSecond)))
+
+	if s.status.TotalBytes > 0 {
+		fmt.Fprintf(&line, " total=%q percent=%.1f eta=%s", FormatBytes(s.status.TotalBytes),
+			min(float64(s.status.DoneBytes)/float64(s.status.TotalBytes), 1)*100, //nolint:gomnd
+			formatETA(s.status.TotalBytes-s.status.DoneBytes, s.bytesPerSecond))
+	}
+
+	if s.current.path != "" {
+		fmt.Fprintf(&line, " current=%q", formatCurrent(s.current))
+	}
+
+	fmt.Fprintln(r.writer, line.String())
+}
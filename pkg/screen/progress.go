@@ -0,0 +1,117 @@
+package screen
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// LargeFileSize is the minimum size of the files shown with their own progress.
+	LargeFileSize = 16 * 1024 * 1024
+	// barWidth is the number of characters of the progress bar.
+	barWidth = 20
+	// rateWindow is the number of samples the throughput is averaged over.
+	rateWindow = 5
+	// maxETA is the longest ETA shown, longer ones are not meaningful estimates.
+	maxETA = 1000 * time.Hour
+)
+
+// currentFile is the large file being copied.
+type currentFile struct {
+	path       string
+	size, done int64
+}
+
+// sample is the number of bytes done at a time.
+type sample struct {
+	time time.Time
+	done int64
+}
+
+// rate computes the throughput over the last samples.
+type rate struct {
+	samples []sample
+}
+
+// add adds the sample and returns the bytes per second since the oldest sample in the window.
+func (r *rate) add(now time.Time, done int64) float64 {
+	r.samples = append(r.samples, sample{time: now, done: done})
+	if len(r.samples) > rateWindow {
+		r.samples = r.samples[len(r.samples)-rateWindow:]
+	}
+
+	first := r.samples[0]
+	if elapsed := now.Sub(first.time).Seconds(); elapsed > 0 {
+		return float64(done-first.done) / elapsed
+	}
+
+	return 0
+}
+
+// FormatBytes formats the size with binary units, like 1.5 GiB.
+func FormatBytes(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value, exponent := float64(size)/unit, 0
+	for ; value >= unit && exponent < 5; exponent++ {
+		value /= unit
+	}
+
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[exponent])
+}
+
+// formatBar renders the fraction as bar like [#####---------------].
+func formatBar(fraction float64) string {
+	done := int(min(max(fraction, 0), 1) * barWidth)
+
+	return "[" + strings.Repeat("#", done) + strings.Repeat("-", barWidth-done) + "]"
+}
+
+// formatETA returns the estimated time until the remaining bytes are done, `-` when nothing moves
+// or it is longer than maxETA.
+func formatETA(remaining int64, bytesPerSecond float64) string {
+	if bytesPerSecond <= 0 || remaining < 0 {
+		return "-"
+	}
+
+	seconds := float64(remaining) / bytesPerSecond
+	if seconds > maxETA.Seconds() {
+		return "-"
+	}
+
+	return time.Duration(seconds * float64(time.Second)).Truncate(time.Second).String()
+}
+
+// formatTransfer describes the bytes done, with the pre-counted total also the bar, percentage and ETA.
+func formatTransfer(status Status, bytesPerSecond float64) string {
+	throughput := FormatBytes(int64(bytesPerSecond)) + "/s"
+	if status.TotalBytes <= 0 {
+		return fmt.Sprintf("%s  %s", FormatBytes(status.DoneBytes), throughput)
+	}
+
+	fraction := float64(status.DoneBytes) / float64(status.TotalBytes)
+
+	return fmt.Sprintf("%s %5.1f%%  %s / %s  %s  ETA %s", formatBar(fraction), min(fraction, 1)*100, //nolint:gomnd
+		FormatBytes(status.DoneBytes), FormatBytes(status.TotalBytes), throughput,
+		formatETA(status.TotalBytes-status.DoneBytes, bytesPerSecond))
+}
+
+// formatCurrent describes the large file being copied, empty when there is none.
+func formatCurrent(current currentFile) string {
+	if current.path == "" || current.size <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s  %s / %s (%.1f%%)", current.path, FormatBytes(current.done), FormatBytes(current.size),
+		float64(current.done)/float64(current.size)*100) //nolint:gomnd
+}
+
+// formatMethods shows how many files each method copied, like reflink 3  kernel 5  stream 1.
+func formatMethods(status Status) string {
+	return fmt.Sprintf("reflink %d  kernel %d  stream %d", status.Reflinked, status.KernelCopied, status.Streamed)
+}
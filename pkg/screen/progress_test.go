@@ -0,0 +1,78 @@
+package screen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatBytes(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "512 B", FormatBytes(512))
+	assert.Equal(t, "1.5 KiB", FormatBytes(1536))
+	assert.Equal(t, "2.0 GiB", FormatBytes(2<<30))
+}
+
+func TestFormatTransfer(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "1.0 MiB  512.0 KiB/s", formatTransfer(Status{DoneBytes: 1 << 20}, 512*1024))
+	assert.Equal(t, "[#####---------------]  25.0%  1.0 MiB / 4.0 MiB  1.0 MiB/s  ETA 3s",
+		formatTransfer(Status{DoneBytes: 1 << 20, TotalBytes: 4 << 20}, 1<<20))
+	assert.Equal(t, "[--------------------]   0.0%  0 B / 4.0 MiB  0 B/s  ETA -",
+		formatTransfer(Status{TotalBytes: 4 << 20}, 0))
+}
+
+func TestFormatETA(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "2s", formatETA(5, 2))
+	assert.Equal(t, "1m40s", formatETA(100<<20, 1<<20))
+	assert.Equal(t, "-", formatETA(1<<40, 1))
+	assert.Equal(t, "-", formatETA(1<<20, 0))
+	assert.Equal(t, "-", formatETA(-1, 1))
+}
+
+func TestFormatCurrent(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "", formatCurrent(currentFile{}))
+	assert.Equal(t, "a.iso  16.0 MiB / 64.0 MiB (25.0%)",
+		formatCurrent(currentFile{path: "a.iso", size: 64 << 20, done: 16 << 20}))
+}
+
+func TestRate(t *testing.T) {
+	t.Parallel()
+
+	var (
+		rate rate
+		now  = time.Now()
+	)
+
+	assert.Zero(t, rate.add(now, 0))
+	assert.Equal(t, 100.0, rate.add(now.Add(time.Second), 100))
+
+	// the rate is averaged over the last samples only
+	for i := 2; i < 10; i++ {
+		rate.add(now.Add(time.Duration(i)*time.Second), 100)
+	}
+
+	assert.Zero(t, rate.add(now.Add(10*time.Second), 100))
+}
+
+func TestTrackerProgress(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTracker(nil, time.Second, nil)
+
+	tracker.Progress("small", LargeFileSize-1, 1)
+	assert.Equal(t, currentFile{}, tracker.current)
+
+	tracker.Progress("large", LargeFileSize, 1)
+	assert.Equal(t, currentFile{path: "large", size: LargeFileSize, done: 1}, tracker.current)
+
+	tracker.Progress("large", LargeFileSize, LargeFileSize)
+	assert.Equal(t, currentFile{}, tracker.current)
+}
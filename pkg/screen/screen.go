@@ -4,106 +4,304 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 	"time"
 
-	"github.com/fatih/color"
+	"golang.org/x/term"
 )
 
-// ErrNoOutputBuffer is returned when the output buffer is not set.
-var ErrNoOutputBuffer = errors.New("no output buffer")
+var (
+	// ErrNoOutputBuffer is returned when the output buffer is not set.
+	ErrNoOutputBuffer = errors.New("no output buffer")
+	// ErrUnknownOutput is returned for output modes other than the Output constants.
+	ErrUnknownOutput = errors.New("unknown output")
+)
+
+// Output selects a renderer.
+type Output string
+
+const (
+	// OutputAuto renders on a TTY when the output is a terminal and plain lines otherwise.
+	OutputAuto Output = "auto"
+	// OutputTTY redraws the view in place with ANSI escape codes.
+	OutputTTY Output = "tty"
+	// OutputPlain prints a progress line periodically, for logs.
+	OutputPlain Output = "plain"
+	// OutputJSON prints newline delimited JSON progress events, for wrapper tools.
+	OutputJSON Output = "json"
+	// OutputQuiet prints nothing.
+	OutputQuiet Output = "quiet"
+)
+
+const (
+	// defaultWidth is the width of terminals which don't report it.
+	defaultWidth = 80
+	// ttyInterval is the default interval of the TTY and JSON renderer.
+	ttyInterval = time.Second
+	// plainInterval is the default interval of the plain renderer, which should not flood the logs.
+	plainInterval = 10 * time.Second
+)
 
 // Status holds the current progress status.
+// The bytes are the sizes of the files, Active is the number of file operations in progress.
+// Failed counts the entries which failed in runs continuing on errors,
+// Conflicts the entries left because dst has another type, like a directory in place of a file.
+// SkippedSpecials counts the sockets, FIFOs and devices which are not recreated, they are also Skipped.
+// Collisions counts the src entries whose names collide on dst with the names of others in their directory.
+// TotalBytes are the bytes to copy estimated by a pre-count, DoneBytes the bytes copied so far.
+// The copied and replaced files are also counted by the method copying them,
+// KernelCopied are the copies with copy_file_range or sendfile.
 type Status struct {
-	SrcTotalFiles       int
-	SrcTotalDirectories int
-	DstTotalFiles       int
-	DstTotalDirectories int
-	Copied              int
-	Replaced            int
-	Removed             int
-	Skipped             int
-}
-
-// View provides a CLI view that shows a fixed text with the given number sets.
-type View struct {
-	stdout         io.Writer
+	SrcTotalFiles       int   `json:"srcTotalFiles"`
+	SrcTotalDirectories int   `json:"srcTotalDirectories"`
+	DstTotalFiles       int   `json:"dstTotalFiles"`
+	DstTotalDirectories int   `json:"dstTotalDirectories"`
+	Copied              int   `json:"copied"`
+	Replaced            int   `json:"replaced"`
+	Removed             int   `json:"removed"`
+	Skipped             int   `json:"skipped"`
+	CopiedBytes         int64 `json:"copiedBytes"`
+	ReplacedBytes       int64 `json:"replacedBytes"`
+	RemovedBytes        int64 `json:"removedBytes"`
+	SkippedBytes        int64 `json:"skippedBytes"`
+	Active              int   `json:"active"`
+	Failed              int   `json:"failed"`
+	Retrying            int   `json:"retrying"`
+	Conflicts           int   `json:"conflicts"`
+	SkippedSpecials     int   `json:"skippedSpecials"`
+	Collisions          int   `json:"collisions"`
+	TotalBytes          int64 `json:"totalBytes"`
+	DoneBytes           int64 `json:"doneBytes"`
+	Reflinked           int   `json:"reflinked"`
+	KernelCopied        int   `json:"kernelCopied"`
+	Streamed            int   `json:"streamed"`
+	PermissionFailed    int   `json:"permissionFailed"`
+	NotExistFailed      int   `json:"notExistFailed"`
+	ExistFailed         int   `json:"existFailed"`
+	NoSpaceFailed       int   `json:"noSpaceFailed"`
+	TransientFailed     int   `json:"transientFailed"`
+	OtherFailed         int   `json:"otherFailed"`
+}
+
+// Add adds the counters of the other status.
+func (s *Status) Add(other Status) {
+	s.SrcTotalFiles += other.SrcTotalFiles
+	s.SrcTotalDirectories += other.SrcTotalDirectories
+	s.DstTotalFiles += other.DstTotalFiles
+	s.DstTotalDirectories += other.DstTotalDirectories
+	s.Copied += other.Copied
+	s.Removed += other.Removed
+	s.Replaced += other.Replaced
+	s.Skipped += other.Skipped
+	s.CopiedBytes += other.CopiedBytes
+	s.ReplacedBytes += other.ReplacedBytes
+	s.RemovedBytes += other.RemovedBytes
+	s.SkippedBytes += other.SkippedBytes
+	s.Active += other.Active
+	s.Failed += other.Failed
+	s.Retrying += other.Retrying
+	s.Conflicts += other.Conflicts
+	s.SkippedSpecials += other.SkippedSpecials
+	s.Collisions += other.Collisions
+	s.TotalBytes += other.TotalBytes
+	s.DoneBytes += other.DoneBytes
+	s.Reflinked += other.Reflinked
+	s.KernelCopied += other.KernelCopied
+	s.Streamed += other.Streamed
+	s.PermissionFailed += other.PermissionFailed
+	s.NotExistFailed += other.NotExistFailed
+	s.ExistFailed += other.ExistFailed
+	s.NoSpaceFailed += other.NoSpaceFailed
+	s.TransientFailed += other.TransientFailed
+	s.OtherFailed += other.OtherFailed
+}
+
+// Renderer shows the progress of a sync.
+type Renderer interface {
+	// AddStatus adds progress counters.
+	AddStatus(status Status)
+	// Progress shows the file being copied, when it is large, with the bytes copied so far.
+	Progress(path string, size, done int64)
+	// Copied logs the method which copied the file.
+	Copied(path, method string)
+	// Warn logs an entry skipped with a warning, like a socket.
+	Warn(path, message string)
+	// Info logs a message about the sync, like the profile of the dst file system.
+	Info(message string)
+	// Start renders the progress periodically until Stop, the elapsed time is counted from it.
+	Start() error
+	// Stop renders the final state and returns once it is written, it can be called more than once.
+	Stop()
+}
+
+// NewRenderer creates the renderer of the output writing to the file.
+// The interval between two renderings is the default of the renderer when 0.
+func NewRenderer(output Output, file *os.File, interval time.Duration) (Renderer, error) {
+	if output == OutputAuto || output == "" {
+		output = OutputPlain
+		if term.IsTerminal(int(file.Fd())) {
+			output = OutputTTY
+		}
+	}
+
+	switch output {
+	case OutputTTY:
+		return NewTTYRenderer(file, func() int {
+			return terminalWidth(file)
+		}, interval), nil
+	case OutputPlain:
+		return NewPlainRenderer(file, interval), nil
+	case OutputJSON:
+		return NewJSONRenderer(file, interval), nil
+	case OutputQuiet:
+		return QuietRenderer{}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownOutput, output)
+}
+
+// terminalWidth returns the current width of the terminal, defaultWidth when it doesn't report it.
+func terminalWidth(file *os.File) int {
+	width, _, err := term.GetSize(int(file.Fd()))
+	if err != nil || width <= 0 {
+		return defaultWidth
+	}
+
+	return width
+}
+
+// QuietRenderer shows nothing.
+type QuietRenderer struct{}
+
+// AddStatus ignores the counters.
+func (QuietRenderer) AddStatus(Status) {}
+
+// Progress ignores the file.
+func (QuietRenderer) Progress(string, int64, int64) {}
+
+// Copied ignores the file.
+func (QuietRenderer) Copied(string, string) {}
+
+// Warn ignores the warning.
+func (QuietRenderer) Warn(string, string) {}
+
+// Info ignores the message.
+func (QuietRenderer) Info(string) {}
+
+// Start does nothing.
+func (QuietRenderer) Start() error {
+	return nil
+}
+
+// Stop does nothing.
+func (QuietRenderer) Stop() {}
+
+// snapshot is the state a renderer shows.
+type snapshot struct {
 	status         Status
-	numberSetsLock sync.Mutex
-	ticker         *time.Ticker
-	stopChan       chan struct{}
-	startTime      time.Time
-	firstPrint     bool
-}
-
-func NewView(stdout io.Writer) View {
-	return View{
-		stdout:     stdout,
-		firstPrint: true,
-		startTime:  time.Now(),
-		stopChan:   make(chan struct{}),
+	current        currentFile
+	elapsed        time.Duration
+	bytesPerSecond float64
+	final          bool
+	// notices are the warnings and infos logged since the last rendering.
+	notices []notice
+}
+
+// notice is a logged warning about an entry or an info, which has no path.
+type notice struct {
+	event   string
+	path    string
+	message string
+}
+
+// tracker collects the progress and renders it periodically, it is the base of the renderers.
+type tracker struct {
+	lock     sync.Mutex
+	status   Status
+	current  currentFile
+	rate     rate
+	started  time.Time
+	writer   io.Writer
+	interval time.Duration
+	render   func(snapshot)
+	notices  []notice
+	running  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newTracker(writer io.Writer, interval time.Duration, render func(snapshot)) *tracker {
+	return &tracker{
+		started: time.Now(), writer: writer, interval: interval, render: render,
+		stop: make(chan struct{}), done: make(chan struct{}),
 	}
 }
 
-// AddStatus sets number sets to display.
-func (v *View) AddStatus(status Status) {
-	v.numberSetsLock.Lock()
-	defer v.numberSetsLock.Unlock()
+// AddStatus adds progress counters.
+func (t *tracker) AddStatus(status Status) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
-	v.status.SrcTotalFiles += status.SrcTotalFiles
-	v.status.SrcTotalDirectories += status.SrcTotalDirectories
-	v.status.DstTotalFiles += status.DstTotalFiles
-	v.status.DstTotalDirectories += status.DstTotalDirectories
-	v.status.Copied += status.Copied
-	v.status.Removed += status.Removed
-	v.status.Replaced += status.Replaced
-	v.status.Skipped += status.Skipped
+	t.status.Add(status)
 }
 
-// Render renders the view.
-func (v *View) Render() {
-	v.numberSetsLock.Lock()
-	defer v.numberSetsLock.Unlock()
+// Progress shows the file being copied, when it is large, with the bytes copied so far.
+func (t *tracker) Progress(path string, size, done int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
-	if v.firstPrint {
-		v.firstPrint = false
+	if size < LargeFileSize || done >= size {
+		t.current = currentFile{}
 	} else {
-		fmt.Print("\033[11F")
+		t.current = currentFile{path: path, size: size, done: done}
 	}
+}
+
+// Copied ignores the file, only the JSON renderer logs the copies.
+func (t *tracker) Copied(string, string) {}
+
+// Warn queues the warning, it is printed with the next rendering.
+func (t *tracker) Warn(path, message string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
-	fmt.Printf("Elapsed       : %s\n\n", color.BlueString("%v", time.Since(v.startTime)))
+	t.notices = append(t.notices, notice{event: "warning", path: path, message: message})
+}
 
-	fmt.Printf("TotalSrcFiles : %s\n", color.CyanString("%d", v.status.SrcTotalFiles))
-	fmt.Printf("TotalSrcDirs  : %s\n", color.CyanString("%d", v.status.SrcTotalDirectories))
-	fmt.Printf("TotalDstFiles : %s\n", color.CyanString("%d", v.status.DstTotalFiles))
-	fmt.Printf("TotalDstDirs  : %s\n\n", color.CyanString("%d", v.status.DstTotalDirectories))
+// Info queues the message, it is printed with the next rendering.
+func (t *tracker) Info(message string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
-	fmt.Printf("Copied        : %s\n", color.HiCyanString("%d", v.status.Copied))
-	fmt.Printf("Replaced      : %s\n", color.HiCyanString("%d", v.status.Replaced))
-	fmt.Printf("Removed       : %s\n", color.HiCyanString("%d", v.status.Removed))
-	fmt.Printf("Skipped       : %s\n", color.HiCyanString("%d", v.status.Skipped))
+	t.notices = append(t.notices, notice{event: "info", message: message})
 }
 
-// Start the view rendering.
-func (v *View) Start() error {
-	if v.stdout == nil {
+// Start renders the progress periodically until Stop.
+func (t *tracker) Start() error {
+	if t.writer == nil {
 		return ErrNoOutputBuffer
 	}
 
-	v.stopChan = make(chan struct{})
+	t.lock.Lock()
+	t.started, t.running = time.Now(), true
+	t.lock.Unlock()
 
 	go func() {
-		v.ticker = time.NewTicker(time.Second)
+		defer close(t.done)
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
 
-		v.Render()
+		t.renderSnapshot(false)
 
 		for {
 			select {
-			case <-v.ticker.C:
-				v.Render()
-			case <-v.stopChan:
-				v.ticker.Stop()
-				v.Render()
+			case <-ticker.C:
+				t.renderSnapshot(false)
+			case <-t.stop:
+				t.renderSnapshot(true)
 
 				return
 			}
@@ -113,9 +311,27 @@ func (v *View) Start() error {
 	return nil
 }
 
-// Stop the view rendering.
-func (v *View) Stop() {
-	if v.stopChan != nil {
-		close(v.stopChan)
+// Stop renders the final state and returns once it is written.
+// Like Start it is called by the goroutine owning the renderer.
+func (t *tracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+
+	if t.running {
+		<-t.done
 	}
 }
+
+// renderSnapshot renders the current state, the lock keeps the renderings from overlapping.
+func (t *tracker) renderSnapshot(final bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	t.render(snapshot{
+		status: t.status, current: t.current, elapsed: now.Sub(t.started),
+		bytesPerSecond: t.rate.add(now, t.status.DoneBytes), final: final, notices: t.notices,
+	})
+	t.notices = nil
+}
@@ -0,0 +1,177 @@
+package screen
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlainRenderer(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+
+	renderer := NewPlainRenderer(&buffer, time.Hour)
+	require.NoError(t, renderer.Start())
+
+	renderer.AddStatus(Status{Copied: 2, DoneBytes: 1 << 20, TotalBytes: 4 << 20})
+	renderer.Progress("a.iso", 64<<20, 16<<20)
+	renderer.Warn("src/fifo", "special file skipped: fifo")
+	renderer.Stop()
+	renderer.Stop()
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	// the warning is printed with the first or the final rendering
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[:2], `warning path="src/fifo" message="special file skipped: fifo"`)
+
+	progress := lines[0]
+	if strings.HasPrefix(progress, "warning") {
+		progress = lines[1]
+	}
+
+	assert.True(t, strings.HasPrefix(progress, "progress elapsed=0s"), progress)
+	assert.True(t, strings.HasPrefix(lines[2], "done elapsed=0s"), lines[2])
+	assert.Contains(t, lines[2], "copied=2")
+	assert.Contains(t, lines[2], "reflinked=0 kernel_copied=0 streamed=0")
+	assert.Contains(t, lines[2], `transferred="1.0 MiB" `)
+	assert.Contains(t, lines[2], `total="4.0 MiB" percent=25.0`)
+	assert.Contains(t, lines[2], `current="a.iso  16.0 MiB / 64.0 MiB (25.0%)"`)
+	assert.NotContains(t, buffer.String(), "\033")
+}
+
+func TestJSONRenderer(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+
+	renderer := NewJSONRenderer(&buffer, time.Hour)
+	require.NoError(t, renderer.Start())
+
+	renderer.AddStatus(Status{Copied: 1, Failed: 1})
+	renderer.Progress("a.iso", 64<<20, 16<<20)
+	renderer.Copied("dst/a.iso", "reflink")
+	renderer.Warn("src/socket", "special file skipped: socket")
+	renderer.Stop()
+
+	assert.Contains(t, buffer.String(), `{"event":"copied","path":"dst/a.iso","method":"reflink"}`+"\n")
+	assert.Contains(t, buffer.String(),
+		`{"event":"warning","path":"src/socket","message":"special file skipped: socket"}`+"\n")
+
+	decoder := json.NewDecoder(&buffer)
+
+	var events []JSONEvent
+
+	for decoder.More() {
+		var event JSONEvent
+		require.NoError(t, decoder.Decode(&event))
+		events = append(events, event)
+	}
+
+	require.Len(t, events, 4)
+	assert.Equal(t, "copied", events[0].Event)
+	assert.Equal(t, "warning", events[1].Event)
+	assert.Equal(t, "progress", events[2].Event)
+	assert.Equal(t, "done", events[3].Event)
+	assert.Equal(t, Status{Copied: 1, Failed: 1}, events[3].Status)
+	assert.Nil(t, events[3].ETASeconds)
+	assert.Equal(t, &JSONFile{Path: "a.iso", Size: 64 << 20, Done: 16 << 20}, events[3].Current)
+}
+
+func TestTTYRenderer(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+
+	renderer := NewTTYRenderer(&buffer, func() int {
+		return 30
+	}, time.Hour)
+	require.NoError(t, renderer.Start())
+
+	renderer.Progress(strings.Repeat("long/", 20)+"a.iso", 64<<20, 16<<20)
+	renderer.Warn("src/socket", "special file skipped: socket")
+	renderer.Stop()
+
+	output := buffer.String()
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+
+	// the warning is printed above the redrawn view
+	require.Len(t, lines, 2*renderer.lines+1)
+	assert.Equal(t, 1, strings.Count(output, "\033[19F"))
+	assert.Contains(t, output, "Warning: src/s…kipped: socket\n")
+	assert.Contains(t, output, "…")
+
+	t.Run("Resize", func(t *testing.T) {
+		var buffer bytes.Buffer
+
+		width := 80
+		renderer := NewTTYRenderer(&buffer, func() int {
+			return width
+		}, time.Hour)
+		current := currentFile{path: strings.Repeat("long/", 20) + "a.iso", size: 64 << 20}
+
+		renderer.draw(snapshot{current: current})
+		assert.Contains(t, buffer.String(), "long/long/long/long/long/long/")
+
+		width = 30
+		buffer.Reset()
+		renderer.draw(snapshot{current: current})
+		assert.NotContains(t, buffer.String(), "long/long/")
+	})
+}
+
+func TestRendererWithoutWriter(t *testing.T) {
+	t.Parallel()
+
+	renderer := NewPlainRenderer(nil, time.Second)
+	assert.ErrorIs(t, renderer.Start(), ErrNoOutputBuffer)
+	renderer.Stop()
+}
+
+func TestNewRenderer(t *testing.T) {
+	t.Parallel()
+
+	file, err := os.Create("test_renderer")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		file.Close()
+		os.Remove("test_renderer")
+	})
+
+	tests := []struct {
+		output   Output
+		expected Renderer
+	}{
+		{OutputAuto, &PlainRenderer{}},
+		{"", &PlainRenderer{}},
+		{OutputTTY, &TTYRenderer{}},
+		{OutputPlain, &PlainRenderer{}},
+		{OutputJSON, &JSONRenderer{}},
+		{OutputQuiet, QuietRenderer{}},
+	}
+
+	for _, test := range tests {
+		renderer, err := NewRenderer(test.output, file, 0)
+		require.NoError(t, err)
+		assert.IsType(t, test.expected, renderer, test.output)
+	}
+
+	_, err = NewRenderer("html", file, 0)
+	assert.ErrorIs(t, err, ErrUnknownOutput)
+}
+
+func TestFit(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "short", Fit("short", 10))
+	assert.Equal(t, "ab…yz", Fit("abcdefghijklmnopqrstuvwxyz", 5))
+	assert.Equal(t, "a…z", Fit("abcdefghijklmnopqrstuvwxyz", 3))
+	assert.Equal(t, "", Fit("abc", 0))
+}
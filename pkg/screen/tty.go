@@ -0,0 +1,118 @@
+package screen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// ttyLabelWidth is the width of the labels in front of the values.
+const ttyLabelWidth = 16
+
+// TTYRenderer redraws the counters in place with ANSI escape codes, the lines are cut to the terminal width.
+type TTYRenderer struct {
+	*tracker
+	// width returns the current terminal width, it is read before each rendering to follow resizes.
+	width func() int
+	// lines is the number of lines of the last rendering, the cursor moves up by them before the next one.
+	lines int
+}
+
+// ttyLine is a labeled value of the view, the zero line is an empty line.
+type ttyLine struct {
+	label string
+	value string
+	color *color.Color
+}
+
+// NewTTYRenderer creates a renderer for a terminal whose width is returned by the function,
+// it redraws every second by default.
+func NewTTYRenderer(writer io.Writer, width func() int, interval time.Duration) *TTYRenderer {
+	if interval == 0 {
+		interval = ttyInterval
+	}
+
+	renderer := &TTYRenderer{width: width}
+	renderer.tracker = newTracker(writer, interval, renderer.draw)
+
+	return renderer
+}
+
+func (r *TTYRenderer) draw(s snapshot) {
+	var (
+		buffer  bytes.Buffer
+		counter = color.New(color.FgCyan)
+		action  = color.New(color.FgHiCyan)
+	)
+
+	if r.lines > 0 {
+		fmt.Fprintf(&buffer, "\033[%dF", r.lines)
+	}
+
+	width := r.width()
+
+	// the notices stay above the view, which is redrawn below them
+	for _, notice := range s.notices {
+		text, noticeColor := notice.message, color.New(color.Reset)
+		if notice.event == "warning" {
+			text, noticeColor = "Warning: "+notice.path+": "+notice.message, color.New(color.FgYellow)
+		}
+
+		buffer.WriteString("\033[K" + noticeColor.Sprint(Fit(text, width-1)) + "\n")
+	}
+
+	lines := []ttyLine{
+		{"Elapsed", s.elapsed.Truncate(time.Second).String(), color.New(color.FgBlue)},
+		{},
+		{"TotalSrcFiles", strconv.Itoa(s.status.SrcTotalFiles), counter},
+		{"TotalSrcDirs", strconv.Itoa(s.status.SrcTotalDirectories), counter},
+		{"TotalDstFiles", strconv.Itoa(s.status.DstTotalFiles), counter},
+		{"TotalDstDirs", strconv.Itoa(s.status.DstTotalDirectories), counter},
+		{},
+		{"Copied", strconv.Itoa(s.status.Copied), action},
+		{"Replaced", strconv.Itoa(s.status.Replaced), action},
+		{"Removed", strconv.Itoa(s.status.Removed), action},
+		{"Skipped", strconv.Itoa(s.status.Skipped), action},
+		{"SkippedSpecial", strconv.Itoa(s.status.SkippedSpecials), color.New(color.FgYellow)},
+		{"Failed", strconv.Itoa(s.status.Failed), color.New(color.FgHiRed)},
+		{"Conflicts", strconv.Itoa(s.status.Conflicts), color.New(color.FgYellow)},
+		{"Collisions", strconv.Itoa(s.status.Collisions), color.New(color.FgYellow)},
+		{"CopiedBy", formatMethods(s.status), action},
+		{},
+		{"Transferred", formatTransfer(s.status, s.bytesPerSecond), color.New(color.FgGreen)},
+		{"Current", formatCurrent(s.current), color.New(color.Reset)},
+	}
+
+	// the lines change their length, so they are cleared first
+	for _, line := range lines {
+		buffer.WriteString("\033[K")
+
+		if line.label != "" {
+			label := fmt.Sprintf("%-*s: ", ttyLabelWidth-2, line.label) //nolint:gomnd
+			buffer.WriteString(label + line.color.Sprint(Fit(line.value, width-len(label)-1)))
+		}
+
+		buffer.WriteByte('\n')
+	}
+
+	r.lines = len(lines)
+	r.writer.Write(buffer.Bytes()) //nolint:errcheck
+}
+
+// Fit cuts the middle of the text to fit into the width.
+func Fit(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	} else if width < 1 {
+		return ""
+	}
+
+	head := (width - 1) / 2 //nolint:gomnd
+
+	return string(runes[:head]) + "…" + string(runes[len(runes)-(width-1-head):])
+}
@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnknownDeleteMode is returned for delete modes other than the DeleteMode constants.
+var ErrUnknownDeleteMode = errors.New("unknown delete mode")
+
+// DeleteMode is when the removals are executed relative to the copies.
+type DeleteMode string
+
+const (
+	// DeleteBefore walks dst for removals first, freeing space before anything is copied.
+	DeleteBefore DeleteMode = "before"
+	// DeleteDuring walks src and dst at the same time.
+	DeleteDuring DeleteMode = "during"
+	// DeleteAfter walks dst for removals once all copies succeeded, the removals are skipped when entries failed.
+	DeleteAfter DeleteMode = "after"
+	// DeleteDelay walks src and dst at the same time, but queues the removals and executes them at the end.
+	DeleteDelay DeleteMode = "delay"
+)
+
+// ParseDeleteMode returns the delete mode of the name, DeleteDuring when it is empty.
+func ParseDeleteMode(name string) (DeleteMode, error) {
+	switch mode := DeleteMode(name); mode {
+	case "":
+		return DeleteDuring, nil
+	case DeleteBefore, DeleteDuring, DeleteAfter, DeleteDelay:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownDeleteMode, name)
+}
+
+// removal is a removal queued with DeleteDelay.
+type removal struct {
+	path      string
+	operation func() error
+}
+
+// delay queues the removal of the dst path until the walks are done.
+func (r *run) delay(dstPath string, operation func() error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.delayed = append(r.delayed, removal{path: dstPath, operation: operation})
+}
+
+// delayedAbove reports whether a directory above the dst path is queued for removal.
+// The walk visits the entries of a directory right after it and queues none of them,
+// so the directory is still the last queued removal.
+func (r *run) delayedAbove(dstPath string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	last := len(r.delayed) - 1
+
+	return last >= 0 && strings.HasPrefix(dstPath, r.delayed[last].path+string(filepath.Separator))
+}
+
+// removeDelayed executes the queued removals in walk order.
+func (r *run) removeDelayed() error {
+	for _, removal := range r.delayed {
+		if err := r.options.Wait(r.ctx); err != nil {
+			return err
+		}
+
+		if err := r.execute(ActionRemove, removal.path, removal.operation); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
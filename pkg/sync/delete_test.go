@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"dtsync/pkg/fs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseDeleteMode(t *testing.T) {
+	t.Parallel()
+
+	for name, expected := range map[string]DeleteMode{
+		"": DeleteDuring, "before": DeleteBefore, "during": DeleteDuring, "after": DeleteAfter, "delay": DeleteDelay,
+	} {
+		mode, err := ParseDeleteMode(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, mode)
+	}
+
+	_, err := ParseDeleteMode("later")
+	assert.ErrorIs(t, err, ErrUnknownDeleteMode)
+}
+
+// existsObserver records the actions in order and whether the file existed at each decision.
+type existsObserver struct {
+	recorder
+	path    string
+	actions []Action
+	exists  []bool
+}
+
+func (o *existsObserver) Decided(decision Decision) {
+	o.recorder.Decided(decision)
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	_, err := os.Stat(o.path)
+	o.actions = append(o.actions, decision.Action)
+	o.exists = append(o.exists, err == nil)
+}
+
+func TestDeleteMode(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_delete_mode")
+	})
+
+	for _, test := range []struct {
+		mode    DeleteMode
+		actions []Action
+	}{
+		{DeleteBefore, []Action{ActionRemove, ActionRemove, ActionSkip, ActionCopy}},
+		{DeleteAfter, []Action{ActionSkip, ActionCopy, ActionRemove, ActionRemove}},
+		{DeleteDelay, nil},
+	} {
+		test := test
+
+		t.Run(string(test.mode), func(t *testing.T) {
+			t.Parallel()
+
+			root := filepath.Join("test_delete_mode", string(test.mode))
+			for _, dir := range []string{"src", "dst/leftover"} {
+				assert.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0o755))
+			}
+
+			for _, name := range []string{"src/new.txt", "dst/old.txt", "dst/leftover/a.txt"} {
+				assert.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(name), 0o644))
+			}
+
+			observer := &existsObserver{path: filepath.Join(root, "dst/old.txt")}
+			engine, err := New(Options{
+				SrcRootPath: filepath.Join(root, "src"), DstRootPath: filepath.Join(root, "dst"), Remove: true,
+				DeleteMode: test.mode, Observer: observer,
+			})
+			assert.NoError(t, err)
+
+			result, err := engine.Run(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, result.Complete)
+			assert.Equal(t, 1, result.Status.Copied)
+			assert.Equal(t, 2, result.Status.Removed)
+			assert.FileExists(t, filepath.Join(root, "dst/new.txt"))
+			assert.NoFileExists(t, filepath.Join(root, "dst/old.txt"))
+			assert.NoDirExists(t, filepath.Join(root, "dst/leftover"))
+
+			if test.actions != nil {
+				assert.Equal(t, test.actions, observer.actions)
+			} else {
+				// the removals are executed after all decisions, also the entries of the removed directory are skipped
+				assert.Len(t, observer.actions, 4)
+				assert.NotContains(t, observer.exists, false)
+			}
+		})
+	}
+}
+
+func TestDeleteAfterFailure(t *testing.T) {
+	t.Parallel()
+
+	operation := &fs.MockFS{}
+	operation.On("Exists", mock.Anything).Return(false)
+	operation.On("Copy", "src/denied", "dst/denied").Return(syscall.EACCES)
+
+	// the remove scanner is never started
+	removeScanner := &fs.MockShadowScanner{}
+	removeScanner.On("Stop").Return()
+
+	engine, err := New(Options{
+		SrcRootPath: "src", DstRootPath: "dst", Operation: operation, Remove: true, DeleteMode: DeleteAfter,
+		Scanner: scanFiles("denied"), RemoveScanner: removeScanner, ContinueOnError: true,
+	})
+	assert.NoError(t, err)
+
+	result, err := engine.Run(context.Background())
+	assert.ErrorIs(t, err, ErrEntriesFailed)
+	assert.EqualError(t, err, "1 entries failed, removals skipped")
+	assert.True(t, result.RemovalsSkipped)
+	assert.False(t, result.Complete)
+	assert.Len(t, result.Failures, 1)
+	removeScanner.AssertExpectations(t)
+}
@@ -0,0 +1,806 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"path/filepath"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"dtsync/pkg/fs"
+	"dtsync/pkg/screen"
+)
+
+// ErrMissingRootPath is returned when the src or dst root path is missing.
+var ErrMissingRootPath = errors.New("src and dst root path are required")
+
+// DefaultRetryDelay is the delay before the first retry of an entry failed with a transient error.
+const DefaultRetryDelay = time.Second
+
+// Options configure an Engine.
+type Options struct {
+	// SrcRootPath and DstRootPath are the synced roots.
+	SrcRootPath string
+	DstRootPath string
+	// Replace replaces files on dst which differ from src.
+	Replace bool
+	// Remove removes the entries on dst missing on src.
+	Remove bool
+	// Specials recreates the FIFOs and Devices the block and character devices of src, with fs.WithSpecials
+	// and fs.WithDevices for the default operation. The other special files, like sockets, are skipped
+	// with a warning to a WarningObserver and counted as SkippedSpecials.
+	Specials bool
+	Devices  bool
+	// Profile is what the dst file system supports, the default operation adapts to it with fs.WithProfile.
+	// It is fs.FullProfile when nil, see Probe.
+	Profile *fs.Profile
+	// Normalization is the Unicode normalization form of the dst names, fs.NormalizeNone by default.
+	// The names of a src directory colliding on dst after it or, with a case-insensitive or normalization-insensitive
+	// Profile, case folding or Unicode normalization are handled by the Collisions policy, fs.CollisionSkip by default, and reported like the skipped specials,
+	// counted as Collisions. The remove walk matches the dst names with the src names in the same way.
+	Normalization fs.Normalization
+	Collisions    fs.CollisionPolicy
+	// DeleteMode is when the removals are executed relative to the copies, DeleteDuring by default.
+	DeleteMode DeleteMode
+	// Backend is used to look up the file sizes, the local file system by default.
+	Backend fs.Backend
+	// Operation executes the actions, by default fs.NewOperation on Backend with the OperationOptions,
+	// which reports the bytes copied as DoneBytes and to a ProgressObserver
+	// and the copy methods to the status and a CopyObserver.
+	Operation fs.OperationI
+	// OperationOptions configure the default operation, like its codec.
+	OperationOptions []fs.OperationOption
+	// Scanner walks the src root, fs.NewShadowScan on Backend with the ScanOptions by default.
+	Scanner fs.ShadowScanI
+	// RemoveScanner walks the dst root for removals, fs.NewShadowScan on Backend with the RemoveScanOptions by default.
+	RemoveScanner fs.ShadowScanI
+	// ScanOptions and RemoveScanOptions configure the default scanners, like their name mappers.
+	ScanOptions       []fs.ShadowScanOption
+	RemoveScanOptions []fs.ShadowScanOption
+	// ContinueOnError records the failed entries and continues instead of stopping at the first error.
+	// Entries failed with a transient error are retried after the walks.
+	// Only the default scanners skip unreadable directories, injected ones stop at them.
+	ContinueOnError bool
+	// MaxErrors stops a run continuing on errors when more entries failed, 0 is unlimited.
+	MaxErrors int
+	// Retries is the number of retries of an entry failed with a transient error.
+	Retries int
+	// RetryDelay is the delay before the first retry, doubled for each further one, DefaultRetryDelay by default.
+	RetryDelay time.Duration
+	// Observer is notified about the progress and decisions.
+	Observer Observer
+	// Wait is called before each entry and blocks while transfers are paused.
+	Wait func(ctx context.Context) error
+	// Approve is called for each copy, replacement and removal before it is executed,
+	// the rejected ones are skipped. Everything is approved when it is nil, see Approved.
+	Approve func(decision Decision) bool
+}
+
+// operationOptions returns the options of the default operation on the backend.
+func (o Options) operationOptions() []fs.OperationOption {
+	options := []fs.OperationOption{fs.WithBackend(o.Backend)}
+	if o.Specials {
+		options = append(options, fs.WithSpecials())
+	}
+
+	if o.Devices {
+		options = append(options, fs.WithDevices())
+	}
+
+	if o.Profile != nil {
+		options = append(options, fs.WithProfile(*o.Profile))
+	}
+
+	return append(options, o.OperationOptions...)
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	Status   screen.Status
+	Started  time.Time
+	Duration time.Duration
+	// Complete is true when all walks reached their end, even if entries failed with ContinueOnError,
+	// and the removals were not skipped.
+	Complete bool
+	// Failures are the entries which failed with ContinueOnError.
+	Failures []Failure
+	// RemovalsSkipped is true when DeleteAfter skipped the removals because entries failed.
+	RemovalsSkipped bool
+}
+
+// Engine syncs the dst root with the src root.
+// The scanners can't be reused, so an engine with injected scanners runs once.
+type Engine struct {
+	options Options
+	// defaultOperation is true when the operation is built from the OperationOptions.
+	defaultOperation bool
+}
+
+// New creates an engine, filling in the defaults of the options.
+func New(options Options) (*Engine, error) {
+	if options.SrcRootPath == "" || options.DstRootPath == "" {
+		return nil, ErrMissingRootPath
+	}
+
+	if options.Backend == nil {
+		options.Backend = fs.LocalBackend{}
+	}
+
+	defaultOperation := options.Operation == nil
+	if defaultOperation {
+		options.Operation = fs.NewOperation(options.operationOptions()...)
+	}
+
+	if options.Observer == nil {
+		options.Observer = nopObserver{}
+	}
+
+	if options.Normalization == "" {
+		options.Normalization = fs.NormalizeNone
+	}
+
+	if options.Collisions == "" {
+		options.Collisions = fs.CollisionSkip
+	}
+
+	if options.DeleteMode == "" {
+		options.DeleteMode = DeleteDuring
+	}
+
+	if options.RetryDelay == 0 {
+		options.RetryDelay = DefaultRetryDelay
+	}
+
+	if options.Wait == nil {
+		options.Wait = func(ctx context.Context) error {
+			return ctx.Err()
+		}
+	}
+
+	return &Engine{options: options, defaultOperation: defaultOperation}, nil
+}
+
+// Run syncs until everything is done, an action failed or ctx is canceled.
+// On cancellation the actions in progress are finished before it returns ctx.Err().
+// A failing walk stops the other one, the result holds the progress of both until then.
+// With Remove the dst walk runs at the same time as the src walk, before or after it, see DeleteMode.
+// With ContinueOnError the failed entries are returned as *FailedError after the retries.
+func (e *Engine) Run(ctx context.Context) (Result, error) {
+	passCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	run := &run{Engine: e, ctx: passCtx, result: Result{Started: time.Now()}, operation: e.options.Operation}
+	if e.defaultOperation {
+		run.operation = fs.NewOperation(
+			append(e.options.operationOptions(), fs.WithProgress(run.progress), fs.WithCopyReport(run.copied))...)
+	}
+
+	scanner, removeScanner := e.options.Scanner, e.options.RemoveScanner
+	if scanner == nil {
+		scanner = fs.NewShadowScan(append(run.scanOptions(e.options.ScanOptions), e.nameOptions(false, run.warn)...)...)
+	}
+
+	if removeScanner == nil {
+		removeScanner = fs.NewShadowScan(
+			append(run.scanOptions(e.options.RemoveScanOptions), e.nameOptions(true, nil)...)...)
+	}
+
+	defer scanner.Stop()
+	defer removeScanner.Stop()
+
+	var (
+		copyWalk = func() <-chan error {
+			return scanner.Start(passCtx, e.options.SrcRootPath, e.options.DstRootPath,
+				run.track(run.syncFile), run.track(run.syncDirectory))
+		}
+		removeWalk = func() <-chan error {
+			return removeScanner.Start(passCtx, e.options.DstRootPath, e.options.SrcRootPath,
+				run.track(run.removeFile), run.track(run.removeDirectory))
+		}
+		passes = [][]func() <-chan error{{copyWalk}}
+	)
+
+	switch {
+	case !e.options.Remove:
+	case e.options.DeleteMode == DeleteBefore:
+		passes = [][]func() <-chan error{{removeWalk}, {copyWalk}}
+	case e.options.DeleteMode == DeleteAfter:
+		passes = [][]func() <-chan error{{copyWalk}, {removeWalk}}
+	default:
+		passes = [][]func() <-chan error{{copyWalk, removeWalk}}
+	}
+
+	var (
+		errs    []error
+		stopped bool
+	)
+
+	for i, walks := range passes {
+		if i > 0 && e.options.DeleteMode == DeleteAfter {
+			// the removals wait also for the retries of the copies, which all have to succeed
+			stopped = errors.Is(run.retry(), ErrTooManyErrors)
+			if run.result.RemovalsSkipped = len(run.failures()) > 0; run.result.RemovalsSkipped {
+				break
+			}
+		}
+
+		if errs, stopped = run.walk(cancel, walks); len(errs) > 0 || stopped {
+			break
+		}
+	}
+
+	if len(errs) == 0 && !stopped && ctx.Err() == nil {
+		err := run.removeDelayed()
+		if err == nil {
+			err = run.retry()
+		}
+
+		switch {
+		case errors.Is(err, ErrTooManyErrors):
+			stopped = true
+		case err != nil && ctx.Err() == nil:
+			errs = append(errs, err)
+		}
+	}
+
+	// also after errors, so the new directories don't keep their temporary mode
+	if err := run.fixDirectories(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+
+	run.result.Complete = len(errs) == 0 && !stopped && !run.result.RemovalsSkipped
+
+	if failures := run.failures(); len(failures) > 0 {
+		errs = append(errs, &FailedError{
+			Failures: failures, Stopped: stopped, RemovalsSkipped: run.result.RemovalsSkipped,
+		})
+	}
+
+	return run.finish(errors.Join(errs...))
+}
+
+// walk runs the walks at the same time until each reported its end or error,
+// a failing walk stops the others. The errors caused by the cancellation are not returned.
+func (r *run) walk(cancel context.CancelFunc, walks []func() <-chan error) ([]error, bool) {
+	errChans := make([]<-chan error, 0, len(walks))
+	for _, walk := range walks {
+		errChans = append(errChans, walk())
+	}
+
+	var (
+		errs    []error
+		stopped bool
+	)
+
+	for _, errChan := range errChans {
+		err := <-errChan
+
+		switch {
+		case errors.Is(err, fs.ErrScannerAtEnd):
+		case errors.Is(err, ErrTooManyErrors):
+			stopped = true
+
+			cancel()
+		case r.ctx.Err() != nil && errors.Is(err, r.ctx.Err()):
+		default:
+			errs = append(errs, err)
+			cancel()
+		}
+	}
+
+	return errs, stopped
+}
+
+// run is the state of one Run.
+type run struct {
+	*Engine
+	ctx     context.Context //nolint:containedctx
+	lock    gosync.Mutex
+	result  Result
+	retries []retry
+	delayed []removal
+	fixups  []fixup
+	// locked are the read-only dst directories made writable, by their mode before
+	locked    map[string]iofs.FileMode
+	operation fs.OperationI
+}
+
+// step decides about a scanned entry, it returns the action and the operation executing it,
+// which is nil when there is nothing to do.
+type step func(srcPath, dstPath string) (Action, func() error)
+
+// retry is an entry failed with a transient error, waiting for its next attempt.
+type retry struct {
+	path      string
+	action    Action
+	operation func() error
+	attempts  int
+	due       time.Time
+	err       error
+}
+
+// fixup is a directory which gets the attributes of its src after its entries are done.
+type fixup struct {
+	srcPath string
+	dstPath string
+	action  Action
+}
+
+// scanOptions adds the backend and, with ContinueOnError, the handler of unreadable directories to the options.
+func (r *run) scanOptions(options []fs.ShadowScanOption) []fs.ShadowScanOption {
+	options = append([]fs.ShadowScanOption{fs.WithScanBackend(r.options.Backend)}, options...)
+	if r.options.ContinueOnError {
+		options = append(options, fs.WithErrorHandler(func(path string, err error) error {
+			return r.fail(retry{path: path, attempts: 1, err: err})
+		}))
+	}
+
+	return options
+}
+
+// track waits until transfers are allowed and executes the operation of the step,
+// the removals are queued with DeleteDelay.
+func (r *run) track(step step) fs.ScannerCallback {
+	// the scanned path is the src path of the src walk and the dst path of the remove walk
+	return func(scannedPath, otherPath string) error {
+		if err := r.options.Wait(r.ctx); err != nil {
+			return err
+		}
+
+		action, operation := step(scannedPath, otherPath)
+
+		switch {
+		case operation == nil:
+			return nil
+		case action == ActionConflict:
+			return operation()
+		case action == ActionRemove && r.options.DeleteMode == DeleteDelay:
+			r.delay(scannedPath, operation)
+
+			return nil
+		}
+
+		return r.execute(action, scannedPath, operation)
+	}
+}
+
+// execute runs the operation of the entry counting it as active and, with ContinueOnError,
+// records its failure instead of returning it.
+func (r *run) execute(action Action, path string, operation func() error) error {
+	r.addStatus(screen.Status{Active: 1})
+	defer r.addStatus(screen.Status{Active: -1})
+
+	err := operation()
+	if err == nil || !r.options.ContinueOnError || r.ctx.Err() != nil && errors.Is(err, r.ctx.Err()) {
+		return err
+	}
+
+	return r.fail(retry{path: path, action: action, operation: operation, attempts: 1, err: err})
+}
+
+// fail queues the entry for a retry when its error is transient, otherwise it records the failure.
+// It returns ErrTooManyErrors when more than MaxErrors entries failed.
+func (r *run) fail(entry retry) error {
+	cause := Classify(entry.err)
+
+	r.lock.Lock()
+
+	if cause == CauseTransient && entry.operation != nil && entry.attempts <= r.options.Retries {
+		entry.due = time.Now().Add(r.options.RetryDelay << (entry.attempts - 1))
+		r.retries = append(r.retries, entry)
+		r.lock.Unlock()
+
+		r.addStatus(screen.Status{Retrying: 1})
+
+		return nil
+	}
+
+	r.result.Failures = append(r.result.Failures, Failure{
+		Path: entry.path, Action: entry.action, Cause: cause, Attempts: entry.attempts, Error: entry.err.Error(),
+	})
+	failed := len(r.result.Failures)
+	r.lock.Unlock()
+
+	r.addStatus(failedStatus(cause))
+
+	if r.options.MaxErrors > 0 && failed > r.options.MaxErrors {
+		return ErrTooManyErrors
+	}
+
+	return nil
+}
+
+// retry runs the queued operations in the order they are due, with exponential backoff until they succeed
+// or the retries are exhausted. When ctx is canceled, the remaining entries are recorded as failed.
+func (r *run) retry() error {
+	for {
+		r.lock.Lock()
+		if len(r.retries) == 0 {
+			r.lock.Unlock()
+
+			return nil
+		}
+
+		sort.SliceStable(r.retries, func(i, j int) bool {
+			return r.retries[i].due.Before(r.retries[j].due)
+		})
+		entry := r.retries[0]
+		r.retries = r.retries[1:]
+		r.lock.Unlock()
+
+		if err := r.await(entry.due); err != nil {
+			r.lock.Lock()
+			remaining := append([]retry{entry}, r.retries...)
+			r.retries = nil
+			r.lock.Unlock()
+
+			r.addStatus(screen.Status{Retrying: -len(remaining)})
+
+			for _, entry := range remaining {
+				entry.operation = nil
+				r.fail(entry) //nolint:errcheck
+			}
+
+			return err
+		}
+
+		r.addStatus(screen.Status{Active: 1, Retrying: -1})
+		entry.attempts++
+		entry.err = entry.operation()
+		r.addStatus(screen.Status{Active: -1})
+
+		if entry.err == nil {
+			continue
+		} else if err := r.fail(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// await waits until the time is due and transfers are allowed.
+func (r *run) await(due time.Time) error {
+	timer := time.NewTimer(time.Until(due))
+	defer timer.Stop()
+
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-timer.C:
+	}
+
+	return r.options.Wait(r.ctx)
+}
+
+func (r *run) failures() []Failure {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.result.Failures
+}
+
+func (r *run) syncFile(srcPath, dstPath string) (Action, func() error) {
+	size := r.fileSize(srcPath)
+	operation := r.operation
+	conflict := r.typeConflict(srcPath, dstPath)
+	skipped := r.specialSkipped(srcPath)
+
+	switch {
+	case skipped != nil:
+		r.warn(srcPath, skipped)
+
+		return r.decide(Decision{Action: ActionSkip, SrcPath: srcPath, DstPath: dstPath},
+			screen.Status{SrcTotalFiles: 1, Skipped: 1, SkippedSpecials: 1}, nil)
+	case conflict && r.options.Replace:
+		return r.decide(Decision{Action: ActionReplace, SrcPath: srcPath, DstPath: dstPath, Size: size},
+			screen.Status{SrcTotalFiles: 1, Replaced: 1, ReplacedBytes: size}, r.replaceType(srcPath, dstPath))
+	case conflict:
+		return r.decide(Decision{Action: ActionConflict, SrcPath: srcPath, DstPath: dstPath, Size: size},
+			screen.Status{SrcTotalFiles: 1, Conflicts: 1}, nil)
+	case !operation.Exists(dstPath):
+		return r.decide(Decision{Action: ActionCopy, SrcPath: srcPath, DstPath: dstPath, Size: size},
+			screen.Status{SrcTotalFiles: 1, Copied: 1, CopiedBytes: size}, r.copy(srcPath, dstPath))
+	case r.options.Replace && !operation.Equal(srcPath, dstPath):
+		return r.decide(Decision{Action: ActionReplace, SrcPath: srcPath, DstPath: dstPath, Size: size},
+			screen.Status{SrcTotalFiles: 1, Replaced: 1, ReplacedBytes: size}, r.copy(srcPath, dstPath))
+	}
+
+	return r.decide(Decision{Action: ActionSkip, SrcPath: srcPath, DstPath: dstPath, Size: size},
+		screen.Status{SrcTotalFiles: 1, Skipped: 1, SkippedBytes: size}, nil)
+}
+
+// syncDirectory creates the missing dst directories and, with Replace, updates the mode of the existing ones.
+// Their attributes are applied by fixDirectories after the walks, with Replace also to all existing ones.
+func (r *run) syncDirectory(srcPath, dstPath string) (Action, func() error) {
+	conflict := r.typeConflict(srcPath, dstPath)
+
+	switch {
+	case conflict && r.options.Replace:
+		return r.decide(Decision{Action: ActionReplace, SrcPath: srcPath, DstPath: dstPath, IsDir: true},
+			screen.Status{SrcTotalDirectories: 1, Replaced: 1},
+			r.fixLater(fixup{srcPath, dstPath, ActionReplace}, r.replaceType(srcPath, dstPath)))
+	case conflict:
+		return r.decide(Decision{Action: ActionConflict, SrcPath: srcPath, DstPath: dstPath, IsDir: true},
+			screen.Status{SrcTotalDirectories: 1, Conflicts: 1}, skipEntries)
+	case !r.operation.Exists(dstPath):
+		return r.decide(Decision{Action: ActionCopy, SrcPath: srcPath, DstPath: dstPath, IsDir: true},
+			screen.Status{SrcTotalDirectories: 1, Copied: 1},
+			r.fixLater(fixup{srcPath, dstPath, ActionCopy}, r.copy(srcPath, dstPath)))
+	}
+
+	// the entries of read-only directories fail otherwise, the mode is compared before
+	equal := r.options.Replace && r.operation.Equal(srcPath, dstPath)
+	r.unlock(dstPath)
+
+	switch {
+	case r.options.Replace && !equal:
+		return r.decide(Decision{Action: ActionReplace, SrcPath: srcPath, DstPath: dstPath, IsDir: true},
+			screen.Status{SrcTotalDirectories: 1, Replaced: 1},
+			r.fixLater(fixup{srcPath, dstPath, ActionReplace}, nil))
+	case r.options.Replace:
+		// the mtime changes with the entries of the directory
+		r.fixLater(fixup{srcPath, dstPath, ActionReplace}, nil)() //nolint:errcheck
+	}
+
+	return r.decide(Decision{Action: ActionSkip, SrcPath: srcPath, DstPath: dstPath, IsDir: true},
+		screen.Status{SrcTotalDirectories: 1, Skipped: 1}, nil)
+}
+
+// fixLater returns an operation running the operation, if any, and queuing the fixup of the directory
+// when it succeeded.
+func (r *run) fixLater(directory fixup, operation func() error) func() error {
+	return func() error {
+		if operation != nil {
+			if err := operation(); err != nil {
+				return err
+			}
+		}
+
+		r.lock.Lock()
+		r.fixups = append(r.fixups, directory)
+		r.lock.Unlock()
+
+		return nil
+	}
+}
+
+// fixDirectories applies the attributes of the queued directories in reverse walk order,
+// so each directory is done after the directories below it.
+// With ContinueOnError the failures are recorded, otherwise they are returned after all directories were tried.
+func (r *run) fixDirectories() error {
+	var errs []error
+
+	for i := len(r.fixups) - 1; i >= 0; i-- {
+		directory := r.fixups[i]
+		delete(r.locked, directory.dstPath)
+
+		err := r.operation.CopyAttributes(directory.srcPath, directory.dstPath)
+		switch {
+		case err == nil:
+		case r.options.ContinueOnError:
+			r.fail(retry{path: directory.srcPath, action: directory.action, attempts: 1, err: err}) //nolint:errcheck
+		default:
+			errs = append(errs, err)
+		}
+	}
+
+	for dstPath, mode := range r.locked {
+		err := r.options.Backend.Chmod(dstPath, mode)
+		switch {
+		case err == nil, errors.Is(err, iofs.ErrNotExist):
+		case r.options.ContinueOnError:
+			r.fail(retry{path: dstPath, action: ActionSkip, attempts: 1, err: err}) //nolint:errcheck
+		default:
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// removeFile removes the dst file, the remove scanner swaps the paths.
+func (r *run) removeFile(dstPath, srcPath string) (Action, func() error) {
+	if r.operation.Exists(srcPath) || r.delayedAbove(dstPath) {
+		return "", nil
+	}
+
+	size := r.fileSize(dstPath)
+
+	return r.decide(Decision{Action: ActionRemove, SrcPath: srcPath, DstPath: dstPath, Size: size},
+		screen.Status{DstTotalFiles: 1, Removed: 1, RemovedBytes: size}, r.delete(dstPath))
+}
+
+// removeDirectory removes the dst directory, the remove scanner swaps the paths.
+// Directories in conflict with a src entry are left to the src walk.
+func (r *run) removeDirectory(dstPath, srcPath string) (Action, func() error) {
+	if r.typeConflict(srcPath, dstPath) {
+		return ActionConflict, skipEntries
+	} else if r.operation.Exists(srcPath) || r.delayedAbove(dstPath) {
+		return "", nil
+	}
+
+	return r.decide(Decision{Action: ActionRemove, SrcPath: srcPath, DstPath: dstPath, IsDir: true},
+		screen.Status{DstTotalDirectories: 1, Removed: 1}, r.delete(dstPath))
+}
+
+// replaceType removes the dst entry of another type and copies the src entry in its place.
+func (r *run) replaceType(srcPath, dstPath string) func() error {
+	return func() error {
+		if err := r.operation.Delete(dstPath); err != nil {
+			return err
+		}
+
+		return r.operation.Copy(srcPath, dstPath)
+	}
+}
+
+// skipEntries is the operation of directories in conflict, the walk skips their entries.
+func skipEntries() error {
+	return iofs.SkipDir
+}
+
+func (r *run) copy(srcPath, dstPath string) func() error {
+	return func() error {
+		return r.operation.Copy(srcPath, dstPath)
+	}
+}
+
+func (r *run) delete(dstPath string) func() error {
+	return func() error {
+		r.unlock(filepath.Dir(dstPath))
+
+		return r.operation.Delete(dstPath)
+	}
+}
+
+// unlock makes the existing read-only dst directory writable for the owner before its entries are changed,
+// fixDirectories restores its mode unless it applies the attributes of src to it anyway.
+// When it fails the entries fail with their own errors.
+func (r *run) unlock(dstPath string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.locked[dstPath]; ok {
+		return
+	}
+
+	if mode, unlocked, _ := fs.Unlock(r.options.Backend, dstPath); unlocked {
+		if r.locked == nil {
+			r.locked = map[string]iofs.FileMode{}
+		}
+
+		r.locked[dstPath] = mode
+	}
+}
+
+// decide notifies the observer about the decision and counts it, it returns the action and its operation.
+// Actions rejected by Approve are skipped.
+func (r *run) decide(decision Decision, status screen.Status, operation func() error) (Action, func() error) {
+	if operation != nil && decision.Action != ActionConflict && r.options.Approve != nil && !r.options.Approve(decision) {
+		decision.Action, operation = ActionSkip, nil
+		status = screen.Status{
+			SrcTotalFiles: status.SrcTotalFiles, SrcTotalDirectories: status.SrcTotalDirectories,
+			DstTotalFiles: status.DstTotalFiles, DstTotalDirectories: status.DstTotalDirectories,
+			Skipped: 1, SkippedBytes: decision.Size,
+		}
+	}
+
+	r.options.Observer.Decided(decision)
+	r.addStatus(status)
+
+	return decision.Action, operation
+}
+
+// progress counts the bytes copied and passes the file being copied to a ProgressObserver.
+func (r *run) progress(progress fs.Progress) {
+	r.addStatus(screen.Status{DoneBytes: progress.Delta})
+
+	if observer, ok := r.options.Observer.(ProgressObserver); ok {
+		observer.Progress(progress)
+	}
+}
+
+// copied counts the copy by its method and passes it to a CopyObserver.
+func (r *run) copied(dst string, method fs.CopyMethod) {
+	switch method {
+	case fs.MethodReflink:
+		r.addStatus(screen.Status{Reflinked: 1})
+	case fs.MethodCopyFileRange, fs.MethodSendfile:
+		r.addStatus(screen.Status{KernelCopied: 1})
+	default:
+		r.addStatus(screen.Status{Streamed: 1})
+	}
+
+	if observer, ok := r.options.Observer.(CopyObserver); ok {
+		observer.Copied(dst, method)
+	}
+}
+
+func (r *run) addStatus(status screen.Status) {
+	r.lock.Lock()
+	r.result.Status.Add(status)
+	r.lock.Unlock()
+
+	r.options.Observer.AddStatus(status)
+}
+
+// typeConflict reports whether the dst entry exists with another type than the src entry.
+// The src entry is followed like it is copied, so symlinks on dst are in conflict with every entry.
+// The roots are never in conflict.
+func (e *Engine) typeConflict(srcPath, dstPath string) bool {
+	if srcPath == e.options.SrcRootPath {
+		return false
+	}
+
+	srcState, err := e.options.Backend.Stat(srcPath)
+	if err != nil {
+		return false
+	}
+
+	dstState, err := e.options.Backend.Lstat(dstPath)
+
+	return err == nil && fs.TypeOf(srcState.Mode()) != fs.TypeOf(dstState.Mode())
+}
+
+// warn notifies a WarningObserver about the entry, counting the name collisions.
+func (r *run) warn(path string, err error) {
+	if errors.Is(err, fs.ErrNameCollision) {
+		r.addStatus(screen.Status{Collisions: 1})
+	}
+
+	if observer, ok := r.options.Observer.(WarningObserver); ok {
+		observer.Warned(path, err)
+	}
+}
+
+// nameOptions returns the options of a default scanner handling the names colliding on dst,
+// none when they can't collide. The names of the remove walk are matched with the src names.
+func (e *Engine) nameOptions(remove bool, report func(path string, err error)) []fs.ShadowScanOption {
+	profile := fs.FullProfile
+	if e.options.Profile != nil {
+		profile = *e.options.Profile
+	}
+
+	switch {
+	case !fs.NamesCollide(e.options.Normalization, profile):
+		return nil
+	case remove:
+		return []fs.ShadowScanOption{fs.WithDenormalization(e.options.Normalization, profile, e.options.Collisions)}
+	}
+
+	return []fs.ShadowScanOption{fs.WithNormalization(e.options.Normalization, profile, e.options.Collisions, report)}
+}
+
+// specialSkipped returns fs.ErrSpecialSkipped when the src entry is a special file which is not recreated.
+func (e *Engine) specialSkipped(srcPath string) error {
+	state, err := e.options.Backend.Stat(srcPath)
+	if err != nil {
+		return nil //nolint:nilerr
+	}
+
+	return fs.SpecialSkipped(state.Mode(), e.options.Specials, e.options.Devices)
+}
+
+// fileSize returns the size of the file, 0 when its state is unknown.
+func (r *run) fileSize(path string) int64 {
+	state, err := r.options.Backend.Stat(path)
+	if err != nil {
+		return 0
+	}
+
+	return state.Size()
+}
+
+func (r *run) finish(err error) (Result, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.result.Duration = time.Since(r.result.Started)
+
+	return r.result, err
+}
@@ -0,0 +1,73 @@
+//go:build linux || darwin || freebsd
+
+package sync
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineSpecials(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_engine_specials")
+	})
+
+	for _, dir := range []string{"test_engine_specials/src", "test_engine_specials/dst"} {
+		assert.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	assert.NoError(t, syscall.Mkfifo("test_engine_specials/src/fifo", 0o644))
+
+	listener, err := net.Listen("unix", "test_engine_specials/src/socket")
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	options := Options{SrcRootPath: "test_engine_specials/src", DstRootPath: "test_engine_specials/dst"}
+
+	t.Run("Skipped", func(t *testing.T) {
+		observer := &recorder{}
+		options := options
+		options.Observer = observer
+
+		engine, err := New(options)
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, result.Status.SkippedSpecials)
+		assert.Equal(t, 3, result.Status.Skipped, "the specials and the root")
+		assert.Zero(t, result.Status.Copied)
+		assert.ElementsMatch(t, []string{
+			"test_engine_specials/src/fifo: special file skipped: fifo",
+			"test_engine_specials/src/socket: special file skipped: socket",
+		}, observer.warnings)
+		assert.NoFileExists(t, "test_engine_specials/dst/fifo")
+	})
+
+	t.Run("Specials", func(t *testing.T) {
+		options := options
+		options.Specials = true
+
+		engine, err := New(options)
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, result.Status.SkippedSpecials)
+		assert.Equal(t, 1, result.Status.Copied)
+
+		state, err := os.Lstat("test_engine_specials/dst/fifo")
+		assert.NoError(t, err)
+		assert.Equal(t, os.ModeNamedPipe, state.Mode().Type())
+	})
+}
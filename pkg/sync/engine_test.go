@@ -0,0 +1,608 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	gosync "sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"dtsync/pkg/fs"
+	"dtsync/pkg/screen"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// recorder is an observer keeping the decisions.
+type recorder struct {
+	lock      gosync.Mutex
+	status    screen.Status
+	decisions []Decision
+	warnings  []string
+}
+
+func (r *recorder) AddStatus(status screen.Status) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.status.Add(status)
+}
+
+func (r *recorder) Decided(decision Decision) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.decisions = append(r.decisions, decision)
+}
+
+func (r *recorder) Warned(path string, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.warnings = append(r.warnings, path+": "+err.Error())
+}
+
+func TestEngine(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_engine")
+	})
+
+	for _, dir := range []string{"test_engine/src/dir", "test_engine/dst/leftover"} {
+		assert.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	for name, content := range map[string]string{
+		"test_engine/src/new.txt":     "new",
+		"test_engine/src/changed.txt": "changed",
+		"test_engine/src/dir/same":    "",
+		"test_engine/dst/changed.txt": "old",
+		"test_engine/dst/removed.txt": "removed",
+	} {
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+
+	t.Run("Run", func(t *testing.T) {
+		observer := &recorder{}
+		engine, err := New(Options{
+			SrcRootPath: "test_engine/src", DstRootPath: "test_engine/dst", Replace: true, Remove: true, Observer: observer,
+		})
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, result.Complete)
+		assert.Equal(t, observer.status, result.Status)
+		// the copy methods depend on the file system
+		assert.Equal(t, 3, result.Status.Reflinked+result.Status.KernelCopied+result.Status.Streamed)
+		result.Status.Reflinked, result.Status.KernelCopied, result.Status.Streamed = 0, 0, 0
+		assert.Equal(t, screen.Status{
+			SrcTotalFiles: 3, SrcTotalDirectories: 2, DstTotalFiles: 1, DstTotalDirectories: 1,
+			Copied: 3, Replaced: 1, Removed: 2, Skipped: 1, CopiedBytes: 3, ReplacedBytes: 7, RemovedBytes: 7, DoneBytes: 10,
+		}, result.Status)
+		assert.False(t, result.Started.IsZero())
+
+		sort.Slice(observer.decisions, func(i, j int) bool {
+			return observer.decisions[i].DstPath < observer.decisions[j].DstPath
+		})
+		assert.Equal(t, []Decision{
+			{Action: ActionSkip, SrcPath: "test_engine/src", DstPath: "test_engine/dst", IsDir: true},
+			{Action: ActionReplace, SrcPath: "test_engine/src/changed.txt", DstPath: "test_engine/dst/changed.txt", Size: 7},
+			{Action: ActionCopy, SrcPath: "test_engine/src/dir", DstPath: "test_engine/dst/dir", IsDir: true},
+			{Action: ActionCopy, SrcPath: "test_engine/src/dir/same", DstPath: "test_engine/dst/dir/same"},
+			{Action: ActionRemove, SrcPath: "test_engine/src/leftover", DstPath: "test_engine/dst/leftover", IsDir: true},
+			{Action: ActionCopy, SrcPath: "test_engine/src/new.txt", DstPath: "test_engine/dst/new.txt", Size: 3},
+			{Action: ActionRemove, SrcPath: "test_engine/src/removed.txt", DstPath: "test_engine/dst/removed.txt", Size: 7},
+		}, observer.decisions)
+
+		data, err := os.ReadFile("test_engine/dst/changed.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "changed", string(data))
+		assert.NoFileExists(t, "test_engine/dst/removed.txt")
+		assert.NoDirExists(t, "test_engine/dst/leftover")
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		engine, err := New(Options{SrcRootPath: "test_engine/src", DstRootPath: "test_engine/dst"})
+		assert.NoError(t, err)
+
+		_, err = engine.Run(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("MissingRootPath", func(t *testing.T) {
+		_, err := New(Options{SrcRootPath: "test_engine/src"})
+		assert.ErrorIs(t, err, ErrMissingRootPath)
+	})
+}
+
+func TestEngineDirectories(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		for _, dir := range []string{"test_engine_dirs/src/ro", "test_engine_dirs/dst/ro"} {
+			os.Chmod(dir, 0o755)
+		}
+
+		os.RemoveAll("test_engine_dirs")
+	})
+
+	for _, dir := range []string{"test_engine_dirs/src/ro", "test_engine_dirs/src/mode", "test_engine_dirs/dst/mode"} {
+		assert.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	assert.NoError(t, os.WriteFile("test_engine_dirs/src/ro/file.txt", []byte("file"), 0o644))
+	assert.NoError(t, os.Chmod("test_engine_dirs/src/ro", 0o500))
+	assert.NoError(t, os.Chmod("test_engine_dirs/src/mode", 0o750))
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, dir := range []string{"test_engine_dirs/src/ro", "test_engine_dirs/src/mode", "test_engine_dirs/src"} {
+		assert.NoError(t, os.Chtimes(dir, modTime, modTime))
+	}
+
+	assertDir := func(t *testing.T, path string, perm os.FileMode) {
+		t.Helper()
+
+		state, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, perm, state.Mode().Perm())
+		assert.Equal(t, modTime, state.ModTime())
+	}
+
+	t.Run("Copy", func(t *testing.T) {
+		engine, err := New(Options{SrcRootPath: "test_engine_dirs/src", DstRootPath: "test_engine_dirs/dst"})
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, result.Status.Copied)
+		assert.FileExists(t, "test_engine_dirs/dst/ro/file.txt")
+		assertDir(t, "test_engine_dirs/dst/ro", 0o500)
+
+		// existing directories are kept without Replace
+		state, err := os.Stat("test_engine_dirs/dst/mode")
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o755), state.Mode().Perm())
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		observer := &recorder{}
+		engine, err := New(Options{
+			SrcRootPath: "test_engine_dirs/src", DstRootPath: "test_engine_dirs/dst", Replace: true, Observer: observer,
+		})
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, result.Status.Replaced)
+		assert.Contains(t, observer.decisions, Decision{
+			Action: ActionReplace, SrcPath: "test_engine_dirs/src/mode", DstPath: "test_engine_dirs/dst/mode", IsDir: true,
+		})
+		assertDir(t, "test_engine_dirs/dst/mode", 0o750)
+		assertDir(t, "test_engine_dirs/dst", 0o755)
+	})
+}
+
+func TestEngineReadOnlyDirectories(t *testing.T) {
+	t.Parallel()
+
+	if os.Getuid() == 0 {
+		t.Skip("root writes into read-only directories")
+	}
+
+	t.Cleanup(func() {
+		for _, dir := range []string{"src/ro", "dst/ro", "dst/gone", "dst/gone/sub"} {
+			os.Chmod("test_engine_ro/"+dir, 0o755)
+		}
+
+		os.RemoveAll("test_engine_ro")
+	})
+
+	assert.NoError(t, os.MkdirAll("test_engine_ro/src/ro", 0o755))
+	assert.NoError(t, os.MkdirAll("test_engine_ro/dst", 0o755))
+	assert.NoError(t, os.WriteFile("test_engine_ro/src/ro/a.txt", []byte("a"), 0o644))
+	assert.NoError(t, os.Chmod("test_engine_ro/src/ro", 0o500))
+
+	assertMode := func(t *testing.T, path string, perm os.FileMode) {
+		t.Helper()
+
+		state, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, perm, state.Mode().Perm(), path)
+	}
+
+	run := func(t *testing.T, options Options) Result {
+		t.Helper()
+
+		options.SrcRootPath, options.DstRootPath = "test_engine_ro/src", "test_engine_ro/dst"
+		engine, err := New(options)
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+
+		return result
+	}
+
+	run(t, Options{})
+	assertMode(t, "test_engine_ro/dst/ro", 0o500)
+
+	// new src entries and dst leftovers in the read-only directories of the second run
+	assert.NoError(t, os.Chmod("test_engine_ro/src/ro", 0o755))
+	assert.NoError(t, os.WriteFile("test_engine_ro/src/ro/b.txt", []byte("b"), 0o644))
+	assert.NoError(t, os.Chmod("test_engine_ro/src/ro", 0o500))
+	assert.NoError(t, os.Chmod("test_engine_ro/dst/ro", 0o755))
+	assert.NoError(t, os.WriteFile("test_engine_ro/dst/ro/old.txt", []byte("old"), 0o644))
+	assert.NoError(t, os.Chmod("test_engine_ro/dst/ro", 0o500))
+	assert.NoError(t, os.MkdirAll("test_engine_ro/dst/gone/sub", 0o755))
+	assert.NoError(t, os.WriteFile("test_engine_ro/dst/gone/sub/file.txt", []byte("gone"), 0o644))
+	assert.NoError(t, os.Chmod("test_engine_ro/dst/gone/sub", 0o500))
+	assert.NoError(t, os.Chmod("test_engine_ro/dst/gone", 0o500))
+
+	t.Run("Copy", func(t *testing.T) {
+		result := run(t, Options{Remove: true, DeleteMode: DeleteBefore})
+		assert.Equal(t, 1, result.Status.Copied)
+		assert.Equal(t, 2, result.Status.Removed)
+		assert.FileExists(t, "test_engine_ro/dst/ro/b.txt")
+		assert.NoFileExists(t, "test_engine_ro/dst/ro/old.txt")
+		assert.NoDirExists(t, "test_engine_ro/dst/gone")
+		assertMode(t, "test_engine_ro/dst/ro", 0o500)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		assert.NoError(t, os.Chtimes("test_engine_ro/src/ro/a.txt", time.Now(), time.Now().Add(time.Hour)))
+
+		result := run(t, Options{Replace: true})
+		assert.Equal(t, 1, result.Status.Replaced)
+		assertMode(t, "test_engine_ro/dst/ro", 0o500)
+	})
+}
+
+func TestEngineTypeConflict(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_engine_types")
+	})
+
+	for _, dir := range []string{"test_engine_types/src/dir", "test_engine_types/dst/file"} {
+		assert.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	for _, file := range []string{
+		"test_engine_types/src/file", "test_engine_types/src/dir/file.txt",
+		"test_engine_types/dst/file/file.txt", "test_engine_types/dst/dir", "test_engine_types/dst/target",
+	} {
+		assert.NoError(t, os.WriteFile(file, []byte("file"), 0o644))
+	}
+
+	assert.NoError(t, os.WriteFile("test_engine_types/src/link", []byte("link"), 0o644))
+	assert.NoError(t, os.Symlink("target", "test_engine_types/dst/link"))
+
+	options := Options{SrcRootPath: "test_engine_types/src", DstRootPath: "test_engine_types/dst", Remove: true}
+
+	assertType := func(t *testing.T, path string, entryType fs.EntryType) {
+		t.Helper()
+
+		state, err := os.Lstat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, entryType, fs.TypeOf(state.Mode()), path)
+	}
+
+	t.Run("Conflict", func(t *testing.T) {
+		observer := &recorder{}
+		options := options
+		options.Observer = observer
+
+		engine, err := New(options)
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 3, result.Status.Conflicts)
+		assert.Equal(t, 1, result.Status.Removed, "the target of the symlink")
+		assert.Contains(t, observer.decisions, Decision{
+			Action: ActionConflict, SrcPath: "test_engine_types/src/dir", DstPath: "test_engine_types/dst/dir", IsDir: true,
+		})
+
+		// the entries of directories in conflict are neither copied nor removed
+		assertType(t, "test_engine_types/dst/file", fs.TypeDir)
+		assert.FileExists(t, "test_engine_types/dst/file/file.txt")
+		assertType(t, "test_engine_types/dst/dir", fs.TypeFile)
+		assertType(t, "test_engine_types/dst/link", fs.TypeSymlink)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		options := options
+		options.Replace = true
+
+		engine, err := New(options)
+		assert.NoError(t, err)
+
+		plan, err := engine.Plan(context.Background())
+		assert.NoError(t, err)
+		reasons := make(map[string]string, len(plan))
+		for _, entry := range plan {
+			reasons[entry.DstPath] = entry.Reason
+		}
+
+		for _, name := range []string{"file", "dir", "link"} {
+			path := "test_engine_types/dst/" + name
+
+			assert.Equal(t, ReasonTypeDiffers, reasons[path], path)
+		}
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 3, result.Status.Replaced)
+		assert.Zero(t, result.Status.Conflicts)
+		assertType(t, "test_engine_types/dst/file", fs.TypeFile)
+		assertType(t, "test_engine_types/dst/dir", fs.TypeDir)
+		assert.FileExists(t, "test_engine_types/dst/dir/file.txt")
+		assertType(t, "test_engine_types/dst/link", fs.TypeFile)
+
+		// the symlink is replaced, not written through
+		data, err := os.ReadFile("test_engine_types/dst/link")
+		assert.NoError(t, err)
+		assert.Equal(t, "link", string(data))
+		assert.NoFileExists(t, "test_engine_types/dst/target")
+	})
+}
+
+func TestEngineNameCollisions(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_engine_names")
+	})
+	assert.NoError(t, os.MkdirAll("test_engine_names/dst", 0o755))
+	assert.NoError(t, os.MkdirAll("test_engine_names/src", 0o755))
+
+	for _, file := range []string{"README.md", "Readme.md", "cafe\u0301"} {
+		assert.NoError(t, os.WriteFile("test_engine_names/src/"+file, []byte(file), 0o644))
+	}
+
+	profile := fs.FullProfile
+	profile.CaseSensitive = false
+
+	observer := &recorder{}
+	engine, err := New(Options{
+		SrcRootPath: "test_engine_names/src", DstRootPath: "test_engine_names/dst", Replace: true, Remove: true,
+		Profile: &profile, Normalization: fs.NormalizeNFC, Collisions: fs.CollisionRename, Observer: observer,
+	})
+	assert.NoError(t, err)
+
+	result, err := engine.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Status.Copied)
+	assert.Equal(t, 1, result.Status.Collisions)
+	assert.Equal(t, []string{
+		"test_engine_names/src/Readme.md: name collision with test_engine_names/src/README.md, renamed to Readme~1.md",
+	}, observer.warnings)
+
+	data, err := os.ReadFile("test_engine_names/dst/Readme~1.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "Readme.md", string(data))
+	assert.FileExists(t, "test_engine_names/dst/caf\u00e9")
+
+	// the remove walk finds the src entries of the normalized and renamed names
+	result, err = engine.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, result.Status.Copied)
+	assert.Zero(t, result.Status.Replaced)
+	assert.Zero(t, result.Status.Removed)
+}
+
+// cancelObserver cancels the run at the first file decision.
+type cancelObserver struct {
+	cancel context.CancelFunc
+}
+
+func (o cancelObserver) AddStatus(screen.Status) {}
+
+func (o cancelObserver) Decided(decision Decision) {
+	if !decision.IsDir {
+		o.cancel()
+	}
+}
+
+func TestEngineGracefulCancel(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_engine_cancel")
+	})
+
+	for _, dir := range []string{"test_engine_cancel/src", "test_engine_cancel/dst"} {
+		assert.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		assert.NoError(t, os.WriteFile("test_engine_cancel/src/"+name, []byte(name), 0o644))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine, err := New(Options{
+		SrcRootPath: "test_engine_cancel/src", DstRootPath: "test_engine_cancel/dst", Remove: true,
+		Observer: cancelObserver{cancel: cancel},
+	})
+	assert.NoError(t, err)
+
+	// the copy in progress is finished, then both walks stop
+	result, err := engine.Run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, result.Complete)
+	assert.Equal(t, 1, result.Status.SrcTotalFiles)
+	assert.Equal(t, 0, result.Status.Active)
+	assert.FileExists(t, "test_engine_cancel/dst/a.txt")
+	assert.NoFileExists(t, "test_engine_cancel/dst/b.txt")
+}
+
+func TestEngineInjected(t *testing.T) {
+	t.Parallel()
+
+	operation := &fs.MockFS{}
+	operation.On("Exists", "dst/file").Return(false)
+	operation.On("Copy", "src/file", "dst/file").Return(nil)
+
+	errChan := make(chan error, 1)
+	scanner := &fs.MockShadowScanner{}
+	scanner.On("Start", mock.Anything, "src", "dst", mock.Anything, mock.Anything).Return(errChan).Run(
+		func(args mock.Arguments) {
+			errChan <- args.Get(3).(fs.ScannerCallback)("src/file", "dst/file") //nolint:forcetypeassert
+		},
+	)
+	scanner.On("Stop").Return()
+
+	removeScanner := &fs.MockShadowScanner{}
+	removeScanner.On("Stop").Return()
+
+	paused := 0
+	engine, err := New(Options{
+		SrcRootPath: "src", DstRootPath: "dst", Operation: operation, Scanner: scanner, RemoveScanner: removeScanner,
+		Wait: func(context.Context) error {
+			paused++
+
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	result, err := engine.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, screen.Status{SrcTotalFiles: 1, Copied: 1}, result.Status)
+	assert.Equal(t, 1, paused)
+	assert.Less(t, result.Duration, time.Minute)
+
+	operation.AssertExpectations(t)
+	scanner.AssertExpectations(t)
+	removeScanner.AssertExpectations(t)
+}
+
+// scanFiles returns a scanner passing the files to the file callback until it returns an error.
+func scanFiles(files ...string) *fs.MockShadowScanner {
+	errChan := make(chan error, 1)
+	scanner := &fs.MockShadowScanner{}
+	scanner.On("Start", mock.Anything, "src", "dst", mock.Anything, mock.Anything).Return(errChan).Run(
+		func(args mock.Arguments) {
+			for _, file := range files {
+				if err := args.Get(3).(fs.ScannerCallback)("src/"+file, "dst/"+file); err != nil { //nolint:forcetypeassert
+					errChan <- err
+
+					return
+				}
+			}
+
+			errChan <- fs.ErrScannerAtEnd
+		},
+	)
+	scanner.On("Stop").Return()
+
+	return scanner
+}
+
+func TestEngineContinueOnError(t *testing.T) {
+	t.Parallel()
+
+	denied := &os.PathError{Op: "open", Path: "src/denied", Err: syscall.EACCES}
+
+	newOperation := func() *fs.MockFS {
+		operation := &fs.MockFS{}
+		operation.On("Exists", mock.Anything).Return(false)
+		operation.On("Copy", "src/denied", "dst/denied").Return(denied)
+		operation.On("Copy", "src/busy", "dst/busy").Return(syscall.EAGAIN).Once()
+		operation.On("Copy", "src/busy", "dst/busy").Return(nil)
+		operation.On("Copy", "src/broken", "dst/broken").Return(syscall.EIO)
+		operation.On("Copy", "src/ok", "dst/ok").Return(nil)
+
+		return operation
+	}
+
+	t.Run("Failures", func(t *testing.T) {
+		t.Parallel()
+
+		operation := newOperation()
+		engine, err := New(Options{
+			SrcRootPath: "src", DstRootPath: "dst", Operation: operation,
+			Scanner: scanFiles("denied", "busy", "broken", "ok"), RemoveScanner: scanFiles(),
+			ContinueOnError: true, Retries: 2, RetryDelay: time.Millisecond,
+		})
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.ErrorIs(t, err, ErrEntriesFailed)
+		assert.NotErrorIs(t, err, ErrTooManyErrors)
+		assert.EqualError(t, err, "2 entries failed")
+		assert.True(t, result.Complete)
+		assert.Equal(t, []Failure{
+			{Path: "src/denied", Action: ActionCopy, Cause: CausePermission, Attempts: 1, Error: denied.Error()},
+			{Path: "src/broken", Action: ActionCopy, Cause: CauseTransient, Attempts: 3, Error: syscall.EIO.Error()},
+		}, result.Failures)
+		assert.Equal(t, screen.Status{
+			SrcTotalFiles: 4, Copied: 4, Failed: 2, PermissionFailed: 1, TransientFailed: 1,
+		}, result.Status, "the retry queue is empty")
+
+		var failed *FailedError
+
+		assert.ErrorAs(t, err, &failed)
+		assert.Equal(t, result.Failures, failed.Failures)
+		operation.AssertNumberOfCalls(t, "Copy", 7)
+	})
+
+	t.Run("MaxErrors", func(t *testing.T) {
+		t.Parallel()
+
+		engine, err := New(Options{
+			SrcRootPath: "src", DstRootPath: "dst", Operation: newOperation(),
+			Scanner: scanFiles("denied", "denied", "ok"), RemoveScanner: scanFiles(),
+			ContinueOnError: true, MaxErrors: 1,
+		})
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.ErrorIs(t, err, ErrTooManyErrors)
+		assert.ErrorIs(t, err, ErrEntriesFailed)
+		assert.False(t, result.Complete)
+		assert.Len(t, result.Failures, 2)
+		assert.Equal(t, 2, result.Status.SrcTotalFiles)
+	})
+
+	t.Run("StopOnError", func(t *testing.T) {
+		t.Parallel()
+
+		engine, err := New(Options{
+			SrcRootPath: "src", DstRootPath: "dst", Operation: newOperation(),
+			Scanner: scanFiles("denied", "ok"), RemoveScanner: scanFiles(),
+		})
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.ErrorIs(t, err, syscall.EACCES)
+		assert.NotErrorIs(t, err, ErrEntriesFailed)
+		assert.Empty(t, result.Failures)
+		assert.Equal(t, 1, result.Status.SrcTotalFiles)
+	})
+}
+
+func TestClassify(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, CausePermission, Classify(&os.PathError{Op: "open", Path: "a", Err: syscall.EACCES}))
+	assert.Equal(t, CauseNotExist, Classify(fmt.Errorf("stat: %w", os.ErrNotExist)))
+	assert.Equal(t, CauseExist, Classify(os.ErrExist))
+	assert.Equal(t, CauseNoSpace, Classify(syscall.ENOSPC))
+	assert.Equal(t, CauseTransient, Classify(&os.PathError{Op: "read", Path: "a", Err: syscall.ESTALE}))
+	assert.Equal(t, CauseTransient, Classify(io.ErrUnexpectedEOF))
+	assert.Equal(t, CauseOther, Classify(assert.AnError))
+}
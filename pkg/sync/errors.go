@@ -0,0 +1,115 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"syscall"
+
+	"dtsync/pkg/screen"
+)
+
+var (
+	// ErrEntriesFailed is returned by runs which continued on errors when entries failed.
+	ErrEntriesFailed = errors.New("entries failed")
+	// ErrTooManyErrors is returned when a run stopped because more entries failed than allowed.
+	ErrTooManyErrors = errors.New("too many errors")
+)
+
+// Cause is the classified cause of a failure.
+type Cause string
+
+const (
+	// CausePermission is a denied access.
+	CausePermission Cause = "permission"
+	// CauseNotExist is an entry which vanished during the run.
+	CauseNotExist Cause = "not_exist"
+	// CauseExist is an entry which appeared during the run.
+	CauseExist Cause = "exist"
+	// CauseNoSpace is a full disk or exceeded quota.
+	CauseNoSpace Cause = "no_space"
+	// CauseTransient is an error which may go away when retried, like EAGAIN, EIO or a stale NFS handle.
+	CauseTransient Cause = "transient"
+	// CauseOther is any other error.
+	CauseOther Cause = "other"
+)
+
+// Classify returns the cause of the error.
+func Classify(err error) Cause {
+	switch {
+	case errors.Is(err, iofs.ErrPermission):
+		return CausePermission
+	case errors.Is(err, iofs.ErrNotExist):
+		return CauseNotExist
+	case errors.Is(err, iofs.ErrExist):
+		return CauseExist
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return CauseNoSpace
+	case errors.Is(err, syscall.EAGAIN), errors.Is(err, syscall.EIO), errors.Is(err, syscall.ESTALE),
+		errors.Is(err, syscall.EINTR), errors.Is(err, syscall.EBUSY), errors.Is(err, syscall.ETIMEDOUT),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, io.ErrUnexpectedEOF):
+		return CauseTransient
+	}
+
+	return CauseOther
+}
+
+// failedStatus is the status of an entry failed with the cause.
+func failedStatus(cause Cause) screen.Status {
+	status := screen.Status{Failed: 1}
+
+	switch cause {
+	case CausePermission:
+		status.PermissionFailed = 1
+	case CauseNotExist:
+		status.NotExistFailed = 1
+	case CauseExist:
+		status.ExistFailed = 1
+	case CauseNoSpace:
+		status.NoSpaceFailed = 1
+	case CauseTransient:
+		status.TransientFailed = 1
+	case CauseOther:
+		status.OtherFailed = 1
+	}
+
+	return status
+}
+
+// Failure is an entry which failed in a run continuing on errors.
+type Failure struct {
+	Path     string `json:"path"`
+	Action   Action `json:"action,omitempty"`
+	Cause    Cause  `json:"cause"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+// FailedError is returned by runs which continued on errors, it lists the failed entries.
+type FailedError struct {
+	Failures []Failure
+	// Stopped is true when the run stopped because of too many errors.
+	Stopped bool
+	// RemovalsSkipped is true when DeleteAfter skipped the removals because of the failures.
+	RemovalsSkipped bool
+}
+
+func (e *FailedError) Error() string {
+	message := fmt.Sprintf("%d %s", len(e.Failures), ErrEntriesFailed)
+	if e.Stopped {
+		message = fmt.Sprintf("%s: stopped after %d failed entries", ErrTooManyErrors, len(e.Failures))
+	}
+
+	if e.RemovalsSkipped {
+		message += ", removals skipped"
+	}
+
+	return message
+}
+
+// Is matches ErrEntriesFailed and, when the run stopped, ErrTooManyErrors.
+func (e *FailedError) Is(target error) bool {
+	return target == ErrEntriesFailed || e.Stopped && target == ErrTooManyErrors //nolint:errorlint,goerr113
+}
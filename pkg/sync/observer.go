@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"dtsync/pkg/fs"
+	"dtsync/pkg/screen"
+)
+
+// Action is the decision taken for an entry.
+type Action string
+
+const (
+	// ActionCopy copies an entry missing on dst.
+	ActionCopy Action = "copy"
+	// ActionReplace replaces a file on dst which differs from src.
+	ActionReplace Action = "replace"
+	// ActionRemove removes an entry on dst missing on src.
+	ActionRemove Action = "remove"
+	// ActionSkip leaves an entry existing on both sides as it is.
+	ActionSkip Action = "skip"
+	// ActionConflict leaves an entry whose dst has another type without Replace, like a directory in place of a file.
+	// The entries of a src directory in conflict are not synced.
+	ActionConflict Action = "conflict"
+)
+
+// Decision is the action taken for one file or directory.
+// For removals DstPath is the removed entry and SrcPath its missing counterpart in src.
+type Decision struct {
+	Action  Action
+	SrcPath string
+	DstPath string
+	IsDir   bool
+	Size    int64
+}
+
+// Observer is notified about the progress and the decisions of a run.
+// The methods are called concurrently by the src and the dst scan.
+type Observer interface {
+	// AddStatus adds progress counters.
+	AddStatus(status screen.Status)
+	// Decided is called for each entry before its action is executed.
+	Decided(decision Decision)
+}
+
+// ProgressObserver is implemented by observers which show the file being copied.
+// The bytes copied are also counted as DoneBytes of the status.
+type ProgressObserver interface {
+	// Progress is called after each read of the src file while it is copied.
+	Progress(progress fs.Progress)
+}
+
+// CopyObserver is implemented by observers which log the method copying each file.
+// The copies are also counted by method in the status.
+type CopyObserver interface {
+	// Copied is called after each file copied or replaced with its dst path.
+	Copied(path string, method fs.CopyMethod)
+}
+
+// WarningObserver is implemented by observers which log the entries skipped with a warning,
+// like the special files which are not recreated and the names colliding on dst. They are also counted in the status.
+type WarningObserver interface {
+	// Warned is called for each entry skipped with a warning with its src path.
+	Warned(path string, err error)
+}
+
+// ProfileObserver is implemented by observers which show the profile of the dst file system.
+type ProfileObserver interface {
+	// Profiled is called with the profile the run adapts to, see Engine.UseProfile.
+	Profiled(profile fs.Profile)
+}
+
+// StatusObserver adapts a progress function to an Observer ignoring the decisions.
+type StatusObserver func(screen.Status)
+
+// AddStatus calls the function.
+func (o StatusObserver) AddStatus(status screen.Status) {
+	o(status)
+}
+
+// Decided ignores the decision.
+func (o StatusObserver) Decided(Decision) {}
+
+// nopObserver is the observer of engines without one.
+type nopObserver struct{}
+
+func (nopObserver) AddStatus(screen.Status) {}
+
+func (nopObserver) Decided(Decision) {}
@@ -0,0 +1,183 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"time"
+
+	"dtsync/pkg/fs"
+)
+
+// Reasons of the planned actions.
+const (
+	ReasonMissingOnDst   = "missing on dst"
+	ReasonMissingOnSrc   = "missing on src"
+	ReasonSrcNewer       = "src is newer"
+	ReasonDstNewer       = "dst is newer"
+	ReasonSizeDiffers    = "size differs"
+	ReasonModeDiffers    = "mode differs"
+	ReasonContentDiffers = "content differs"
+	ReasonTypeDiffers    = "type differs"
+)
+
+// PlanEntry is an action a run would execute, with the details to review it.
+// The mtimes are zero for missing entries.
+type PlanEntry struct {
+	Decision
+	SrcModTime time.Time
+	DstModTime time.Time
+	Reason     string
+}
+
+// Plan walks both roots like a run and returns the copies, replacements and removals it would execute,
+// in walk order, without changing anything. The entries of removed directories are listed too,
+// although a run removes them with their directory. Entries in conflict are listed only when they are replaced,
+// skipped special files are not listed.
+func (e *Engine) Plan(ctx context.Context) ([]PlanEntry, error) {
+	var entries []PlanEntry
+
+	plan := func(decision Decision, reason string) {
+		entry := PlanEntry{Decision: decision, Reason: reason}
+		if state, err := e.options.Backend.Stat(decision.SrcPath); err == nil {
+			entry.SrcModTime = state.ModTime()
+		}
+
+		if state, err := e.options.Backend.Stat(decision.DstPath); err == nil {
+			entry.DstModTime = state.ModTime()
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err := e.walk(ctx, append(e.nameOptions(false, nil), e.options.ScanOptions...),
+		e.options.SrcRootPath, e.options.DstRootPath,
+		func(srcPath, dstPath string) error {
+			srcState, err := e.options.Backend.Stat(srcPath)
+			if err != nil {
+				return nil //nolint:nilerr
+			}
+
+			decision := Decision{SrcPath: srcPath, DstPath: dstPath, Size: srcState.Size()}
+			dstState, err := e.options.Backend.Stat(dstPath)
+
+			switch {
+			case e.specialSkipped(srcPath) != nil:
+			case e.typeConflict(srcPath, dstPath):
+				if e.options.Replace {
+					decision.Action = ActionReplace
+					plan(decision, ReasonTypeDiffers)
+				}
+			case err != nil:
+				decision.Action = ActionCopy
+				plan(decision, ReasonMissingOnDst)
+			case e.options.Replace && !e.options.Operation.Equal(srcPath, dstPath):
+				decision.Action = ActionReplace
+				plan(decision, reason(srcState, dstState))
+			}
+
+			return nil
+		},
+		func(srcPath, dstPath string) error {
+			decision := Decision{SrcPath: srcPath, DstPath: dstPath, IsDir: true}
+
+			switch {
+			case e.typeConflict(srcPath, dstPath) && e.options.Replace:
+				decision.Action = ActionReplace
+				plan(decision, ReasonTypeDiffers)
+			case e.typeConflict(srcPath, dstPath):
+				return iofs.SkipDir
+			case !e.options.Operation.Exists(dstPath):
+				decision.Action = ActionCopy
+				plan(decision, ReasonMissingOnDst)
+			case e.options.Replace && !e.options.Operation.Equal(srcPath, dstPath):
+				// directories differ only by their mode
+				decision.Action = ActionReplace
+				plan(decision, ReasonModeDiffers)
+			}
+
+			return nil
+		},
+	)
+	if err != nil || !e.options.Remove {
+		return entries, err
+	}
+
+	// the remove scanner swaps the paths
+	remove := func(isDir bool) fs.ScannerCallback {
+		return func(dstPath, srcPath string) error {
+			if isDir && e.typeConflict(srcPath, dstPath) {
+				return iofs.SkipDir
+			} else if e.options.Operation.Exists(srcPath) {
+				return nil
+			}
+
+			decision := Decision{Action: ActionRemove, SrcPath: srcPath, DstPath: dstPath, IsDir: isDir}
+			if state, err := e.options.Backend.Stat(dstPath); err == nil && !isDir {
+				decision.Size = state.Size()
+			}
+
+			plan(decision, ReasonMissingOnSrc)
+
+			return nil
+		}
+	}
+
+	err = e.walk(ctx, append(e.nameOptions(true, nil), e.options.RemoveScanOptions...),
+		e.options.DstRootPath, e.options.SrcRootPath, remove(false), remove(true))
+
+	return entries, err
+}
+
+// walk runs a default scanner on the backend to its end, skipping unreadable directories with ContinueOnError.
+func (e *Engine) walk(
+	ctx context.Context, options []fs.ShadowScanOption, srcRootPath, dstRootPath string,
+	fileCallback, dirCallback fs.ScannerCallback,
+) error {
+	options = append([]fs.ShadowScanOption{fs.WithScanBackend(e.options.Backend)}, options...)
+	if e.options.ContinueOnError {
+		options = append(options, fs.WithErrorHandler(func(string, error) error {
+			return nil
+		}))
+	}
+
+	scanner := fs.NewShadowScan(options...)
+	defer scanner.Stop()
+
+	err := <-scanner.Start(ctx, srcRootPath, dstRootPath, fileCallback, dirCallback)
+	if errors.Is(err, fs.ErrScannerAtEnd) {
+		return nil
+	}
+
+	return err
+}
+
+// reason tells why the dst file is replaced.
+func reason(srcState, dstState iofs.FileInfo) string {
+	switch {
+	case srcState.ModTime().After(dstState.ModTime()):
+		return ReasonSrcNewer
+	case srcState.ModTime().Before(dstState.ModTime()):
+		return ReasonDstNewer
+	case srcState.Size() != dstState.Size():
+		return ReasonSizeDiffers
+	case srcState.Mode().Perm() != dstState.Mode().Perm():
+		return ReasonModeDiffers
+	}
+
+	return ReasonContentDiffers
+}
+
+// Approved returns an Options.Approve function accepting only the actions of the entries.
+func Approved(entries []PlanEntry) func(Decision) bool {
+	approved := make(map[string]Action, len(entries))
+	for _, entry := range entries {
+		approved[entry.DstPath] = entry.Action
+	}
+
+	return func(decision Decision) bool {
+		action, ok := approved[decision.DstPath]
+
+		return ok && action == decision.Action
+	}
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"dtsync/pkg/screen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_plan")
+	})
+
+	for _, dir := range []string{"test_plan/src/dir", "test_plan/dst/leftover"} {
+		assert.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	for name, content := range map[string]string{
+		"test_plan/src/new.txt":           "new",
+		"test_plan/src/changed.txt":       "changed",
+		"test_plan/src/dir/new.txt":       "new",
+		"test_plan/dst/changed.txt":       "old",
+		"test_plan/dst/leftover/file.txt": "leftover",
+	} {
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+
+	older := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes("test_plan/dst/changed.txt", older, older))
+
+	engine, err := New(Options{SrcRootPath: "test_plan/src", DstRootPath: "test_plan/dst", Replace: true, Remove: true})
+	assert.NoError(t, err)
+
+	entries, err := engine.Plan(context.Background())
+	assert.NoError(t, err)
+
+	var (
+		decisions []Decision
+		reasons   []string
+	)
+
+	for _, entry := range entries {
+		decisions = append(decisions, entry.Decision)
+		reasons = append(reasons, entry.Reason)
+	}
+
+	assert.Equal(t, []Decision{
+		{Action: ActionReplace, SrcPath: "test_plan/src/changed.txt", DstPath: "test_plan/dst/changed.txt", Size: 7},
+		{Action: ActionCopy, SrcPath: "test_plan/src/dir", DstPath: "test_plan/dst/dir", IsDir: true},
+		{Action: ActionCopy, SrcPath: "test_plan/src/dir/new.txt", DstPath: "test_plan/dst/dir/new.txt", Size: 3},
+		{Action: ActionCopy, SrcPath: "test_plan/src/new.txt", DstPath: "test_plan/dst/new.txt", Size: 3},
+		{Action: ActionRemove, SrcPath: "test_plan/src/leftover", DstPath: "test_plan/dst/leftover", IsDir: true},
+		{
+			Action: ActionRemove, SrcPath: "test_plan/src/leftover/file.txt",
+			DstPath: "test_plan/dst/leftover/file.txt", Size: 8,
+		},
+	}, decisions)
+	assert.Equal(t, []string{
+		ReasonSrcNewer, ReasonMissingOnDst, ReasonMissingOnDst, ReasonMissingOnDst, ReasonMissingOnSrc, ReasonMissingOnSrc,
+	}, reasons)
+	assert.False(t, entries[0].DstModTime.IsZero())
+	assert.True(t, entries[1].DstModTime.IsZero())
+	assert.NoFileExists(t, "test_plan/dst/new.txt")
+
+	t.Run("Approved", func(t *testing.T) {
+		observer := &recorder{}
+		engine, err := New(Options{
+			SrcRootPath: "test_plan/src", DstRootPath: "test_plan/dst", Replace: true, Remove: true,
+			Observer: observer, Approve: Approved([]PlanEntry{entries[3], entries[5]}),
+		})
+		assert.NoError(t, err)
+
+		result, err := engine.Run(context.Background())
+		assert.NoError(t, err)
+		// the copy methods depend on the file system
+		assert.Equal(t, 1, result.Status.Reflinked+result.Status.KernelCopied+result.Status.Streamed)
+		result.Status.Reflinked, result.Status.KernelCopied, result.Status.Streamed = 0, 0, 0
+		assert.Equal(t, screen.Status{
+			SrcTotalFiles: 3, SrcTotalDirectories: 2, DstTotalFiles: 1, DstTotalDirectories: 1,
+			Copied: 1, Removed: 1, Skipped: 5, CopiedBytes: 3, RemovedBytes: 8, SkippedBytes: 10, DoneBytes: 3,
+		}, result.Status)
+
+		assert.FileExists(t, "test_plan/dst/new.txt")
+		assert.NoFileExists(t, "test_plan/dst/leftover/file.txt")
+		assert.DirExists(t, "test_plan/dst/leftover")
+		assert.NoDirExists(t, "test_plan/dst/dir")
+
+		data, err := os.ReadFile("test_plan/dst/changed.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "old", string(data))
+	})
+}
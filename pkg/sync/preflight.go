@@ -0,0 +1,279 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+
+	"dtsync/pkg/fs"
+)
+
+// preflightProbe is the file created in dst to check it is writable, the scanner skips it like partial files.
+const preflightProbe = fs.PartialPrefix + "preflight"
+
+var (
+	// ErrOverlappingRoots is returned when the roots are identical or one is inside the other.
+	ErrOverlappingRoots = errors.New("src and dst overlap")
+	// ErrDstNotWritable is returned when no file can be created in dst.
+	ErrDstNotWritable = errors.New("dst is not writable")
+	// ErrInsufficientSpace is returned when the estimated transfer doesn't fit into the free space of dst.
+	ErrInsufficientSpace = errors.New("insufficient space on dst")
+)
+
+// Estimate is the outcome of the pre-count scan, what a run would add to dst.
+type Estimate struct {
+	Files       int
+	Directories int
+	// Bytes are the sizes of the new files and the growth of the replaced ones.
+	Bytes int64
+	// TransferBytes are the sizes of the new and the replaced files, the bytes a run copies.
+	TransferBytes int64
+}
+
+// Preflight checks that a run can work before it changes anything:
+// the roots must not overlap, also after resolving symlinks and comparing the device and inode of local roots,
+// dst must be writable and the estimated bytes and entries of a quick scan must fit into its free space.
+// The space is checked only on backends reporting it, see fs.SpaceBackend.
+func (e *Engine) Preflight(ctx context.Context) (Estimate, error) {
+	if err := e.checkOverlap(); err != nil {
+		return Estimate{}, err
+	}
+
+	dstPath, err := e.existingDst()
+	if err != nil {
+		return Estimate{}, err
+	}
+
+	if err := e.checkWritable(dstPath); err != nil {
+		return Estimate{}, err
+	}
+
+	estimate, err := e.Estimate(ctx)
+	if err != nil {
+		return estimate, err
+	}
+
+	return estimate, e.checkSpace(dstPath, estimate)
+}
+
+// Probe detects the profile of the dst file system in the dst root or its nearest existing ancestor,
+// see fs.Probe. It changes nothing but the temporary probe files, UseProfile applies the profile.
+// Like Preflight it refuses overlapping roots before it writes them.
+func (e *Engine) Probe() (fs.Profile, error) {
+	if err := e.checkOverlap(); err != nil {
+		return fs.Profile{}, err
+	}
+
+	dstPath, err := e.existingDst()
+	if err != nil {
+		return fs.Profile{}, err
+	}
+
+	profile, err := fs.Probe(e.options.Backend, dstPath)
+	if err != nil {
+		return profile, fmt.Errorf("%w: %w", ErrDstNotWritable, err)
+	}
+
+	return profile, nil
+}
+
+// UseProfile adapts the default operation to the profile of the dst file system and passes the profile
+// to a ProfileObserver. It is called before the run, injected operations are kept as they are.
+func (e *Engine) UseProfile(profile fs.Profile) {
+	e.options.Profile = &profile
+	if e.defaultOperation {
+		e.options.Operation = fs.NewOperation(e.options.operationOptions()...)
+	}
+
+	if observer, ok := e.options.Observer.(ProfileObserver); ok {
+		observer.Profiled(profile)
+	}
+}
+
+// checkOverlap compares the cleaned roots and, when both are local, the device and inode of their ancestors.
+func (e *Engine) checkOverlap() error {
+	src, dst := filepath.Clean(e.options.SrcRootPath), filepath.Clean(e.options.DstRootPath)
+	if fs.Within(dst, src) || fs.Within(src, dst) {
+		return fmt.Errorf("%w: %s and %s", ErrOverlappingRoots, src, dst)
+	}
+
+	if !e.isLocal(src) || !e.isLocal(dst) {
+		return nil
+	}
+
+	// unresolvable roots are reported by the next checks and the run
+	resolvedSrc, srcErr := resolveExisting(src)
+	resolvedDst, dstErr := resolveExisting(dst)
+
+	if srcErr != nil || dstErr != nil {
+		return nil
+	}
+
+	if sameAncestor(resolvedDst, resolvedSrc) || sameAncestor(resolvedSrc, resolvedDst) {
+		return fmt.Errorf("%w: %s and %s", ErrOverlappingRoots, src, dst)
+	}
+
+	return nil
+}
+
+// checkWritable creates and removes a probe file in the existing dst path.
+func (e *Engine) checkWritable(dstPath string) error {
+	probe := filepath.Join(dstPath, preflightProbe)
+
+	file, err := e.options.Backend.Create(probe, 0o600) //nolint:gomnd
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDstNotWritable, err)
+	}
+
+	if err := file.Close(); err != nil {
+		e.options.Backend.RemoveAll(probe) //nolint:errcheck
+
+		return fmt.Errorf("%w: %w", ErrDstNotWritable, err)
+	}
+
+	return e.options.Backend.RemoveAll(probe)
+}
+
+// Estimate walks src like a run, counting the entries missing on dst and the bytes to write,
+// without changing anything. Preflight returns the same estimate.
+func (e *Engine) Estimate(ctx context.Context) (Estimate, error) {
+	var estimate Estimate
+
+	err := e.walk(ctx, append(e.nameOptions(false, nil), e.options.ScanOptions...),
+		e.options.SrcRootPath, e.options.DstRootPath,
+		func(srcPath, dstPath string) error {
+			srcState, err := e.options.Backend.Stat(srcPath)
+			if err != nil {
+				return nil //nolint:nilerr
+			}
+
+			dstState, err := e.options.Backend.Stat(dstPath)
+
+			switch {
+			case err != nil:
+				estimate.Files++
+				estimate.Bytes += srcState.Size()
+				estimate.TransferBytes += srcState.Size()
+			case e.options.Replace && !e.options.Operation.Equal(srcPath, dstPath):
+				estimate.Bytes += max(srcState.Size()-dstState.Size(), 0)
+				estimate.TransferBytes += srcState.Size()
+			}
+
+			return nil
+		},
+		func(srcPath, dstPath string) error {
+			if !e.options.Operation.Exists(dstPath) {
+				estimate.Directories++
+			}
+
+			return nil
+		},
+	)
+
+	return estimate, err
+}
+
+// checkSpace compares the estimate with the free bytes and inodes of the dst file system.
+func (e *Engine) checkSpace(dstPath string, estimate Estimate) error {
+	space, err := fs.FreeSpace(e.options.Backend, dstPath)
+
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		return nil
+	case err != nil:
+		return err
+	case uint64(estimate.Bytes) > space.FreeBytes:
+		return fmt.Errorf("%w: %d bytes needed, %d free", ErrInsufficientSpace, estimate.Bytes, space.FreeBytes)
+	case space.Files > 0 && uint64(estimate.Files+estimate.Directories) > space.FreeFiles:
+		return fmt.Errorf("%w: %d inodes needed, %d free", ErrInsufficientSpace,
+			estimate.Files+estimate.Directories, space.FreeFiles)
+	}
+
+	return nil
+}
+
+// existingDst returns the dst root or its nearest existing ancestor on the same backend, which a run creates it in.
+func (e *Engine) existingDst() (string, error) {
+	root := filepath.Clean(e.options.DstRootPath)
+	backend := e.backendOf(root)
+
+	for path := root; ; path = filepath.Dir(path) {
+		_, err := e.options.Backend.Stat(path)
+
+		switch {
+		case err == nil:
+			return path, nil
+		case !errors.Is(err, iofs.ErrNotExist):
+			return "", fmt.Errorf("%w: %w", ErrDstNotWritable, err)
+		case filepath.Dir(path) == path || e.backendOf(filepath.Dir(path)) != backend:
+			return root, nil
+		}
+	}
+}
+
+// backendOf returns the backend the path is routed to.
+func (e *Engine) backendOf(path string) fs.Backend {
+	if mux, ok := e.options.Backend.(*fs.Mux); ok {
+		backend, _ := mux.Resolve(path)
+
+		return backend
+	}
+
+	return e.options.Backend
+}
+
+func (e *Engine) isLocal(path string) bool {
+	_, ok := e.backendOf(path).(fs.LocalBackend)
+
+	return ok
+}
+
+// resolveExisting returns the absolute path with the symlinks of its existing part resolved.
+func resolveExisting(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+
+		switch {
+		case err == nil:
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		case !errors.Is(err, iofs.ErrNotExist) || filepath.Dir(path) == path:
+			return "", err
+		}
+
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = filepath.Dir(path)
+	}
+}
+
+// sameAncestor reports whether the path or one of its existing ancestors is the same directory as parent,
+// which also detects bind mounts.
+func sameAncestor(path, parent string) bool {
+	if fs.Within(path, parent) {
+		return true
+	}
+
+	parentState, err := os.Stat(parent)
+	if err != nil {
+		return false
+	}
+
+	for ; ; path = filepath.Dir(path) {
+		if state, err := os.Stat(path); err == nil && os.SameFile(state, parentState) {
+			return true
+		}
+
+		if filepath.Dir(path) == path {
+			return false
+		}
+	}
+}
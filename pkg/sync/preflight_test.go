@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"dtsync/pkg/fs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spaceBackend is the local file system reporting a fixed free space.
+type spaceBackend struct {
+	fs.LocalBackend
+	space fs.Space
+}
+
+func (b spaceBackend) Space(string) (fs.Space, error) {
+	return b.space, nil
+}
+
+func TestPreflight(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_preflight")
+	})
+
+	for _, dir := range []string{"test_preflight/src/dir", "test_preflight/dst"} {
+		assert.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	for name, content := range map[string]string{
+		"test_preflight/src/new.txt":     "new",
+		"test_preflight/src/grown.txt":   "grown",
+		"test_preflight/src/dir/new.txt": "new",
+		"test_preflight/dst/grown.txt":   "old",
+		"test_preflight/file":            "",
+	} {
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+
+	assert.NoError(t, os.Symlink("src", "test_preflight/link"))
+
+	preflight := func(options Options) (Estimate, error) {
+		engine, err := New(options)
+		assert.NoError(t, err)
+
+		return engine.Preflight(context.Background())
+	}
+
+	t.Run("Estimate", func(t *testing.T) {
+		t.Parallel()
+
+		estimate, err := preflight(Options{
+			SrcRootPath: "test_preflight/src", DstRootPath: "test_preflight/dst", Replace: true,
+			Backend: spaceBackend{space: fs.Space{FreeBytes: 8, Files: 100, FreeFiles: 3}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, Estimate{Files: 2, Directories: 1, Bytes: 8, TransferBytes: 11}, estimate)
+		assert.NoFileExists(t, "test_preflight/dst/"+preflightProbe)
+	})
+
+	t.Run("Overlapping", func(t *testing.T) {
+		t.Parallel()
+
+		for _, roots := range [][2]string{
+			{"test_preflight/src", "test_preflight/src"},
+			{"test_preflight/src", "test_preflight/src/dir/out"},
+			{"test_preflight/src/dir", "test_preflight/src/"},
+			{"test_preflight/src", "test_preflight/link/out"},
+			{"test_preflight/link", "test_preflight/src"},
+		} {
+			_, err := preflight(Options{SrcRootPath: roots[0], DstRootPath: roots[1]})
+			assert.ErrorIs(t, err, ErrOverlappingRoots, roots)
+		}
+	})
+
+	t.Run("NotWritable", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := preflight(Options{SrcRootPath: "test_preflight/src", DstRootPath: "test_preflight/file/dst"})
+		assert.ErrorIs(t, err, ErrDstNotWritable)
+	})
+
+	t.Run("InsufficientSpace", func(t *testing.T) {
+		t.Parallel()
+
+		for _, space := range []fs.Space{
+			{FreeBytes: 5, Files: 100, FreeFiles: 100},
+			{FreeBytes: 100, Files: 100, FreeFiles: 2},
+		} {
+			_, err := preflight(Options{
+				SrcRootPath: "test_preflight/src", DstRootPath: "test_preflight/dst/missing",
+				Backend: spaceBackend{space: space},
+			})
+			assert.ErrorIs(t, err, ErrInsufficientSpace)
+		}
+	})
+}
+
+// profileRecorder records the profiles the engine adapts to.
+type profileRecorder struct {
+	recorder
+	profiles []fs.Profile
+}
+
+func (r *profileRecorder) Profiled(profile fs.Profile) {
+	r.profiles = append(r.profiles, profile)
+}
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+
+	t.Cleanup(func() {
+		os.RemoveAll("test_probe")
+	})
+
+	for _, dir := range []string{"test_probe/src", "test_probe/dst"} {
+		assert.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+
+	// dst was written by a file system storing 2 s without permissions
+	modTime := time.Now().Truncate(2 * time.Second)
+	for name, perm := range map[string]os.FileMode{"test_probe/src/file.txt": 0o640, "test_probe/dst/file.txt": 0o755} {
+		assert.NoError(t, os.WriteFile(name, []byte("file"), perm))
+		assert.NoError(t, os.Chmod(name, perm))
+	}
+
+	assert.NoError(t, os.Chtimes("test_probe/src/file.txt", modTime, modTime.Add(time.Second)))
+	assert.NoError(t, os.Chtimes("test_probe/dst/file.txt", modTime, modTime))
+
+	observer := &profileRecorder{}
+	engine, err := New(Options{
+		SrcRootPath: "test_probe/src", DstRootPath: "test_probe/dst/new", Replace: true, Observer: observer,
+	})
+	assert.NoError(t, err)
+
+	// the missing dst root is probed in its parent
+	profile, err := engine.Probe()
+	assert.NoError(t, err)
+	assert.True(t, profile.CaseSensitive)
+	assert.NoDirExists(t, "test_probe/dst/new")
+
+	// overlapping roots are refused before the probe files are written
+	engine, err = New(Options{SrcRootPath: "test_probe/dst", DstRootPath: "test_probe/dst/src"})
+	assert.NoError(t, err)
+
+	_, err = engine.Probe()
+	assert.ErrorIs(t, err, ErrOverlappingRoots)
+	assert.NoDirExists(t, "test_probe/dst/src")
+
+	engine, err = New(Options{
+		SrcRootPath: "test_probe/src", DstRootPath: "test_probe/dst", Replace: true, Observer: observer,
+	})
+	assert.NoError(t, err)
+
+	profile = fs.Profile{TimeGranularity: 2 * time.Second, CaseSensitive: true}
+	engine.UseProfile(profile)
+	assert.Equal(t, []fs.Profile{profile}, observer.profiles)
+
+	result, err := engine.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, result.Status.Replaced)
+	assert.Equal(t, 2, result.Status.Skipped, "the file and the root")
+}
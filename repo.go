@@ -0,0 +1,75 @@
+package main
+
+import (
+	"dtsync/pkg/args"
+	"dtsync/pkg/fs"
+	"dtsync/pkg/repo"
+	"fmt"
+	"log"
+)
+
+// RunRepo is the main function of the repo command.
+func RunRepo(arguments args.RepoArguments) {
+	if err := runRepo(arguments); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+func runRepo(arguments args.RepoArguments) error {
+	if arguments.Command == "init" {
+		config := repo.ChunkerConfig{
+			MinSize: arguments.AvgChunkSize / 4, //nolint:gomnd
+			AvgSize: arguments.AvgChunkSize,
+			MaxSize: arguments.AvgChunkSize * 4, //nolint:gomnd
+		}
+
+		if _, err := repo.Init(arguments.RepoPath, config); err != nil {
+			return err
+		}
+
+		fmt.Printf("Initialized repository at %s\n", arguments.RepoPath)
+
+		return nil
+	}
+
+	repository, err := repo.Open(arguments.RepoPath)
+	if err != nil {
+		return err
+	}
+
+	switch arguments.Command {
+	case "backup":
+		scanner := fs.NewShadowScan()
+		defer scanner.Stop()
+
+		ctx, stop := interruptContext()
+		defer stop()
+
+		snapshot, stats, err := repository.Backup(ctx, scanner, arguments.SrcRootPath)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Snapshot      : %s\n", snapshot.ID)
+		fmt.Printf("Files         : %d (%d bytes)\n", stats.Files, stats.Bytes)
+		fmt.Printf("Directories   : %d\n", stats.Directories)
+		fmt.Printf("NewChunks     : %d (%d bytes)\n", stats.NewChunks, stats.NewBytes)
+		fmt.Printf("ReusedChunks  : %d\n", stats.ReusedChunks)
+	case "restore":
+		if err := repository.Restore(arguments.Snapshot, arguments.DstRootPath); err != nil {
+			return err
+		}
+
+		fmt.Printf("Restored %s into %s\n", arguments.Snapshot, arguments.DstRootPath)
+	case "prune":
+		stats, err := repository.Prune(arguments.KeepLast)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("RemovedSnapshots : %d\n", stats.RemovedSnapshots)
+		fmt.Printf("RemovedChunks    : %d\n", stats.RemovedChunks)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// exitAborted is the exit code after the second interrupt, like the shells use for SIGINT.
+const exitAborted = 130
+
+// interruptContext returns a context canceled by the first SIGINT or SIGTERM,
+// which lets the file operations in progress finish. The second signal exits immediately.
+// The returned function releases the signals.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var (
+		signals = make(chan os.Signal, 2) //nolint:gomnd
+		done    = make(chan struct{})
+		once    sync.Once
+	)
+
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+
+		log.Println("Finishing the files in progress, interrupt again to abort")
+		cancel()
+
+		select {
+		case <-signals:
+			log.Println("Aborted")
+			os.Exit(exitAborted)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+			cancel()
+		})
+	}
+}